@@ -0,0 +1,108 @@
+package apfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// AppleDoublePrefix is prepended to a file name to form its AppleDouble file
+const AppleDoublePrefix = "._"
+
+// finderInfoXattr is the extended attribute holding a file's Finder info
+const finderInfoXattr = "com.apple.FinderInfo"
+
+// AppleDouble version 2 layout, as written by copyfile(3). All fields are big
+// endian. The Finder info entry is extended past its 32 bytes with an
+// attribute header, the attribute entries and their values, and the resource
+// fork entry follows it.
+const (
+	appleDoubleMagic       = 0x00051607
+	appleDoubleVersion     = 0x00020000
+	appleDoubleFiller      = "Mac OS X        "
+	appleDoubleFinderInfo  = 9
+	appleDoubleRsrcFork    = 2
+	appleDoubleHeaderSize  = 26 + 2*12
+	appleDoubleAttrMagic   = 0x41545452 // ATTR
+	appleDoubleAttrHeader  = appleDoubleHeaderSize + finderInfoSize + 2
+	appleDoubleAttrEntries = appleDoubleAttrHeader + 36
+	finderInfoSize         = 32
+	maxAppleDoubleName     = 127
+)
+
+// appleDoublePath returns the path of the AppleDouble file for host
+func appleDoublePath(host string) string {
+	return filepath.Join(filepath.Dir(host), AppleDoublePrefix+filepath.Base(host))
+}
+
+// encodeAppleDouble encodes the Finder info, resource fork and remaining
+// extended attributes in attrs as an AppleDouble file. Missing Finder info
+// is written as zeroes.
+func encodeAppleDouble(attrs map[string][]byte) ([]byte, error) {
+	var names []string
+	entriesSize := 0
+	for name := range attrs {
+		if name == finderInfoXattr || name == resourceForkXattr {
+			continue
+		}
+		if len(name) > maxAppleDoubleName {
+			return nil, fmt.Errorf("appledouble: extended attribute name %q is longer than %d bytes", name, maxAppleDoubleName)
+		}
+		names = append(names, name)
+		entriesSize += appleDoubleEntrySize(name)
+	}
+	sort.Strings(names)
+
+	dataStart := appleDoubleAttrEntries + entriesSize
+	dataLength := 0
+	for _, name := range names {
+		dataLength += len(attrs[name])
+	}
+	totalSize := dataStart + dataLength
+	fork := attrs[resourceForkXattr]
+
+	be := binary.BigEndian
+	buf := make([]byte, totalSize, totalSize+len(fork))
+	be.PutUint32(buf[0:], appleDoubleMagic)
+	be.PutUint32(buf[4:], appleDoubleVersion)
+	copy(buf[8:24], appleDoubleFiller)
+	be.PutUint16(buf[24:], 2)
+	putAppleDoubleEntry(buf[26:], appleDoubleFinderInfo, appleDoubleHeaderSize, totalSize-appleDoubleHeaderSize)
+	putAppleDoubleEntry(buf[38:], appleDoubleRsrcFork, totalSize, len(fork))
+	copy(buf[appleDoubleHeaderSize:appleDoubleHeaderSize+finderInfoSize], attrs[finderInfoXattr])
+
+	header := buf[appleDoubleAttrHeader:]
+	be.PutUint32(header[0:], appleDoubleAttrMagic)
+	be.PutUint32(header[8:], uint32(totalSize))
+	be.PutUint32(header[12:], uint32(dataStart))
+	be.PutUint32(header[16:], uint32(dataLength))
+	be.PutUint16(header[34:], uint16(len(names)))
+
+	entry, data := appleDoubleAttrEntries, dataStart
+	for _, name := range names {
+		value := attrs[name]
+		be.PutUint32(buf[entry:], uint32(data))
+		be.PutUint32(buf[entry+4:], uint32(len(value)))
+		buf[entry+10] = byte(len(name) + 1)
+		copy(buf[entry+11:], name)
+		copy(buf[data:], value)
+		entry += appleDoubleEntrySize(name)
+		data += len(value)
+	}
+
+	return append(buf, fork...), nil
+}
+
+// appleDoubleEntrySize returns the size of an attribute entry, a 11 byte
+// header followed by the NUL terminated name, padded to four bytes
+func appleDoubleEntrySize(name string) int {
+	return (11 + len(name) + 1 + 3) &^ 3
+}
+
+// putAppleDoubleEntry writes an entry descriptor of the AppleDouble header
+func putAppleDoubleEntry(b []byte, id uint32, offset, length int) {
+	binary.BigEndian.PutUint32(b[0:], id)
+	binary.BigEndian.PutUint32(b[4:], uint32(offset))
+	binary.BigEndian.PutUint32(b[8:], uint32(length))
+}
@@ -0,0 +1,86 @@
+package apfs
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeAppleDouble parses an AppleDouble file back into its Finder info,
+// resource fork and attributes
+func decodeAppleDouble(t *testing.T, data []byte) (finderInfo, fork []byte, attrs map[string][]byte) {
+	t.Helper()
+	be := binary.BigEndian
+	require.GreaterOrEqual(t, len(data), appleDoubleHeaderSize)
+	require.Equal(t, uint32(appleDoubleMagic), be.Uint32(data[0:]))
+	require.Equal(t, uint32(appleDoubleVersion), be.Uint32(data[4:]))
+	require.Equal(t, appleDoubleFiller, string(data[8:24]))
+
+	attrs = map[string][]byte{}
+	for i := 0; i < int(be.Uint16(data[24:])); i++ {
+		entry := data[26+12*i:]
+		id, offset, length := be.Uint32(entry), be.Uint32(entry[4:]), be.Uint32(entry[8:])
+		require.LessOrEqual(t, int(offset+length), len(data))
+		switch id {
+		case appleDoubleRsrcFork:
+			fork = data[offset : offset+length]
+		case appleDoubleFinderInfo:
+			info := data[offset : offset+length]
+			finderInfo = info[:finderInfoSize]
+			header := data[appleDoubleAttrHeader:]
+			require.Equal(t, uint32(appleDoubleAttrMagic), be.Uint32(header))
+			require.Equal(t, offset+length, be.Uint32(header[8:]))
+
+			pos := appleDoubleAttrEntries
+			for n := 0; n < int(be.Uint16(header[34:])); n++ {
+				valueOff, valueLen := be.Uint32(data[pos:]), be.Uint32(data[pos+4:])
+				nameLen := int(data[pos+10])
+				name := string(data[pos+11 : pos+11+nameLen-1])
+				attrs[name] = data[valueOff : valueOff+valueLen]
+				pos += (11 + nameLen + 3) &^ 3
+			}
+		}
+	}
+	return finderInfo, fork, attrs
+}
+
+func TestEncodeAppleDouble(t *testing.T) {
+	finderInfo := []byte("TEXTttxt\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	data, err := encodeAppleDouble(map[string][]byte{
+		finderInfoXattr:        finderInfo,
+		resourceForkXattr:      []byte("resource fork"),
+		"com.apple.quarantine": []byte("0081;"),
+		"a":                    []byte("1"),
+		"com.example.empty":    {},
+	})
+	require.NoError(t, err)
+
+	gotInfo, fork, attrs := decodeAppleDouble(t, data)
+	assert.Equal(t, finderInfo, gotInfo)
+	assert.Equal(t, "resource fork", string(fork))
+	assert.Equal(t, map[string][]byte{
+		"com.apple.quarantine": []byte("0081;"),
+		"a":                    []byte("1"),
+		"com.example.empty":    {},
+	}, attrs)
+
+	// Attribute entries are 4-byte aligned and start at 0x78
+	assert.Equal(t, 0x78, appleDoubleAttrEntries)
+	assert.Equal(t, uint32(0x78+16+32+32), binary.BigEndian.Uint32(data[appleDoubleAttrHeader+12:]))
+}
+
+func TestEncodeAppleDoubleEmpty(t *testing.T) {
+	data, err := encodeAppleDouble(map[string][]byte{resourceForkXattr: []byte("fork")})
+	require.NoError(t, err)
+	assert.Len(t, data, appleDoubleAttrEntries+4)
+
+	finderInfo, fork, attrs := decodeAppleDouble(t, data)
+	assert.Equal(t, make([]byte, finderInfoSize), finderInfo)
+	assert.Equal(t, "fork", string(fork))
+	assert.Empty(t, attrs)
+
+	_, err = encodeAppleDouble(map[string][]byte{string(make([]byte, 128)): nil})
+	assert.Error(t, err)
+}
@@ -0,0 +1,324 @@
+package apfs
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/internal/disk"
+	"github.com/deploymenttheory/go-apfs/internal/parsers/volumes"
+	"github.com/deploymenttheory/go-apfs/internal/services"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// UUID is a 128-bit identifier as stored on disk
+type UUID [16]byte
+
+// String returns the UUID in its canonical 8-4-4-4-12 form
+func (u UUID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// ContainerInfo describes an APFS container
+type ContainerInfo struct {
+	UUID        UUID
+	BlockSize   uint32
+	BlockCount  uint64
+	Size        uint64
+	NextXID     uint64
+	VolumeCount int
+}
+
+// CheckpointInfo describes a checkpoint the container can be opened at
+type CheckpointInfo struct {
+	XID uint64
+
+	// Block is the address of the checkpoint's superblock in the checkpoint
+	// descriptor area
+	Block uint64
+
+	// Timestamp is the latest volume modification time at the checkpoint,
+	// zero when it cannot be determined
+	Timestamp   time.Time
+	VolumeCount int
+
+	// Current is set for the checkpoint the container is opened at
+	Current bool
+}
+
+// Container is an opened APFS container
+type Container struct {
+	reader *services.ContainerReader
+	closer io.Closer
+
+	mu      sync.Mutex
+	volumes []*Volume
+	closed  bool
+}
+
+// Open opens the APFS container stored in the image at path. Raw images,
+// partitioned disk images, UDIF DMGs, compressed or not, sparse images,
+// sparse bundle directories, EWF (.E01) images and split raw images, opened
+// by their first segment, are supported.
+func Open(path string) (*Container, error) {
+	return OpenWithPassword(path, "")
+}
+
+// OpenWithPassword is Open for encrypted disk images, which are unlocked
+// with password
+func OpenWithPassword(path, password string) (*Container, error) {
+	device, err := disk.OpenDMG(path, &disk.DMGConfig{
+		AutoDetectAPFS: true,
+		DefaultOffset:  0,
+		CacheEnabled:   true,
+		CacheSize:      100,
+		Password:       password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("apfs: open %s: %w", path, err)
+	}
+
+	c, err := NewContainer(device, device.Size())
+	if err != nil {
+		device.Close()
+		return nil, err
+	}
+	c.closer = device
+
+	return c, nil
+}
+
+// NewContainer opens an APFS container that starts at offset zero of r and
+// spans size bytes. The caller remains responsible for closing r.
+func NewContainer(r io.ReaderAt, size int64) (*Container, error) {
+	if r == nil {
+		return nil, fmt.Errorf("apfs: reader cannot be nil")
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("apfs: invalid container size %d", size)
+	}
+
+	reader, err := services.NewContainerReaderFromDevice(r, uint64(size))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAPFS, err)
+	}
+
+	return &Container{reader: reader}, nil
+}
+
+// Close releases the container. Volumes and files obtained from the
+// container must not be used afterwards.
+func (c *Container) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	c.volumes = nil
+
+	c.reader.Close()
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+// Info returns information about the container
+func (c *Container) Info() ContainerInfo {
+	sb := c.reader.GetSuperblock()
+
+	info := ContainerInfo{
+		UUID:        UUID(sb.NxUuid),
+		BlockSize:   sb.NxBlockSize,
+		BlockCount:  sb.NxBlockCount,
+		Size:        uint64(sb.NxBlockSize) * sb.NxBlockCount,
+		NextXID:     uint64(sb.NxNextXid),
+		VolumeCount: len(c.volumeOIDs()),
+	}
+	return info
+}
+
+// MountError reports why the container could not be opened at its latest
+// checkpoint, in which case the superblock at block zero is used, or nil
+func (c *Container) MountError() error {
+	return c.reader.MountError()
+}
+
+// Checkpoints returns the checkpoints still present in the container's
+// checkpoint area, newest first
+func (c *Container) Checkpoints() ([]CheckpointInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	found, err := services.NewCheckpointDiscoveryService(c.reader).ListCheckpoints()
+	if err != nil {
+		return nil, fmt.Errorf("apfs: list checkpoints: %w", err)
+	}
+
+	infos := make([]CheckpointInfo, len(found))
+	for i, cp := range found {
+		infos[i] = CheckpointInfo{
+			XID:         uint64(cp.TransactionID),
+			Block:       cp.BlockAddress,
+			Timestamp:   cp.Timestamp,
+			VolumeCount: cp.VolumeCount,
+			Current:     cp.Mounted,
+		}
+	}
+	return infos, nil
+}
+
+// AtCheckpoint returns the container as it was at the checkpoint with
+// transaction identifier xid. The returned container shares the image of c,
+// which must stay open while it is in use.
+func (c *Container) AtCheckpoint(xid uint64) (*Container, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	reader, err := c.reader.AtCheckpoint(types.XidT(xid))
+	if err != nil {
+		return nil, fmt.Errorf("apfs: %w", err)
+	}
+	return &Container{reader: reader}, nil
+}
+
+// Volumes returns the volumes of the container in superblock order
+func (c *Container) Volumes() ([]*Volume, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.volumes != nil {
+		return c.volumes, nil
+	}
+
+	var vols []*Volume
+	for i, oid := range c.volumeOIDs() {
+		vol, err := c.openVolume(i, oid)
+		if err != nil {
+			return nil, fmt.Errorf("apfs: open volume %d: %w", i, err)
+		}
+		vols = append(vols, vol)
+	}
+
+	c.volumes = vols
+	return vols, nil
+}
+
+// Volume returns the volume with the given name
+func (c *Container) Volume(name string) (*Volume, error) {
+	vols, err := c.Volumes()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vol := range vols {
+		if vol.info.Name == name {
+			return vol, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrVolumeNotFound, name)
+}
+
+// volumeOIDs returns the non-zero volume object identifiers from the superblock
+func (c *Container) volumeOIDs() []types.OidT {
+	var oids []types.OidT
+	for _, oid := range c.reader.GetSuperblock().NxFsOid {
+		if oid != 0 {
+			oids = append(oids, oid)
+		}
+	}
+	return oids
+}
+
+// openVolume wires up the services backing a single volume
+func (c *Container) openVolume(index int, oid types.OidT) (*Volume, error) {
+	vs, err := services.NewVolumeService(c.reader, oid)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := vs.GetSuperblock()
+	fsys, err := services.NewFileSystemService(c.reader, oid, sb)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := services.NewSnapshotService(c.reader, sb)
+	if err != nil {
+		return nil, err
+	}
+
+	decryption, err := services.NewDecryptionService(c.reader, fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	vol := &Volume{
+		info:      newVolumeInfo(index, oid, sb),
+		fs:        fsys,
+		snapshots: snapshots,
+	}
+	vol.openSnapshot = func(snap *services.SnapshotInfo) (fileSystem, error) {
+		snapFS, err := services.NewSnapshotFileSystemService(c.reader, oid, sb, snap)
+		if err != nil {
+			return nil, err
+		}
+		if key := decryption.VolumeKey(); key != nil {
+			if err := snapFS.SetVolumeKey(key); err != nil {
+				return nil, err
+			}
+		}
+		return snapFS, nil
+	}
+	if decryption.IsDecryptionPossible() {
+		vol.unlock = func(password string) error {
+			ok, err := decryption.Authenticate(password)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrWrongPassword
+			}
+			return nil
+		}
+	}
+
+	return vol, nil
+}
+
+// newVolumeInfo extracts the public volume description from a volume superblock
+func newVolumeInfo(index int, oid types.OidT, sb *types.ApfsSuperblockT) VolumeInfo {
+	identity := volumes.NewVolumeIdentity(sb)
+	features := volumes.NewVolumeFeatures(sb)
+	encryption := volumes.NewVolumeEncryptionMetadata(sb)
+
+	return VolumeInfo{
+		Index:                    index,
+		OID:                      uint64(oid),
+		Name:                     identity.Name(),
+		Role:                     identity.RoleName(),
+		UUID:                     UUID(identity.UUID()),
+		Files:                    sb.ApfsNumFiles,
+		Directories:              sb.ApfsNumDirectories,
+		Symlinks:                 sb.ApfsNumSymlinks,
+		Snapshots:                sb.ApfsNumSnapshots,
+		CaseInsensitive:          features.IsCaseInsensitive(),
+		NormalizationInsensitive: features.IsNormalizationInsensitive(),
+		Encrypted:                encryption.IsEncrypted(),
+	}
+}
@@ -0,0 +1,153 @@
+package apfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildMinimalContainer returns an image holding a container superblock, a
+// container object map at blocks 2 and 3, and a single volume superblock
+// stored at block 1 and mapped from OID 1026
+func buildMinimalContainer(volumeName string) []byte {
+	const blockSize = 4096
+	img := make([]byte, 4*blockSize)
+
+	nx := img[0:blockSize]
+	binary.LittleEndian.PutUint32(nx[32:36], types.NxMagic)
+	binary.LittleEndian.PutUint32(nx[36:40], blockSize)
+	binary.LittleEndian.PutUint64(nx[40:48], 4)
+	copy(nx[72:88], []byte{0xde, 0xad, 0xbe, 0xef, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	binary.LittleEndian.PutUint64(nx[96:104], 8)
+	binary.LittleEndian.PutUint64(nx[160:168], 2)
+	binary.LittleEndian.PutUint32(nx[180:184], types.NxMaxFileSystems)
+	binary.LittleEndian.PutUint64(nx[184:192], 1026)
+
+	apsb := img[blockSize : 2*blockSize]
+	binary.LittleEndian.PutUint64(apsb[8:16], 1026)
+	copy(apsb[32:36], "APSB")
+	binary.LittleEndian.PutUint64(apsb[0x88:], 1026)
+	binary.LittleEndian.PutUint64(apsb[0xB8:], 3)
+	binary.LittleEndian.PutUint64(apsb[0xC0:], 2)
+	copy(apsb[0x2C0:], volumeName)
+	binary.LittleEndian.PutUint16(apsb[0x3C4:], types.ApfsVolRoleData)
+
+	omap := img[2*blockSize : 3*blockSize]
+	binary.LittleEndian.PutUint64(omap[8:16], 2)
+	binary.LittleEndian.PutUint32(omap[24:28], types.ObjectTypeOmap|types.ObjPhysical)
+	binary.LittleEndian.PutUint32(omap[40:44], types.ObjectTypeBtree|types.ObjPhysical)
+	binary.LittleEndian.PutUint64(omap[48:56], 3)
+
+	// A root leaf with a single fixed-size entry mapping 1026 at XID 1 to
+	// block 1, followed by the btree_info_t
+	node := img[3*blockSize : 4*blockSize]
+	binary.LittleEndian.PutUint64(node[8:16], 3)
+	binary.LittleEndian.PutUint32(node[24:28], types.ObjectTypeBtree|types.ObjPhysical)
+	binary.LittleEndian.PutUint32(node[28:32], types.ObjectTypeOmap)
+	binary.LittleEndian.PutUint16(node[32:34], types.BtnodeRoot|types.BtnodeLeaf|types.BtnodeFixedKvSize)
+	binary.LittleEndian.PutUint32(node[36:40], 1)
+	binary.LittleEndian.PutUint16(node[42:44], 4)
+	binary.LittleEndian.PutUint16(node[58:60], 16)
+	binary.LittleEndian.PutUint64(node[60:68], 1026)
+	binary.LittleEndian.PutUint64(node[68:76], 1)
+	value := node[blockSize-40-16 : blockSize-40]
+	binary.LittleEndian.PutUint32(value[4:8], blockSize)
+	binary.LittleEndian.PutUint64(value[8:16], 1)
+	info := node[blockSize-40:]
+	binary.LittleEndian.PutUint32(info[4:8], blockSize)
+	binary.LittleEndian.PutUint32(info[8:12], 16)
+	binary.LittleEndian.PutUint32(info[12:16], 16)
+	binary.LittleEndian.PutUint64(info[24:32], 1)
+	binary.LittleEndian.PutUint64(info[32:40], 1)
+
+	sealObject(omap)
+	sealObject(node)
+	return img
+}
+
+// sealObject computes the Fletcher-64 checksum of an object and stores it in its header
+func sealObject(block []byte) {
+	const mod = uint64(0xFFFFFFFF)
+	var sum1, sum2 uint64
+	for i := 8; i < len(block); i += 4 {
+		sum1 = (sum1 + uint64(binary.LittleEndian.Uint32(block[i:i+4]))) % mod
+		sum2 = (sum2 + sum1) % mod
+	}
+	ckLow := mod - ((sum1 + sum2) % mod)
+	ckHigh := mod - ((sum1 + ckLow) % mod)
+	binary.LittleEndian.PutUint64(block[0:8], ckLow|ckHigh<<32)
+}
+
+func TestNewContainer(t *testing.T) {
+	img := buildMinimalContainer("Data")
+
+	c, err := NewContainer(bytes.NewReader(img), int64(len(img)))
+	require.NoError(t, err)
+	defer c.Close()
+
+	info := c.Info()
+	assert.Equal(t, uint32(4096), info.BlockSize)
+	assert.Equal(t, uint64(4), info.BlockCount)
+	assert.Equal(t, 1, info.VolumeCount)
+	assert.Equal(t, "deadbeef-0102-0304-0506-0708090a0b0c", info.UUID.String())
+
+	vols, err := c.Volumes()
+	require.NoError(t, err)
+	require.Len(t, vols, 1)
+	assert.Equal(t, "Data", vols[0].Name())
+	assert.Equal(t, uint64(1026), vols[0].Info().OID)
+	assert.Equal(t, uint64(3), vols[0].Info().Files)
+	assert.Equal(t, uint64(2), vols[0].Info().Directories)
+
+	vol, err := c.Volume("Data")
+	require.NoError(t, err)
+	assert.Same(t, vols[0], vol)
+
+	_, err = c.Volume("Preboot")
+	assert.True(t, errors.Is(err, ErrVolumeNotFound))
+}
+
+func TestNewContainerRejectsNonAPFS(t *testing.T) {
+	img := make([]byte, 8192)
+
+	_, err := NewContainer(bytes.NewReader(img), int64(len(img)))
+	assert.True(t, errors.Is(err, ErrNotAPFS))
+}
+
+func TestContainerClose(t *testing.T) {
+	img := buildMinimalContainer("Data")
+
+	c, err := NewContainer(bytes.NewReader(img), int64(len(img)))
+	require.NoError(t, err)
+
+	require.NoError(t, c.Close())
+	assert.True(t, errors.Is(c.Close(), ErrClosed))
+
+	_, err = c.Volumes()
+	assert.True(t, errors.Is(err, ErrClosed))
+}
+
+func TestContainerCheckpoints(t *testing.T) {
+	img := buildMinimalContainer("Data")
+
+	c, err := NewContainer(bytes.NewReader(img), int64(len(img)))
+	require.NoError(t, err)
+
+	// The minimal image has no checkpoint area to go back to
+	assert.Error(t, c.MountError())
+	checkpoints, err := c.Checkpoints()
+	require.NoError(t, err)
+	assert.Empty(t, checkpoints)
+
+	_, err = c.AtCheckpoint(7)
+	assert.ErrorContains(t, err, "checkpoint 7 not found")
+
+	require.NoError(t, c.Close())
+	_, err = c.Checkpoints()
+	assert.True(t, errors.Is(err, ErrClosed))
+}
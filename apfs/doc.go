@@ -0,0 +1,23 @@
+// Package apfs provides read-only access to Apple File System containers.
+//
+// A Container is opened from an image file or any io.ReaderAt. Each Container
+// holds one or more Volumes, which expose the file system through path based
+// methods such as Stat, ReadDir, Open and Walk, together with extended
+// attributes and snapshots:
+//
+//	c, err := apfs.Open("disk.img")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	vols, err := c.Volumes()
+//	if err != nil {
+//		return err
+//	}
+//	data, err := vols[0].ReadFile("/etc/hosts")
+//
+// Paths are slash separated and interpreted relative to the volume root; a
+// leading slash is optional. Errors for path based operations are returned as
+// *fs.PathError values wrapping the sentinel errors of this package.
+package apfs
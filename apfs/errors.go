@@ -0,0 +1,43 @@
+package apfs
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Errors returned by the package. Path based operations wrap these in an
+// *fs.PathError, so callers should compare with errors.Is.
+var (
+	// ErrNotExist is returned when a path does not exist on the volume
+	ErrNotExist = fs.ErrNotExist
+
+	// ErrClosed is returned when using a closed container or file
+	ErrClosed = fs.ErrClosed
+
+	// ErrNotDir is returned when a directory operation targets a non-directory
+	ErrNotDir = errors.New("not a directory")
+
+	// ErrIsDir is returned when a file operation targets a directory
+	ErrIsDir = errors.New("is a directory")
+
+	// ErrNotSymlink is returned by Readlink for paths that are not symbolic links
+	ErrNotSymlink = errors.New("not a symbolic link")
+
+	// ErrNoXattr is returned when a named extended attribute does not exist
+	ErrNoXattr = errors.New("extended attribute not found")
+
+	// ErrNotAPFS is returned when the image does not contain an APFS container
+	ErrNotAPFS = errors.New("apfs: not an APFS container")
+
+	// ErrVolumeNotFound is returned when a volume cannot be found in the container
+	ErrVolumeNotFound = errors.New("apfs: volume not found")
+
+	// ErrSnapshotNotFound is returned when a snapshot cannot be found on the volume
+	ErrSnapshotNotFound = errors.New("apfs: snapshot not found")
+
+	// ErrLocked is returned when reading an encrypted volume that has not been unlocked
+	ErrLocked = errors.New("apfs: volume is locked")
+
+	// ErrWrongPassword is returned by Unlock when no user of the volume has the password
+	ErrWrongPassword = errors.New("apfs: wrong password")
+)
@@ -0,0 +1,69 @@
+package apfs
+
+import "io/fs"
+
+// Region is a byte range of a file
+type Region struct {
+	Offset int64
+	Length int64
+}
+
+// DataRegions returns the parts of a regular file that are backed by
+// storage, in order. A file without holes is reported as a single region
+// covering its whole size. Holes are only reported when the file's extent
+// records describe them explicitly and cover the whole file, so an
+// incomplete extent list never turns data into zeroes.
+func (v *Volume) DataRegions(name string) ([]Region, error) {
+	info, err := v.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, pathError("dataregions", name, ErrIsDir)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, pathError("dataregions", name, fs.ErrInvalid)
+	}
+	return v.dataRegions(info), nil
+}
+
+// dataRegions computes the data regions of a regular file
+func (v *Volume) dataRegions(info *FileInfo) []Region {
+	size := info.Size()
+	dense := []Region{{Offset: 0, Length: size}}
+	if size == 0 {
+		return nil
+	}
+
+	extents, err := v.fs.GetFileExtents(info.Inode())
+	if err != nil || len(extents) == 0 {
+		return dense
+	}
+
+	var regions []Region
+	var next int64
+	for _, extent := range extents {
+		offset, length := int64(extent.LogicalOffset), int64(extent.LogicalSize)
+		if offset != next || extent.IsCompressed {
+			return dense
+		}
+		next = offset + length
+
+		if extent.PhysicalBlock == 0 || offset >= size {
+			continue
+		}
+		if offset+length > size {
+			length = size - offset
+		}
+		if n := len(regions); n > 0 && regions[n-1].Offset+regions[n-1].Length == offset {
+			regions[n-1].Length += length
+		} else {
+			regions = append(regions, Region{Offset: offset, Length: length})
+		}
+	}
+	if next < size {
+		return dense
+	}
+
+	return regions
+}
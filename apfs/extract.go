@@ -0,0 +1,640 @@
+package apfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// XattrMode selects how Extract restores extended attributes
+type XattrMode int
+
+const (
+	// XattrNone discards extended attributes
+	XattrNone XattrMode = iota
+
+	// XattrUser writes extended attributes to the host's user.* namespace
+	XattrUser
+
+	// XattrSidecar writes extended attributes to a JSON file next to each
+	// extracted file, named after the file with XattrSidecarSuffix appended
+	XattrSidecar
+
+	// XattrAppleDouble writes the Finder info, resource fork and extended
+	// attributes to an AppleDouble file next to each extracted file, named
+	// after the file with AppleDoublePrefix prepended, as macOS does on file
+	// systems without native support
+	XattrAppleDouble
+)
+
+// XattrSidecarSuffix is appended to a file name to form its sidecar file
+const XattrSidecarSuffix = ".xattrs.json"
+
+// ConflictPolicy selects what Extract does when a destination path exists
+type ConflictPolicy int
+
+const (
+	// ConflictOverwrite replaces existing files and merges into existing directories
+	ConflictOverwrite ConflictPolicy = iota
+
+	// ConflictSkip leaves existing files untouched
+	ConflictSkip
+
+	// ConflictRename extracts to a new name alongside the existing file
+	ConflictRename
+)
+
+// ExtractOptions controls Extract. The zero value restores permissions and
+// timestamps, discards extended attributes and overwrites existing files.
+type ExtractOptions struct {
+	// Owner restores file ownership, which usually requires privileges
+	Owner bool
+
+	// Xattrs selects how extended attributes are restored
+	Xattrs XattrMode
+
+	// Conflict selects what happens when a destination already exists
+	Conflict ConflictPolicy
+
+	// Manifest is the path of a resume manifest. Every completed file is
+	// recorded in it, and files already recorded are skipped when an
+	// interrupted extraction is restarted with the same manifest.
+	Manifest string
+
+	// HostOS selects the file naming rules of the destination. It defaults
+	// to runtime.GOOS.
+	HostOS string
+}
+
+// ExtractResult summarises an extraction
+type ExtractResult struct {
+	Files       int
+	Directories int
+	Symlinks    int
+	HardLinks   int
+	Bytes       int64
+
+	// Resumed counts files skipped because the manifest recorded them
+	Resumed int
+
+	// Skipped counts files left alone because of ConflictSkip or because
+	// their type cannot be recreated on the host
+	Skipped int
+
+	// Renamed maps volume paths to the host paths used when the name had
+	// to be changed, either to make it valid on the host or to avoid a
+	// collision
+	Renamed map[string]string
+
+	// Warnings holds metadata that could not be restored, such as
+	// ownership without privileges or extended attributes on a file
+	// system without xattr support
+	Warnings []error
+}
+
+// manifestEntry is a line of the resume manifest
+type manifestEntry struct {
+	Path string `json:"path"`
+	Host string `json:"host"`
+}
+
+// Extract recreates the file or directory tree at root on the host at dest.
+// File data is written sparsely where the volume records holes, hard links
+// are recreated as links, and metadata is restored as selected by opts,
+// which may be nil. Directory metadata is applied after their contents so
+// that read-only directories and timestamps survive the extraction.
+func (v *Volume) Extract(root, dest string, opts *ExtractOptions) (*ExtractResult, error) {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+
+	x := &extractor{
+		vol:    v,
+		opts:   opts,
+		hostOS: opts.HostOS,
+		hosts:  map[string]string{},
+		used:   map[string]map[string]bool{},
+		links:  map[uint64]string{},
+		done:   map[string]string{},
+		result: &ExtractResult{Renamed: map[string]string{}},
+	}
+	if x.hostOS == "" {
+		x.hostOS = runtime.GOOS
+	}
+
+	if err := x.openManifest(); err != nil {
+		return nil, err
+	}
+	defer x.closeManifest()
+
+	root = cleanPath(root)
+	err := v.Walk(root, func(p string, info *FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return x.extract(root, p, dest, info)
+	})
+	if err != nil {
+		return x.result, err
+	}
+
+	// Apply directory metadata deepest first
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		if err := x.applyMetadata(x.dirs[i].host, x.dirs[i].info); err != nil {
+			return x.result, err
+		}
+	}
+
+	return x.result, nil
+}
+
+// extractor holds the state of a single Extract call
+type extractor struct {
+	vol    *Volume
+	opts   *ExtractOptions
+	hostOS string
+	result *ExtractResult
+
+	// hosts maps extracted volume directories to their host paths
+	hosts map[string]string
+
+	// used records the names taken in each host directory, folded for
+	// case-insensitive hosts
+	used map[string]map[string]bool
+
+	// links maps inode numbers of multiply linked files to their host path
+	links map[uint64]string
+
+	// dirs lists extracted directories in walk order
+	dirs []extractedDir
+
+	// done holds the entries of the resume manifest
+	done     map[string]string
+	manifest *os.File
+}
+
+type extractedDir struct {
+	host string
+	info *FileInfo
+}
+
+// openManifest loads the resume manifest, if any, and opens it for appending
+func (x *extractor) openManifest() error {
+	if x.opts.Manifest == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(x.opts.Manifest, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest: %w", err)
+	}
+
+	// Entries are only trusted up to the last complete line; a torn line
+	// left by an interrupted run is cut off so new entries start cleanly
+	var valid int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to read manifest: %w", err)
+		}
+		valid += int64(len(line))
+
+		var entry manifestEntry
+		if json.Unmarshal(line, &entry) == nil && entry.Path != "" {
+			x.done[entry.Path] = entry.Host
+		}
+	}
+
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to truncate manifest: %w", err)
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	x.manifest = f
+	return nil
+}
+
+func (x *extractor) closeManifest() {
+	if x.manifest != nil {
+		x.manifest.Close()
+	}
+}
+
+// record appends a completed file to the manifest
+func (x *extractor) record(p, host string) error {
+	if x.manifest == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(manifestEntry{Path: p, Host: host})
+	if err != nil {
+		return err
+	}
+	if _, err := x.manifest.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to update manifest: %w", err)
+	}
+	return nil
+}
+
+// extract recreates a single file or directory
+func (x *extractor) extract(root, p, dest string, info *FileInfo) error {
+	host := dest
+	if p != root {
+		parent, ok := x.hosts[path.Dir(p)]
+		if !ok {
+			// The parent directory was skipped
+			return nil
+		}
+		host = x.hostPath(parent, p)
+	}
+
+	if done, ok := x.done[p]; ok && !info.IsDir() {
+		x.result.Resumed++
+		if info.Nlink() > 1 && info.Mode().IsRegular() {
+			if _, ok := x.links[info.Inode()]; !ok {
+				x.links[info.Inode()] = done
+			}
+		}
+		return nil
+	}
+
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return x.extractDir(p, host, info)
+	case mode&fs.ModeSymlink != 0:
+		return x.extractSymlink(p, host, info)
+	case mode.IsRegular():
+		return x.extractFile(p, host, info)
+	default:
+		x.result.Skipped++
+		x.warn(p, fmt.Errorf("cannot recreate %s files", fileTypeName(mode)))
+		return nil
+	}
+}
+
+// hostPath chooses the host path for p inside the host directory parent,
+// making the name valid on the host and unique within the directory
+func (x *extractor) hostPath(parent, p string) string {
+	if done, ok := x.done[p]; ok {
+		x.claim(parent, filepath.Base(done))
+		return done
+	}
+
+	name := hostName(path.Base(p), x.hostOS)
+	if x.taken(parent, name) {
+		name = x.uniqueName(parent, name, false)
+	}
+	x.claim(parent, name)
+
+	host := filepath.Join(parent, name)
+	if name != path.Base(p) {
+		x.result.Renamed[p] = host
+	}
+	return host
+}
+
+// foldName returns the key used to detect name collisions on the host
+func (x *extractor) foldName(name string) string {
+	if x.hostOS == "darwin" || x.hostOS == "windows" {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+func (x *extractor) taken(dir, name string) bool {
+	return x.used[dir][x.foldName(name)]
+}
+
+func (x *extractor) claim(dir, name string) {
+	if x.used[dir] == nil {
+		x.used[dir] = map[string]bool{}
+	}
+	x.used[dir][x.foldName(name)] = true
+}
+
+// uniqueName returns name with a numeric suffix that is not used in dir,
+// and that does not exist on disk when onDisk is set
+func (x *extractor) uniqueName(dir, name string, onDisk bool) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if stem == "" {
+		stem, ext = name, ""
+	}
+
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		if x.taken(dir, candidate) {
+			continue
+		}
+		if onDisk {
+			if _, err := os.Lstat(filepath.Join(dir, candidate)); err == nil {
+				continue
+			}
+		}
+		return candidate
+	}
+}
+
+// prepare resolves a conflict with an existing non-directory at host. It
+// returns the path to create, or "" when the file must be skipped.
+func (x *extractor) prepare(p, host string) (string, error) {
+	existing, err := os.Lstat(host)
+	if errors.Is(err, fs.ErrNotExist) {
+		return host, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch x.opts.Conflict {
+	case ConflictSkip:
+		x.result.Skipped++
+		return "", nil
+	case ConflictRename:
+		dir := filepath.Dir(host)
+		name := x.uniqueName(dir, filepath.Base(host), true)
+		x.claim(dir, name)
+		renamed := filepath.Join(dir, name)
+		x.result.Renamed[p] = renamed
+		return renamed, nil
+	default:
+		if existing.IsDir() {
+			return "", fmt.Errorf("%s: destination %s is a directory", p, host)
+		}
+		// Removing rather than truncating avoids writing through a symlink
+		if err := os.Remove(host); err != nil {
+			return "", err
+		}
+		return host, nil
+	}
+}
+
+func (x *extractor) extractDir(p, host string, info *FileInfo) error {
+	existing, err := os.Lstat(host)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// Created owner-writable; the real mode is applied after the contents
+		if err := os.Mkdir(host, 0o700); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !existing.IsDir():
+		if host, err = x.prepare(p, host); err != nil || host == "" {
+			return err
+		}
+		if err := os.Mkdir(host, 0o700); err != nil {
+			return err
+		}
+	}
+
+	x.hosts[p] = host
+	x.dirs = append(x.dirs, extractedDir{host: host, info: info})
+	x.result.Directories++
+	return nil
+}
+
+func (x *extractor) extractSymlink(p, host string, info *FileInfo) error {
+	target, err := x.vol.Readlink(p)
+	if err != nil {
+		return err
+	}
+
+	if host, err = x.prepare(p, host); err != nil || host == "" {
+		return err
+	}
+	if err := os.Symlink(target, host); err != nil {
+		return err
+	}
+
+	if x.opts.Owner {
+		if err := os.Lchown(host, int(info.UID()), int(info.GID())); err != nil {
+			x.warn(p, err)
+		}
+	}
+	if err := lutimes(host, info.AccessTime(), info.ModTime()); err != nil {
+		x.warn(p, err)
+	}
+	if x.opts.Xattrs == XattrSidecar || x.opts.Xattrs == XattrAppleDouble {
+		x.writeXattrs(p, host, false)
+	}
+
+	x.result.Symlinks++
+	return x.record(p, host)
+}
+
+func (x *extractor) extractFile(p, host string, info *FileInfo) error {
+	var err error
+	if host, err = x.prepare(p, host); err != nil || host == "" {
+		return err
+	}
+
+	if info.Nlink() > 1 {
+		if target, ok := x.links[info.Inode()]; ok {
+			if err := os.Link(target, host); err == nil {
+				x.result.HardLinks++
+				return x.record(p, host)
+			}
+			// Fall back to a copy when the host cannot link
+		} else {
+			x.links[info.Inode()] = host
+		}
+	}
+
+	if err := x.writeData(p, host, info); err != nil {
+		return err
+	}
+	if err := x.applyMetadata(host, info); err != nil {
+		return err
+	}
+
+	x.result.Files++
+	x.result.Bytes += info.Size()
+	return x.record(p, host)
+}
+
+// writeData copies the contents of p to a new host file, leaving holes unwritten
+func (x *extractor) writeData(p, host string, info *FileInfo) error {
+	src, err := x.vol.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(host, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	regions := x.vol.dataRegions(info)
+	if err := dst.Truncate(info.Size()); err != nil {
+		dst.Close()
+		return err
+	}
+	for _, r := range regions {
+		w := io.NewOffsetWriter(dst, r.Offset)
+		if _, err := io.Copy(w, io.NewSectionReader(src, r.Offset, r.Length)); err != nil {
+			dst.Close()
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+
+	return dst.Close()
+}
+
+// applyMetadata restores ownership, permissions, extended attributes and
+// timestamps of a file or directory. Ownership and extended attributes are
+// best effort and reported as warnings.
+func (x *extractor) applyMetadata(host string, info *FileInfo) error {
+	if x.opts.Owner {
+		if err := os.Lchown(host, int(info.UID()), int(info.GID())); err != nil {
+			x.warn(info.Path(), err)
+		}
+	}
+
+	perm := info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	if err := os.Chmod(host, perm); err != nil {
+		return err
+	}
+
+	if x.opts.Xattrs != XattrNone {
+		x.writeXattrs(info.Path(), host, true)
+	}
+
+	atime := info.AccessTime()
+	if atime.IsZero() {
+		atime = info.ModTime()
+	}
+	return os.Chtimes(host, atime, info.ModTime())
+}
+
+// writeXattrs restores the extended attributes of p. follow is false for
+// symbolic links, which cannot carry user.* attributes on Linux.
+func (x *extractor) writeXattrs(p, host string, follow bool) {
+	attrs, err := x.vol.Xattrs(p)
+	if err != nil {
+		x.warn(p, err)
+		return
+	}
+	for name := range attrs {
+		if internalXattr(name) {
+			delete(attrs, name)
+		}
+	}
+	if len(attrs) == 0 {
+		return
+	}
+
+	switch x.opts.Xattrs {
+	case XattrSidecar:
+		data, err := json.MarshalIndent(attrs, "", "  ")
+		if err == nil {
+			err = os.WriteFile(host+XattrSidecarSuffix, data, 0o644)
+		}
+		if err != nil {
+			x.warn(p, err)
+		}
+	case XattrAppleDouble:
+		data, err := encodeAppleDouble(attrs)
+		if err == nil {
+			err = os.WriteFile(appleDoublePath(host), data, 0o644)
+		}
+		if err != nil {
+			x.warn(p, err)
+		}
+	case XattrUser:
+		if !follow {
+			return
+		}
+		for name, value := range attrs {
+			if err := setXattr(host, "user."+name, value); err != nil {
+				x.warn(p, fmt.Errorf("xattr %s: %w", name, err))
+			}
+		}
+	}
+}
+
+func (x *extractor) warn(p string, err error) {
+	x.result.Warnings = append(x.result.Warnings, &fs.PathError{Op: "extract", Path: p, Err: err})
+}
+
+// windowsReserved lists device names that cannot be used as file names on
+// Windows, with or without an extension
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// hostName converts an APFS file name to one that is valid on hostOS.
+// Invalid characters are replaced with underscores.
+func hostName(name, hostOS string) string {
+	if !utf8.ValidString(name) {
+		name = strings.ToValidUTF8(name, "_")
+	}
+
+	invalid := func(r rune) bool { return r == '/' || r == 0 }
+	if hostOS == "windows" {
+		invalid = func(r rune) bool {
+			return r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r)
+		}
+	}
+	name = strings.Map(func(r rune) rune {
+		if invalid(r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if name == "" || name == "." || name == ".." {
+		return strings.Repeat("_", len(name)+1)
+	}
+
+	if hostOS == "windows" {
+		if trimmed := strings.TrimRight(name, ". "); trimmed != name {
+			name = trimmed + strings.Repeat("_", len(name)-len(trimmed))
+		}
+		stem, _, _ := strings.Cut(name, ".")
+		if windowsReserved[strings.ToUpper(stem)] {
+			name = "_" + name
+		}
+	}
+	return name
+}
+
+// fileTypeName names the type of a file mode in messages
+func fileTypeName(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "character device"
+	case mode&fs.ModeDevice != 0:
+		return "block device"
+	default:
+		return "mode " + strconv.FormatUint(uint64(mode.Type()), 8)
+	}
+}
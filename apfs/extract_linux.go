@@ -0,0 +1,21 @@
+package apfs
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setXattr sets an extended attribute on the file at path
+func setXattr(path, name string, value []byte) error {
+	return unix.Setxattr(path, name, value, 0)
+}
+
+// lutimes sets the timestamps of a symbolic link without following it
+func lutimes(path string, atime, mtime time.Time) error {
+	if atime.IsZero() {
+		atime = mtime
+	}
+	ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package apfs
+
+import (
+	"errors"
+	"time"
+)
+
+// errXattrUnsupported is reported when the host cannot store extended attributes
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// setXattr sets an extended attribute on the file at path
+func setXattr(path, name string, value []byte) error {
+	return errXattrUnsupported
+}
+
+// lutimes sets the timestamps of a symbolic link without following it. It is
+// a no-op on platforms without a portable lutimes.
+func lutimes(path string, atime, mtime time.Time) error {
+	return nil
+}
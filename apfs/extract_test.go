@@ -0,0 +1,240 @@
+package apfs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractTree(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	readme := fsys.nodes["/docs/readme.txt"]
+	readme.ModifiedTime = time.Unix(1700000000, 123456789)
+	fsys.nodes["/docs/notes"].Mode = 0o040500
+
+	dest := filepath.Join(t.TempDir(), "out")
+	result, err := vol.Extract("/", dest, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Chmod(filepath.Join(dest, "docs", "notes"), 0o700) })
+
+	assert.Equal(t, 2, result.Files)
+	assert.Equal(t, 3, result.Directories)
+	assert.Equal(t, 1, result.Symlinks)
+	assert.Equal(t, int64(25), result.Bytes)
+	assert.Empty(t, result.Warnings)
+
+	data, err := os.ReadFile(filepath.Join(dest, "docs", "readme.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello, apfs", string(data))
+
+	info, err := os.Stat(filepath.Join(dest, "docs", "readme.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+	assert.True(t, readme.ModifiedTime.Equal(info.ModTime()))
+
+	// Directory metadata is applied after the directory has been filled
+	notes, err := os.Stat(filepath.Join(dest, "docs", "notes"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o500), notes.Mode().Perm())
+	todo, err := os.ReadFile(filepath.Join(dest, "docs", "notes", "todo.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "- write tests\n", string(todo))
+
+	target, err := os.Readlink(filepath.Join(dest, "latest"))
+	require.NoError(t, err)
+	assert.Equal(t, "docs/readme.txt", target)
+}
+
+func TestExtractHardLinksAndSparseFiles(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	readme := fsys.nodes["/docs/readme.txt"]
+	readme.HardLinkCount = 2
+	alias := *readme
+	alias.Path, alias.Name = "/docs/alias.txt", "alias.txt"
+	fsys.nodes[alias.Path] = &alias
+
+	content := make([]byte, 3*4096)
+	copy(content[2*4096:], "tail")
+	node := fsys.add("/docs/sparse.img", 0o100644, string(content))
+	fsys.extents[node.Inode] = []services.ExtentMapping{
+		{LogicalOffset: 0, LogicalSize: 8192, PhysicalBlock: 0},
+		{LogicalOffset: 8192, LogicalSize: 4096, PhysicalBlock: 300},
+	}
+
+	dest := t.TempDir()
+	result, err := vol.Extract("/docs", dest, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.HardLinks)
+
+	a, err := os.Stat(filepath.Join(dest, "alias.txt"))
+	require.NoError(t, err)
+	b, err := os.Stat(filepath.Join(dest, "readme.txt"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(a, b))
+
+	data, err := os.ReadFile(filepath.Join(dest, "sparse.img"))
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestExtractSingleFile(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	dest := filepath.Join(t.TempDir(), "copy.txt")
+	result, err := vol.Extract("/docs/readme.txt", dest, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Files)
+
+	data, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, apfs", string(data))
+}
+
+func TestExtractConflicts(t *testing.T) {
+	vol, _ := newTestVolume(t)
+	dest := t.TempDir()
+	existing := filepath.Join(dest, "readme.txt")
+	require.NoError(t, os.WriteFile(existing, []byte("local"), 0o644))
+
+	result, err := vol.Extract("/docs", dest, &ExtractOptions{Conflict: ConflictSkip})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Skipped)
+	data, _ := os.ReadFile(existing)
+	assert.Equal(t, "local", string(data))
+
+	result, err = vol.Extract("/docs", dest, &ExtractOptions{Conflict: ConflictRename})
+	require.NoError(t, err)
+	renamed := filepath.Join(dest, "readme (1).txt")
+	assert.Equal(t, renamed, result.Renamed["/docs/readme.txt"])
+	data, _ = os.ReadFile(renamed)
+	assert.Equal(t, "hello, apfs", string(data))
+
+	_, err = vol.Extract("/docs", dest, nil)
+	require.NoError(t, err)
+	data, _ = os.ReadFile(existing)
+	assert.Equal(t, "hello, apfs", string(data))
+}
+
+func TestExtractInvalidAndCollidingNames(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	fsys.add("/docs/a:b.txt", 0o100644, "colon")
+	fsys.add("/docs/a_b.txt", 0o100644, "underscore")
+	fsys.add("/docs/README.TXT", 0o100644, "upper")
+
+	dest := t.TempDir()
+	result, err := vol.Extract("/docs", dest, &ExtractOptions{HostOS: "windows"})
+	require.NoError(t, err)
+
+	// "README.TXT" sorts before "readme.txt" and keeps its name; the
+	// lowercase file collides on a case-insensitive host
+	assert.Equal(t, filepath.Join(dest, "readme (1).txt"), result.Renamed["/docs/readme.txt"])
+	assert.Equal(t, filepath.Join(dest, "a_b.txt"), result.Renamed["/docs/a:b.txt"])
+	assert.Equal(t, filepath.Join(dest, "a_b (1).txt"), result.Renamed["/docs/a_b.txt"])
+
+	data, err := os.ReadFile(filepath.Join(dest, "a_b (1).txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "underscore", string(data))
+}
+
+func TestExtractResume(t *testing.T) {
+	vol, _ := newTestVolume(t)
+	dest := t.TempDir()
+	manifest := filepath.Join(t.TempDir(), "manifest.jsonl")
+
+	// Simulate an interrupted run that completed one file and left a torn line
+	require.NoError(t, os.MkdirAll(filepath.Join(dest, "docs"), 0o755))
+	done := filepath.Join(dest, "docs", "readme.txt")
+	require.NoError(t, os.WriteFile(done, []byte("already extracted"), 0o644))
+	line, _ := json.Marshal(manifestEntry{Path: "/docs/readme.txt", Host: done})
+	require.NoError(t, os.WriteFile(manifest, append(line, []byte("\n{\"path\":\"/docs/no")...), 0o644))
+
+	result, err := vol.Extract("/", dest, &ExtractOptions{Manifest: manifest})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Resumed)
+	assert.Equal(t, 1, result.Files)
+
+	data, _ := os.ReadFile(done)
+	assert.Equal(t, "already extracted", string(data))
+	data, err = os.ReadFile(filepath.Join(dest, "docs", "notes", "todo.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "- write tests\n", string(data))
+
+	// Every file is now recorded, so a further run has nothing to do
+	result, err = vol.Extract("/", dest, &ExtractOptions{Manifest: manifest})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Resumed)
+	assert.Zero(t, result.Files)
+}
+
+func TestExtractXattrSidecar(t *testing.T) {
+	vol, _ := newTestVolume(t)
+	dest := t.TempDir()
+
+	_, err := vol.Extract("/docs", dest, &ExtractOptions{Xattrs: XattrSidecar})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dest, "readme.txt"+XattrSidecarSuffix))
+	require.NoError(t, err)
+	var attrs map[string][]byte
+	require.NoError(t, json.Unmarshal(data, &attrs))
+	assert.Equal(t, []byte("0081;"), attrs["com.apple.quarantine"])
+
+	_, err = os.Stat(filepath.Join(dest, "notes", "todo.md"+XattrSidecarSuffix))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExtractXattrAppleDouble(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	readme := fsys.nodes["/docs/readme.txt"]
+	fsys.xattrs[readme.Inode][resourceForkXattr] = []byte("fork")
+	fsys.xattrs[readme.Inode][finderInfoXattr] = []byte("TEXTttxt")
+	packed := fsys.add("/docs/packed.txt", 0o100644, "data")
+	fsys.xattrs[packed.Inode] = map[string][]byte{
+		resourceForkXattr:         []byte("compressed"),
+		services.DecmpfsXattrName: []byte("fpmc"),
+	}
+	fsys.compressed[packed.Inode] = true
+	dest := t.TempDir()
+
+	_, err := vol.Extract("/docs", dest, &ExtractOptions{Xattrs: XattrAppleDouble})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dest, AppleDoublePrefix+"readme.txt"))
+	require.NoError(t, err)
+	finderInfo, fork, attrs := decodeAppleDouble(t, data)
+	assert.Equal(t, "TEXTttxt", string(finderInfo[:8]))
+	assert.Equal(t, "fork", string(fork))
+	assert.Equal(t, map[string][]byte{"com.apple.quarantine": []byte("0081;")}, attrs)
+
+	// Nothing is written for files without attributes, or whose only
+	// attributes hold compressed data
+	for _, name := range []string{"packed.txt", "notes/todo.md"} {
+		_, err = os.Stat(appleDoublePath(filepath.Join(dest, name)))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+}
+
+func TestHostName(t *testing.T) {
+	tests := []struct {
+		name, hostOS, want string
+	}{
+		{"report.txt", "linux", "report.txt"},
+		{"a:b", "linux", "a:b"},
+		{"a:b?", "windows", "a_b_"},
+		{"trailing. ", "windows", "trailing__"},
+		{"con.txt", "windows", "_con.txt"},
+		{"console", "windows", "console"},
+		{"..", "linux", "___"},
+		{"bad\xffutf8", "linux", "bad_utf8"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, hostName(tt.name, tt.hostOS), tt.name)
+	}
+}
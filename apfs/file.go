@@ -0,0 +1,182 @@
+package apfs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// File is an open file or directory on a volume. It implements io.Reader,
+// io.ReaderAt, io.Seeker and fs.ReadDirFile.
+type File struct {
+	vol  *Volume
+	info *FileInfo
+
+	mu      sync.Mutex
+	offset  int64
+	closed  bool
+	entries []fs.DirEntry
+	listed  bool
+}
+
+// Stat returns the file's metadata. The returned value is a *FileInfo.
+func (f *File) Stat() (fs.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, f.pathError("stat", ErrClosed)
+	}
+	return f.info, nil
+}
+
+// Info returns the file's metadata
+func (f *File) Info() *FileInfo {
+	return f.info
+}
+
+// Read reads up to len(p) bytes from the current offset
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.readAt(p, f.offset, "read")
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes starting at offset off
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if off < 0 {
+		return 0, f.pathError("readat", fmt.Errorf("negative offset"))
+	}
+
+	n, err := f.readAt(p, off, "readat")
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readAt reads file data at off; the caller holds f.mu
+func (f *File) readAt(p []byte, off int64, op string) (int, error) {
+	if f.closed {
+		return 0, f.pathError(op, ErrClosed)
+	}
+	if f.info.IsDir() {
+		return 0, f.pathError(op, ErrIsDir)
+	}
+
+	size := f.info.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	length := int64(len(p))
+	if off+length > size {
+		length = size - off
+	}
+
+	data, err := f.vol.fs.ReadFileRange(f.info.Inode(), uint64(off), uint64(length))
+	if err != nil {
+		return 0, f.pathError(op, err)
+	}
+
+	// Ranges past the last extent are holes, which read as zeros
+	n := copy(p[:length], data)
+	clear(p[n:length])
+	return int(length), nil
+}
+
+// Seek sets the offset for the next Read
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, f.pathError("seek", ErrClosed)
+	}
+
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.info.Size() + offset
+	default:
+		return 0, f.pathError("seek", fmt.Errorf("invalid whence %d", whence))
+	}
+	if abs < 0 {
+		return 0, f.pathError("seek", fmt.Errorf("negative position"))
+	}
+
+	f.offset = abs
+	return abs, nil
+}
+
+// ReadDir reads the contents of the directory. If n > 0 it returns at most n
+// entries and io.EOF once the directory is exhausted; if n <= 0 it returns
+// all remaining entries.
+func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, f.pathError("readdir", ErrClosed)
+	}
+	if !f.info.IsDir() {
+		return nil, f.pathError("readdir", ErrNotDir)
+	}
+
+	if !f.listed {
+		entries, err := f.vol.ReadDir(f.info.Path())
+		if err != nil {
+			return nil, err
+		}
+		f.entries = entries
+		f.listed = true
+	}
+
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.entries) {
+		n = len(f.entries)
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return f.pathError("close", ErrClosed)
+	}
+	f.closed = true
+	f.entries = nil
+	return nil
+}
+
+// pathError wraps err with the file's path
+func (f *File) pathError(op string, err error) error {
+	return pathError(op, f.info.Path(), err)
+}
@@ -0,0 +1,112 @@
+package apfs
+
+import (
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/internal/services"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// FileInfo describes a file system object on an APFS volume. It implements
+// fs.FileInfo and exposes the APFS specific inode metadata.
+type FileInfo struct {
+	path   string
+	inode  uint64
+	parent uint64
+	size   uint64
+	mode   uint16
+	uid    uint32
+	gid    uint32
+	nlink  uint32
+	flags  uint32
+	atime  time.Time
+	mtime  time.Time
+	ctime  time.Time
+	btime  time.Time
+	isDir  bool
+}
+
+// newFileInfo converts a service level file node to a FileInfo
+func newFileInfo(p string, node *services.FileNode) *FileInfo {
+	return &FileInfo{
+		path:   p,
+		inode:  node.Inode,
+		parent: node.ParentInode,
+		size:   node.Size,
+		mode:   node.Mode,
+		uid:    node.UID,
+		gid:    node.GID,
+		nlink:  node.HardLinkCount,
+		flags:  node.Flags,
+		atime:  node.AccessedTime,
+		mtime:  node.ModifiedTime,
+		ctime:  node.ChangedTime,
+		btime:  node.CreatedTime,
+		isDir:  node.IsDirectory,
+	}
+}
+
+// Name returns the base name of the file, or "/" for the volume root
+func (fi *FileInfo) Name() string { return path.Base(fi.path) }
+
+// Path returns the absolute path of the file on the volume
+func (fi *FileInfo) Path() string { return fi.path }
+
+// Size returns the logical size of the file in bytes
+func (fi *FileInfo) Size() int64 { return int64(fi.size) }
+
+// Mode returns the file mode bits, including the file type
+func (fi *FileInfo) Mode() fs.FileMode {
+	mode := fileMode(fi.mode)
+	if fi.isDir {
+		mode |= fs.ModeDir
+	}
+	return mode
+}
+
+// ModTime returns the last modification time
+func (fi *FileInfo) ModTime() time.Time { return fi.mtime }
+
+// IsDir reports whether the file is a directory
+func (fi *FileInfo) IsDir() bool { return fi.Mode().IsDir() }
+
+// Sys returns the FileInfo itself so that callers holding an fs.FileInfo can
+// recover the APFS metadata with a type assertion
+func (fi *FileInfo) Sys() any { return fi }
+
+// Inode returns the inode number
+func (fi *FileInfo) Inode() uint64 { return fi.inode }
+
+// ParentInode returns the inode number of the parent directory
+func (fi *FileInfo) ParentInode() uint64 { return fi.parent }
+
+// RawMode returns the unconverted APFS mode_t value
+func (fi *FileInfo) RawMode() uint16 { return fi.mode }
+
+// UID returns the owning user ID
+func (fi *FileInfo) UID() uint32 { return fi.uid }
+
+// GID returns the owning group ID
+func (fi *FileInfo) GID() uint32 { return fi.gid }
+
+// Nlink returns the number of hard links to the file
+func (fi *FileInfo) Nlink() uint32 { return fi.nlink }
+
+// Flags returns the inode's internal flags
+func (fi *FileInfo) Flags() uint32 { return fi.flags }
+
+// AccessTime returns the last access time
+func (fi *FileInfo) AccessTime() time.Time { return fi.atime }
+
+// ChangeTime returns the last inode change time
+func (fi *FileInfo) ChangeTime() time.Time { return fi.ctime }
+
+// BirthTime returns the creation time
+func (fi *FileInfo) BirthTime() time.Time { return fi.btime }
+
+// fileMode converts an APFS mode_t value to an fs.FileMode
+func fileMode(mode uint16) fs.FileMode {
+	return types.Mode(mode).FileMode()
+}
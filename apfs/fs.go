@@ -0,0 +1,137 @@
+package apfs
+
+import (
+	"io/fs"
+	"path"
+)
+
+// FS adapts a Volume to the io/fs interfaces. It implements fs.FS,
+// fs.ReadDirFS, fs.StatFS, fs.ReadFileFS and fs.SubFS, so a volume can be
+// used with fs.WalkDir, http.FS, template.ParseFS and other standard library
+// consumers. Names follow the io/fs conventions: they are unrooted, slash
+// separated and "." refers to the root. The fs.FileInfo values returned by
+// the adapter carry a *FileInfo in their Sys method.
+type FS struct {
+	vol  *Volume
+	root string
+}
+
+// FS returns an io/fs view of the volume
+func (v *Volume) FS() *FS {
+	return &FS{vol: v, root: "/"}
+}
+
+// Open opens the named file or directory
+func (f *FS) Open(name string) (fs.File, error) {
+	p, err := f.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.vol.Open(p)
+	if err != nil {
+		return nil, f.pathError("open", name, err)
+	}
+
+	return &fsFile{File: file, name: path.Base(name)}, nil
+}
+
+// Stat returns metadata for the named file
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.vol.Stat(p)
+	if err != nil {
+		return nil, f.pathError("stat", name, err)
+	}
+
+	return &fsFileInfo{FileInfo: info, name: path.Base(name)}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := f.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := f.vol.ReadDir(p)
+	if err != nil {
+		return nil, f.pathError("readdir", name, err)
+	}
+	return entries, nil
+}
+
+// ReadFile returns the contents of the named file
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	p, err := f.resolve("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := f.vol.ReadFile(p)
+	if err != nil {
+		return nil, f.pathError("readfile", name, err)
+	}
+	return data, nil
+}
+
+// Sub returns an FS rooted at the named directory
+func (f *FS) Sub(dir string) (fs.FS, error) {
+	p, err := f.resolve("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "." {
+		return f, nil
+	}
+
+	info, err := f.vol.Stat(p)
+	if err != nil {
+		return nil, f.pathError("sub", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: ErrNotDir}
+	}
+
+	return &FS{vol: f.vol, root: p}, nil
+}
+
+// resolve validates an io/fs name and converts it to a volume path
+func (f *FS) resolve(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(f.root, name), nil
+}
+
+// pathError rewrites an error from the volume so that it reports the io/fs
+// name and operation rather than the absolute volume path
+func (f *FS) pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: unwrapPathError(err)}
+}
+
+// fsFileInfo reports the io/fs base name, which differs from the volume
+// name for the root of an FS
+type fsFileInfo struct {
+	*FileInfo
+	name string
+}
+
+func (fi *fsFileInfo) Name() string { return fi.name }
+
+// fsFile is a File whose Stat reports the name it was opened under
+type fsFile struct {
+	*File
+	name string
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	if _, err := f.File.Stat(); err != nil {
+		return nil, err
+	}
+	return &fsFileInfo{FileInfo: f.File.Info(), name: f.name}, nil
+}
@@ -0,0 +1,88 @@
+package apfs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSConformance(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	err := fstest.TestFS(vol.FS(), "docs/readme.txt", "docs/notes/todo.md", "latest")
+	require.NoError(t, err)
+}
+
+func TestFSSubConformance(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	sub, err := fs.Sub(vol.FS(), "docs")
+	require.NoError(t, err)
+	require.NoError(t, fstest.TestFS(sub, "readme.txt", "notes/todo.md"))
+
+	data, err := fs.ReadFile(sub, "notes/todo.md")
+	require.NoError(t, err)
+	assert.Equal(t, "- write tests\n", string(data))
+
+	_, err = fs.Sub(vol.FS(), "docs/readme.txt")
+	assert.True(t, errors.Is(err, ErrNotDir))
+}
+
+func TestFSWalkDir(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	var visited []string
+	err := fs.WalkDir(vol.FS(), ".", func(p string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		visited = append(visited, p)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{".", "docs", "docs/notes", "docs/notes/todo.md", "docs/readme.txt", "latest"}, visited)
+}
+
+func TestFSStat(t *testing.T) {
+	vol, _ := newTestVolume(t)
+	fsys := vol.FS()
+
+	info, err := fs.Stat(fsys, ".")
+	require.NoError(t, err)
+	assert.Equal(t, ".", info.Name())
+	assert.True(t, info.IsDir())
+
+	info, err = fs.Stat(fsys, "docs/notes/todo.md")
+	require.NoError(t, err)
+	assert.Equal(t, "todo.md", info.Name())
+	assert.Equal(t, fs.FileMode(0o600), info.Mode())
+	assert.Equal(t, int64(14), info.Size())
+
+	apfsInfo, ok := info.Sys().(*FileInfo)
+	require.True(t, ok)
+	assert.Equal(t, "/docs/notes/todo.md", apfsInfo.Path())
+
+	info, err = fs.Stat(fsys, "latest")
+	require.NoError(t, err)
+	assert.Equal(t, fs.ModeSymlink, info.Mode().Type())
+}
+
+func TestFSInvalidAndMissingPaths(t *testing.T) {
+	vol, _ := newTestVolume(t)
+	fsys := vol.FS()
+
+	_, err := fsys.Open("/docs")
+	assert.True(t, errors.Is(err, fs.ErrInvalid))
+
+	_, err = fsys.Open("docs/../docs")
+	assert.True(t, errors.Is(err, fs.ErrInvalid))
+
+	_, err = fsys.Open("docs/missing.txt")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	var pe *fs.PathError
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, "docs/missing.txt", pe.Path)
+}
@@ -0,0 +1,393 @@
+package apfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tarBlockSize is the size of a tar header or data block
+const tarBlockSize = 512
+
+// maxUSTARID is the largest uid or gid a ustar header can store
+const maxUSTARID = 0o7777777
+
+// PAX record keys written in addition to those produced by archive/tar
+const (
+	paxSchilyXattr    = "SCHILY.xattr."
+	paxCreationTime   = "LIBARCHIVE.creationtime"
+	paxSparseMajor    = "GNU.sparse.major"
+	paxSparseMinor    = "GNU.sparse.minor"
+	paxSparseName     = "GNU.sparse.name"
+	paxSparseRealSize = "GNU.sparse.realsize"
+)
+
+// TarOptions controls ExportTar. The zero value exports everything.
+type TarOptions struct {
+	// Prefix is prepended to the name of every entry
+	Prefix string
+
+	// NoXattrs omits extended attributes
+	NoXattrs bool
+
+	// NoSparse stores sparse files with their holes filled with zeroes
+	NoSparse bool
+}
+
+// ExportTar streams the file or directory tree at root to w as a POSIX pax
+// archive. Entry names are relative to root. Mode, ownership, nanosecond
+// timestamps, symbolic links and extended attributes (as SCHILY.xattr
+// records) are preserved. Files with several links are stored once and
+// referenced by hard link entries afterwards, and sparse files use the
+// PAX 1.0 sparse format. The archive is terminated but w is not closed.
+// opts may be nil.
+func (v *Volume) ExportTar(w io.Writer, root string, opts *TarOptions) error {
+	if opts == nil {
+		opts = &TarOptions{}
+	}
+
+	e := &tarExporter{
+		vol:   v,
+		w:     w,
+		tw:    tar.NewWriter(w),
+		opts:  opts,
+		root:  cleanPath(root),
+		links: map[uint64]string{},
+	}
+
+	err := v.Walk(e.root, func(p string, info *FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return e.add(p, info)
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.tw.Close()
+}
+
+// tarExporter holds the state of a single ExportTar call
+type tarExporter struct {
+	vol   *Volume
+	w     io.Writer
+	tw    *tar.Writer
+	opts  *TarOptions
+	root  string
+	links map[uint64]string
+}
+
+// entryName returns the archive name of the file at p, or "" when the file
+// is the root directory and no prefix was requested
+func (e *tarExporter) entryName(p string, info *FileInfo) string {
+	var name string
+	switch {
+	case p == e.root && info.IsDir():
+		if e.opts.Prefix == "" {
+			return ""
+		}
+		name = path.Clean(e.opts.Prefix)
+	case p == e.root:
+		name = path.Join(e.opts.Prefix, path.Base(p))
+	default:
+		name = path.Join(e.opts.Prefix, strings.TrimPrefix(p[len(e.root):], "/"))
+	}
+
+	if info.IsDir() {
+		name += "/"
+	}
+	return name
+}
+
+// add writes the archive entry for a single file
+func (e *tarExporter) add(p string, info *FileInfo) error {
+	name := e.entryName(p, info)
+	if name == "" {
+		return nil
+	}
+
+	hdr, err := e.header(p, name, info)
+	if err != nil {
+		return err
+	}
+
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		hdr.Typeflag = tar.TypeDir
+	case mode&fs.ModeSymlink != 0:
+		hdr.Typeflag = tar.TypeSymlink
+		if hdr.Linkname, err = e.vol.Readlink(p); err != nil {
+			return err
+		}
+	case mode&fs.ModeNamedPipe != 0:
+		hdr.Typeflag = tar.TypeFifo
+	case mode&fs.ModeCharDevice != 0:
+		hdr.Typeflag = tar.TypeChar
+	case mode&fs.ModeDevice != 0:
+		hdr.Typeflag = tar.TypeBlock
+	case mode.IsRegular():
+		return e.addFile(p, hdr, info)
+	default:
+		// Sockets cannot be represented in a tar archive
+		return nil
+	}
+
+	if err := e.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
+
+// addFile writes a regular file, as a hard link when its inode was already archived
+func (e *tarExporter) addFile(p string, hdr *tar.Header, info *FileInfo) error {
+	if info.Nlink() > 1 {
+		if target, ok := e.links[info.Inode()]; ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = target
+			return e.tw.WriteHeader(hdr)
+		}
+		e.links[info.Inode()] = hdr.Name
+	}
+
+	f, err := e.vol.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !e.opts.NoSparse {
+		regions := e.vol.dataRegions(info)
+		var stored int64
+		for _, r := range regions {
+			stored += r.Length
+		}
+		if stored < info.Size() {
+			return e.writeSparse(f, hdr, info, regions)
+		}
+	}
+
+	hdr.Typeflag = tar.TypeReg
+	hdr.Size = info.Size()
+	if err := e.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	if _, err := io.Copy(e.tw, f); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return nil
+}
+
+// header builds the fields shared by every entry type
+func (e *tarExporter) header(p, name string, info *FileInfo) (*tar.Header, error) {
+	perm := int64(info.RawMode() & 0o7777)
+	if perm == 0 {
+		perm = int64(info.Mode().Perm())
+	}
+
+	hdr := &tar.Header{
+		Name:       name,
+		Mode:       perm,
+		Uid:        int(info.UID()),
+		Gid:        int(info.GID()),
+		ModTime:    info.ModTime(),
+		AccessTime: info.AccessTime(),
+		ChangeTime: info.ChangeTime(),
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{},
+	}
+
+	if btime := info.BirthTime(); !btime.IsZero() {
+		hdr.PAXRecords[paxCreationTime] = formatPAXTime(btime)
+	}
+
+	if !e.opts.NoXattrs {
+		attrs, err := e.vol.Xattrs(p)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range attrs {
+			if !internalXattr(k) {
+				hdr.PAXRecords[paxSchilyXattr+k] = string(v)
+			}
+		}
+	}
+
+	return hdr, nil
+}
+
+// internalXattr reports whether an extended attribute is file system
+// metadata that is represented in another way in the archive
+func internalXattr(name string) bool {
+	return strings.HasPrefix(name, "com.apple.fs.") || name == "com.apple.decmpfs"
+}
+
+// writeSparse writes a file with holes in the PAX 1.0 sparse format. The
+// archive/tar writer cannot produce sparse entries, so the extended header
+// and the ustar header are written directly to the underlying writer.
+func (e *tarExporter) writeSparse(f *File, hdr *tar.Header, info *FileInfo, regions []Region) error {
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(regions))
+	var stored int64
+	for _, r := range regions {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", r.Offset, r.Length)
+		stored += r.Length
+	}
+	sparseMap.Write(make([]byte, tarPadding(int64(sparseMap.Len()))))
+
+	records := map[string]string{
+		paxSparseMajor:    "1",
+		paxSparseMinor:    "0",
+		paxSparseName:     hdr.Name,
+		paxSparseRealSize: strconv.FormatInt(info.Size(), 10),
+		"mtime":           formatPAXTime(hdr.ModTime),
+		"uid":             strconv.Itoa(hdr.Uid),
+		"gid":             strconv.Itoa(hdr.Gid),
+	}
+	if !hdr.AccessTime.IsZero() {
+		records["atime"] = formatPAXTime(hdr.AccessTime)
+	}
+	if !hdr.ChangeTime.IsZero() {
+		records["ctime"] = formatPAXTime(hdr.ChangeTime)
+	}
+	for k, v := range hdr.PAXRecords {
+		records[k] = v
+	}
+	paxBody := formatPAXRecords(records)
+
+	sparseName := ustarName(path.Join(path.Dir(hdr.Name), "GNUSparseFile.0", path.Base(hdr.Name)))
+	paxHeader, err := rawTarHeader(&tar.Header{
+		Name:     ustarName(path.Join(path.Dir(hdr.Name), "PaxHeaders.0", path.Base(hdr.Name))),
+		Mode:     0o644,
+		Size:     int64(len(paxBody)),
+		ModTime:  hdr.ModTime.Truncate(time.Second),
+		Typeflag: tar.TypeReg,
+	}, tar.TypeXHeader)
+	if err != nil {
+		return err
+	}
+	fileHeader, err := rawTarHeader(&tar.Header{
+		Name:     sparseName,
+		Mode:     hdr.Mode,
+		Uid:      min(hdr.Uid, maxUSTARID),
+		Gid:      min(hdr.Gid, maxUSTARID),
+		Size:     int64(sparseMap.Len()) + stored,
+		ModTime:  hdr.ModTime.Truncate(time.Second),
+		Typeflag: tar.TypeReg,
+	}, tar.TypeReg)
+	if err != nil {
+		return err
+	}
+
+	// Pad the previous entry before writing raw blocks
+	if err := e.tw.Flush(); err != nil {
+		return err
+	}
+
+	blocks := [][]byte{paxHeader, paxBody, make([]byte, tarPadding(int64(len(paxBody)))), fileHeader, sparseMap.Bytes()}
+	for _, b := range blocks {
+		if _, err := e.w.Write(b); err != nil {
+			return err
+		}
+	}
+	for _, r := range regions {
+		if _, err := io.Copy(e.w, io.NewSectionReader(f, r.Offset, r.Length)); err != nil {
+			return fmt.Errorf("%s: %w", f.info.Path(), err)
+		}
+	}
+	_, err = e.w.Write(make([]byte, tarPadding(stored)))
+	return err
+}
+
+// rawTarHeader encodes hdr as a single ustar header block with the given
+// type flag. archive/tar refuses to encode extended headers itself, so the
+// block is produced for a regular file and the type flag patched afterwards.
+func rawTarHeader(hdr *tar.Header, typeflag byte) ([]byte, error) {
+	var buf bytes.Buffer
+	hdr.Format = tar.FormatUSTAR
+	if err := tar.NewWriter(&buf).WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if buf.Len() != tarBlockSize {
+		return nil, fmt.Errorf("unexpected tar header size %d", buf.Len())
+	}
+
+	blk := buf.Bytes()
+	blk[156] = typeflag
+
+	// The checksum is computed with the checksum field itself set to spaces
+	copy(blk[148:156], "        ")
+	var sum int64
+	for _, b := range blk {
+		sum += int64(b)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return blk, nil
+}
+
+// ustarName shortens a name to fit the 100 byte ustar name field
+func ustarName(name string) string {
+	if len(name) <= 100 {
+		return name
+	}
+	base := path.Base(name)
+	if len(base) > 84 {
+		base = base[:84]
+	}
+	return path.Join("GNUSparseFile.0", base)
+}
+
+// formatPAXRecords encodes records in key order
+func formatPAXRecords(records map[string]string) []byte {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString(formatPAXRecord(k, records[k]))
+	}
+	return buf.Bytes()
+}
+
+// formatPAXRecord encodes a single "<length> <key>=<value>\n" record, where
+// length counts the whole record including its own digits
+func formatPAXRecord(k, v string) string {
+	size := len(k) + len(v) + 3
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	if len(record) != size {
+		record = strconv.Itoa(len(record)) + " " + k + "=" + v + "\n"
+	}
+	return record
+}
+
+// formatPAXTime formats t as seconds with a fractional nanosecond part
+func formatPAXTime(t time.Time) string {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	if nsec == 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+	if sec < 0 {
+		// A negative time is written as -<sec>.<nsec> with the fraction
+		// counting towards zero
+		sec, nsec = sec+1, 1e9-nsec
+		return strings.TrimRight(fmt.Sprintf("-%d.%09d", -sec, nsec), "0")
+	}
+	return strings.TrimRight(fmt.Sprintf("%d.%09d", sec, nsec), "0")
+}
+
+// tarPadding returns the number of zero bytes needed after n bytes of data
+func tarPadding(n int64) int64 {
+	return -n & (tarBlockSize - 1)
+}
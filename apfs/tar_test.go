@@ -0,0 +1,188 @@
+package apfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tarEntry is a decoded archive member
+type tarEntry struct {
+	hdr  *tar.Header
+	data []byte
+}
+
+func readTar(t *testing.T, archive []byte) []tarEntry {
+	t.Helper()
+	var entries []tarEntry
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		entries = append(entries, tarEntry{hdr: hdr, data: data})
+	}
+}
+
+func TestExportTar(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	readme := fsys.nodes["/docs/readme.txt"]
+	readme.ModifiedTime = time.Unix(1700000000, 123456789)
+	readme.CreatedTime = time.Unix(1690000000, 5)
+	readme.UID, readme.GID = 501, 20
+
+	var buf bytes.Buffer
+	require.NoError(t, vol.ExportTar(&buf, "/", nil))
+	entries := readTar(t, buf.Bytes())
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.hdr.Name)
+	}
+	assert.Equal(t, []string{"docs/", "docs/notes/", "docs/notes/todo.md", "docs/readme.txt", "latest"}, names)
+
+	assert.Equal(t, byte(tar.TypeDir), entries[0].hdr.Typeflag)
+	assert.Equal(t, int64(0o700), entries[1].hdr.Mode)
+	assert.Equal(t, "- write tests\n", string(entries[2].data))
+
+	file := entries[3].hdr
+	assert.Equal(t, "hello, apfs", string(entries[3].data))
+	assert.Equal(t, int64(0o644), file.Mode)
+	assert.Equal(t, 501, file.Uid)
+	assert.Equal(t, 20, file.Gid)
+	assert.Equal(t, readme.ModifiedTime, file.ModTime)
+	assert.Equal(t, "1690000000.000000005", file.PAXRecords[paxCreationTime])
+	assert.Equal(t, "0081;", file.PAXRecords["SCHILY.xattr.com.apple.quarantine"])
+
+	link := entries[4].hdr
+	assert.Equal(t, byte(tar.TypeSymlink), link.Typeflag)
+	assert.Equal(t, "docs/readme.txt", link.Linkname)
+	assert.NotContains(t, link.PAXRecords, "SCHILY.xattr."+symlinkXattr)
+}
+
+func TestExportTarSubtreeWithPrefix(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, vol.ExportTar(&buf, "/docs", &TarOptions{Prefix: "layer", NoXattrs: true}))
+	entries := readTar(t, buf.Bytes())
+
+	require.Len(t, entries, 4)
+	assert.Equal(t, "layer/", entries[0].hdr.Name)
+	assert.Equal(t, "layer/notes/todo.md", entries[2].hdr.Name)
+	assert.Empty(t, entries[3].hdr.PAXRecords["SCHILY.xattr.com.apple.quarantine"])
+
+	buf.Reset()
+	require.NoError(t, vol.ExportTar(&buf, "/docs/readme.txt", nil))
+	entries = readTar(t, buf.Bytes())
+	require.Len(t, entries, 1)
+	assert.Equal(t, "readme.txt", entries[0].hdr.Name)
+}
+
+func TestExportTarHardLinks(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	readme := fsys.nodes["/docs/readme.txt"]
+	readme.HardLinkCount = 2
+	alias := *readme
+	alias.Path, alias.Name = "/docs/alias.txt", "alias.txt"
+	fsys.nodes[alias.Path] = &alias
+
+	var buf bytes.Buffer
+	require.NoError(t, vol.ExportTar(&buf, "/docs", nil))
+	entries := readTar(t, buf.Bytes())
+
+	require.Len(t, entries, 4)
+	assert.Equal(t, "alias.txt", entries[0].hdr.Name)
+	assert.Equal(t, byte(tar.TypeReg), entries[0].hdr.Typeflag)
+	assert.Equal(t, "hello, apfs", string(entries[0].data))
+
+	assert.Equal(t, "readme.txt", entries[3].hdr.Name)
+	assert.Equal(t, byte(tar.TypeLink), entries[3].hdr.Typeflag)
+	assert.Equal(t, "alias.txt", entries[3].hdr.Linkname)
+	assert.Empty(t, entries[3].data)
+}
+
+func TestExportTarSparse(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+
+	content := make([]byte, 3*4096+100)
+	copy(content, "head")
+	copy(content[2*4096:], "tail")
+	node := fsys.add("/docs/sparse.img", 0o100644, string(content))
+	node.ModifiedTime = time.Unix(1700000000, 42)
+	fsys.xattrs[node.Inode] = map[string][]byte{"user.kind": []byte("disk")}
+	fsys.extents[node.Inode] = []services.ExtentMapping{
+		{LogicalOffset: 0, LogicalSize: 4096, PhysicalBlock: 100},
+		{LogicalOffset: 4096, LogicalSize: 4096, PhysicalBlock: 0},
+		{LogicalOffset: 8192, LogicalSize: 8192, PhysicalBlock: 200},
+	}
+
+	assert.Equal(t, []Region{{0, 4096}, {8192, 4196}}, vol.dataRegions(mustStat(t, vol, "/docs/sparse.img")))
+
+	var sparse, dense bytes.Buffer
+	require.NoError(t, vol.ExportTar(&sparse, "/docs", nil))
+	require.NoError(t, vol.ExportTar(&dense, "/docs", &TarOptions{NoSparse: true}))
+	assert.Less(t, sparse.Len(), dense.Len())
+
+	entries := readTar(t, sparse.Bytes())
+	require.Len(t, entries, 4)
+
+	// Entries after the sparse file must still be readable
+	assert.Equal(t, "readme.txt", entries[2].hdr.Name)
+	assert.Equal(t, "hello, apfs", string(entries[2].data))
+
+	img := entries[3]
+	assert.Equal(t, "sparse.img", img.hdr.Name)
+	assert.Equal(t, int64(len(content)), img.hdr.Size)
+	assert.Equal(t, node.ModifiedTime, img.hdr.ModTime)
+	assert.Equal(t, "disk", img.hdr.PAXRecords["SCHILY.xattr.user.kind"])
+	assert.True(t, bytes.Equal(content, img.data))
+}
+
+func TestDataRegionsIncompleteExtents(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	node := fsys.nodes["/docs/readme.txt"]
+
+	// An extent list that does not start at zero is not trusted for holes
+	fsys.extents[node.Inode] = []services.ExtentMapping{
+		{LogicalOffset: 4096, LogicalSize: 4096, PhysicalBlock: 0},
+	}
+	regions, err := vol.DataRegions("/docs/readme.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []Region{{0, 11}}, regions)
+
+	_, err = vol.DataRegions("/docs")
+	assert.ErrorIs(t, err, ErrIsDir)
+}
+
+func TestFormatPAXRecord(t *testing.T) {
+	assert.Equal(t, "6 k=v\n", formatPAXRecord("k", "v"))
+	assert.Equal(t, "30 mtime=1700000000.123456789\n", formatPAXRecord("mtime", "1700000000.123456789"))
+
+	// The length prefix grows from one to two digits
+	assert.Equal(t, "9 k=abcd\n", formatPAXRecord("k", "abcd"))
+	assert.Equal(t, "11 k=abcde\n", formatPAXRecord("k", "abcde"))
+}
+
+func TestFormatPAXTime(t *testing.T) {
+	assert.Equal(t, "1700000000", formatPAXTime(time.Unix(1700000000, 0)))
+	assert.Equal(t, "1700000000.5", formatPAXTime(time.Unix(1700000000, 500000000)))
+	assert.Equal(t, "-1.5", formatPAXTime(time.Unix(-2, 500000000)))
+}
+
+func mustStat(t *testing.T, vol *Volume, name string) *FileInfo {
+	t.Helper()
+	info, err := vol.Stat(name)
+	require.NoError(t, err)
+	return info
+}
@@ -0,0 +1,412 @@
+package apfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/internal/services"
+)
+
+// symlinkXattr is the extended attribute holding a symbolic link's target
+const symlinkXattr = "com.apple.fs.symlink"
+
+// resourceForkXattr is the extended attribute holding a file's resource fork
+const resourceForkXattr = services.ResourceForkXattrName
+
+// fileSystem is the subset of services.FileSystemServiceImpl used by Volume
+type fileSystem interface {
+	GetInodeByPath(path string) (*services.FileNode, error)
+	ListDirectory(path string) ([]services.FileEntry, error)
+	ReadFileRange(inodeID uint64, offset, length uint64) ([]byte, error)
+	ListExtendedAttributes(inodeID uint64) ([]string, error)
+	ReadExtendedAttribute(inodeID uint64, name string) ([]byte, error)
+	GetFileExtents(inodeID uint64) ([]services.ExtentMapping, error)
+	GetResourceFork(inodeID uint64) ([]byte, error)
+	HasResourceFork(inodeID uint64) (bool, error)
+	FindHardLinks(inodeID uint64) ([]string, error)
+}
+
+// snapshotLister is the subset of services.SnapshotServiceImpl used by Volume
+type snapshotLister interface {
+	ListAllSnapshots() ([]*services.SnapshotInfo, error)
+}
+
+// VolumeInfo describes an APFS volume
+type VolumeInfo struct {
+	Index                    int
+	OID                      uint64
+	Name                     string
+	Role                     string
+	UUID                     UUID
+	Files                    uint64
+	Directories              uint64
+	Symlinks                 uint64
+	Snapshots                uint64
+	CaseInsensitive          bool
+	NormalizationInsensitive bool
+	Encrypted                bool
+
+	// Snapshot is the name of the snapshot this volume view was opened from,
+	// or empty for the live file system
+	Snapshot string
+}
+
+// Snapshot describes a point-in-time snapshot of a volume
+type Snapshot struct {
+	XID       uint64
+	Name      string
+	Created   time.Time
+	Changed   time.Time
+	Files     uint64
+	Size      uint64
+	Dataless  bool
+	ParentXID uint64
+}
+
+// Volume is a read-only view of an APFS volume or one of its snapshots
+type Volume struct {
+	info         VolumeInfo
+	fs           fileSystem
+	snapshots    snapshotLister
+	openSnapshot func(*services.SnapshotInfo) (fileSystem, error)
+	unlock       func(password string) error
+}
+
+// Info returns information about the volume
+func (v *Volume) Info() VolumeInfo {
+	return v.info
+}
+
+// Name returns the volume name
+func (v *Volume) Name() string {
+	return v.info.Name
+}
+
+// Stat returns metadata for the file at name
+func (v *Volume) Stat(name string) (*FileInfo, error) {
+	p := cleanPath(name)
+
+	node, err := v.fs.GetInodeByPath(p)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	return newFileInfo(p, node), nil
+}
+
+// Lstat returns metadata for the file at name. Symbolic links are never
+// followed by this package, so Lstat is equivalent to Stat.
+func (v *Volume) Lstat(name string) (*FileInfo, error) {
+	return v.Stat(name)
+}
+
+// ReadDir reads the directory at name and returns its entries sorted by name
+func (v *Volume) ReadDir(name string) ([]fs.DirEntry, error) {
+	p := cleanPath(name)
+
+	info, err := v.Stat(p)
+	if err != nil {
+		return nil, pathError("readdir", name, unwrapPathError(err))
+	}
+	if !info.IsDir() {
+		return nil, pathError("readdir", name, ErrNotDir)
+	}
+
+	entries, err := v.fs.ListDirectory(p)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+
+	dirEntries := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		dirEntries = append(dirEntries, &dirEntry{vol: v, dir: p, entry: entry})
+	}
+	sort.Slice(dirEntries, func(i, j int) bool {
+		return dirEntries[i].Name() < dirEntries[j].Name()
+	})
+
+	return dirEntries, nil
+}
+
+// Open opens the file or directory at name for reading
+func (v *Volume) Open(name string) (*File, error) {
+	info, err := v.Stat(name)
+	if err != nil {
+		return nil, pathError("open", name, unwrapPathError(err))
+	}
+
+	return &File{vol: v, info: info}, nil
+}
+
+// ReadFile returns the full contents of the file at name
+func (v *Volume) ReadFile(name string) ([]byte, error) {
+	f, err := v.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if f.info.IsDir() {
+		return nil, pathError("read", name, ErrIsDir)
+	}
+
+	data := make([]byte, f.info.Size())
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, pathError("read", name, err)
+	}
+	return data, nil
+}
+
+// Readlink returns the target of the symbolic link at name
+func (v *Volume) Readlink(name string) (string, error) {
+	info, err := v.Stat(name)
+	if err != nil {
+		return "", pathError("readlink", name, unwrapPathError(err))
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return "", pathError("readlink", name, ErrNotSymlink)
+	}
+
+	target, err := v.Xattr(name, symlinkXattr)
+	if err != nil {
+		return "", pathError("readlink", name, unwrapPathError(err))
+	}
+	return string(bytes.TrimRight(target, "\x00")), nil
+}
+
+// Xattrs returns all extended attributes of the file at name
+func (v *Volume) Xattrs(name string) (map[string][]byte, error) {
+	info, err := v.Stat(name)
+	if err != nil {
+		return nil, pathError("xattrs", name, unwrapPathError(err))
+	}
+
+	names, err := v.xattrNames(info)
+	if err != nil {
+		return nil, pathError("xattrs", name, err)
+	}
+
+	attrs := make(map[string][]byte, len(names))
+	for _, attr := range names {
+		value, err := v.fs.ReadExtendedAttribute(info.Inode(), attr)
+		if err != nil {
+			return nil, pathError("xattrs", name, err)
+		}
+		attrs[attr] = value
+	}
+	return attrs, nil
+}
+
+// Xattr returns the value of a single extended attribute of the file at name
+func (v *Volume) Xattr(name, attr string) ([]byte, error) {
+	info, err := v.Stat(name)
+	if err != nil {
+		return nil, pathError("xattr", name, unwrapPathError(err))
+	}
+
+	var value []byte
+	if attr == resourceForkXattr {
+		// GetResourceFork hides a fork holding the file's compressed data
+		value, err = v.fs.GetResourceFork(info.Inode())
+	} else {
+		value, err = v.fs.ReadExtendedAttribute(info.Inode(), attr)
+	}
+	if errors.Is(err, services.ErrNotFound) {
+		return nil, pathError("xattr", name, fmt.Errorf("%w: %s", ErrNoXattr, attr))
+	}
+	if err != nil {
+		return nil, pathError("xattr", name, err)
+	}
+	return value, nil
+}
+
+// xattrNames lists the extended attributes of a file without reading their
+// values. A compressed file may keep its data in the resource fork
+// attribute, which can be as large as the file, so it is left out then.
+func (v *Volume) xattrNames(info *FileInfo) ([]string, error) {
+	names, err := v.fs.ListExtendedAttributes(info.Inode())
+	if err != nil {
+		return nil, err
+	}
+
+	visible := names[:0]
+	for _, attr := range names {
+		if attr == resourceForkXattr {
+			has, err := v.fs.HasResourceFork(info.Inode())
+			if err != nil {
+				return nil, err
+			}
+			if !has {
+				continue
+			}
+		}
+		visible = append(visible, attr)
+	}
+	return visible, nil
+}
+
+// Links returns, in lexical order, the path of every hard link to the file
+// at name, including name itself
+func (v *Volume) Links(name string) ([]string, error) {
+	info, err := v.Stat(name)
+	if err != nil {
+		return nil, pathError("links", name, unwrapPathError(err))
+	}
+	if info.IsDir() || info.Nlink() <= 1 {
+		return []string{info.Path()}, nil
+	}
+
+	links, err := v.fs.FindHardLinks(info.Inode())
+	if err != nil {
+		return nil, pathError("links", name, err)
+	}
+	return links, nil
+}
+
+// ResourceFork returns the resource fork of the file at name
+func (v *Volume) ResourceFork(name string) ([]byte, error) {
+	info, err := v.Stat(name)
+	if err != nil {
+		return nil, pathError("resourcefork", name, unwrapPathError(err))
+	}
+
+	fork, err := v.fs.GetResourceFork(info.Inode())
+	if errors.Is(err, services.ErrNotFound) {
+		return nil, pathError("resourcefork", name, fmt.Errorf("%w: %s", ErrNoXattr, resourceForkXattr))
+	}
+	if err != nil {
+		return nil, pathError("resourcefork", name, err)
+	}
+	return fork, nil
+}
+
+// Unlock unlocks a software-encrypted volume, as FileVault creates on Macs
+// without a T2 chip or Apple silicon, with the password of one of its users
+// or its personal recovery key. It returns ErrWrongPassword when the password
+// unlocks no user. Snapshots opened after Unlock can be read too. Unlock does
+// nothing for volumes that are not encrypted.
+func (v *Volume) Unlock(password string) error {
+	if v.unlock == nil {
+		return nil
+	}
+	if err := v.unlock(password); err != nil {
+		return fmt.Errorf("apfs: unlock volume %q: %w", v.info.Name, err)
+	}
+	return nil
+}
+
+// Snapshots returns the snapshots of the volume ordered from oldest to newest
+func (v *Volume) Snapshots() ([]Snapshot, error) {
+	if v.snapshots == nil {
+		return nil, nil
+	}
+
+	infos, err := v.snapshots.ListAllSnapshots()
+	if err != nil {
+		return nil, fmt.Errorf("apfs: list snapshots: %w", err)
+	}
+
+	snapshots := make([]Snapshot, 0, len(infos))
+	for _, info := range infos {
+		snapshots = append(snapshots, newSnapshot(info))
+	}
+	return snapshots, nil
+}
+
+// Snapshot returns a read-only view of the volume as captured by the named snapshot
+func (v *Volume) Snapshot(name string) (*Volume, error) {
+	if v.snapshots == nil || v.openSnapshot == nil {
+		return nil, fmt.Errorf("%w: %q", ErrSnapshotNotFound, name)
+	}
+
+	infos, err := v.snapshots.ListAllSnapshots()
+	if err != nil {
+		return nil, fmt.Errorf("apfs: list snapshots: %w", err)
+	}
+
+	for _, info := range infos {
+		if info.Name != name {
+			continue
+		}
+
+		fsys, err := v.openSnapshot(info)
+		if err != nil {
+			return nil, fmt.Errorf("apfs: open snapshot %q: %w", name, err)
+		}
+
+		snapInfo := v.info
+		snapInfo.Snapshot = name
+		snapInfo.Files = info.FileCount
+		return &Volume{info: snapInfo, fs: fsys}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrSnapshotNotFound, name)
+}
+
+// newSnapshot converts service level snapshot information to a Snapshot
+func newSnapshot(info *services.SnapshotInfo) Snapshot {
+	return Snapshot{
+		XID:       info.XID,
+		Name:      info.Name,
+		Created:   info.CreatedTime,
+		Changed:   info.ChangedTime,
+		Files:     info.FileCount,
+		Size:      info.Size,
+		Dataless:  info.IsDataless,
+		ParentXID: info.ParentXID,
+	}
+}
+
+// dirEntry implements fs.DirEntry for a directory record
+type dirEntry struct {
+	vol   *Volume
+	dir   string
+	entry services.FileEntry
+}
+
+func (d *dirEntry) Name() string { return d.entry.Name }
+
+func (d *dirEntry) IsDir() bool { return d.entry.IsDir }
+
+func (d *dirEntry) Type() fs.FileMode {
+	if d.entry.IsDir {
+		return fs.ModeDir
+	}
+	return fileMode(d.entry.Mode).Type()
+}
+
+func (d *dirEntry) Info() (fs.FileInfo, error) {
+	return d.vol.Stat(path.Join(d.dir, d.entry.Name))
+}
+
+// cleanPath converts a user supplied path to the absolute form used by the services
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// pathError wraps err in an *fs.PathError, translating service errors to package errors
+func pathError(op, name string, err error) error {
+	if errors.Is(err, services.ErrNotFound) && !errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("%w: %w", ErrNotExist, err)
+	}
+	if errors.Is(err, services.ErrLocked) {
+		err = fmt.Errorf("%w: %w", ErrLocked, err)
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// unwrapPathError returns the error wrapped by an *fs.PathError, so that an
+// operation built on another one reports its own name
+func unwrapPathError(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
@@ -0,0 +1,477 @@
+package apfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFileSystem is an in-memory fileSystem used to exercise Volume without an image
+type fakeFileSystem struct {
+	nodes   map[string]*services.FileNode
+	data    map[uint64][]byte
+	xattrs  map[uint64]map[string][]byte
+	extents map[uint64][]services.ExtentMapping
+	nextID  uint64
+
+	// compressed holds inodes whose resource fork attribute holds their
+	// compressed data rather than a resource fork
+	compressed map[uint64]bool
+
+	// locked makes every lookup fail as on an encrypted volume
+	locked bool
+
+	// xattrReads records the extended attributes whose values were read
+	xattrReads []string
+}
+
+func newFakeFileSystem() *fakeFileSystem {
+	f := &fakeFileSystem{
+		nodes:   map[string]*services.FileNode{},
+		data:    map[uint64][]byte{},
+		xattrs:  map[uint64]map[string][]byte{},
+		extents: map[uint64][]services.ExtentMapping{},
+		nextID:  16,
+
+		compressed: map[uint64]bool{},
+	}
+	f.nodes["/"] = &services.FileNode{Inode: 2, ParentInode: 1, Path: "/", Name: "/", Mode: 0o040755, IsDirectory: true}
+	return f
+}
+
+func (f *fakeFileSystem) add(p string, mode uint16, content string) *services.FileNode {
+	f.nextID++
+	node := &services.FileNode{
+		Inode:         f.nextID,
+		ParentInode:   f.nodes[path.Dir(p)].Inode,
+		Path:          p,
+		Name:          path.Base(p),
+		Mode:          mode,
+		Size:          uint64(len(content)),
+		ModifiedTime:  time.Unix(1700000000, 0),
+		HardLinkCount: 1,
+		IsDirectory:   mode&0o170000 == 0o040000,
+	}
+	f.nodes[p] = node
+	if content != "" {
+		f.data[node.Inode] = []byte(content)
+	}
+	return node
+}
+
+func (f *fakeFileSystem) GetInodeByPath(p string) (*services.FileNode, error) {
+	if f.locked {
+		return nil, fmt.Errorf("file-system tree node 1028 is encrypted: %w", services.ErrLocked)
+	}
+	node, ok := f.nodes[p]
+	if !ok {
+		return nil, fmt.Errorf("path component %s %w", path.Base(p), services.ErrNotFound)
+	}
+	return node, nil
+}
+
+func (f *fakeFileSystem) ListDirectory(p string) ([]services.FileEntry, error) {
+	var entries []services.FileEntry
+	for childPath, node := range f.nodes {
+		if childPath == "/" || path.Dir(childPath) != p {
+			continue
+		}
+		entries = append(entries, services.FileEntry{
+			Inode: node.Inode, Name: node.Name, Path: childPath,
+			IsDir: node.IsDirectory, Size: node.Size, Mode: node.Mode,
+		})
+	}
+	return entries, nil
+}
+
+func (f *fakeFileSystem) ReadFileRange(inodeID uint64, offset, length uint64) ([]byte, error) {
+	data := f.data[inodeID]
+	if offset >= uint64(len(data)) {
+		return nil, nil
+	}
+	end := offset + length
+	if end > uint64(len(data)) {
+		end = uint64(len(data))
+	}
+	return data[offset:end], nil
+}
+
+func (f *fakeFileSystem) ListExtendedAttributes(inodeID uint64) ([]string, error) {
+	names := slices.Sorted(maps.Keys(f.xattrs[inodeID]))
+	return names, nil
+}
+
+func (f *fakeFileSystem) ReadExtendedAttribute(inodeID uint64, name string) ([]byte, error) {
+	f.xattrReads = append(f.xattrReads, name)
+	value, ok := f.xattrs[inodeID][name]
+	if !ok {
+		return nil, fmt.Errorf("extended attribute %s of inode %d %w", name, inodeID, services.ErrNotFound)
+	}
+	return value, nil
+}
+
+func (f *fakeFileSystem) GetFileExtents(inodeID uint64) ([]services.ExtentMapping, error) {
+	return f.extents[inodeID], nil
+}
+
+func (f *fakeFileSystem) GetResourceFork(inodeID uint64) ([]byte, error) {
+	fork, ok := f.xattrs[inodeID][resourceForkXattr]
+	if !ok || f.compressed[inodeID] {
+		return nil, fmt.Errorf("resource fork of inode %d %w", inodeID, services.ErrNotFound)
+	}
+	return fork, nil
+}
+
+func (f *fakeFileSystem) HasResourceFork(inodeID uint64) (bool, error) {
+	_, ok := f.xattrs[inodeID][resourceForkXattr]
+	return ok && !f.compressed[inodeID], nil
+}
+
+func (f *fakeFileSystem) FindHardLinks(inodeID uint64) ([]string, error) {
+	var links []string
+	for p, node := range f.nodes {
+		if node.Inode == inodeID {
+			links = append(links, p)
+		}
+	}
+	sort.Strings(links)
+	return links, nil
+}
+
+// fakeSnapshots returns a fixed snapshot list
+type fakeSnapshots []*services.SnapshotInfo
+
+func (s fakeSnapshots) ListAllSnapshots() ([]*services.SnapshotInfo, error) {
+	return s, nil
+}
+
+func newTestVolume(t *testing.T) (*Volume, *fakeFileSystem) {
+	t.Helper()
+	fsys := newFakeFileSystem()
+	fsys.add("/docs", 0o040755, "")
+	fsys.add("/docs/readme.txt", 0o100644, "hello, apfs")
+	fsys.add("/docs/notes", 0o040700, "")
+	fsys.add("/docs/notes/todo.md", 0o100600, "- write tests\n")
+	link := fsys.add("/latest", 0o120755, "")
+	fsys.xattrs[link.Inode] = map[string][]byte{symlinkXattr: []byte("docs/readme.txt\x00")}
+	readme := fsys.nodes["/docs/readme.txt"]
+	fsys.xattrs[readme.Inode] = map[string][]byte{"com.apple.quarantine": []byte("0081;")}
+
+	return &Volume{info: VolumeInfo{Name: "Data"}, fs: fsys}, fsys
+}
+
+func TestVolumeStat(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	info, err := vol.Stat("docs/readme.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "readme.txt", info.Name())
+	assert.Equal(t, "/docs/readme.txt", info.Path())
+	assert.Equal(t, int64(11), info.Size())
+	assert.Equal(t, fs.FileMode(0o644), info.Mode())
+	assert.False(t, info.IsDir())
+	assert.Equal(t, info, info.Sys())
+
+	root, err := vol.Stat("/")
+	require.NoError(t, err)
+	assert.True(t, root.IsDir())
+	assert.Equal(t, uint64(2), root.Inode())
+}
+
+func TestVolumeStatNotExist(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	_, err := vol.Stat("/missing")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrNotExist))
+
+	var pe *fs.PathError
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, "stat", pe.Op)
+	assert.Equal(t, "/missing", pe.Path)
+}
+
+func TestVolumeReadDir(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	entries, err := vol.ReadDir("/docs")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "notes", entries[0].Name())
+	assert.True(t, entries[0].IsDir())
+	assert.Equal(t, "readme.txt", entries[1].Name())
+	assert.Equal(t, fs.FileMode(0), entries[1].Type())
+
+	info, err := entries[1].Info()
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), info.Size())
+
+	_, err = vol.ReadDir("/docs/readme.txt")
+	assert.True(t, errors.Is(err, ErrNotDir))
+}
+
+func TestVolumeReadFile(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	data, err := vol.ReadFile("/docs/readme.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, apfs", string(data))
+
+	_, err = vol.ReadFile("/docs")
+	assert.True(t, errors.Is(err, ErrIsDir))
+}
+
+func TestFileReadSeek(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	f, err := vol.Open("/docs/readme.txt")
+	require.NoError(t, err)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	n, err := f.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+
+	pos, err := f.Seek(-4, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), pos)
+
+	rest, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "apfs", string(rest))
+
+	n, err = f.ReadAt(buf, 9)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "fs", string(buf[:n]))
+
+	require.NoError(t, f.Close())
+	_, err = f.Read(buf)
+	assert.True(t, errors.Is(err, ErrClosed))
+}
+
+func TestFileReadPastLastExtent(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+
+	// The inode is larger than its data, as when the tail is a hole or the
+	// last extent is missing
+	fsys.nodes["/docs/readme.txt"].Size = 20
+
+	data, err := io.ReadAll(mustOpen(t, vol, "/docs/readme.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello, apfs\x00\x00\x00\x00\x00\x00\x00\x00\x00", string(data))
+
+	buf := make([]byte, 8)
+	n, err := mustOpen(t, vol, "/docs/readme.txt").ReadAt(buf, 14)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, make([]byte, 6), buf[:n])
+}
+
+// mustOpen opens name on vol and closes it when the test ends
+func mustOpen(t *testing.T, vol *Volume, name string) *File {
+	t.Helper()
+	f, err := vol.Open(name)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestFileReadDir(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	dir, err := vol.Open("/docs")
+	require.NoError(t, err)
+	defer dir.Close()
+
+	first, err := dir.ReadDir(1)
+	require.NoError(t, err)
+	require.Len(t, first, 1)
+	assert.Equal(t, "notes", first[0].Name())
+
+	second, err := dir.ReadDir(5)
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+
+	_, err = dir.ReadDir(1)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestVolumeWalk(t *testing.T) {
+	vol, _ := newTestVolume(t)
+
+	var visited []string
+	err := vol.Walk("/", func(p string, info *FileInfo, err error) error {
+		require.NoError(t, err)
+		visited = append(visited, p)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/", "/docs", "/docs/notes", "/docs/notes/todo.md", "/docs/readme.txt", "/latest"}, visited)
+
+	visited = nil
+	err = vol.Walk("/", func(p string, info *FileInfo, err error) error {
+		visited = append(visited, p)
+		if p == "/docs/notes" {
+			return SkipDir
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, visited, "/docs/notes/todo.md")
+	assert.Contains(t, visited, "/docs/readme.txt")
+
+	visited = nil
+	err = vol.Walk("/", func(p string, info *FileInfo, err error) error {
+		visited = append(visited, p)
+		if strings.HasSuffix(p, ".md") {
+			return SkipAll
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/docs/notes/todo.md", visited[len(visited)-1])
+}
+
+func TestVolumeReadlinkAndXattrs(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	latest := fsys.nodes["/latest"].Inode
+	fsys.xattrs[latest]["com.apple.large"] = make([]byte, 1<<20)
+
+	target, err := vol.Readlink("/latest")
+	require.NoError(t, err)
+	assert.Equal(t, "docs/readme.txt", target)
+	assert.Equal(t, []string{symlinkXattr}, fsys.xattrReads)
+
+	_, err = vol.Readlink("/docs/readme.txt")
+	assert.True(t, errors.Is(err, ErrNotSymlink))
+
+	attrs, err := vol.Xattrs("/docs/readme.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0081;"), attrs["com.apple.quarantine"])
+
+	_, err = vol.Xattr("/docs/readme.txt", "user.missing")
+	assert.True(t, errors.Is(err, ErrNoXattr))
+
+	attrs, err = vol.Xattrs("/docs")
+	require.NoError(t, err)
+	assert.Empty(t, attrs)
+}
+
+func TestVolumeLinks(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	readme := fsys.nodes["/docs/readme.txt"]
+	readme.HardLinkCount = 2
+	fsys.nodes["/docs/notes/readme.txt"] = readme
+
+	links, err := vol.Links("/docs/notes/readme.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/docs/notes/readme.txt", "/docs/readme.txt"}, links)
+
+	links, err = vol.Links("docs/notes/todo.md")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/docs/notes/todo.md"}, links)
+
+	_, err = vol.Links("/missing")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestVolumeResourceFork(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	app := fsys.add("/docs/app", 0o100644, "data")
+	fsys.xattrs[app.Inode] = map[string][]byte{resourceForkXattr: []byte("fork")}
+	packed := fsys.add("/docs/packed", 0o100644, "data")
+	fsys.xattrs[packed.Inode] = map[string][]byte{resourceForkXattr: []byte("compressed")}
+	fsys.compressed[packed.Inode] = true
+
+	fork, err := vol.ResourceFork("/docs/app")
+	require.NoError(t, err)
+	assert.Equal(t, "fork", string(fork))
+	attrs, err := vol.Xattrs("/docs/app")
+	require.NoError(t, err)
+	assert.Contains(t, attrs, resourceForkXattr)
+
+	// The fork of a compressed file holds its data and is hidden
+	_, err = vol.ResourceFork("/docs/packed")
+	assert.True(t, errors.Is(err, ErrNoXattr))
+	fsys.xattrReads = nil
+	attrs, err = vol.Xattrs("/docs/packed")
+	require.NoError(t, err)
+	assert.Empty(t, attrs)
+	_, err = vol.Xattr("/docs/packed", resourceForkXattr)
+	assert.True(t, errors.Is(err, ErrNoXattr))
+	assert.Empty(t, fsys.xattrReads, "the compressed data must not be read")
+
+	_, err = vol.ResourceFork("/docs/readme.txt")
+	assert.True(t, errors.Is(err, ErrNoXattr))
+	_, err = vol.ResourceFork("/missing")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestVolumeUnlock(t *testing.T) {
+	vol, fsys := newTestVolume(t)
+	require.NoError(t, vol.Unlock("anything"))
+
+	fsys.locked = true
+	vol.unlock = func(password string) error {
+		if password != "hunter2" {
+			return ErrWrongPassword
+		}
+		fsys.locked = false
+		return nil
+	}
+
+	_, err := vol.Stat("/docs/readme.txt")
+	assert.True(t, errors.Is(err, ErrLocked))
+	var pe *fs.PathError
+	assert.True(t, errors.As(err, &pe))
+
+	assert.True(t, errors.Is(vol.Unlock("hunter3"), ErrWrongPassword))
+	require.NoError(t, vol.Unlock("hunter2"))
+
+	data, err := vol.ReadFile("/docs/readme.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, apfs", string(data))
+}
+
+func TestVolumeSnapshots(t *testing.T) {
+	vol, _ := newTestVolume(t)
+	snapFS := newFakeFileSystem()
+	snapFS.add("/old.txt", 0o100644, "old")
+
+	vol.snapshots = fakeSnapshots{
+		{XID: 40, Name: "nightly", FileCount: 1, CreatedTime: time.Unix(1690000000, 0)},
+	}
+	vol.openSnapshot = func(info *services.SnapshotInfo) (fileSystem, error) {
+		return snapFS, nil
+	}
+
+	snapshots, err := vol.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "nightly", snapshots[0].Name)
+	assert.Equal(t, uint64(40), snapshots[0].XID)
+
+	snap, err := vol.Snapshot("nightly")
+	require.NoError(t, err)
+	assert.Equal(t, "nightly", snap.Info().Snapshot)
+
+	data, err := snap.ReadFile("/old.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+
+	_, err = vol.Snapshot("weekly")
+	assert.True(t, errors.Is(err, ErrSnapshotNotFound))
+}
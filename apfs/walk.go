@@ -0,0 +1,70 @@
+package apfs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+)
+
+// SkipDir and SkipAll can be returned from a WalkFunc to skip the current
+// directory or the remainder of the walk respectively
+var (
+	SkipDir = fs.SkipDir
+	SkipAll = fs.SkipAll
+)
+
+// WalkFunc is called by Volume.Walk for every file visited. When reading a
+// directory fails, the function is called a second time for that directory
+// with the error; returning nil then continues the walk.
+type WalkFunc func(path string, info *FileInfo, err error) error
+
+// Walk walks the file tree rooted at root in lexical order, calling fn for
+// each file or directory including root. Symbolic links are not followed.
+func (v *Volume) Walk(root string, fn WalkFunc) error {
+	root = cleanPath(root)
+
+	info, err := v.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = v.walk(root, info, fn)
+	}
+
+	if errors.Is(err, SkipDir) || errors.Is(err, SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walk recursively descends into p. A SkipDir returned for a file is passed
+// up so that the caller skips the remaining entries of the parent directory.
+func (v *Volume) walk(p string, info *FileInfo, fn WalkFunc) error {
+	if err := fn(p, info, nil); err != nil || !info.IsDir() {
+		return err
+	}
+
+	entries, err := v.ReadDir(p)
+	if err != nil {
+		return fn(p, info, err)
+	}
+
+	for _, entry := range entries {
+		child := path.Join(p, entry.Name())
+
+		childInfo, err := v.Stat(child)
+		if err != nil {
+			if err := fn(child, nil, err); err != nil && !errors.Is(err, SkipDir) {
+				return err
+			}
+			continue
+		}
+
+		if err := v.walk(child, childInfo, fn); err != nil {
+			if !childInfo.IsDir() || !errors.Is(err, SkipDir) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// newCheckpointsCommand builds the command that lists the checkpoints a
+// container can be opened at
+func newCheckpointsCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "checkpoints",
+		Short: "Show the checkpoints the container can be opened at",
+		Long: `Show the checkpoints still present in the container's checkpoint area,
+newest first. Pass a checkpoint's XID to --checkpoint to open the container as
+it was at that checkpoint with the other commands. When no checkpoint can be
+mounted, the reason is printed and the superblock at block zero is used.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			c, device, err := openContainer(opts, path)
+			if err != nil {
+				return err
+			}
+			defer device.Close()
+			defer c.Close()
+
+			if err := c.MountError(); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: using the block zero superblock: %v\n", err)
+			}
+			checkpoints, err := c.Checkpoints()
+			if err != nil {
+				return err
+			}
+			return printCheckpoints(cmd.OutOrStdout(), checkpoints)
+		},
+	}
+}
+
+// printCheckpoints writes a table of checkpoints, marking the open one
+func printCheckpoints(w io.Writer, checkpoints []apfs.CheckpointInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "XID\tBLOCK\tMODIFIED\tVOLUMES\tOPEN")
+	for _, cp := range checkpoints {
+		modified := "-"
+		if !cp.Timestamp.IsZero() {
+			modified = cp.Timestamp.UTC().Format(time.RFC3339)
+		}
+		open := ""
+		if cp.Current {
+			open = "*"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%s\n", cp.XID, cp.Block, modified, cp.VolumeCount, open)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// newExtractCommand builds the command that copies files out of a volume
+func newExtractCommand(opts *globalOptions) *cobra.Command {
+	var src, out, snapshot, xattrs, conflict string
+	var recursive bool
+	var extractOpts apfs.ExtractOptions
+
+	cmd := &cobra.Command{
+		Use:   "extract --src <path> --out <dest>",
+		Short: "Copy files from the volume to the local file system",
+		Long: `Copy a file, or with --recursive a directory tree, from the volume to the
+local file system, restoring permissions, timestamps, symbolic and hard links
+and optionally ownership and extended attributes. When --out names an existing
+directory a single file is written inside it under its original name. Use
+--out - to write the contents of a single file to standard output.
+
+An interrupted extraction can be resumed by passing the same --manifest again;
+files recorded in the manifest are not extracted a second time.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if extractOpts.Xattrs, err = parseXattrMode(xattrs); err != nil {
+				return err
+			}
+			if extractOpts.Conflict, err = parseConflictPolicy(conflict); err != nil {
+				return err
+			}
+
+			image, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			c, device, vol, err := openVolume(opts, image)
+			if err != nil {
+				return err
+			}
+			defer device.Close()
+			defer c.Close()
+			if snapshot != "" {
+				if vol, err = vol.Snapshot(snapshot); err != nil {
+					return err
+				}
+			}
+
+			info, err := vol.Lstat(src)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && !recursive {
+				return fmt.Errorf("%s is a directory, use --recursive to extract it", src)
+			}
+
+			if out == "-" {
+				if !info.Mode().IsRegular() {
+					return fmt.Errorf("%s is not a regular file", src)
+				}
+				f, err := vol.Open(src)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				_, err = io.Copy(cmd.OutOrStdout(), f)
+				return err
+			}
+
+			dest := out
+			if !info.IsDir() {
+				dest = extractDestination(out, info.Name())
+			}
+
+			result, err := vol.Extract(src, dest, &extractOpts)
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %w", src, err)
+			}
+
+			stderr := cmd.ErrOrStderr()
+			for _, warning := range result.Warnings {
+				fmt.Fprintf(stderr, "warning: %v\n", warning)
+			}
+			for from, to := range result.Renamed {
+				fmt.Fprintf(stderr, "renamed %s -> %s\n", from, to)
+			}
+			fmt.Fprintf(stderr, "extracted %s -> %s: %d files, %d directories, %d symlinks, %d hard links (%d bytes)",
+				src, dest, result.Files, result.Directories, result.Symlinks, result.HardLinks, result.Bytes)
+			if result.Resumed > 0 || result.Skipped > 0 {
+				fmt.Fprintf(stderr, ", %d resumed, %d skipped", result.Resumed, result.Skipped)
+			}
+			fmt.Fprintln(stderr)
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&src, "src", "", "path of the file or directory inside the volume")
+	flags.StringVar(&out, "out", "", "destination file or directory, or - for standard output")
+	flags.StringVar(&snapshot, "snapshot", "", "extract from the named snapshot instead of the live file system")
+	flags.BoolVarP(&recursive, "recursive", "r", false, "extract directories recursively")
+	flags.BoolVar(&extractOpts.Owner, "owner", false, "restore file ownership (usually requires root)")
+	flags.StringVar(&xattrs, "xattrs", "none", "extended attributes: none, user (user.* namespace), sidecar (JSON files) or appledouble (._ files)")
+	flags.StringVar(&conflict, "on-conflict", "overwrite", "existing destination files: overwrite, skip or rename")
+	flags.StringVar(&extractOpts.Manifest, "manifest", "", "resume manifest recording completed files")
+	cmd.MarkFlagRequired("src")
+	cmd.MarkFlagRequired("out")
+
+	return cmd
+}
+
+// extractDestination resolves the local path a file named name is written to
+func extractDestination(out, name string) string {
+	if info, err := os.Stat(out); err == nil && info.IsDir() {
+		return filepath.Join(out, path.Base(name))
+	}
+	return out
+}
+
+// parseXattrMode parses the --xattrs flag
+func parseXattrMode(s string) (apfs.XattrMode, error) {
+	switch s {
+	case "none":
+		return apfs.XattrNone, nil
+	case "user":
+		return apfs.XattrUser, nil
+	case "sidecar":
+		return apfs.XattrSidecar, nil
+	case "appledouble":
+		return apfs.XattrAppleDouble, nil
+	}
+	return 0, fmt.Errorf("invalid --xattrs %q: must be none, user, sidecar or appledouble", s)
+}
+
+// parseConflictPolicy parses the --on-conflict flag
+func parseConflictPolicy(s string) (apfs.ConflictPolicy, error) {
+	switch s {
+	case "overwrite":
+		return apfs.ConflictOverwrite, nil
+	case "skip":
+		return apfs.ConflictSkip, nil
+	case "rename":
+		return apfs.ConflictRename, nil
+	}
+	return 0, fmt.Errorf("invalid --on-conflict %q: must be overwrite, skip or rename", s)
+}
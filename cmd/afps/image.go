@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+	"github.com/deploymenttheory/go-apfs/internal/disk"
+)
+
+// openDevice opens the image at path using the configuration file and the
+// --offset, --container and --password flags
+func openDevice(opts *globalOptions, path string) (*disk.DMGDevice, error) {
+	config, err := disk.LoadDMGConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if opts.offset >= 0 {
+		config.AutoDetectAPFS = false
+		config.DefaultOffset = opts.offset
+	}
+	if opts.container != 0 {
+		config.ContainerIndex = opts.container
+	}
+	config.Password = opts.imagePassword()
+
+	device, err := disk.OpenDMG(path, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image %s: %w", path, err)
+	}
+	return device, nil
+}
+
+// openContainer opens the image at path through the public apfs package, at
+// the checkpoint selected by --checkpoint. Its volumes stay locked. The device
+// must be closed after the container.
+func openContainer(opts *globalOptions, path string) (*apfs.Container, *disk.DMGDevice, error) {
+	device, err := openDevice(opts, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := apfs.NewContainer(device, device.Size())
+	if err != nil {
+		device.Close()
+		return nil, nil, fmt.Errorf("failed to open APFS container in %s: %w", path, err)
+	}
+	if opts.checkpoint != 0 {
+		pinned, err := c.AtCheckpoint(opts.checkpoint)
+		c.Close()
+		if err != nil {
+			device.Close()
+			return nil, nil, err
+		}
+		c = pinned
+	}
+	return c, device, nil
+}
+
+// openVolume opens the image at path and the volume selected by --volume,
+// unlocked with --volume-password. A password that does not unlock the
+// volume is an error. The device must be closed after the container.
+func openVolume(opts *globalOptions, path string) (*apfs.Container, *disk.DMGDevice, *apfs.Volume, error) {
+	c, device, err := openContainer(opts, path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	vol, err := selectContainerVolume(c, opts.volume)
+	if err == nil {
+		if password := opts.volumeUnlockPassword(); password != "" {
+			err = vol.Unlock(password)
+		}
+	}
+	if err != nil {
+		c.Close()
+		device.Close()
+		return nil, nil, nil, err
+	}
+	return c, device, vol, nil
+}
+
+// unlockVolumes unlocks the encrypted volumes of c that password unlocks.
+// The others stay locked, as each volume may have different users, and are
+// reported on w. It fails when password unlocks none of them.
+func unlockVolumes(c *apfs.Container, password string, w io.Writer) error {
+	vols, err := c.Volumes()
+	if err != nil {
+		return err
+	}
+
+	encrypted, unlocked := 0, 0
+	for _, vol := range vols {
+		if !vol.Info().Encrypted {
+			continue
+		}
+		encrypted++
+		err := vol.Unlock(password)
+		if errors.Is(err, apfs.ErrWrongPassword) {
+			fmt.Fprintf(w, "warning: the volume password does not unlock volume %q\n", vol.Name())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		unlocked++
+	}
+	if encrypted > 0 && unlocked == 0 {
+		return fmt.Errorf("the volume password unlocks no volume: %w", apfs.ErrWrongPassword)
+	}
+	return nil
+}
+
+// selectContainerVolume returns the volume of c matching selector, which is
+// either a zero-based index or a volume name. An empty selector picks the
+// first volume.
+func selectContainerVolume(c *apfs.Container, selector string) (*apfs.Volume, error) {
+	vols, err := c.Volumes()
+	if err != nil {
+		return nil, err
+	}
+	if len(vols) == 0 {
+		return nil, fmt.Errorf("container has no volumes")
+	}
+	if selector == "" {
+		return vols[0], nil
+	}
+
+	if index, err := strconv.Atoi(selector); err == nil {
+		if index < 0 || index >= len(vols) {
+			return nil, fmt.Errorf("volume index %d out of range (container has %d volumes)", index, len(vols))
+		}
+		return vols[index], nil
+	}
+
+	return c.Volume(selector)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// newListCommand builds the command that summarises a container and its volumes
+func newListCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"volumes"},
+		Short:   "Show the APFS container and the volumes it holds",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			c, device, err := openContainer(opts, path)
+			if err != nil {
+				return err
+			}
+			defer device.Close()
+			defer c.Close()
+
+			return printContainer(cmd.OutOrStdout(), path, c)
+		},
+	}
+}
+
+// printContainer writes the container summary followed by a table of volumes
+func printContainer(w io.Writer, path string, c *apfs.Container) error {
+	info := c.Info()
+	vols, err := c.Volumes()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Image:       %s\n", path)
+	fmt.Fprintf(w, "Block size:  %d\n", info.BlockSize)
+	fmt.Fprintf(w, "Block count: %d\n", info.BlockCount)
+	fmt.Fprintf(w, "Size:        %s\n", formatBytes(info.Size))
+	fmt.Fprintf(w, "Next XID:    %d\n", info.NextXID)
+	fmt.Fprintf(w, "Volumes:     %d\n\n", len(vols))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tOID\tNAME\tROLE\tUUID\tFILES\tDIRS\tENCRYPTED\tCASE")
+	for _, vol := range vols {
+		vi := vol.Info()
+
+		sensitivity := "sensitive"
+		if vi.CaseInsensitive {
+			sensitivity = "insensitive"
+		}
+
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%d\t%d\t%t\t%s\n",
+			vi.Index, vi.OID, vi.Name, vi.Role, vi.UUID,
+			vi.Files, vi.Directories, vi.Encrypted, sensitivity)
+	}
+
+	return tw.Flush()
+}
+
+// formatBytes renders a byte count using binary units
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// newLsCommand builds the command that lists a directory on a volume
+func newLsCommand(opts *globalOptions) *cobra.Command {
+	var long bool
+
+	cmd := &cobra.Command{
+		Use:   "ls [path]",
+		Short: "List the contents of a directory",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "/"
+			if len(args) == 1 {
+				dir = args[0]
+			}
+
+			path, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			c, device, vol, err := openVolume(opts, path)
+			if err != nil {
+				return err
+			}
+			defer device.Close()
+			defer c.Close()
+
+			entries, err := vol.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+
+			return printEntries(cmd.OutOrStdout(), entries, long)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&long, "long", "l", false, "show mode, size, modification time and inode")
+
+	return cmd
+}
+
+// printEntries writes directory entries, which are sorted by name
+func printEntries(w io.Writer, entries []fs.DirEntry, long bool) error {
+	if !long {
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() {
+				name += "/"
+			}
+			fmt.Fprintln(w, name)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		var inode uint64
+		if fi, ok := info.(*apfs.FileInfo); ok {
+			inode = fi.Inode()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n",
+			info.Mode(),
+			info.Size(),
+			formatTime(info.ModTime()),
+			inode,
+			entry.Name())
+	}
+	return tw.Flush()
+}
+
+// formatTime renders a timestamp for listings
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
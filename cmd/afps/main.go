@@ -0,0 +1,13 @@
+// Command afps is a read-only tool for exploring and extracting data from
+// APFS containers stored in raw images, block devices and DMG files.
+package main
+
+import (
+	"os"
+)
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
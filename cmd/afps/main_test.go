@@ -0,0 +1,317 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+	"github.com/deploymenttheory/go-apfs/internal/disk"
+)
+
+func TestImagePath(t *testing.T) {
+	_, err := (&globalOptions{}).imagePath()
+	assert.Error(t, err)
+
+	_, err = (&globalOptions{device: "a.img", dmg: "b.dmg"}).imagePath()
+	assert.Error(t, err)
+
+	path, err := (&globalOptions{dmg: "b.dmg"}).imagePath()
+	require.NoError(t, err)
+	assert.Equal(t, "b.dmg", path)
+}
+
+func TestPrintEntries(t *testing.T) {
+	path := fixtureImage(t, "volume")
+	c, device, vol, err := openVolume(&globalOptions{device: path, offset: -1}, path)
+	require.NoError(t, err)
+	defer device.Close()
+	defer c.Close()
+	entries, err := vol.ReadDir("/")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, printEntries(&buf, entries, false))
+	assert.Equal(t, "docs/\nhello.txt\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, printEntries(&buf, entries, true))
+	assert.Contains(t, buf.String(), "drwxr-xr-x")
+	assert.Contains(t, buf.String(), "-rw-r--r--")
+}
+
+func TestExtractDestination(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, filepath.Join(dir, "file.txt"), extractDestination(dir, "file.txt"))
+
+	target := filepath.Join(dir, "renamed.txt")
+	assert.Equal(t, target, extractDestination(target, "file.txt"))
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.0 KiB", formatBytes(1024))
+	assert.Equal(t, "1.5 MiB", formatBytes(3*512*1024))
+}
+
+func TestListCommandRequiresImage(t *testing.T) {
+	cmd := newRootCommand()
+	cmd.SetArgs([]string{"list"})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	assert.Error(t, cmd.Execute())
+}
+
+func TestListCommandWithDMG(t *testing.T) {
+	testDMG := filepath.Join("..", "..", "tests", "populated_apfs.dmg")
+	if _, err := os.Stat(testDMG); err != nil {
+		t.Skipf("Test DMG not found: %v", testDMG)
+	}
+
+	var out bytes.Buffer
+	cmd := newRootCommand()
+	cmd.SetArgs([]string{"list", "--from-dmg", testDMG})
+	cmd.SetOut(&out)
+	require.NoError(t, cmd.Execute())
+
+	assert.Contains(t, out.String(), "Block size:")
+	assert.Contains(t, out.String(), "INDEX")
+}
+
+func TestPrintPartitions(t *testing.T) {
+	table := &disk.PartitionTable{
+		Scheme:   disk.SchemeGPT,
+		DiskGUID: "00000000-0000-0000-0000-000000000001",
+		Partitions: []disk.Partition{
+			{Index: 1, Scheme: disk.SchemeGPT, Type: disk.GPTTypeEFISystem, TypeName: "EFI System", Name: "EFI", Offset: 20480, Size: 200 << 20},
+			{Index: 2, Scheme: disk.SchemeGPT, Type: disk.GPTTypeAPFS, TypeName: "Apple APFS", Offset: 209735680, Size: 1 << 30},
+		},
+	}
+	containers := []disk.ContainerLocation{
+		{Partition: &table.Partitions[1], Offset: 209735680, Size: 1 << 30, BlockSize: 4096},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, printPartitions(&buf, "disk.img", 2<<30, table, containers))
+	out := buf.String()
+	assert.Contains(t, out, "Scheme:      gpt\n")
+	assert.Contains(t, out, "EFI System")
+	assert.Contains(t, out, "Apple APFS")
+	assert.Contains(t, out, "APFS containers: 1")
+	assert.Contains(t, out, "209735680  1.0 GiB")
+}
+
+func TestPrintCheckpoints(t *testing.T) {
+	checkpoints := []apfs.CheckpointInfo{
+		{XID: 12, Block: 3, Timestamp: time.Unix(1700000000, 0), VolumeCount: 2, Current: true},
+		{XID: 11, Block: 9, VolumeCount: 1},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, printCheckpoints(&buf, checkpoints))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"12", "3", "2023-11-14T22:13:20Z", "2", "*"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"11", "9", "-", "1"}, strings.Fields(lines[2]))
+}
+
+func TestPrintSnapshots(t *testing.T) {
+	snapshots := []apfs.Snapshot{
+		{XID: 40, Name: "com.apple.TimeMachine.2023-11-14-221320.local", Created: time.Unix(1700000000, 0), Files: 12, Size: 2048},
+		{XID: 52, Name: "nightly"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, printSnapshots(&buf, snapshots))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"40", "com.apple.TimeMachine.2023-11-14-221320.local", "2023-11-14T22:13:20Z", "12", "2.0", "KiB"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"52", "nightly", "-", "0", "0", "B"}, strings.Fields(lines[2]))
+}
+
+// fixtureImage decompresses a test image written by the services package
+// tests and returns its path
+func fixtureImage(t *testing.T, name string) string {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name+".img.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), name+".img")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+func TestTarCommandToStdout(t *testing.T) {
+	path := fixtureImage(t, "volume")
+
+	// Capture the process's standard output, not only the command's, so that
+	// anything printed beside the archive is caught
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	require.NoError(t, err)
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	cmd := newRootCommand()
+	cmd.SetArgs([]string{"tar", "--device", path, "--volume", "Data", "--out", "-"})
+	err = cmd.Execute()
+	os.Stdout = stdout
+	require.NoError(t, err)
+
+	archive, err := os.ReadFile(out.Name())
+	require.NoError(t, err)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	contents := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		contents[hdr.Name] = string(data)
+	}
+	assert.Equal(t, "hello world", contents["hello.txt"])
+	assert.Contains(t, contents, "docs/")
+	assert.Len(t, contents["docs/a.txt"], 4100)
+}
+
+func TestVerifyCommand(t *testing.T) {
+	var out bytes.Buffer
+	cmd := newRootCommand()
+	cmd.SetArgs([]string{"verify", "--device", filepath.Join("testdata", "verified.E01")})
+	cmd.SetOut(&out)
+	require.NoError(t, cmd.Execute())
+	assert.Contains(t, out.String(), "ewf image matches its stored hash")
+
+	cmd = newRootCommand()
+	cmd.SetArgs([]string{"verify", "--device", filepath.Join("testdata", "tampered.E01")})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	assert.ErrorIs(t, cmd.Execute(), disk.ErrEWFHashMismatch)
+
+	cmd = newRootCommand()
+	cmd.SetArgs([]string{"verify", "--device", fixtureImage(t, "volume")})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	assert.ErrorIs(t, cmd.Execute(), disk.ErrNoStoredHash)
+}
+
+// tarNames runs the tar command with args and returns the archived names
+func tarNames(t *testing.T, args ...string) ([]string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := newRootCommand()
+	cmd.SetArgs(append([]string{"tar", "--out", "-"}, args...))
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	if err := cmd.Execute(); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestVolumePasswordCommands(t *testing.T) {
+	path := fixtureImage(t, "encrypted")
+
+	names, err := tarNames(t, "--device", path, "--volume-password", "hunter2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"secret.txt"}, names)
+
+	_, err = tarNames(t, "--device", path, "--volume-password", "hunter3")
+	assert.ErrorIs(t, err, apfs.ErrWrongPassword)
+	assert.ErrorContains(t, err, `"Macintosh HD"`)
+
+	cmd := newRootCommand()
+	cmd.SetArgs([]string{"ls", "/", "--device", path, "--volume-password", "hunter3"})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	assert.ErrorContains(t, cmd.Execute(), "wrong password")
+}
+
+// runCommand runs the root command with args and returns its output
+func runCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := newRootCommand()
+	cmd.SetArgs(args)
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestVolumeCommands(t *testing.T) {
+	path := fixtureImage(t, "volume")
+
+	out, err := runCommand(t, "list", "--device", path)
+	require.NoError(t, err)
+	assert.Contains(t, out, "Block size:  4096\n")
+	assert.Contains(t, out, "Volumes:     1\n")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Equal(t, []string{"0", "1026", "Data"}, strings.Fields(lines[len(lines)-1])[:3])
+
+	out, err = runCommand(t, "ls", "--device", path, "--volume", "Data", "/docs")
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt\n", out)
+
+	out, err = runCommand(t, "ls", "-l", "--device", path, "/")
+	require.NoError(t, err)
+	assert.Contains(t, out, "drwxr-xr-x")
+	assert.Regexp(t, `-rw-r--r--\s+11\s`, out)
+
+	out, err = runCommand(t, "stat", "--device", path, "/docs/a.txt")
+	require.NoError(t, err)
+	assert.Contains(t, out, "  Path: /docs/a.txt\n")
+	assert.Contains(t, out, "  Size: 4100\n")
+
+	_, err = runCommand(t, "stat", "--device", path, "/missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	out, err = runCommand(t, "checkpoints", "--device", path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "XID"))
+}
+
+func TestUnlockVolumes(t *testing.T) {
+	path := fixtureImage(t, "encrypted")
+	opts := &globalOptions{device: path, offset: -1}
+	c, device, err := openContainer(opts, path)
+	require.NoError(t, err)
+	defer device.Close()
+	defer c.Close()
+
+	var warnings bytes.Buffer
+	err = unlockVolumes(c, "hunter3", &warnings)
+	assert.ErrorIs(t, err, apfs.ErrWrongPassword)
+	assert.Contains(t, warnings.String(), `does not unlock volume "Macintosh HD"`)
+
+	warnings.Reset()
+	require.NoError(t, unlockVolumes(c, "hunter2", &warnings))
+	assert.Empty(t, warnings.String())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+	"github.com/deploymenttheory/go-apfs/internal/disk"
+)
+
+// newPartitionsCommand builds the command that lists the partitions and APFS
+// containers of a whole-disk image
+func newPartitionsCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "partitions",
+		Short: "Show the partition table and the APFS containers of a disk image",
+		Long: `Show every GPT and MBR partition of a whole-disk image or block device and
+the APFS containers found in them. Pass a container's index to --container to
+open it with the other commands.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			img, err := disk.OpenImageWithPassword(path, opts.imagePassword())
+			if err != nil {
+				return err
+			}
+			defer img.Close()
+
+			table, err := disk.ScanPartitions(img, img.Size())
+			if err != nil {
+				return err
+			}
+			containers, err := table.Containers(img)
+			if err != nil {
+				return err
+			}
+
+			return printPartitions(cmd.OutOrStdout(), path, img.Size(), table, containers)
+		},
+	}
+}
+
+// printPartitions writes the partition table followed by the containers
+func printPartitions(w io.Writer, path string, size int64, table *disk.PartitionTable, containers []disk.ContainerLocation) error {
+	fmt.Fprintf(w, "Image:       %s\n", path)
+	fmt.Fprintf(w, "Size:        %s\n", formatBytes(uint64(size)))
+	fmt.Fprintf(w, "Scheme:      %s", table.Scheme)
+	if table.HybridMBR {
+		fmt.Fprint(w, " (hybrid MBR)")
+	}
+	fmt.Fprintln(w)
+	if table.DiskGUID != "" {
+		fmt.Fprintf(w, "Disk GUID:   %s\n", table.DiskGUID)
+	}
+	for _, warning := range table.Warnings {
+		fmt.Fprintf(w, "Warning:     %v\n", warning)
+	}
+
+	if len(table.Partitions) > 0 {
+		fmt.Fprintln(w)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "#\tSCHEME\tOFFSET\tSIZE\tTYPE\tNAME")
+		for _, p := range table.Partitions {
+			typ := p.TypeName
+			if typ == "" {
+				typ = p.Type
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t%s\n", p.Index, p.Scheme, p.Offset, formatBytes(uint64(p.Size)), typ, p.Name)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "\nAPFS containers: %d\n", len(containers))
+	if len(containers) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER\tPARTITION\tOFFSET\tSIZE\tBLOCK SIZE\tUUID")
+	for i, c := range containers {
+		partition := "-"
+		if c.Partition != nil {
+			partition = fmt.Sprint(c.Partition.Index)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%d\t%s\n", i, partition, c.Offset, formatBytes(uint64(c.Size)), c.BlockSize, apfs.UUID(c.UUID))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// globalOptions holds the flags shared by every afps subcommand
+type globalOptions struct {
+	device     string
+	dmg        string
+	configFile string
+	offset     int64
+	container  int
+	password   string
+	checkpoint uint64
+	volume     string
+
+	volumePassword string
+}
+
+// imagePath returns the image selected on the command line
+func (o *globalOptions) imagePath() (string, error) {
+	switch {
+	case o.device != "" && o.dmg != "":
+		return "", fmt.Errorf("--device and --from-dmg are mutually exclusive")
+	case o.device != "":
+		return o.device, nil
+	case o.dmg != "":
+		return o.dmg, nil
+	default:
+		return "", fmt.Errorf("no image specified: use --device or --from-dmg")
+	}
+}
+
+// imagePassword returns the password for encrypted images, taken from
+// --password or the AFPS_PASSWORD environment variable
+func (o *globalOptions) imagePassword() string {
+	if o.password != "" {
+		return o.password
+	}
+	return os.Getenv("AFPS_PASSWORD")
+}
+
+// volumeUnlockPassword returns the password that unlocks encrypted volumes,
+// taken from --volume-password or the AFPS_VOLUME_PASSWORD environment variable
+func (o *globalOptions) volumeUnlockPassword() string {
+	if o.volumePassword != "" {
+		return o.volumePassword
+	}
+	return os.Getenv("AFPS_VOLUME_PASSWORD")
+}
+
+// newRootCommand builds the afps command tree
+func newRootCommand() *cobra.Command {
+	opts := &globalOptions{}
+
+	root := &cobra.Command{
+		Use:   "afps",
+		Short: "Read-only explorer for Apple File System containers",
+		Long: `afps reads APFS containers directly from raw disk images, block devices
+and .dmg files without mounting them. All operations are read-only.`,
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.configFile != "" {
+				viper.SetConfigFile(opts.configFile)
+			}
+			return nil
+		},
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&opts.device, "device", "", "path to a raw APFS image or block device")
+	flags.StringVar(&opts.dmg, "from-dmg", "", "path to a .dmg image containing an APFS container")
+	flags.StringVar(&opts.configFile, "config", "", "configuration file (default apfs-config.yaml in the usual search paths)")
+	flags.Int64Var(&opts.offset, "offset", -1, "byte offset of the APFS container, disables auto-detection")
+	flags.IntVar(&opts.container, "container", 0, "APFS container to open when a disk image holds several, by index")
+	flags.StringVar(&opts.password, "password", "", "password of an encrypted disk image (default $AFPS_PASSWORD)")
+	flags.Uint64Var(&opts.checkpoint, "checkpoint", 0, "open the container at an older checkpoint, by transaction ID (see the checkpoints command)")
+	flags.StringVar(&opts.volume, "volume", "", "volume to operate on, by index or name (default first volume)")
+	flags.StringVar(&opts.volumePassword, "volume-password", "", "user password or recovery key of FileVault encrypted volumes (default $AFPS_VOLUME_PASSWORD)")
+
+	root.AddCommand(
+		newPartitionsCommand(opts),
+		newVerifyCommand(opts),
+		newListCommand(opts),
+		newCheckpointsCommand(opts),
+		newListSnapshotsCommand(opts),
+		newLsCommand(opts),
+		newStatCommand(opts),
+		newExtractCommand(opts),
+		newTarCommand(opts),
+		newServeCommand(opts),
+		newServe9PCommand(opts),
+	)
+
+	return root
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/internal/server"
+)
+
+// newServeCommand builds the command that publishes images over HTTP
+func newServeCommand(opts *globalOptions) *cobra.Command {
+	var listen string
+
+	cmd := &cobra.Command{
+		Use:   "serve [image...]",
+		Short: "Serve containers over a read-only HTTP API",
+		Long: `Serve one or more APFS containers over HTTP. Images are taken from the
+arguments, or from --device/--from-dmg when no arguments are given.
+
+Endpoints (containers and volumes are addressed by name or index):
+  GET /api/containers
+  GET /api/containers/{container}
+  GET /api/containers/{container}/volumes
+  GET /api/containers/{container}/volumes/{volume}
+  GET /api/containers/{container}/volumes/{volume}/snapshots
+  GET /api/containers/{container}/volumes/{volume}/ls/{path}
+  GET /api/containers/{container}/volumes/{volume}/stat/{path}
+  GET /api/containers/{container}/volumes/{volume}/download/{path}
+
+Add ?snapshot=<name> to ls, stat and download to read from a snapshot.
+Downloads support HTTP range requests.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths := args
+			if len(paths) == 0 {
+				path, err := opts.imagePath()
+				if err != nil {
+					return err
+				}
+				paths = []string{path}
+			}
+
+			var containers []server.Container
+			for _, path := range paths {
+				c, device, err := openContainer(opts, path)
+				if err != nil {
+					return err
+				}
+				defer device.Close()
+				defer c.Close()
+				if password := opts.volumeUnlockPassword(); password != "" {
+					if err := unlockVolumes(c, password, cmd.ErrOrStderr()); err != nil {
+						return fmt.Errorf("failed to unlock volumes of %s: %w", path, err)
+					}
+				}
+
+				published, err := server.NewContainer(filepath.Base(path), c)
+				if err != nil {
+					return fmt.Errorf("failed to read volumes of %s: %w", path, err)
+				}
+				containers = append(containers, published)
+			}
+
+			ln, err := net.Listen("tcp", listen)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Serving %d container(s) on http://%s\n", len(containers), ln.Addr())
+
+			return serve(cmd.Context(), ln, server.New(containers))
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "127.0.0.1:8080", "address to listen on")
+
+	return cmd
+}
+
+// serve runs the HTTP server on ln until ctx is cancelled or an interrupt is received
+func serve(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
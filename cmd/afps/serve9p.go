@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/internal/ninep"
+)
+
+// newServe9PCommand builds the command that exports a volume over 9P
+func newServe9PCommand(opts *globalOptions) *cobra.Command {
+	var listen, snapshot string
+
+	cmd := &cobra.Command{
+		Use:   "serve-9p",
+		Short: "Export a volume read-only over 9P2000.L for mounting on Linux",
+		Long: `Export the selected volume, or one of its snapshots, read-only over the
+9P2000.L protocol. Linux can mount the export without FUSE or extra kernel
+modules:
+
+  mount -t 9p -o trans=tcp,port=5640,version=9p2000.L,ro 127.0.0.1 /mnt/apfs
+
+Inode numbers are used as file handles, and extended attributes are served
+through the usual xattr calls.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			c, device, vol, err := openVolume(opts, path)
+			if err != nil {
+				return err
+			}
+			defer device.Close()
+			defer c.Close()
+			if snapshot != "" {
+				if vol, err = vol.Snapshot(snapshot); err != nil {
+					return err
+				}
+			}
+
+			ln, err := net.Listen("tcp", listen)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Exporting volume %s on %s\n", vol.Name(), ln.Addr())
+
+			ctx := cmd.Context()
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				ln.Close()
+			}()
+
+			err = ninep.NewServer(ninep.NewVolumeFileSystem(vol)).Serve(ln)
+			if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "127.0.0.1:5640", "address to listen on")
+	cmd.Flags().StringVar(&snapshot, "snapshot", "", "export the named snapshot instead of the live file system")
+
+	return cmd
+}
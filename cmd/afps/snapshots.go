@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// newListSnapshotsCommand builds the command that lists the snapshots of a volume
+func newListSnapshotsCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:     "list-snapshots",
+		Aliases: []string{"snapshots"},
+		Short:   "Show the snapshots of the selected volume",
+		Long: `Show the snapshots of the volume selected by --volume, oldest first. Pass a
+snapshot's name to the --snapshot flag of extract, tar or serve9p to read the
+volume as it was when the snapshot was taken.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			c, device, vol, err := openVolume(opts, path)
+			if err != nil {
+				return err
+			}
+			defer device.Close()
+			defer c.Close()
+			snapshots, err := vol.Snapshots()
+			if err != nil {
+				return err
+			}
+			return printSnapshots(cmd.OutOrStdout(), snapshots)
+		},
+	}
+}
+
+// printSnapshots writes a table of snapshots
+func printSnapshots(w io.Writer, snapshots []apfs.Snapshot) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "XID\tNAME\tCREATED\tFILES\tSIZE")
+	for _, snap := range snapshots {
+		created := "-"
+		if !snap.Created.IsZero() {
+			created = snap.Created.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\n", snap.XID, snap.Name, created, snap.Files, formatBytes(snap.Size))
+	}
+	return tw.Flush()
+}
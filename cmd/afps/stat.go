@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// newStatCommand builds the command that prints inode metadata for a path
+func newStatCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stat <path>",
+		Short: "Show inode metadata for a file or directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			c, device, vol, err := openVolume(opts, path)
+			if err != nil {
+				return err
+			}
+			defer device.Close()
+			defer c.Close()
+
+			info, err := vol.Stat(args[0])
+			if err != nil {
+				return err
+			}
+
+			printNode(cmd.OutOrStdout(), info)
+			if info.Nlink() > 1 && !info.IsDir() {
+				links, err := vol.Links(args[0])
+				if err != nil {
+					return err
+				}
+				for _, link := range links {
+					fmt.Fprintf(cmd.OutOrStdout(), "  Link: %s\n", link)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// printNode writes the metadata of a single inode
+func printNode(w io.Writer, info *apfs.FileInfo) {
+	fmt.Fprintf(w, "  Path: %s\n", info.Path())
+	fmt.Fprintf(w, " Inode: %d\n", info.Inode())
+	fmt.Fprintf(w, "Parent: %d\n", info.ParentInode())
+	fmt.Fprintf(w, "  Mode: %s (%#o)\n", info.Mode(), info.RawMode())
+	fmt.Fprintf(w, "  Size: %d\n", info.Size())
+	fmt.Fprintf(w, " Links: %d\n", info.Nlink())
+	fmt.Fprintf(w, "   UID: %d\n", info.UID())
+	fmt.Fprintf(w, "   GID: %d\n", info.GID())
+	fmt.Fprintf(w, " Flags: %#x\n", info.Flags())
+	fmt.Fprintf(w, "Access: %s\n", formatTime(info.AccessTime()))
+	fmt.Fprintf(w, "Modify: %s\n", formatTime(info.ModTime()))
+	fmt.Fprintf(w, "Change: %s\n", formatTime(info.ChangeTime()))
+	fmt.Fprintf(w, " Birth: %s\n", formatTime(info.BirthTime()))
+}
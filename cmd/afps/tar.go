@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// newTarCommand builds the command that streams a tree as a tar archive
+func newTarCommand(opts *globalOptions) *cobra.Command {
+	var src, out, snapshot string
+	var tarOpts apfs.TarOptions
+
+	cmd := &cobra.Command{
+		Use:   "tar --out <archive>",
+		Short: "Write a file, directory or snapshot as a pax tar archive",
+		Long: `Write the tree at --src as a POSIX pax tar archive, preserving permissions,
+ownership, nanosecond timestamps, symbolic and hard links, sparse files and
+extended attributes. Use --out - to stream the archive to standard output.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			c, device, vol, err := openVolume(opts, path)
+			if err != nil {
+				return err
+			}
+			defer device.Close()
+			defer c.Close()
+			if snapshot != "" {
+				if vol, err = vol.Snapshot(snapshot); err != nil {
+					return err
+				}
+			}
+
+			var w io.Writer = cmd.OutOrStdout()
+			if out != "-" {
+				f, err := os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+
+			if err := vol.ExportTar(w, src, &tarOpts); err != nil {
+				return fmt.Errorf("failed to export %s: %w", src, err)
+			}
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&src, "src", "/", "path of the file or directory to archive")
+	flags.StringVar(&out, "out", "", "archive to write, or - for standard output")
+	flags.StringVar(&snapshot, "snapshot", "", "archive the named snapshot instead of the live file system")
+	flags.StringVar(&tarOpts.Prefix, "prefix", "", "directory prepended to every entry name")
+	flags.BoolVar(&tarOpts.NoXattrs, "no-xattrs", false, "omit extended attributes")
+	flags.BoolVar(&tarOpts.NoSparse, "no-sparse", false, "store sparse files densely")
+	cmd.MarkFlagRequired("out")
+
+	return cmd
+}
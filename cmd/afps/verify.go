@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deploymenttheory/go-apfs/internal/disk"
+)
+
+// newVerifyCommand builds the command that checks an image against the hash
+// recorded when it was acquired
+func newVerifyCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Check a forensic image against its stored hash",
+		Long: `Read the whole image and compare it with the hash recorded by the acquiring
+tool, such as the MD5 hash section of an EWF (.E01) image. Fails when the
+media does not match or the image format stores no hash.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := opts.imagePath()
+			if err != nil {
+				return err
+			}
+
+			img, err := disk.OpenImageWithPassword(path, opts.imagePassword())
+			if err != nil {
+				return err
+			}
+			defer img.Close()
+
+			if err := img.Verify(); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", path, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: %s image matches its stored hash\n", path, img.Format())
+			return nil
+		},
+	}
+}
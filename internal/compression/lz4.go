@@ -0,0 +1,127 @@
+package compression
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Apple frames LZ4 as a sequence of blocks, each starting with "bv4" and a
+// block type, ending with an end-of-stream block
+const (
+	lz4EndOfStreamMagic  uint32 = 0x24347662 // bv4$
+	lz4UncompressedMagic uint32 = 0x2d347662 // bv4-
+	lz4CompressedMagic   uint32 = 0x31347662 // bv41
+)
+
+// DecodeLZ4 decompresses an LZ4 stream in Apple's block framing, appending
+// the output to dst. Decoding stops at the end-of-stream block.
+func DecodeLZ4(dst, src []byte) ([]byte, error) {
+	le := binary.LittleEndian
+	for {
+		if len(src) < 4 {
+			return dst, fmt.Errorf("lz4: stream ends without an end-of-stream block: %w", ErrCorrupt)
+		}
+
+		switch magic := le.Uint32(src); magic {
+		case lz4EndOfStreamMagic:
+			return dst, nil
+
+		case lz4UncompressedMagic:
+			if len(src) < 8 {
+				return dst, fmt.Errorf("lz4: truncated block header: %w", ErrCorrupt)
+			}
+			rawBytes := uint64(le.Uint32(src[4:]))
+			if 8+rawBytes > uint64(len(src)) {
+				return dst, fmt.Errorf("lz4: block of %d bytes exceeds input of %d: %w", rawBytes, len(src)-8, ErrCorrupt)
+			}
+			dst = append(dst, src[8:8+rawBytes]...)
+			src = src[8+rawBytes:]
+
+		case lz4CompressedMagic:
+			if len(src) < 12 {
+				return dst, fmt.Errorf("lz4: truncated block header: %w", ErrCorrupt)
+			}
+			rawBytes := le.Uint32(src[4:])
+			payload := uint64(le.Uint32(src[8:]))
+			if 12+payload > uint64(len(src)) {
+				return dst, fmt.Errorf("lz4: block of %d bytes exceeds input of %d: %w", payload, len(src)-12, ErrCorrupt)
+			}
+			start := len(dst)
+			var err error
+			dst, err = decodeLZ4Block(dst, src[12:12+payload])
+			if err != nil {
+				return dst, err
+			}
+			if uint64(len(dst)-start) != uint64(rawBytes) {
+				return dst, fmt.Errorf("lz4: block decoded to %d bytes, expected %d: %w", len(dst)-start, rawBytes, ErrCorrupt)
+			}
+			src = src[12+payload:]
+
+		default:
+			return dst, fmt.Errorf("lz4: unknown block magic 0x%08x: %w", magic, ErrCorrupt)
+		}
+	}
+}
+
+// decodeLZ4Block decodes one LZ4 block, a run of sequences each holding
+// literals and then a match, except the last which holds only literals. A
+// zero match offset also ends the block. Matches may refer back into data
+// already in dst.
+func decodeLZ4Block(dst, src []byte) ([]byte, error) {
+	pos := 0
+	for pos < len(src) {
+		token := src[pos]
+		pos++
+
+		literals, n, err := lz4Length(src[pos:], int(token>>4))
+		if err != nil {
+			return dst, err
+		}
+		pos += n
+		if literals > len(src)-pos {
+			return dst, fmt.Errorf("lz4: %d literal bytes at offset %d exceed input: %w", literals, pos, ErrCorrupt)
+		}
+		dst = append(dst, src[pos:pos+literals]...)
+		pos += literals
+		if pos == len(src) {
+			break
+		}
+
+		if pos+2 > len(src) {
+			return dst, fmt.Errorf("lz4: truncated match offset at offset %d: %w", pos, ErrCorrupt)
+		}
+		distance := int(binary.LittleEndian.Uint16(src[pos:]))
+		pos += 2
+		if distance == 0 {
+			break
+		}
+
+		match, n, err := lz4Length(src[pos:], int(token&0x0f))
+		if err != nil {
+			return dst, err
+		}
+		pos += n
+		if distance > len(dst) {
+			return dst, fmt.Errorf("lz4: match distance %d at offset %d is out of range: %w", distance, pos, ErrCorrupt)
+		}
+		dst = appendMatch(dst, distance, match+4)
+	}
+	return dst, nil
+}
+
+// lz4Length returns a literal or match length that starts as a token nibble
+// and, when the nibble is 15, continues with bytes added to it up to and
+// including the first byte that is not 255, and the number of bytes read
+func lz4Length(src []byte, nibble int) (int, int, error) {
+	length := nibble
+	if nibble != 15 {
+		return length, 0, nil
+	}
+	for n, b := range src {
+		length += int(b)
+		if b != 255 {
+			return length, n + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("lz4: truncated length: %w", ErrCorrupt)
+}
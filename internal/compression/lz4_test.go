@@ -0,0 +1,92 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lz4Block returns a bv41 block holding an LZ4 payload that decodes to
+// rawBytes bytes
+func lz4Block(rawBytes int, payload string) []byte {
+	block := binary.LittleEndian.AppendUint32([]byte("bv41"), uint32(rawBytes))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(payload)))
+	return append(block, payload...)
+}
+
+// lz4Stream joins blocks and appends the end-of-stream block
+func lz4Stream(blocks ...[]byte) []byte {
+	return append(bytes.Join(blocks, nil), "bv4$"...)
+}
+
+func TestDecodeLZ4(t *testing.T) {
+	long := strings.Repeat("0123456789", 28)
+
+	tests := []struct {
+		name string
+		src  []byte
+		want string
+	}{
+		{
+			name: "empty stream",
+			src:  lz4Stream(),
+			want: "",
+		},
+		{
+			name: "uncompressed block",
+			src:  lz4Stream([]byte("bv4-\x03\x00\x00\x00raw")),
+			want: "raw",
+		},
+		{
+			name: "literals, match and final literals",
+			src:  lz4Stream(lz4Block(15, "\x44abcd\x04\x00"+"\x30xyz")),
+			want: "abcdabcdabcd" + "xyz",
+		},
+		{
+			name: "extended lengths",
+			src:  lz4Stream(lz4Block(280+274, "\xff\xff\x0a"+long+"\x01\x00\xff\x00")),
+			want: long + strings.Repeat("9", 274),
+		},
+		{
+			name: "zero offset ends the block",
+			src:  lz4Stream(lz4Block(2, "\x20ab\x00\x00")),
+			want: "ab",
+		},
+		{
+			name: "matches across blocks",
+			src:  lz4Stream([]byte("bv4-\x04\x00\x00\x00wxyz"), lz4Block(6, "\x02\x04\x00")),
+			want: "wxyz" + "wxyzwx",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeLZ4(nil, tt.src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestDecodeLZ4Corrupt(t *testing.T) {
+	for name, src := range map[string][]byte{
+		"no end of stream":  []byte("bv4-\x01\x00\x00\x00a"),
+		"unknown magic":     lz4Stream([]byte("bv4?")),
+		"truncated header":  []byte("bv41\x01\x00"),
+		"truncated block":   []byte("bv41\x03\x00\x00\x00\x10\x00\x00\x00\x30abc"),
+		"size mismatch":     lz4Stream(lz4Block(4, "\x30abc")),
+		"match distance":    lz4Stream(lz4Block(8, "\x40abcd\x05\x00")),
+		"truncated offset":  lz4Stream(lz4Block(4, "\x40abcd\x05")),
+		"truncated length":  lz4Stream(lz4Block(20, "\xf0\xff")),
+		"literals past end": lz4Stream(lz4Block(4, "\x40ab")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeLZ4(nil, src)
+			assert.ErrorIs(t, err, ErrCorrupt)
+		})
+	}
+}
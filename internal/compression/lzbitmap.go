@@ -0,0 +1,53 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// lzbitmapSignature starts every LZBITMAP stream
+const lzbitmapSignature = "ZBM\x09"
+
+// lzbitmapChunkHeader is the size of a chunk's compressed and uncompressed
+// sizes, each 24 bits
+const lzbitmapChunkHeader = 6
+
+// DecodeLZBitmap decompresses an LZBITMAP stream, appending the output to
+// dst. The stream is a signature followed by chunks of at most 64 KiB, each
+// either stored, when its compressed size is its uncompressed size plus the
+// header, or coded as literals, match distances, a bitmap and tokens. Only
+// stored chunks are decoded; a coded chunk returns ErrUnsupported. Decoding
+// stops at the empty chunk that ends the stream.
+func DecodeLZBitmap(dst, src []byte) ([]byte, error) {
+	if !bytes.HasPrefix(src, []byte(lzbitmapSignature)) {
+		return dst, fmt.Errorf("lzbitmap: missing stream signature: %w", ErrCorrupt)
+	}
+
+	pos := len(lzbitmapSignature)
+	for pos < len(src) {
+		if pos+lzbitmapChunkHeader > len(src) {
+			return dst, fmt.Errorf("lzbitmap: truncated chunk header at offset %d: %w", pos, ErrCorrupt)
+		}
+		compressedSize := uint24(src[pos:])
+		uncompressedSize := uint24(src[pos+3:])
+		if compressedSize < lzbitmapChunkHeader || compressedSize > len(src)-pos {
+			return dst, fmt.Errorf("lzbitmap: chunk at offset %d has invalid size %d: %w", pos, compressedSize, ErrCorrupt)
+		}
+
+		switch {
+		case compressedSize == lzbitmapChunkHeader && uncompressedSize == 0:
+			return dst, nil
+		case compressedSize == uncompressedSize+lzbitmapChunkHeader:
+			dst = append(dst, src[pos+lzbitmapChunkHeader:pos+compressedSize]...)
+		default:
+			return dst, fmt.Errorf("lzbitmap: chunk at offset %d is bitmap coded: %w", pos, ErrUnsupported)
+		}
+		pos += compressedSize
+	}
+	return dst, fmt.Errorf("lzbitmap: stream ends without an end-of-stream chunk: %w", ErrCorrupt)
+}
+
+// uint24 decodes a little-endian 24-bit integer
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
@@ -0,0 +1,49 @@
+package compression
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lzbitmapEOS is the empty chunk that ends a stream
+const lzbitmapEOS = "\x06\x00\x00\x00\x00\x00"
+
+func TestDecodeLZBitmap(t *testing.T) {
+	src := []byte(lzbitmapSignature +
+		"\x09\x00\x00\x03\x00\x00abc" +
+		"\x0a\x00\x00\x04\x00\x00defg" +
+		lzbitmapEOS + "trailing")
+
+	got, err := DecodeLZBitmap([]byte("prefix "), src)
+	require.NoError(t, err)
+	assert.Equal(t, "prefix abcdefg", string(got))
+
+	got, err = DecodeLZBitmap(nil, []byte(lzbitmapSignature+lzbitmapEOS))
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+// TestDecodeLZBitmapCodedChunk covers the known gap listed in the README:
+// coded chunks are refused rather than decoded without reference streams
+func TestDecodeLZBitmapCodedChunk(t *testing.T) {
+	src := []byte(lzbitmapSignature + "\x0a\x00\x00\x10\x00\x00abcd" + lzbitmapEOS)
+	_, err := DecodeLZBitmap(nil, src)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestDecodeLZBitmapCorrupt(t *testing.T) {
+	for name, src := range map[string]string{
+		"no signature":         "ZBM\x08" + lzbitmapEOS,
+		"no end of stream":     lzbitmapSignature + "\x09\x00\x00\x03\x00\x00abc",
+		"truncated header":     lzbitmapSignature + "\x09\x00",
+		"chunk past end":       lzbitmapSignature + "\x09\x00\x00\x03\x00\x00ab",
+		"chunk size too small": lzbitmapSignature + "\x02\x00\x00\x00\x00\x00",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeLZBitmap(nil, []byte(src))
+			assert.ErrorIs(t, err, ErrCorrupt)
+		})
+	}
+}
@@ -0,0 +1,678 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// LZFSE streams are a sequence of blocks, each starting with "bvx" and a
+// block type, ending with an end-of-stream block
+const (
+	lzfseEndOfStreamMagic  uint32 = 0x24787662 // bvx$
+	lzfseUncompressedMagic uint32 = 0x2d787662 // bvx-
+	lzfseCompressedV1Magic uint32 = 0x31787662 // bvx1
+	lzfseCompressedV2Magic uint32 = 0x32787662 // bvx2
+	lzfseLZVNMagic         uint32 = 0x6e787662 // bvxn
+)
+
+const (
+	lzfseLSymbols       = 20
+	lzfseMSymbols       = 20
+	lzfseDSymbols       = 64
+	lzfseLiteralSymbols = 256
+	lzfseFreqCount      = lzfseLSymbols + lzfseMSymbols + lzfseDSymbols + lzfseLiteralSymbols
+
+	lzfseLStates       = 64
+	lzfseMStates       = 64
+	lzfseDStates       = 256
+	lzfseLiteralStates = 1024
+
+	lzfseMatchesPerBlock  = 10000
+	lzfseLiteralsPerBlock = 4 * lzfseMatchesPerBlock
+
+	// lzfseV1HeaderSize is the size of lzfse_compressed_block_header_v1,
+	// whose 770 bytes of fields are padded to a multiple of four
+	lzfseV1HeaderSize = 772
+	// lzfseV2HeaderSize is the fixed part of a v2 header, before its packed
+	// frequency tables
+	lzfseV2HeaderSize = 32
+
+	// lzfseWindow covers the largest match distance either block encoding
+	// can express
+	lzfseWindow = 1 << 18
+)
+
+// Extra bits read after the symbol of a literal length (L), match length (M)
+// and match distance (D); each symbol's base value follows the range of the
+// one before it
+var (
+	lzfseLExtraBits = []uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 3, 5, 8,
+	}
+	lzfseMExtraBits = []uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 5, 8, 11,
+	}
+	lzfseDExtraBits = []uint8{
+		0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3,
+		4, 4, 4, 4, 5, 5, 5, 5, 6, 6, 6, 6, 7, 7, 7, 7,
+		8, 8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 11, 11, 11, 11,
+		12, 12, 12, 12, 13, 13, 13, 13, 14, 14, 14, 14, 15, 15, 15, 15,
+	}
+
+	lzfseLBase = valueBases(lzfseLExtraBits)
+	lzfseMBase = valueBases(lzfseMExtraBits)
+	lzfseDBase = valueBases(lzfseDExtraBits)
+)
+
+// valueBases returns the smallest value of each symbol
+func valueBases(extraBits []uint8) []int32 {
+	bases := make([]int32, len(extraBits))
+	for i := 1; i < len(bases); i++ {
+		bases[i] = bases[i-1] + 1<<extraBits[i-1]
+	}
+	return bases
+}
+
+// DecodeLZFSE decompresses an LZFSE stream, appending the output to dst.
+// Decoding stops at the end-of-stream block; any data after it is ignored.
+func DecodeLZFSE(dst, src []byte) ([]byte, error) {
+	for {
+		length, err := lzfseBlockLength(src)
+		if err != nil {
+			return dst, err
+		}
+		if length > uint64(len(src)) {
+			return dst, fmt.Errorf("lzfse: block of %d bytes exceeds input of %d: %w", length, len(src), ErrCorrupt)
+		}
+		if binary.LittleEndian.Uint32(src) == lzfseEndOfStreamMagic {
+			return dst, nil
+		}
+
+		dst, err = decodeLZFSEBlock(dst, src[:length])
+		if err != nil {
+			return dst, err
+		}
+		src = src[length:]
+	}
+}
+
+// NewLZFSEReader returns a reader that decompresses the LZFSE stream read
+// from r, one block at a time
+func NewLZFSEReader(r io.Reader) io.Reader {
+	return &lzfseReader{r: r}
+}
+
+// lzfseReader decodes an LZFSE stream incrementally. Matches may reach back
+// into earlier blocks, so the last lzfseWindow bytes of output are kept.
+type lzfseReader struct {
+	r     io.Reader
+	out   []byte // decoded output, including the history kept for matches
+	off   int    // start of the output not yet returned
+	block []byte
+	err   error
+}
+
+// Read implements io.Reader
+func (z *lzfseReader) Read(p []byte) (int, error) {
+	for z.off == len(z.out) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.next()
+	}
+
+	n := copy(p, z.out[z.off:])
+	z.off += n
+	return n, nil
+}
+
+// next reads and decodes the next block, returning io.EOF after the
+// end-of-stream block
+func (z *lzfseReader) next() error {
+	if len(z.out) > 2*lzfseWindow {
+		z.out = append(z.out[:0], z.out[len(z.out)-lzfseWindow:]...)
+		z.off = len(z.out)
+	}
+
+	z.block = resize(z.block, 4)
+	if err := z.read(z.block); err != nil {
+		return err
+	}
+	prefix, err := lzfseBlockPrefix(binary.LittleEndian.Uint32(z.block))
+	if err != nil {
+		return err
+	}
+	z.block = resize(z.block, prefix)
+	if err := z.read(z.block[4:]); err != nil {
+		return err
+	}
+
+	length, err := lzfseBlockLength(z.block)
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(z.block) == lzfseEndOfStreamMagic {
+		return io.EOF
+	}
+	if length > math.MaxInt32 {
+		return fmt.Errorf("lzfse: block of %d bytes is too large: %w", length, ErrCorrupt)
+	}
+	z.block = resize(z.block, int(length))
+	if err := z.read(z.block[prefix:]); err != nil {
+		return err
+	}
+
+	z.out, err = decodeLZFSEBlock(z.out, z.block)
+	return err
+}
+
+// read fills p from the underlying reader, treating the end of input as
+// truncation since a stream ends with its end-of-stream block
+func (z *lzfseReader) read(p []byte) error {
+	_, err := io.ReadFull(z.r, p)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("lzfse: stream ends without an end-of-stream block: %w", io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// resize returns b with length n, keeping its contents
+func resize(b []byte, n int) []byte {
+	if cap(b) >= n {
+		return b[:n]
+	}
+	grown := make([]byte, n)
+	copy(grown, b)
+	return grown
+}
+
+// lzfseBlockPrefix returns how many leading bytes of a block, including its
+// magic, are needed to determine the block's length
+func lzfseBlockPrefix(magic uint32) (int, error) {
+	switch magic {
+	case lzfseEndOfStreamMagic:
+		return 4, nil
+	case lzfseUncompressedMagic:
+		return 8, nil
+	case lzfseLZVNMagic:
+		return 12, nil
+	case lzfseCompressedV1Magic:
+		return 28, nil
+	case lzfseCompressedV2Magic:
+		return lzfseV2HeaderSize, nil
+	}
+	return 0, fmt.Errorf("lzfse: unknown block magic 0x%08x: %w", magic, ErrCorrupt)
+}
+
+// lzfseBlockLength returns the length of the block at the start of src,
+// which must hold at least the block's prefix
+func lzfseBlockLength(src []byte) (uint64, error) {
+	if len(src) < 4 {
+		return 0, fmt.Errorf("lzfse: stream ends without an end-of-stream block: %w", ErrCorrupt)
+	}
+	magic := binary.LittleEndian.Uint32(src)
+	prefix, err := lzfseBlockPrefix(magic)
+	if err != nil {
+		return 0, err
+	}
+	if len(src) < prefix {
+		return 0, fmt.Errorf("lzfse: truncated block header: %w", ErrCorrupt)
+	}
+
+	le := binary.LittleEndian
+	switch magic {
+	case lzfseUncompressedMagic:
+		return 8 + uint64(le.Uint32(src[4:])), nil
+	case lzfseLZVNMagic:
+		return 12 + uint64(le.Uint32(src[8:])), nil
+	case lzfseCompressedV1Magic:
+		return lzfseV1HeaderSize + uint64(le.Uint32(src[20:])) + uint64(le.Uint32(src[24:])), nil
+	case lzfseCompressedV2Magic:
+		headerSize := le.Uint64(src[24:]) & 0xffffffff
+		if headerSize < lzfseV2HeaderSize {
+			return 0, fmt.Errorf("lzfse: header size %d is too small: %w", headerSize, ErrCorrupt)
+		}
+		literalPayload := le.Uint64(src[8:]) >> 20 & 0xfffff
+		lmdPayload := le.Uint64(src[16:]) >> 40 & 0xfffff
+		return headerSize + literalPayload + lmdPayload, nil
+	}
+	return 4, nil
+}
+
+// decodeLZFSEBlock appends the output of the block that is exactly src to dst
+func decodeLZFSEBlock(dst, src []byte) ([]byte, error) {
+	le := binary.LittleEndian
+	switch le.Uint32(src) {
+	case lzfseUncompressedMagic:
+		return append(dst, src[8:]...), nil
+
+	case lzfseLZVNMagic:
+		rawBytes := le.Uint32(src[4:])
+		start := len(dst)
+		dst, _, err := decodeLZVN(dst, src[12:])
+		if err != nil {
+			return dst, fmt.Errorf("lzfse: lzvn block: %w", err)
+		}
+		if uint64(len(dst)-start) != uint64(rawBytes) {
+			return dst, fmt.Errorf("lzfse: lzvn block decoded to %d bytes, expected %d: %w", len(dst)-start, rawBytes, ErrCorrupt)
+		}
+		return dst, nil
+
+	case lzfseCompressedV1Magic:
+		h, err := parseLZFSEHeaderV1(src)
+		if err != nil {
+			return dst, err
+		}
+		return h.decode(dst, src[h.size:])
+
+	case lzfseCompressedV2Magic:
+		h, err := parseLZFSEHeaderV2(src)
+		if err != nil {
+			return dst, err
+		}
+		return h.decode(dst, src[h.size:])
+	}
+	return dst, fmt.Errorf("lzfse: unexpected block magic 0x%08x: %w", le.Uint32(src), ErrCorrupt)
+}
+
+// lzfseBlockHeader describes a compressed block: its literals, entropy coded
+// in four interleaved FSE streams, and its matches, each an L, M, D triple
+// coded in three more. The states are the decoders' initial states and the
+// bit counts the (non-positive) number of bits to drop from the end of each
+// payload.
+type lzfseBlockHeader struct {
+	rawBytes       uint32
+	literals       uint32
+	matches        uint32
+	literalPayload uint32
+	lmdPayload     uint32
+	literalBits    int
+	literalState   [4]uint16
+	lmdBits        int
+	lState         uint16
+	mState         uint16
+	dState         uint16
+	freq           [lzfseFreqCount]uint16
+	size           int // length of the header in bytes
+}
+
+// parseLZFSEHeaderV1 parses the uncompressed header of a bvx1 block
+func parseLZFSEHeaderV1(src []byte) (*lzfseBlockHeader, error) {
+	if len(src) < lzfseV1HeaderSize {
+		return nil, fmt.Errorf("lzfse: truncated v1 block header: %w", ErrCorrupt)
+	}
+
+	le := binary.LittleEndian
+	h := &lzfseBlockHeader{
+		rawBytes:       le.Uint32(src[4:]),
+		literals:       le.Uint32(src[12:]),
+		matches:        le.Uint32(src[16:]),
+		literalPayload: le.Uint32(src[20:]),
+		lmdPayload:     le.Uint32(src[24:]),
+		literalBits:    int(int32(le.Uint32(src[28:]))),
+		lmdBits:        int(int32(le.Uint32(src[40:]))),
+		lState:         le.Uint16(src[44:]),
+		mState:         le.Uint16(src[46:]),
+		dState:         le.Uint16(src[48:]),
+		size:           lzfseV1HeaderSize,
+	}
+	for i := range h.literalState {
+		h.literalState[i] = le.Uint16(src[32+2*i:])
+	}
+	for i := range h.freq {
+		h.freq[i] = le.Uint16(src[50+2*i:])
+	}
+	return h, nil
+}
+
+// parseLZFSEHeaderV2 parses the header of a bvx2 block, whose fields are
+// packed into three 64-bit words and whose frequency tables are stored with
+// a variable-length code, or omitted when every frequency is zero
+func parseLZFSEHeaderV2(src []byte) (*lzfseBlockHeader, error) {
+	le := binary.LittleEndian
+	v0 := le.Uint64(src[8:])
+	v1 := le.Uint64(src[16:])
+	v2 := le.Uint64(src[24:])
+	field := func(v uint64, offset, n int) uint32 {
+		return uint32(v >> offset & (1<<n - 1))
+	}
+
+	h := &lzfseBlockHeader{
+		rawBytes:       le.Uint32(src[4:]),
+		literals:       field(v0, 0, 20),
+		literalPayload: field(v0, 20, 20),
+		matches:        field(v0, 40, 20),
+		literalBits:    int(field(v0, 60, 3)) - 7,
+		literalState: [4]uint16{
+			uint16(field(v1, 0, 10)),
+			uint16(field(v1, 10, 10)),
+			uint16(field(v1, 20, 10)),
+			uint16(field(v1, 30, 10)),
+		},
+		lmdPayload: field(v1, 40, 20),
+		lmdBits:    int(field(v1, 60, 3)) - 7,
+		lState:     uint16(field(v2, 32, 10)),
+		mState:     uint16(field(v2, 42, 10)),
+		dState:     uint16(field(v2, 52, 10)),
+		size:       int(field(v2, 0, 32)),
+	}
+	if h.size > len(src) {
+		return nil, fmt.Errorf("lzfse: truncated v2 block header: %w", ErrCorrupt)
+	}
+
+	tables := src[lzfseV2HeaderSize:h.size]
+	if len(tables) == 0 {
+		return h, nil
+	}
+	var accum uint32
+	var accumBits, pos int
+	for i := range h.freq {
+		for pos < len(tables) && accumBits+8 <= 32 {
+			accum |= uint32(tables[pos]) << accumBits
+			accumBits += 8
+			pos++
+		}
+		value, n := decodeLZFSEFreq(accum)
+		if n > accumBits {
+			return nil, fmt.Errorf("lzfse: truncated frequency tables: %w", ErrCorrupt)
+		}
+		h.freq[i] = value
+		accum >>= n
+		accumBits -= n
+	}
+	if accumBits >= 8 || pos != len(tables) {
+		return nil, fmt.Errorf("lzfse: frequency tables do not fill the header: %w", ErrCorrupt)
+	}
+	return h, nil
+}
+
+// decodeLZFSEFreq decodes one frequency from the low bits of bits, returning
+// it and the length of its code: 2, 3 or 5 bits for values up to 7, then
+// 8 bits for up to 23 and 14 bits above that
+func decodeLZFSEFreq(bits uint32) (uint16, int) {
+	switch {
+	case bits&0b11 == 0b00:
+		return 0, 2
+	case bits&0b11 == 0b10:
+		return 1, 2
+	case bits&0b111 == 0b001:
+		return 2, 3
+	case bits&0b111 == 0b101:
+		return 3, 3
+	case bits&0b111 == 0b011:
+		return uint16(4 + bits>>3&3), 5
+	case bits&0b1111 == 0b0111:
+		return uint16(8 + bits>>4&0xf), 8
+	}
+	return uint16(24 + bits>>4&0x3ff), 14
+}
+
+// decode decodes the literals and matches of the block's payload, appending
+// the output to dst
+func (h *lzfseBlockHeader) decode(dst, payload []byte) ([]byte, error) {
+	if err := h.validate(); err != nil {
+		return dst, err
+	}
+	if uint64(len(payload)) != uint64(h.literalPayload)+uint64(h.lmdPayload) {
+		return dst, fmt.Errorf("lzfse: block payload is %d bytes, expected %d: %w", len(payload), uint64(h.literalPayload)+uint64(h.lmdPayload), ErrCorrupt)
+	}
+
+	literals, err := h.decodeLiterals(payload[:h.literalPayload])
+	if err != nil {
+		return dst, err
+	}
+
+	lTable := newFSEValueTable(lzfseLStates, h.freq[:lzfseLSymbols], lzfseLExtraBits, lzfseLBase)
+	mTable := newFSEValueTable(lzfseMStates, h.freq[lzfseLSymbols:][:lzfseMSymbols], lzfseMExtraBits, lzfseMBase)
+	dTable := newFSEValueTable(lzfseDStates, h.freq[lzfseLSymbols+lzfseMSymbols:][:lzfseDSymbols], lzfseDExtraBits, lzfseDBase)
+
+	in, err := newFSEBitReader(payload[h.literalPayload:], h.lmdBits)
+	if err != nil {
+		return dst, err
+	}
+	lState, mState, dState := int(h.lState), int(h.mState), int(h.dState)
+	start := len(dst)
+	distance := -1
+	for i := uint32(0); i < h.matches; i++ {
+		if err := in.flush(); err != nil {
+			return dst, err
+		}
+		literalLen := lTable.decode(&lState, in)
+		matchLen := mTable.decode(&mState, in)
+		// A zero distance repeats the previous match's distance
+		if d := dTable.decode(&dState, in); d != 0 {
+			distance = d
+		}
+
+		if literalLen > len(literals) {
+			return dst, fmt.Errorf("lzfse: match %d uses %d literals, %d remain: %w", i, literalLen, len(literals), ErrCorrupt)
+		}
+		dst = append(dst, literals[:literalLen]...)
+		literals = literals[literalLen:]
+
+		if distance <= 0 || distance > len(dst) {
+			return dst, fmt.Errorf("lzfse: match %d distance %d is out of range: %w", i, distance, ErrCorrupt)
+		}
+		if matchLen > 0 {
+			dst = appendMatch(dst, distance, matchLen)
+		}
+	}
+
+	if uint64(len(dst)-start) != uint64(h.rawBytes) {
+		return dst, fmt.Errorf("lzfse: block decoded to %d bytes, expected %d: %w", len(dst)-start, h.rawBytes, ErrCorrupt)
+	}
+	return dst, nil
+}
+
+// validate checks the header's counts, initial states and frequency tables
+// against the limits the decoding tables rely on
+func (h *lzfseBlockHeader) validate() error {
+	switch {
+	case h.literals > lzfseLiteralsPerBlock:
+		return fmt.Errorf("lzfse: block has %d literals: %w", h.literals, ErrCorrupt)
+	case h.matches > lzfseMatchesPerBlock:
+		return fmt.Errorf("lzfse: block has %d matches: %w", h.matches, ErrCorrupt)
+	case h.lState >= lzfseLStates, h.mState >= lzfseMStates, h.dState >= lzfseDStates:
+		return fmt.Errorf("lzfse: initial match state out of range: %w", ErrCorrupt)
+	}
+	for _, state := range h.literalState {
+		if state >= lzfseLiteralStates {
+			return fmt.Errorf("lzfse: initial literal state %d out of range: %w", state, ErrCorrupt)
+		}
+	}
+
+	tables := []struct {
+		freq    []uint16
+		nstates int
+	}{
+		{h.freq[:lzfseLSymbols], lzfseLStates},
+		{h.freq[lzfseLSymbols:][:lzfseMSymbols], lzfseMStates},
+		{h.freq[lzfseLSymbols+lzfseMSymbols:][:lzfseDSymbols], lzfseDStates},
+		{h.freq[lzfseLSymbols+lzfseMSymbols+lzfseDSymbols:], lzfseLiteralStates},
+	}
+	for _, table := range tables {
+		sum := 0
+		for _, f := range table.freq {
+			sum += int(f)
+		}
+		if sum > table.nstates {
+			return fmt.Errorf("lzfse: frequencies sum to %d, more than %d states: %w", sum, table.nstates, ErrCorrupt)
+		}
+	}
+	return nil
+}
+
+// decodeLiterals decodes the block's literals, four at a time from four
+// interleaved states. The count is rounded up to a multiple of four.
+func (h *lzfseBlockHeader) decodeLiterals(payload []byte) ([]byte, error) {
+	table := newFSETable(lzfseLiteralStates, h.freq[lzfseLSymbols+lzfseMSymbols+lzfseDSymbols:])
+	in, err := newFSEBitReader(payload, h.literalBits)
+	if err != nil {
+		return nil, err
+	}
+
+	var states [4]int
+	for i, state := range h.literalState {
+		states[i] = int(state)
+	}
+	literals := make([]byte, (h.literals+3)&^3)
+	for i := 0; i < len(literals); i += 4 {
+		if err := in.flush(); err != nil {
+			return nil, err
+		}
+		for j := range states {
+			literals[i+j] = table.decode(&states[j], in)
+		}
+	}
+	return literals, nil
+}
+
+// fseEntry is the decoding of one state: its symbol, and how to compute the
+// next state from the given number of bits of input
+type fseEntry struct {
+	bits   uint8
+	symbol uint8
+	delta  int32
+}
+
+// fseTable decodes symbols, indexed by state
+type fseTable []fseEntry
+
+// fseSpread calls fn for each state of each symbol with the state's number
+// of input bits and next state base. A symbol with frequency f owns f
+// consecutive states; the first of them read k bits, the rest k-1, so that
+// between them they cover all nstates next states.
+func fseSpread(nstates int, freq []uint16, fn func(state, symbol, k int, delta int32)) {
+	nlz := bits.LeadingZeros32(uint32(nstates))
+	state := 0
+	for symbol, f := range freq {
+		if f == 0 {
+			continue
+		}
+		k := bits.LeadingZeros32(uint32(f)) - nlz
+		j0 := (2*nstates)>>k - int(f)
+		for j := 0; j < int(f); j++ {
+			if j < j0 {
+				fn(state, symbol, k, int32((int(f)+j)<<k-nstates))
+			} else {
+				fn(state, symbol, k-1, int32((j-j0)<<(k-1)))
+			}
+			state++
+		}
+	}
+}
+
+// newFSETable builds the decoding table for frequencies that sum to at most
+// nstates
+func newFSETable(nstates int, freq []uint16) fseTable {
+	table := make(fseTable, nstates)
+	fseSpread(nstates, freq, func(state, symbol, k int, delta int32) {
+		table[state] = fseEntry{bits: uint8(k), symbol: uint8(symbol), delta: delta}
+	})
+	return table
+}
+
+// decode returns the symbol of *state and moves to the next state
+func (t fseTable) decode(state *int, in *fseBitReader) uint8 {
+	e := t[*state]
+	*state = int(e.delta) + int(in.pull(int(e.bits)))
+	return e.symbol
+}
+
+// fseValueEntry is the decoding of one state of an L, M or D stream: the
+// symbol's base value and extra bits, read together with the state bits
+type fseValueEntry struct {
+	totalBits uint8
+	valueBits uint8
+	delta     int32
+	base      int32
+}
+
+// fseValueTable decodes values, indexed by state
+type fseValueTable []fseValueEntry
+
+// newFSEValueTable builds the decoding table for a value stream
+func newFSEValueTable(nstates int, freq []uint16, extraBits []uint8, bases []int32) fseValueTable {
+	table := make(fseValueTable, nstates)
+	fseSpread(nstates, freq, func(state, symbol, k int, delta int32) {
+		table[state] = fseValueEntry{
+			totalBits: uint8(k) + extraBits[symbol],
+			valueBits: extraBits[symbol],
+			delta:     delta,
+			base:      bases[symbol],
+		}
+	})
+	return table
+}
+
+// decode returns the value of *state and moves to the next state
+func (t fseValueTable) decode(state *int, in *fseBitReader) int {
+	e := t[*state]
+	v := in.pull(int(e.totalBits))
+	*state = int(e.delta) + int(v>>e.valueBits)
+	return int(e.base) + int(v&(1<<e.valueBits-1))
+}
+
+// fseBitReader reads an FSE bit stream backwards from the end of its
+// payload, most significant bits first. The accumulator is refilled a byte
+// at a time so that it always holds at least 56 bits, enough for a group of
+// four literals or one L, M, D triple.
+type fseBitReader struct {
+	src   []byte // bytes not yet loaded into the accumulator
+	accum uint64
+	nbits int
+}
+
+// newFSEBitReader starts reading src, dropping -extraBits padding bits from
+// its final byte
+func newFSEBitReader(src []byte, extraBits int) (*fseBitReader, error) {
+	if extraBits < -7 || extraBits > 0 {
+		return nil, fmt.Errorf("lzfse: invalid stream bit count %d: %w", extraBits, ErrCorrupt)
+	}
+
+	// A stream with no padding bits starts with seven bytes, otherwise eight
+	n := 8
+	if extraBits == 0 {
+		n = 7
+	}
+	if len(src) < n {
+		return nil, fmt.Errorf("lzfse: stream payload of %d bytes is too short: %w", len(src), ErrCorrupt)
+	}
+	var initial [8]byte
+	copy(initial[:], src[len(src)-n:])
+	r := &fseBitReader{
+		src:   src[:len(src)-n],
+		accum: binary.LittleEndian.Uint64(initial[:]),
+		nbits: 8*n + extraBits,
+	}
+	if r.accum>>r.nbits != 0 {
+		return nil, fmt.Errorf("lzfse: stream padding bits are not zero: %w", ErrCorrupt)
+	}
+	return r, nil
+}
+
+// flush refills the accumulator with as many whole bytes as fit
+func (r *fseBitReader) flush() error {
+	n := (63 - r.nbits) >> 3
+	if n > len(r.src) {
+		return fmt.Errorf("lzfse: bit stream underflow: %w", ErrCorrupt)
+	}
+	for i := len(r.src) - 1; i >= len(r.src)-n; i-- {
+		r.accum = r.accum<<8 | uint64(r.src[i])
+	}
+	r.nbits += 8 * n
+	r.src = r.src[:len(r.src)-n]
+	return nil
+}
+
+// pull removes and returns the n most significant bits of the accumulator
+func (r *fseBitReader) pull(n int) uint64 {
+	r.nbits -= n
+	v := r.accum >> r.nbits
+	r.accum &= 1<<r.nbits - 1
+	return v
+}
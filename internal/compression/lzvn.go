@@ -0,0 +1,182 @@
+// Package compression implements pure-Go decoders for the compression
+// formats Apple uses in decmpfs-compressed files and UDIF disk images.
+package compression
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrCorrupt is returned when compressed data is malformed or truncated
+	ErrCorrupt = errors.New("corrupt compressed data")
+	// ErrUnsupported is returned for valid data using an encoding this
+	// package does not decode
+	ErrUnsupported = errors.New("unsupported compressed data")
+)
+
+// lzvnOpcode classifies the first byte of an LZVN instruction
+type lzvnOpcode uint8
+
+const (
+	lzvnUndefined lzvnOpcode = iota
+	lzvnEndOfStream
+	lzvnNop
+	lzvnSmallDistance    // LLMMMDDD DDDDDDDD
+	lzvnMediumDistance   // 101LLMMM DDDDDDMM DDDDDDDD
+	lzvnLargeDistance    // LLMMM111 DDDDDDDD DDDDDDDD
+	lzvnPreviousDistance // LLMMM110
+	lzvnSmallLiteral     // 1110LLLL
+	lzvnLargeLiteral     // 11100000 LLLLLLLL
+	lzvnSmallMatch       // 1111MMMM
+	lzvnLargeMatch       // 11110000 MMMMMMMM
+)
+
+// lzvnOpcodes maps every first byte to its instruction
+var lzvnOpcodes = func() (table [256]lzvnOpcode) {
+	for opc := 0; opc < 256; opc++ {
+		low := opc & 7
+		switch {
+		case opc >= 0xf0:
+			table[opc] = lzvnSmallMatch
+			if opc == 0xf0 {
+				table[opc] = lzvnLargeMatch
+			}
+		case opc >= 0xe0:
+			table[opc] = lzvnSmallLiteral
+			if opc == 0xe0 {
+				table[opc] = lzvnLargeLiteral
+			}
+		case opc >= 0xd0 && opc < 0xe0, opc >= 0x70 && opc < 0x80:
+			table[opc] = lzvnUndefined
+		case opc >= 0xa0 && opc < 0xc0:
+			table[opc] = lzvnMediumDistance
+		case low == 7:
+			table[opc] = lzvnLargeDistance
+		case low == 6:
+			switch {
+			case opc == 0x06:
+				table[opc] = lzvnEndOfStream
+			case opc == 0x0e || opc == 0x16:
+				table[opc] = lzvnNop
+			case opc < 0x40:
+				table[opc] = lzvnUndefined
+			default:
+				table[opc] = lzvnPreviousDistance
+			}
+		default:
+			table[opc] = lzvnSmallDistance
+		}
+	}
+	return table
+}()
+
+// DecodeLZVN decompresses an LZVN stream, appending the output to dst. Passing
+// a dst with enough capacity for the output avoids reallocation. Matches may
+// refer back into data already in dst, as they do across the blocks of an
+// LZFSE stream. Decoding stops at the end-of-stream instruction or when src
+// is exhausted.
+func DecodeLZVN(dst, src []byte) ([]byte, error) {
+	out, _, err := decodeLZVN(dst, src)
+	return out, err
+}
+
+// decodeLZVN decodes src into dst and also returns the number of bytes of src
+// consumed, including the end-of-stream instruction
+func decodeLZVN(dst, src []byte) ([]byte, int, error) {
+	var distance int
+	pos := 0
+
+	for pos < len(src) {
+		opc := src[pos]
+		var opLen, literals, match int
+
+		switch lzvnOpcodes[opc] {
+		case lzvnEndOfStream:
+			// The end-of-stream instruction is padded to eight bytes
+			return dst, min(pos+8, len(src)), nil
+		case lzvnNop:
+			pos++
+			continue
+		case lzvnUndefined:
+			return dst, pos, fmt.Errorf("lzvn: undefined opcode 0x%02x at offset %d: %w", opc, pos, ErrCorrupt)
+		case lzvnSmallDistance:
+			if pos+2 > len(src) {
+				return dst, pos, fmt.Errorf("lzvn: truncated instruction at offset %d: %w", pos, ErrCorrupt)
+			}
+			opLen = 2
+			literals = int(opc >> 6)
+			match = int(opc>>3&7) + 3
+			distance = int(opc&7)<<8 | int(src[pos+1])
+		case lzvnMediumDistance:
+			if pos+3 > len(src) {
+				return dst, pos, fmt.Errorf("lzvn: truncated instruction at offset %d: %w", pos, ErrCorrupt)
+			}
+			opLen = 3
+			operand := int(src[pos+1]) | int(src[pos+2])<<8
+			literals = int(opc >> 3 & 3)
+			match = (int(opc&7)<<2 | operand&3) + 3
+			distance = operand >> 2
+		case lzvnLargeDistance:
+			if pos+3 > len(src) {
+				return dst, pos, fmt.Errorf("lzvn: truncated instruction at offset %d: %w", pos, ErrCorrupt)
+			}
+			opLen = 3
+			literals = int(opc >> 6)
+			match = int(opc>>3&7) + 3
+			distance = int(src[pos+1]) | int(src[pos+2])<<8
+		case lzvnPreviousDistance:
+			opLen = 1
+			literals = int(opc >> 6)
+			match = int(opc>>3&7) + 3
+		case lzvnSmallLiteral:
+			opLen = 1
+			literals = int(opc & 0x0f)
+		case lzvnLargeLiteral:
+			if pos+2 > len(src) {
+				return dst, pos, fmt.Errorf("lzvn: truncated instruction at offset %d: %w", pos, ErrCorrupt)
+			}
+			opLen = 2
+			literals = int(src[pos+1]) + 16
+		case lzvnSmallMatch:
+			opLen = 1
+			match = int(opc & 0x0f)
+		case lzvnLargeMatch:
+			if pos+2 > len(src) {
+				return dst, pos, fmt.Errorf("lzvn: truncated instruction at offset %d: %w", pos, ErrCorrupt)
+			}
+			opLen = 2
+			match = int(src[pos+1]) + 16
+		}
+
+		pos += opLen
+		if literals > 0 {
+			if pos+literals > len(src) {
+				return dst, pos, fmt.Errorf("lzvn: %d literal bytes at offset %d exceed input: %w", literals, pos, ErrCorrupt)
+			}
+			dst = append(dst, src[pos:pos+literals]...)
+			pos += literals
+		}
+		if match > 0 {
+			if distance == 0 || distance > len(dst) {
+				return dst, pos, fmt.Errorf("lzvn: match distance %d at offset %d is out of range: %w", distance, pos, ErrCorrupt)
+			}
+			dst = appendMatch(dst, distance, match)
+		}
+	}
+
+	return dst, pos, nil
+}
+
+// appendMatch appends length bytes copied from distance bytes back in dst.
+// The source and destination overlap when distance is less than length,
+// which repeats the last distance bytes; each copy doubles the repeated run.
+func appendMatch(dst []byte, distance, length int) []byte {
+	from := len(dst) - distance
+	for length > 0 {
+		n := min(len(dst)-from, length)
+		dst = append(dst, dst[from:from+n]...)
+		length -= n
+	}
+	return dst
+}
@@ -0,0 +1,105 @@
+package compression
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lzvnEOS is the end-of-stream instruction with its padding
+var lzvnEOS = []byte{0x06, 0, 0, 0, 0, 0, 0, 0}
+
+func lzvnStream(parts ...string) []byte {
+	return append([]byte(strings.Join(parts, "")), lzvnEOS...)
+}
+
+// TestDecodeLZVN decodes hand assembled instructions. No payload taken from
+// a real compressed file is checked in yet.
+func TestDecodeLZVN(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []byte
+		want string
+	}{
+		{
+			name: "small literal",
+			src:  lzvnStream("\xe3abc"),
+			want: "abc",
+		},
+		{
+			name: "large literal and large distance",
+			src:  lzvnStream("\xe0\x02abcdefghijklmnopqr", "\x3f\x12\x00"),
+			want: "abcdefghijklmnopqr" + "abcdefghij",
+		},
+		{
+			name: "medium distance and small match",
+			src:  lzvnStream("\xe3hij", "\x0e", "\xa1\x0e\x00", "\xf5"),
+			want: "hij" + "hijhijhij" + "hijhi",
+		},
+		{
+			name: "small distance, previous distance and large match",
+			src:  lzvnStream("\x80\x02XY", "\x4eZ", "\xf0\x00"),
+			want: "XYXYX" + "ZXZXZ" + strings.Repeat("XZ", 8),
+		},
+		{
+			name: "literals only, no end of stream",
+			src:  []byte("\xe2ok"),
+			want: "ok",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeLZVN(nil, tt.src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestDecodeLZVNAppends(t *testing.T) {
+	// Matches may refer to data already in dst
+	dst := make([]byte, 0, 64)
+	dst = append(dst, "prefix"...)
+	got, err := DecodeLZVN(dst, lzvnStream("\x3f\x06\x00"))
+	require.NoError(t, err)
+	assert.Equal(t, "prefix"+"prefixpref", string(got))
+	assert.Equal(t, 64, cap(got))
+}
+
+func TestDecodeLZVNConsumed(t *testing.T) {
+	src := append(lzvnStream("\xe3abc"), "trailing"...)
+	got, n, err := decodeLZVN(nil, src)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(got))
+	assert.Equal(t, 12, n)
+}
+
+func TestDecodeLZVNCorrupt(t *testing.T) {
+	for name, src := range map[string][]byte{
+		"undefined opcode":        {0x1e},
+		"distance before start":   []byte("\xe1a\x3f\x05\x00"),
+		"zero distance":           []byte("\xe1a\x00\x00"),
+		"previous distance unset": []byte("\xe1a\xf3"),
+		"truncated literals":      []byte("\xe5ab"),
+		"truncated instruction":   []byte("\xe1a\xa1\x0e"),
+	} {
+		_, err := DecodeLZVN(nil, src)
+		assert.ErrorIs(t, err, ErrCorrupt, name)
+	}
+}
+
+func BenchmarkDecodeLZVN(b *testing.B) {
+	src := append([]byte("\xe3abc"), bytes.Repeat([]byte("\x3f\x03\x00\xf0\xff"), 256)...)
+	src = append(src, lzvnEOS...)
+	dst := make([]byte, 0, 3+256*(10+271))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeLZVN(dst[:0], src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
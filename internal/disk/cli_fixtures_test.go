@@ -0,0 +1,46 @@
+package disk
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateFixtures = flag.Bool("update-fixtures", false, "rewrite the images opened by the afps command tests")
+
+// cliFixtureDir holds the images opened by the afps command tests, which
+// cannot reach the test image builders of this package
+const cliFixtureDir = "../../cmd/afps/testdata"
+
+// TestCLIFixtures checks the EWF images used by the afps verify tests: one
+// matching its stored MD5 hash and one with a chunk changed after
+// acquisition. Run it with -update-fixtures to rewrite them.
+func TestCLIFixtures(t *testing.T) {
+	if *updateFixtures {
+		media := ewfMedia(16 * 512)
+		for name, corrupt := range map[string]bool{"verified.E01": false, "tampered.E01": true} {
+			path := buildEWF(t, media, 2, 8, func(int) bool { return false })
+			if corrupt {
+				corruptEWFChunk(t, path, 3, 1024)
+			}
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NoError(t, os.MkdirAll(cliFixtureDir, 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(cliFixtureDir, name), data, 0o644))
+		}
+	}
+
+	img, err := OpenImage(filepath.Join(cliFixtureDir, "verified.E01"))
+	require.NoError(t, err)
+	defer img.Close()
+	assert.NoError(t, img.Verify())
+
+	img, err = OpenImage(filepath.Join(cliFixtureDir, "tampered.E01"))
+	require.NoError(t, err)
+	defer img.Close()
+	assert.ErrorIs(t, img.Verify(), ErrEWFHashMismatch)
+}
@@ -1,8 +1,7 @@
 package disk
 
 import (
-	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -15,16 +14,22 @@ import (
 	"github.com/deploymenttheory/go-apfs/internal/types"
 )
 
+// ErrContainerIndex is returned when the configured container index does not
+// match any APFS container in the image
+var ErrContainerIndex = errors.New("container index out of range")
+
 // DMGDevice provides access to APFS containers within DMG files
 type DMGDevice struct {
-	file             *os.File
+	file             Image
 	size             int64
 	offset           int64 // Offset to APFS container within DMG
+	length           int64 // Size of the container's partition, zero when unknown
 	blockCache       map[uint64][]byte
 	cacheMutex       sync.RWMutex
 	maxCacheSize     int64
 	currentCacheSize int64
 	stats            *DMGStatistics
+	containerIndex   int
 }
 
 // DMGStatistics tracks DMG access statistics
@@ -45,6 +50,14 @@ type DMGConfig struct {
 	CacheEnabled   bool   `mapstructure:"cache_enabled"`
 	CacheSize      int    `mapstructure:"cache_size"`
 	TestDataPath   string `mapstructure:"test_data_path"`
+
+	// ContainerIndex selects among the APFS containers found in the
+	// partitions of a whole-disk image
+	ContainerIndex int `mapstructure:"container_index"`
+
+	// Password unlocks encrypted images. It is never read from the
+	// configuration file.
+	Password string `mapstructure:"-"`
 }
 
 // LoadDMGConfig loads DMG configuration using Viper
@@ -63,6 +76,7 @@ func LoadDMGConfig() (*DMGConfig, error) {
 	viper.SetDefault("cache_enabled", true)
 	viper.SetDefault("cache_size", 100)
 	viper.SetDefault("test_data_path", "./tests")
+	viper.SetDefault("container_index", 0)
 
 	// Allow environment variables
 	viper.SetEnvPrefix("APFS")
@@ -86,23 +100,18 @@ func LoadDMGConfig() (*DMGConfig, error) {
 
 // OpenDMG opens a DMG file and detects the APFS container within it
 func OpenDMG(path string, config *DMGConfig) (*DMGDevice, error) {
-	file, err := os.Open(path)
+	file, err := OpenImageWithPassword(path, config.Password)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open DMG file: %w", err)
 	}
 
-	stat, err := file.Stat()
-	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to stat DMG file: %w", err)
-	}
-
 	device := &DMGDevice{
 		file:             file,
-		size:             stat.Size(),
+		size:             file.Size(),
 		blockCache:       make(map[uint64][]byte),
 		maxCacheSize:     int64(config.CacheSize) * 1024 * 1024,
 		currentCacheSize: 0,
+		containerIndex:   config.ContainerIndex,
 		stats: &DMGStatistics{
 			offsetMethod: "unknown",
 		},
@@ -114,14 +123,16 @@ func OpenDMG(path string, config *DMGConfig) (*DMGDevice, error) {
 		offset, method, err := device.detectAPFSOffsetWithMethod()
 		device.stats.offsetDetectionTime = time.Since(startTime)
 		device.stats.offsetMethod = method
+		if errors.Is(err, ErrContainerIndex) {
+			file.Close()
+			return nil, err
+		}
 		if err != nil {
 			// Fall back to default offset
 			device.offset = config.DefaultOffset
 			device.stats.offsetMethod = "fallback"
-			fmt.Printf("[DMG] Using fallback offset: %d\n", device.offset)
 		} else {
 			device.offset = offset
-			fmt.Printf("[DMG] Detection complete via %s in %v\n", method, device.stats.offsetDetectionTime)
 		}
 	} else {
 		device.offset = config.DefaultOffset
@@ -140,18 +151,27 @@ func (d *DMGDevice) detectAPFSOffsetWithMethod() (int64, string, error) {
 		return 0, "", fmt.Errorf("failed to read DMG: %w", err)
 	}
 
-	fmt.Printf("[DMG] Starting APFS offset detection (file size: %d bytes)\n", d.size)
-
-	// Method 1: Try to parse GPT partition table
-	offset, err := d.parseGPTPartitionTable(buf[:n])
-	if err == nil {
-		fmt.Printf("[DMG] ✓ APFS found via GPT partition table at offset: %d (0x%x)\n", offset, offset)
-		return offset, "gpt", nil
+	// Method 1: Scan the GPT and MBR partition tables
+	table, err := ScanPartitions(d.file, d.size)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to scan partitions: %w", err)
+	}
+	if table.Scheme != SchemeNone {
+		containers, err := table.Containers(d.file)
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to probe partitions: %w", err)
+		}
+		if len(containers) > 0 {
+			if d.containerIndex < 0 || d.containerIndex >= len(containers) {
+				return 0, "", fmt.Errorf("%w: index %d, image has %d APFS containers", ErrContainerIndex, d.containerIndex, len(containers))
+			}
+			loc := containers[d.containerIndex]
+			d.length = loc.Size
+			return loc.Offset, string(table.Scheme), nil
+		}
 	}
-	fmt.Printf("[DMG] GPT parsing failed: %v\n", err)
 
 	// Method 2: Scan common offsets for APFS magic
-	fmt.Printf("[DMG] Attempting signature scan at common offsets...\n")
 	commonOffsets := []struct {
 		offset      int64
 		description string
@@ -173,14 +193,11 @@ func (d *DMGDevice) detectAPFSOffsetWithMethod() (int64, string, error) {
 			uint32(magicBytes[3])<<24
 
 		if magic == types.NxMagic {
-			fmt.Printf("[DMG] ✓ APFS found via signature scan at offset: %d (0x%x) - %s\n",
-				od.offset, od.offset, od.description)
 			return od.offset, "common_offsets", nil
 		}
 	}
 
 	// Method 3: Full scan at 4096-byte boundaries (APFS block size)
-	fmt.Printf("[DMG] Attempting full signature scan at 4096-byte boundaries...\n")
 	for i := int64(0); i < int64(n)-int64(types.APFSMagicOffset)-4; i += int64(types.NxDefaultBlockSize) {
 		magicBytes := buf[i+int64(types.APFSMagicOffset) : i+int64(types.APFSMagicOffset)+4]
 		magic := uint32(magicBytes[0]) |
@@ -189,12 +206,10 @@ func (d *DMGDevice) detectAPFSOffsetWithMethod() (int64, string, error) {
 			uint32(magicBytes[3])<<24
 
 		if magic == types.NxMagic {
-			fmt.Printf("[DMG] ✓ APFS found via full scan at offset: %d (0x%x)\n", i, i)
 			return i, "full_scan", nil
 		}
 	}
 
-	fmt.Printf("[DMG] ✗ APFS container not found\n")
 	return 0, "", fmt.Errorf("APFS container not found in DMG file")
 }
 
@@ -204,67 +219,6 @@ func (d *DMGDevice) detectAPFSOffset() (int64, error) {
 	return offset, err
 }
 
-// parseGPTPartitionTable parses the GPT header to find APFS partition
-// GPT structure: LBA 0-1 are reserved, LBA 1 contains primary GPT header,
-// LBA 2+ contains partition entries
-func (d *DMGDevice) parseGPTPartitionTable(buf []byte) (int64, error) {
-	// Verify we have enough data
-	if len(buf) < types.GPTEntriesStartOffset+types.GPTEntrySize {
-		return 0, fmt.Errorf("buffer too small for GPT parsing")
-	}
-
-	// Check GPT header signature
-	if len(buf) < types.GPTHeaderOffset+8 {
-		return 0, fmt.Errorf("insufficient data for GPT header signature")
-	}
-
-	gptSignature := string(buf[types.GPTHeaderOffset : types.GPTHeaderOffset+8])
-	if gptSignature != "EFI PART" {
-		return 0, fmt.Errorf("no valid GPT signature found")
-	}
-
-	fmt.Printf("[DMG] Found GPT header signature\n")
-
-	// Parse partition entries looking for APFS
-	// Convert the APFS partition UUID string to byte array (in little-endian format for comparison)
-	// UUID: 7C3457EF-0000-11AA-AA11-00306543ECAC
-	apfsUUID := []byte{0xEF, 0x57, 0x34, 0x7C, 0x00, 0x00, 0xAA, 0x11,
-		0xAA, 0x11, 0x00, 0x30, 0x65, 0x43, 0xEC, 0xAC}
-	// Reference: types.ApfsGptPartitionUUID in efi_jumpstart.go
-
-	// Read up to 128 partition entries
-	for entryIdx := 0; entryIdx < 128; entryIdx++ {
-		entryOffset := types.GPTEntriesStartOffset + (entryIdx * types.GPTEntrySize)
-		if entryOffset+types.GPTEntrySize > len(buf) {
-			break
-		}
-
-		entry := buf[entryOffset : entryOffset+types.GPTEntrySize]
-
-		// First 16 bytes are partition type UUID
-		partTypeUUID := entry[0:16]
-
-		// Check if this is an APFS partition
-		if bytes.Equal(partTypeUUID, apfsUUID) {
-			// Bytes 32-39 contain start LBA (little-endian)
-			startLBA := binary.LittleEndian.Uint64(entry[32:40])
-			// Bytes 40-47 contain end LBA (little-endian)
-			endLBA := binary.LittleEndian.Uint64(entry[40:48])
-
-			startOffset := int64(startLBA) * 512 // Convert LBA to byte offset
-			endOffset := int64(endLBA) * 512
-
-			fmt.Printf("[DMG] Found APFS partition #%d: LBA %d-%d (offset 0x%x-0x%x, size %d MB)\n",
-				entryIdx+1, startLBA, endLBA, startOffset, endOffset,
-				(endOffset-startOffset)/(1024*1024))
-
-			return startOffset, nil
-		}
-	}
-
-	return 0, fmt.Errorf("no APFS partition found in GPT table")
-}
-
 // ReadAt implements io.ReaderAt for the APFS container within the DMG
 func (d *DMGDevice) ReadAt(p []byte, off int64) (n int, err error) {
 	// Adjust offset to account for APFS container position
@@ -314,6 +268,9 @@ func (d *DMGDevice) ReadAt(p []byte, off int64) (n int, err error) {
 
 // Size returns the size of the APFS container
 func (d *DMGDevice) Size() int64 {
+	if d.length > 0 {
+		return d.length
+	}
 	return d.size - d.offset
 }
 
@@ -468,3 +425,14 @@ func (d *DMGDevice) VerifyGPTStructure() (bool, error) {
 
 	return true, nil
 }
+
+// Format names the format of the image file, such as "raw" or "udif"
+func (d *DMGDevice) Format() string {
+	return d.file.Format()
+}
+
+// Verify checks the whole image file against the hash stored in it, which
+// only forensic formats such as EWF record
+func (d *DMGDevice) Verify() error {
+	return d.file.Verify()
+}
@@ -0,0 +1,375 @@
+package disk
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/deploymenttheory/go-apfs/internal/kdf"
+)
+
+// Encrypted disk image (encrcdsa, version 2) layout. The header at the start
+// of the file lists key blobs; a password key blob holds the PBKDF2
+// parameters and the 3DES-wrapped AES and HMAC keys. The payload is split
+// into chunks encrypted with AES-CBC, each with an IV derived from its chunk
+// number. All fields are big-endian.
+const (
+	encryptedMagic   = "encrcdsa"
+	encryptedMagicV1 = "cdsaencr"
+
+	encryptedHeaderSize = 76
+	encryptedKeyPointer = 20
+
+	// encryptedKeyPassword marks a key blob unlocked by a passphrase
+	encryptedKeyPassword = 1
+
+	encryptedPasswordHeaderSize = 104
+	encryptedKDFPBKDF2          = 103
+	encryptedBlobKeySize        = 24
+
+	// defaultEncryptedCacheChunks is the number of decrypted chunks kept
+	defaultEncryptedCacheChunks = 256
+)
+
+// Encrypted disk image (cdsaencr, version 1) layout. The payload starts at
+// offset zero in 4096 byte AES-128 chunks and is followed by a 1276 byte
+// trailer ending in the signature. The trailer holds the PBKDF2 parameters
+// and the AES and HMAC keys, each wrapped with the RFC 3217 3DES key wrap.
+const (
+	encryptedV1TrailerSize = 1276
+	encryptedV1ChunkSize   = 4096
+	encryptedV1KeyBits     = 128
+
+	encryptedV1MaxWrappedKey = 296
+)
+
+// encryptedV1WrapIV is the IV of the outer layer of the RFC 3217 key wrap
+var encryptedV1WrapIV = []byte{0x4a, 0xdd, 0xa2, 0x2c, 0x79, 0xe8, 0x21, 0x05}
+
+var (
+	// ErrNotEncryptedImage is returned by OpenEncryptedImage when the header
+	// signature is missing
+	ErrNotEncryptedImage = errors.New("not an encrypted disk image")
+
+	// ErrPasswordRequired is returned when an encrypted image is opened
+	// without a password
+	ErrPasswordRequired = errors.New("disk image is encrypted, a password is required")
+
+	// ErrBadPassword is returned when the password does not unlock the image
+	ErrBadPassword = errors.New("incorrect password for encrypted disk image")
+)
+
+// EncryptedImage presents the decrypted payload of an encrcdsa or cdsaencr
+// disk image.
+// The payload is usually itself a UDIF image.
+type EncryptedImage struct {
+	r          io.ReaderAt
+	size       int64
+	dataOffset int64
+	chunkSize  int64
+	cipher     cipher.Block
+	hmacKey    []byte
+
+	mu     sync.Mutex
+	chunks *lruCache[int64, []byte]
+}
+
+// encryptedPasswordKey holds the fields of a password key blob
+type encryptedPasswordKey struct {
+	iterations int
+	salt       []byte
+	iv         []byte
+	blob       []byte
+}
+
+// IsEncryptedImage reports whether r, which is size bytes long, starts with
+// a version 2 encrypted image header or ends with a version 1 trailer
+func IsEncryptedImage(r io.ReaderAt, size int64) bool {
+	magic := make([]byte, 8)
+	if _, err := r.ReadAt(magic, 0); err == nil && string(magic) == encryptedMagic {
+		return true
+	}
+	return isEncryptedImageV1(r, size)
+}
+
+// isEncryptedImageV1 reports whether r ends with a version 1 trailer
+func isEncryptedImageV1(r io.ReaderAt, size int64) bool {
+	if size < encryptedV1TrailerSize {
+		return false
+	}
+	magic := make([]byte, 8)
+	if _, err := r.ReadAt(magic, size-8); err != nil {
+		return false
+	}
+	return string(magic) == encryptedMagicV1
+}
+
+// OpenEncryptedImage unlocks the encrypted image in r, which is size bytes
+// long, with password
+func OpenEncryptedImage(r io.ReaderAt, size int64, password string) (*EncryptedImage, error) {
+	header := make([]byte, encryptedHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted image header: %w", err)
+	}
+	if string(header[0:8]) != encryptedMagic {
+		if isEncryptedImageV1(r, size) {
+			return openEncryptedImageV1(r, size, password)
+		}
+		return nil, ErrNotEncryptedImage
+	}
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+
+	if version := binary.BigEndian.Uint32(header[8:12]); version != 2 {
+		return nil, fmt.Errorf("unsupported encrypted image version %d", version)
+	}
+	keyBits := binary.BigEndian.Uint32(header[24:28])
+	chunkSize := int64(binary.BigEndian.Uint32(header[52:56]))
+	dataSize := int64(binary.BigEndian.Uint64(header[56:64]))
+	dataOffset := int64(binary.BigEndian.Uint64(header[64:72]))
+	keyCount := binary.BigEndian.Uint32(header[72:76])
+
+	if keyBits != 128 && keyBits != 256 {
+		return nil, fmt.Errorf("unsupported encrypted image key size %d", keyBits)
+	}
+	if chunkSize == 0 || chunkSize%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted image chunk size %d", chunkSize)
+	}
+	if dataOffset > size || dataSize > size-dataOffset {
+		return nil, fmt.Errorf("encrypted payload of %d bytes at %d exceeds image size %d", dataSize, dataOffset, size)
+	}
+
+	key, err := readPasswordKey(r, size, keyCount)
+	if err != nil {
+		return nil, err
+	}
+
+	derived := kdf.PBKDF2(sha1.New, []byte(password), key.salt, key.iterations, encryptedBlobKeySize)
+	keys, err := unwrapKeyBlob(derived, key.iv, key.blob)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) < int(keyBits/8) || len(keys) < sha1.Size {
+		return nil, fmt.Errorf("unwrapped key blob holds %d bytes, too short for a %d bit key", len(keys), keyBits)
+	}
+
+	return newEncryptedImage(r, dataOffset, dataSize, chunkSize, keys[:keyBits/8], keys[:sha1.Size])
+}
+
+// openEncryptedImageV1 unlocks the version 1 encrypted image in r, whose
+// keys are in the trailer at its end
+func openEncryptedImageV1(r io.ReaderAt, size int64, password string) (*EncryptedImage, error) {
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+
+	trailer := make([]byte, encryptedV1TrailerSize)
+	if _, err := r.ReadAt(trailer, size-encryptedV1TrailerSize); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted image trailer: %w", err)
+	}
+	iterations := binary.BigEndian.Uint32(trailer[48:52])
+	saltLen := binary.BigEndian.Uint32(trailer[52:56])
+	aesKeyLen := binary.BigEndian.Uint32(trailer[136:140])
+	hmacKeyLen := binary.BigEndian.Uint32(trailer[436:440])
+
+	if iterations == 0 {
+		return nil, fmt.Errorf("encrypted image trailer has a zero iteration count")
+	}
+	if saltLen > 48 {
+		return nil, fmt.Errorf("encrypted image trailer has invalid salt length %d", saltLen)
+	}
+	if aesKeyLen > encryptedV1MaxWrappedKey || hmacKeyLen > encryptedV1MaxWrappedKey {
+		return nil, fmt.Errorf("encrypted image trailer has invalid wrapped key lengths %d and %d", aesKeyLen, hmacKeyLen)
+	}
+
+	derived := kdf.PBKDF2(sha1.New, []byte(password), trailer[56:56+saltLen], int(iterations), encryptedBlobKeySize)
+	aesKey, err := unwrapKeyV1(derived, trailer[140:140+aesKeyLen])
+	if err != nil {
+		return nil, err
+	}
+	hmacKey, err := unwrapKeyV1(derived, trailer[440:440+hmacKeyLen])
+	if err != nil {
+		return nil, err
+	}
+	if len(aesKey) < encryptedV1KeyBits/8 || len(hmacKey) < sha1.Size {
+		return nil, fmt.Errorf("unwrapped keys hold %d and %d bytes, too short for the AES and HMAC keys", len(aesKey), len(hmacKey))
+	}
+
+	dataSize := (size - encryptedV1TrailerSize) / encryptedV1ChunkSize * encryptedV1ChunkSize
+	return newEncryptedImage(r, 0, dataSize, encryptedV1ChunkSize, aesKey[:encryptedV1KeyBits/8], hmacKey[:sha1.Size])
+}
+
+// unwrapKeyV1 reverses the RFC 3217 3DES key wrap: the outer layer is
+// decrypted with a fixed IV and reversed, its first block is the IV of the
+// inner layer, and the inner layer holds a 4 byte prefix and then the key
+func unwrapKeyV1(key, wrapped []byte) ([]byte, error) {
+	if len(wrapped) == 0 || len(wrapped)%des.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid wrapped key length %d", len(wrapped))
+	}
+	outer, err := unwrapKeyBlob(key, encryptedV1WrapIV, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(outer)
+	if len(outer) < 2*des.BlockSize || len(outer)%des.BlockSize != 0 {
+		return nil, ErrBadPassword
+	}
+
+	inner, err := unwrapKeyBlob(key, outer[:des.BlockSize], outer[des.BlockSize:])
+	if err != nil {
+		return nil, err
+	}
+	if len(inner) < 4 {
+		return nil, ErrBadPassword
+	}
+	return inner[4:], nil
+}
+
+// newEncryptedImage presents the payload of dataSize bytes at dataOffset in
+// r, encrypted in chunks of chunkSize bytes
+func newEncryptedImage(r io.ReaderAt, dataOffset, dataSize, chunkSize int64, aesKey, hmacKey []byte) (*EncryptedImage, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payload cipher: %w", err)
+	}
+
+	return &EncryptedImage{
+		r:          r,
+		size:       dataSize,
+		dataOffset: dataOffset,
+		chunkSize:  chunkSize,
+		cipher:     block,
+		hmacKey:    hmacKey,
+		chunks:     newLRUCache[int64, []byte](defaultEncryptedCacheChunks, nil),
+	}, nil
+}
+
+// readPasswordKey finds and parses the first password key blob
+func readPasswordKey(r io.ReaderAt, size int64, count uint32) (*encryptedPasswordKey, error) {
+	if count == 0 || count > 64 {
+		return nil, fmt.Errorf("invalid encrypted image key count %d", count)
+	}
+	pointers := make([]byte, int(count)*encryptedKeyPointer)
+	if _, err := r.ReadAt(pointers, encryptedHeaderSize); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted image key pointers: %w", err)
+	}
+
+	for i := 0; i < int(count); i++ {
+		p := pointers[i*encryptedKeyPointer:]
+		if binary.BigEndian.Uint32(p[0:4]) != encryptedKeyPassword {
+			continue
+		}
+		offset := int64(binary.BigEndian.Uint64(p[4:12]))
+		length := int64(binary.BigEndian.Uint64(p[12:20]))
+		if length < encryptedPasswordHeaderSize || offset < 0 || offset > size || length > size-offset {
+			return nil, fmt.Errorf("password key blob of %d bytes at %d is out of range", length, offset)
+		}
+
+		data := make([]byte, length)
+		if _, err := r.ReadAt(data, offset); err != nil {
+			return nil, fmt.Errorf("failed to read password key blob: %w", err)
+		}
+		return parsePasswordKey(data)
+	}
+	return nil, fmt.Errorf("encrypted image has no password key blob")
+}
+
+// parsePasswordKey decodes a password key blob
+func parsePasswordKey(data []byte) (*encryptedPasswordKey, error) {
+	if algorithm := binary.BigEndian.Uint32(data[0:4]); algorithm != encryptedKDFPBKDF2 {
+		return nil, fmt.Errorf("unsupported key derivation algorithm %d", algorithm)
+	}
+	iterations := binary.BigEndian.Uint32(data[8:12])
+	saltLen := binary.BigEndian.Uint32(data[12:16])
+	ivLen := binary.BigEndian.Uint32(data[48:52])
+	blobLen := binary.BigEndian.Uint32(data[100:104])
+
+	if iterations == 0 {
+		return nil, fmt.Errorf("password key blob has a zero iteration count")
+	}
+	if saltLen > 32 || ivLen > 32 || ivLen < des.BlockSize {
+		return nil, fmt.Errorf("password key blob has invalid salt length %d or IV length %d", saltLen, ivLen)
+	}
+	if int64(blobLen) > int64(len(data)-encryptedPasswordHeaderSize) || blobLen == 0 || blobLen%des.BlockSize != 0 {
+		return nil, fmt.Errorf("password key blob has invalid wrapped key length %d", blobLen)
+	}
+
+	return &encryptedPasswordKey{
+		iterations: int(iterations),
+		salt:       data[16 : 16+saltLen],
+		iv:         data[52 : 52+des.BlockSize],
+		blob:       data[encryptedPasswordHeaderSize : encryptedPasswordHeaderSize+blobLen],
+	}, nil
+}
+
+// unwrapKeyBlob decrypts a wrapped key blob with 3DES-EDE in CBC mode and
+// strips its PKCS#7 padding. Invalid padding means the derived key, and so
+// the password, is wrong.
+func unwrapKeyBlob(key, iv, blob []byte) ([]byte, error) {
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key blob cipher: %w", err)
+	}
+	plain := make([]byte, len(blob))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, blob)
+
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > des.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, ErrBadPassword
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// Size returns the size of the decrypted payload
+func (e *EncryptedImage) Size() int64 {
+	return e.size
+}
+
+// ReadAt reads from the decrypted payload
+func (e *EncryptedImage) ReadAt(p []byte, off int64) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return readBands(p, off, e.size, e.chunkSize, func(chunk int64, dst []byte, chunkOff int64) error {
+		data, err := e.chunk(chunk)
+		if err != nil {
+			return err
+		}
+		copy(dst, data[chunkOff:])
+		return nil
+	})
+}
+
+// chunk returns the decrypted contents of a payload chunk
+func (e *EncryptedImage) chunk(index int64) ([]byte, error) {
+	if data, ok := e.chunks.get(index); ok {
+		return data, nil
+	}
+
+	data := make([]byte, e.chunkSize)
+	n, err := e.r.ReadAt(data, e.dataOffset+index*e.chunkSize)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read encrypted chunk %d: %w", index, err)
+	}
+	// A truncated final chunk is decrypted up to its last whole AES block
+	n -= n % aes.BlockSize
+	clear(data[n:])
+
+	mac := hmac.New(sha1.New, e.hmacKey)
+	binary.Write(mac, binary.BigEndian, uint32(index))
+	iv := mac.Sum(nil)[:aes.BlockSize]
+	cipher.NewCBCDecrypter(e.cipher, iv).CryptBlocks(data[:n], data[:n])
+
+	e.chunks.put(index, data)
+	return data, nil
+}
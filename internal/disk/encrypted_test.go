@@ -0,0 +1,249 @@
+package disk
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deploymenttheory/go-apfs/internal/kdf"
+)
+
+// buildEncryptedImage encrypts payload into an encrcdsa v2 image unlocked by
+// password. A certificate key pointer precedes the password key blob.
+func buildEncryptedImage(t *testing.T, payload []byte, password string, keyBits int) []byte {
+	t.Helper()
+	const (
+		chunkSize  = 4096
+		keyOffset  = 512
+		dataOffset = 4096
+		iterations = 1000
+	)
+	salt := []byte("0123456789abcdefghij")
+	iv := []byte("iv-bytes")
+	keys := bytes.Repeat([]byte("k3y!"), 12)[:40]
+
+	// Wrap the keys with 3DES-CBC after PKCS#7 padding
+	pad := des.BlockSize - len(keys)%des.BlockSize
+	wrapped := append(append([]byte{}, keys...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	block, err := des.NewTripleDESCipher(kdf.PBKDF2(sha1.New, []byte(password), salt, iterations, encryptedBlobKeySize))
+	require.NoError(t, err)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(wrapped, wrapped)
+
+	blob := make([]byte, encryptedPasswordHeaderSize+len(wrapped))
+	binary.BigEndian.PutUint32(blob[0:], encryptedKDFPBKDF2)
+	binary.BigEndian.PutUint32(blob[8:], iterations)
+	binary.BigEndian.PutUint32(blob[12:], uint32(len(salt)))
+	copy(blob[16:], salt)
+	binary.BigEndian.PutUint32(blob[48:], uint32(len(iv)))
+	copy(blob[52:], iv)
+	binary.BigEndian.PutUint32(blob[84:], 192)
+	binary.BigEndian.PutUint32(blob[88:], 17)
+	binary.BigEndian.PutUint32(blob[92:], 7)
+	binary.BigEndian.PutUint32(blob[96:], 6)
+	binary.BigEndian.PutUint32(blob[100:], uint32(len(wrapped)))
+	copy(blob[encryptedPasswordHeaderSize:], wrapped)
+
+	img := make([]byte, dataOffset)
+	copy(img, encryptedMagic)
+	binary.BigEndian.PutUint32(img[8:], 2)
+	binary.BigEndian.PutUint32(img[12:], 16)
+	binary.BigEndian.PutUint32(img[24:], uint32(keyBits))
+	binary.BigEndian.PutUint32(img[52:], chunkSize)
+	binary.BigEndian.PutUint64(img[56:], uint64(len(payload)))
+	binary.BigEndian.PutUint64(img[64:], dataOffset)
+	binary.BigEndian.PutUint32(img[72:], 2)
+	binary.BigEndian.PutUint32(img[76:], 2)
+	binary.BigEndian.PutUint32(img[96:], encryptedKeyPassword)
+	binary.BigEndian.PutUint64(img[100:], keyOffset)
+	binary.BigEndian.PutUint64(img[108:], uint64(len(blob)))
+	copy(img[keyOffset:], blob)
+
+	aesBlock, err := aes.NewCipher(keys[:keyBits/8])
+	require.NoError(t, err)
+	for i := 0; i*chunkSize < len(payload); i++ {
+		chunk := make([]byte, chunkSize)
+		copy(chunk, payload[i*chunkSize:])
+
+		mac := hmac.New(sha1.New, keys[:sha1.Size])
+		binary.Write(mac, binary.BigEndian, uint32(i))
+		cipher.NewCBCEncrypter(aesBlock, mac.Sum(nil)[:aes.BlockSize]).CryptBlocks(chunk, chunk)
+		img = append(img, chunk...)
+	}
+	return img
+}
+
+// wrapKeyV1 wraps key with the RFC 3217 3DES key wrap used by cdsaencr
+// trailers, after a 4 byte prefix
+func wrapKeyV1(t *testing.T, kek, key []byte) []byte {
+	t.Helper()
+	block, err := des.NewTripleDESCipher(kek)
+	require.NoError(t, err)
+	pad := func(b []byte) []byte {
+		n := des.BlockSize - len(b)%des.BlockSize
+		return append(b, bytes.Repeat([]byte{byte(n)}, n)...)
+	}
+
+	inner := pad(append([]byte{0, 0, 0, byte(len(key))}, key...))
+	iv := []byte("wrap-iv!")
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(inner, inner)
+
+	outer := append(append([]byte{}, iv...), inner...)
+	slices.Reverse(outer)
+	outer = pad(outer)
+	cipher.NewCBCEncrypter(block, encryptedV1WrapIV).CryptBlocks(outer, outer)
+	return outer
+}
+
+// buildEncryptedImageV1 encrypts payload into a cdsaencr v1 image unlocked
+// by password, with the key trailer after the payload chunks
+func buildEncryptedImageV1(t *testing.T, payload []byte, password string) []byte {
+	t.Helper()
+	const iterations = 1000
+	salt := []byte("0123456789abcdefghij")
+	aesKey := []byte("0123456789abcdef")
+	hmacKey := []byte("hmac-key-of-20-bytes")
+
+	kek := kdf.PBKDF2(sha1.New, []byte(password), salt, iterations, encryptedBlobKeySize)
+	wrappedAES := wrapKeyV1(t, kek, aesKey)
+	wrappedHMAC := wrapKeyV1(t, kek, hmacKey)
+
+	trailer := make([]byte, encryptedV1TrailerSize)
+	binary.BigEndian.PutUint32(trailer[48:], iterations)
+	binary.BigEndian.PutUint32(trailer[52:], uint32(len(salt)))
+	copy(trailer[56:], salt)
+	binary.BigEndian.PutUint32(trailer[136:], uint32(len(wrappedAES)))
+	copy(trailer[140:], wrappedAES)
+	binary.BigEndian.PutUint32(trailer[436:], uint32(len(wrappedHMAC)))
+	copy(trailer[440:], wrappedHMAC)
+	copy(trailer[encryptedV1TrailerSize-8:], encryptedMagicV1)
+
+	aesBlock, err := aes.NewCipher(aesKey)
+	require.NoError(t, err)
+	var img []byte
+	for i := 0; i*encryptedV1ChunkSize < len(payload); i++ {
+		chunk := make([]byte, encryptedV1ChunkSize)
+		copy(chunk, payload[i*encryptedV1ChunkSize:])
+
+		mac := hmac.New(sha1.New, hmacKey)
+		binary.Write(mac, binary.BigEndian, uint32(i))
+		cipher.NewCBCEncrypter(aesBlock, mac.Sum(nil)[:aes.BlockSize]).CryptBlocks(chunk, chunk)
+		img = append(img, chunk...)
+	}
+	return append(img, trailer...)
+}
+
+func TestEncryptedImage(t *testing.T) {
+	payload := bytes.Repeat([]byte("decrypted payload "), 800)
+
+	for _, keyBits := range []int{128, 256} {
+		img := buildEncryptedImage(t, payload, "hunter2", keyBits)
+		require.True(t, IsEncryptedImage(bytes.NewReader(img), int64(len(img))))
+
+		e, err := OpenEncryptedImage(bytes.NewReader(img), int64(len(img)), "hunter2")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(payload)), e.Size())
+
+		got, err := io.ReadAll(io.NewSectionReader(e, 0, e.Size()))
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(payload, got), "AES-%d", keyBits)
+
+		// A read spanning two chunks
+		buf := make([]byte, 100)
+		_, err = e.ReadAt(buf, 4096-50)
+		require.NoError(t, err)
+		assert.Equal(t, payload[4096-50:4096+50], buf)
+	}
+}
+
+func TestEncryptedImagePasswords(t *testing.T) {
+	img := buildEncryptedImage(t, bytes.Repeat([]byte{1}, 4096), "correct horse", 128)
+
+	_, err := OpenEncryptedImage(bytes.NewReader(img), int64(len(img)), "battery staple")
+	assert.ErrorIs(t, err, ErrBadPassword)
+
+	_, err = OpenEncryptedImage(bytes.NewReader(img), int64(len(img)), "")
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+
+	path := filepath.Join(t.TempDir(), "image.dmg")
+	require.NoError(t, os.WriteFile(path, img, 0o644))
+	_, err = OpenImage(path)
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+
+	_, err = OpenEncryptedImage(bytes.NewReader(make([]byte, 4096)), 4096, "password")
+	assert.ErrorIs(t, err, ErrNotEncryptedImage)
+}
+
+func TestEncryptedImageV1(t *testing.T) {
+	disk := make([]byte, 8*4096)
+	writeNXSuperblock(disk, 0, 8)
+	copy(disk[5*4096:], "payload")
+	img := buildEncryptedImageV1(t, disk, "hunter2")
+	require.True(t, IsEncryptedImage(bytes.NewReader(img), int64(len(img))))
+
+	e, err := OpenEncryptedImage(bytes.NewReader(img), int64(len(img)), "hunter2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(disk)), e.Size())
+	got, err := io.ReadAll(io.NewSectionReader(e, 0, e.Size()))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(disk, got))
+
+	_, err = OpenEncryptedImage(bytes.NewReader(img), int64(len(img)), "hunter3")
+	assert.ErrorIs(t, err, ErrBadPassword)
+	_, err = OpenEncryptedImage(bytes.NewReader(img), int64(len(img)), "")
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+
+	path := filepath.Join(t.TempDir(), "v1.dmg")
+	require.NoError(t, os.WriteFile(path, img, 0o644))
+	device, err := OpenDMG(path, &DMGConfig{AutoDetectAPFS: true, CacheSize: 1, Password: "hunter2"})
+	require.NoError(t, err)
+	defer device.Close()
+	assert.Equal(t, "encrypted", device.Format())
+	buf := make([]byte, 7)
+	_, err = device.ReadAt(buf, 5*4096)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(buf))
+}
+
+func TestOpenImageEncryptedFormats(t *testing.T) {
+	udif, want := udifFixture(t, true)
+
+	disk := make([]byte, 8*4096)
+	writeNXSuperblock(disk, 0, 8)
+	copy(disk[5*4096:], "payload")
+
+	dir := t.TempDir()
+	udifPath := filepath.Join(dir, "udif.dmg")
+	rawPath := filepath.Join(dir, "raw.dmg")
+	require.NoError(t, os.WriteFile(udifPath, buildEncryptedImage(t, udif, "secret", 256), 0o644))
+	require.NoError(t, os.WriteFile(rawPath, buildEncryptedImage(t, disk, "secret", 128), 0o644))
+
+	img, err := OpenImageWithPassword(udifPath, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, "encrypted-udif", img.Format())
+	got, err := io.ReadAll(io.NewSectionReader(img, 0, img.Size()))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(want, got))
+	require.NoError(t, img.Close())
+
+	device, err := OpenDMG(rawPath, &DMGConfig{AutoDetectAPFS: true, CacheSize: 1, Password: "secret"})
+	require.NoError(t, err)
+	defer device.Close()
+	assert.Equal(t, "encrypted", device.Format())
+	assert.Equal(t, int64(len(disk)), device.Size())
+	buf := make([]byte, 7)
+	_, err = device.ReadAt(buf, 5*4096)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(buf))
+}
@@ -0,0 +1,389 @@
+package disk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Expert Witness Format (EWF, EnCase .E01) version 1 layout. An image is
+// split into segment files, each starting with a 13 byte file header
+// followed by a chain of sections. Every section starts with a 76 byte
+// descriptor holding its type, the offset of the next section and its size.
+// The media is stored as chunks, zlib compressed or followed by an Adler-32
+// checksum, located through table sections. All fields are little-endian.
+const (
+	ewfSignature      = "EVF\x09\x0d\x0a\xff\x00"
+	ewfFileHeaderSize = 13
+	ewfSectionSize    = 76
+
+	ewfTableHeaderSize = 24
+	ewfVolumeMinSize   = 24
+	ewfHashSize        = 16
+
+	// ewfChunkCompressed marks a compressed chunk in a table entry; the other
+	// bits hold the chunk offset
+	ewfChunkCompressed = 1 << 31
+
+	// defaultEWFCacheChunks is the number of decompressed chunks kept
+	defaultEWFCacheChunks = 64
+)
+
+var (
+	// ErrNotEWF is returned by OpenEWF when the file header signature is
+	// missing
+	ErrNotEWF = errors.New("not an EWF image")
+
+	// ErrEWFHashMismatch is returned by Verify when the media does not match
+	// the MD5 hash stored in the image
+	ErrEWFHashMismatch = errors.New("EWF media does not match its stored MD5 hash")
+)
+
+// EWFImage presents the media stored in the segment files of an EWF v1
+// image as a random access device
+type EWFImage struct {
+	segments  []*os.File
+	size      int64
+	chunkSize int64
+	chunks    []ewfChunk
+	md5       []byte
+
+	mu    sync.Mutex
+	cache *lruCache[int64, []byte]
+}
+
+// ewfChunk locates a stored chunk in a segment file
+type ewfChunk struct {
+	segment    int
+	offset     int64
+	size       int64
+	compressed bool
+}
+
+// IsEWF reports whether r starts with an EWF v1 file header
+func IsEWF(r io.ReaderAt) bool {
+	signature := make([]byte, len(ewfSignature))
+	if _, err := r.ReadAt(signature, 0); err != nil {
+		return false
+	}
+	return string(signature) == ewfSignature
+}
+
+// OpenEWF opens the EWF image whose first segment file is at path. The
+// following segments are found by their extension: .E02 to .E99, then .EAA
+// onwards.
+func OpenEWF(path string) (*EWFImage, error) {
+	img := &EWFImage{cache: newLRUCache[int64, []byte](defaultEWFCacheChunks, nil)}
+
+	for number := 1; ; number++ {
+		name := path
+		if number > 1 {
+			var err error
+			if name, err = ewfSegmentPath(path, number); err != nil {
+				img.Close()
+				return nil, err
+			}
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			img.Close()
+			if number > 1 && errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("EWF segment %d is missing: %w", number, err)
+			}
+			return nil, fmt.Errorf("failed to open EWF segment: %w", err)
+		}
+		img.segments = append(img.segments, file)
+
+		done, err := img.readSegment(number, file)
+		if err != nil {
+			img.Close()
+			return nil, fmt.Errorf("EWF segment %s: %w", filepath.Base(name), err)
+		}
+		if done {
+			break
+		}
+	}
+
+	if img.chunkSize == 0 {
+		img.Close()
+		return nil, fmt.Errorf("EWF image has no volume section")
+	}
+	if count := (img.size + img.chunkSize - 1) / img.chunkSize; int64(len(img.chunks)) < count {
+		img.Close()
+		return nil, fmt.Errorf("EWF image holds %d of its %d chunks", len(img.chunks), count)
+	}
+	return img, nil
+}
+
+// readSegment walks the sections of a segment file, reporting whether it
+// ends with the done section of the last segment
+func (e *EWFImage) readSegment(number int, file *os.File) (bool, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+
+	header := make([]byte, ewfFileHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return false, fmt.Errorf("failed to read file header: %w", err)
+	}
+	if string(header[:8]) != ewfSignature {
+		return false, ErrNotEWF
+	}
+	if n := binary.LittleEndian.Uint16(header[9:11]); int(n) != number {
+		return false, fmt.Errorf("file header names segment %d, expected %d", n, number)
+	}
+
+	index := len(e.segments) - 1
+	sectorsEnd := int64(-1)
+	desc := make([]byte, ewfSectionSize)
+	for offset := int64(ewfFileHeaderSize); ; {
+		if _, err := file.ReadAt(desc, offset); err != nil {
+			return false, fmt.Errorf("failed to read section at %d: %w", offset, err)
+		}
+		if sum := binary.LittleEndian.Uint32(desc[72:76]); sum != adler32.Checksum(desc[:72]) {
+			return false, fmt.Errorf("section descriptor at %d has a bad checksum", offset)
+		}
+
+		kind := strings.TrimRight(string(desc[:16]), "\x00")
+		next := int64(binary.LittleEndian.Uint64(desc[16:24]))
+		sectionSize := int64(binary.LittleEndian.Uint64(desc[24:32]))
+		if sectionSize < ewfSectionSize || sectionSize > size-offset {
+			return false, fmt.Errorf("%s section at %d has invalid size %d", kind, offset, sectionSize)
+		}
+		body := io.NewSectionReader(file, offset+ewfSectionSize, sectionSize-ewfSectionSize)
+
+		switch kind {
+		case "volume", "disk":
+			if err := e.readVolume(body); err != nil {
+				return false, err
+			}
+		case "sectors":
+			sectorsEnd = offset + sectionSize
+		case "table":
+			end := offset
+			if sectorsEnd > 0 {
+				end = sectorsEnd
+			}
+			if err := e.readTable(body, index, end, offset+sectionSize); err != nil {
+				return false, err
+			}
+		case "hash", "digest":
+			sum := make([]byte, ewfHashSize)
+			if _, err := body.ReadAt(sum, 0); err != nil {
+				return false, fmt.Errorf("failed to read %s section: %w", kind, err)
+			}
+			e.md5 = sum
+		case "next":
+			return false, nil
+		case "done":
+			return true, nil
+		}
+
+		if next <= offset || next >= size {
+			return false, fmt.Errorf("%s section at %d points to invalid next section %d", kind, offset, next)
+		}
+		offset = next
+	}
+}
+
+// readVolume decodes the media geometry from a volume or disk section
+func (e *EWFImage) readVolume(r *io.SectionReader) error {
+	data := make([]byte, ewfVolumeMinSize)
+	if _, err := r.ReadAt(data, 0); err != nil {
+		return fmt.Errorf("failed to read volume section: %w", err)
+	}
+	sectorsPerChunk := int64(binary.LittleEndian.Uint32(data[8:12]))
+	bytesPerSector := int64(binary.LittleEndian.Uint32(data[12:16]))
+	sectors := int64(binary.LittleEndian.Uint64(data[16:24]))
+	if sectorsPerChunk == 0 || bytesPerSector == 0 || sectorsPerChunk*bytesPerSector > 1<<26 {
+		return fmt.Errorf("volume section has invalid geometry: %d sectors of %d bytes per chunk", sectorsPerChunk, bytesPerSector)
+	}
+
+	e.chunkSize = sectorsPerChunk * bytesPerSector
+	e.size = sectors * bytesPerSector
+	return nil
+}
+
+// readTable appends the chunks listed in a table section. The last chunk
+// ends at end, or at the end of the table section when the chunks are
+// stored inside it.
+func (e *EWFImage) readTable(r *io.SectionReader, segment int, end, tableEnd int64) error {
+	if e.chunkSize == 0 {
+		return fmt.Errorf("table section precedes the volume section")
+	}
+
+	header := make([]byte, ewfTableHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("failed to read table header: %w", err)
+	}
+	count := int64(binary.LittleEndian.Uint32(header[0:4]))
+	base := int64(binary.LittleEndian.Uint64(header[8:16]))
+	if count*4 > r.Size()-ewfTableHeaderSize {
+		return fmt.Errorf("table lists %d chunks but holds %d", count, (r.Size()-ewfTableHeaderSize)/4)
+	}
+
+	entries := make([]byte, count*4)
+	if _, err := r.ReadAt(entries, ewfTableHeaderSize); err != nil {
+		return fmt.Errorf("failed to read table entries: %w", err)
+	}
+
+	chunks := make([]ewfChunk, count)
+	for i := range chunks {
+		entry := binary.LittleEndian.Uint32(entries[i*4:])
+		chunks[i] = ewfChunk{
+			segment:    segment,
+			offset:     base + int64(entry&^ewfChunkCompressed),
+			compressed: entry&ewfChunkCompressed != 0,
+		}
+	}
+	for i := range chunks {
+		next := end
+		if i+1 < len(chunks) {
+			next = chunks[i+1].offset
+		} else if chunks[i].offset >= end {
+			next = tableEnd
+		}
+		if next <= chunks[i].offset {
+			return fmt.Errorf("table entry %d has invalid offset %d", i, chunks[i].offset)
+		}
+		chunks[i].size = next - chunks[i].offset
+	}
+
+	e.chunks = append(e.chunks, chunks...)
+	return nil
+}
+
+// Size returns the size of the media in bytes
+func (e *EWFImage) Size() int64 {
+	return e.size
+}
+
+// MD5 returns the MD5 hash of the media recorded by the acquiring tool, or
+// nil when the image has no hash section
+func (e *EWFImage) MD5() []byte {
+	return e.md5
+}
+
+// Verify hashes the whole media and compares it with the stored MD5 hash
+func (e *EWFImage) Verify() error {
+	if e.md5 == nil {
+		return fmt.Errorf("EWF image has no hash section: %w", ErrNoStoredHash)
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, io.NewSectionReader(e, 0, e.size)); err != nil {
+		return fmt.Errorf("failed to hash EWF media: %w", err)
+	}
+	if !bytes.Equal(h.Sum(nil), e.md5) {
+		return ErrEWFHashMismatch
+	}
+	return nil
+}
+
+// ReadAt reads from the media
+func (e *EWFImage) ReadAt(p []byte, off int64) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return readBands(p, off, e.size, e.chunkSize, func(chunk int64, dst []byte, chunkOff int64) error {
+		data, err := e.chunk(chunk)
+		if err != nil {
+			return err
+		}
+		n := 0
+		if chunkOff < int64(len(data)) {
+			n = copy(dst, data[chunkOff:])
+		}
+		clear(dst[n:])
+		return nil
+	})
+}
+
+// chunk returns the decompressed contents of a chunk
+func (e *EWFImage) chunk(index int64) ([]byte, error) {
+	if data, ok := e.cache.get(index); ok {
+		return data, nil
+	}
+
+	c := e.chunks[index]
+	stored := make([]byte, c.size)
+	if _, err := e.segments[c.segment].ReadAt(stored, c.offset); err != nil {
+		return nil, fmt.Errorf("failed to read EWF chunk %d: %w", index, err)
+	}
+
+	var data []byte
+	if c.compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(stored))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress EWF chunk %d: %w", index, err)
+		}
+		data, err = io.ReadAll(io.LimitReader(zr, e.chunkSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress EWF chunk %d: %w", index, err)
+		}
+	} else {
+		if len(stored) < 4 {
+			return nil, fmt.Errorf("EWF chunk %d is truncated", index)
+		}
+		data = stored[:len(stored)-4]
+		if len(data) > int(e.chunkSize) {
+			data = data[:e.chunkSize]
+		}
+		if sum := binary.LittleEndian.Uint32(stored[len(data):]); sum != adler32.Checksum(data) {
+			return nil, fmt.Errorf("EWF chunk %d has a bad checksum", index)
+		}
+	}
+
+	e.cache.put(index, data)
+	return data, nil
+}
+
+// Close closes the segment files
+func (e *EWFImage) Close() error {
+	var first error
+	for _, f := range e.segments {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	e.segments = nil
+	return first
+}
+
+// ewfSegmentPath returns the path of segment number of the image whose first
+// segment is at path. Segments 1 to 99 use two digits after the extension
+// letter; later segments use letters, from AA to ZZ, carrying into the
+// extension letter.
+func ewfSegmentPath(path string, number int) (string, error) {
+	ext := filepath.Ext(path)
+	if len(ext) != 4 {
+		return "", fmt.Errorf("EWF segment %s does not have an extension such as .E01", path)
+	}
+	base := path[:len(path)-len(ext)]
+	first := ext[1]
+	if number < 100 {
+		return fmt.Sprintf("%s.%c%02d", base, first, number), nil
+	}
+
+	letter := func(b byte, n int) byte {
+		if b >= 'a' && b <= 'z' {
+			return 'a' + byte(n)
+		}
+		return 'A' + byte(n)
+	}
+	n := number - 100
+	lead := int(first|0x20-'a') + n/(26*26)
+	if lead >= 26 {
+		return "", fmt.Errorf("EWF segment number %d is too large", number)
+	}
+	return fmt.Sprintf("%s.%c%c%c", base, letter(first, lead), letter(first, n/26%26), letter(first, n%26)), nil
+}
@@ -0,0 +1,258 @@
+package disk
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ewfSegmentWriter assembles the sections of one EWF segment file
+type ewfSegmentWriter struct {
+	buf bytes.Buffer
+}
+
+func newEWFSegmentWriter(number int) *ewfSegmentWriter {
+	w := &ewfSegmentWriter{}
+	w.buf.WriteString(ewfSignature)
+	w.buf.WriteByte(1)
+	binary.Write(&w.buf, binary.LittleEndian, uint16(number))
+	binary.Write(&w.buf, binary.LittleEndian, uint16(0))
+	return w
+}
+
+// section appends a section; next and done sections point to themselves
+func (w *ewfSegmentWriter) section(kind string, body []byte) {
+	desc := make([]byte, ewfSectionSize)
+	copy(desc, kind)
+	offset := int64(w.buf.Len())
+	next := offset + ewfSectionSize + int64(len(body))
+	if kind == "next" || kind == "done" {
+		next = offset
+	}
+	binary.LittleEndian.PutUint64(desc[16:], uint64(next))
+	binary.LittleEndian.PutUint64(desc[24:], uint64(ewfSectionSize+len(body)))
+	binary.LittleEndian.PutUint32(desc[72:], adler32.Checksum(desc[:72]))
+	w.buf.Write(desc)
+	w.buf.Write(body)
+}
+
+// buildEWF writes media as an EWF image with chunksPerSegment chunks in each
+// segment file, compressing the chunks for which compress returns true. It
+// returns the path of the first segment.
+func buildEWF(t *testing.T, media []byte, sectorsPerChunk, chunksPerSegment int, compress func(int) bool) string {
+	t.Helper()
+	chunkSize := sectorsPerChunk * 512
+	chunkCount := (len(media) + chunkSize - 1) / chunkSize
+	segments := (chunkCount + chunksPerSegment - 1) / chunksPerSegment
+	dir := t.TempDir()
+
+	volume := make([]byte, 1052)
+	binary.LittleEndian.PutUint32(volume[4:], uint32(chunkCount))
+	binary.LittleEndian.PutUint32(volume[8:], uint32(sectorsPerChunk))
+	binary.LittleEndian.PutUint32(volume[12:], 512)
+	binary.LittleEndian.PutUint64(volume[16:], uint64(len(media)/512))
+
+	for s := 0; s < segments; s++ {
+		w := newEWFSegmentWriter(s + 1)
+		if s == 0 {
+			w.section("header", zlibChunk(t, []byte("1\nmain\nc\tn\ttest\t\n")))
+			w.section("volume", volume)
+		}
+
+		// Chunk offsets are relative to the start of the segment file
+		sectorsStart := w.buf.Len() + ewfSectionSize
+		var data, entries []byte
+		for i := s * chunksPerSegment; i < min((s+1)*chunksPerSegment, chunkCount); i++ {
+			chunk := media[i*chunkSize : min((i+1)*chunkSize, len(media))]
+			entry := uint32(sectorsStart + len(data))
+			if compress(i) {
+				data = append(data, zlibChunk(t, chunk)...)
+				entry |= ewfChunkCompressed
+			} else {
+				data = append(data, chunk...)
+				data = binary.LittleEndian.AppendUint32(data, adler32.Checksum(chunk))
+			}
+			entries = binary.LittleEndian.AppendUint32(entries, entry)
+		}
+		w.section("sectors", data)
+
+		table := make([]byte, ewfTableHeaderSize)
+		binary.LittleEndian.PutUint32(table, uint32(len(entries)/4))
+		binary.LittleEndian.PutUint32(table[20:], adler32.Checksum(table[:20]))
+		table = append(table, entries...)
+		table = binary.LittleEndian.AppendUint32(table, adler32.Checksum(entries))
+		w.section("table", table)
+		w.section("table2", table)
+
+		if s == segments-1 {
+			sum := md5.Sum(media)
+			w.section("hash", append(sum[:], make([]byte, 20)...))
+			w.section("done", nil)
+		} else {
+			w.section("next", nil)
+		}
+
+		name, err := ewfSegmentPath(filepath.Join(dir, "image.E01"), s+1)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(name, w.buf.Bytes(), 0o644))
+	}
+	return filepath.Join(dir, "image.E01")
+}
+
+// ewfMedia returns test media whose chunks differ from one another
+func ewfMedia(size int) []byte {
+	media := make([]byte, size)
+	for i := range media {
+		media[i] = byte(i/512) ^ byte(i%251)
+	}
+	return media
+}
+
+func TestEWFImage(t *testing.T) {
+	media := ewfMedia(23 * 512)
+	path := buildEWF(t, media, 2, 3, func(i int) bool { return i%2 == 0 })
+	require.True(t, IsEWF(mustOpen(t, path)))
+
+	e, err := OpenEWF(path)
+	require.NoError(t, err)
+	defer e.Close()
+	assert.Len(t, e.segments, 4)
+	assert.Equal(t, int64(len(media)), e.Size())
+
+	got, err := io.ReadAll(io.NewSectionReader(e, 0, e.Size()))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(media, got))
+
+	// A read spanning two chunks in different segments
+	buf := make([]byte, 300)
+	_, err = e.ReadAt(buf, 6*512-150)
+	require.NoError(t, err)
+	assert.Equal(t, media[6*512-150:6*512+150], buf)
+
+	sum := md5.Sum(media)
+	assert.Equal(t, sum[:], e.MD5())
+	assert.NoError(t, e.Verify())
+
+	e.md5[0] ^= 0xff
+	assert.ErrorIs(t, e.Verify(), ErrEWFHashMismatch)
+}
+
+func TestEWFImageDamage(t *testing.T) {
+	path := buildEWF(t, ewfMedia(16*512), 2, 4, func(int) bool { return false })
+
+	// A corrupted stored chunk fails its checksum
+	first, err := os.ReadFile(path)
+	require.NoError(t, err)
+	sectors := bytes.Index(first, []byte("sectors"))
+	first[sectors+ewfSectionSize+10] ^= 0xff
+	require.NoError(t, os.WriteFile(path, first, 0o644))
+
+	e, err := OpenEWF(path)
+	require.NoError(t, err)
+	_, err = e.ReadAt(make([]byte, 512), 0)
+	assert.ErrorContains(t, err, "bad checksum")
+	require.NoError(t, e.Close())
+
+	// A missing segment file is reported when opening
+	second, err := ewfSegmentPath(path, 2)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(second))
+	_, err = OpenEWF(path)
+	assert.ErrorContains(t, err, "segment 2 is missing")
+}
+
+// corruptEWFChunk flips a byte of a stored chunk in the first segment of an
+// image built with uncompressed chunks, and updates its Adler-32 checksum so
+// that only the stored hash can detect the change
+func corruptEWFChunk(t *testing.T, path string, chunk, chunkSize int) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	start := bytes.Index(data, []byte("sectors")) + ewfSectionSize + chunk*(chunkSize+4)
+	stored := data[start : start+chunkSize]
+	stored[10] ^= 0xff
+	binary.LittleEndian.PutUint32(data[start+chunkSize:], adler32.Checksum(stored))
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+}
+
+func TestVerifyImage(t *testing.T) {
+	media := ewfMedia(16 * 512)
+	path := buildEWF(t, media, 2, 8, func(int) bool { return false })
+
+	img, err := OpenImage(path)
+	require.NoError(t, err)
+	assert.Equal(t, "ewf", img.Format())
+	assert.NoError(t, img.Verify())
+	require.NoError(t, img.Close())
+
+	corruptEWFChunk(t, path, 3, 1024)
+	img, err = OpenImage(path)
+	require.NoError(t, err)
+	defer img.Close()
+	assert.ErrorIs(t, img.Verify(), ErrEWFHashMismatch)
+
+	raw := filepath.Join(t.TempDir(), "disk.img")
+	require.NoError(t, os.WriteFile(raw, media, 0o644))
+	rawImg, err := OpenImage(raw)
+	require.NoError(t, err)
+	defer rawImg.Close()
+	assert.ErrorIs(t, rawImg.Verify(), ErrNoStoredHash)
+}
+
+func TestEWFSegmentPath(t *testing.T) {
+	for number, want := range map[int]string{
+		2:   "case.E02",
+		99:  "case.E99",
+		100: "case.EAA",
+		101: "case.EAB",
+		126: "case.EBA",
+		775: "case.EZZ",
+		776: "case.FAA",
+	} {
+		got, err := ewfSegmentPath("case.E01", number)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	got, err := ewfSegmentPath("case.e01", 100)
+	require.NoError(t, err)
+	assert.Equal(t, "case.eaa", got)
+
+	_, err = ewfSegmentPath("case.E01", 100+22*26*26)
+	assert.Error(t, err)
+}
+
+func TestOpenDMGEWF(t *testing.T) {
+	disk := make([]byte, 8*4096)
+	writeNXSuperblock(disk, 0, 8)
+	copy(disk[5*4096:], "payload")
+	path := buildEWF(t, disk, 8, 5, func(i int) bool { return i != 3 })
+
+	device, err := OpenDMG(path, &DMGConfig{AutoDetectAPFS: true, CacheSize: 1})
+	require.NoError(t, err)
+	defer device.Close()
+
+	assert.Equal(t, "ewf", device.Format())
+	assert.Equal(t, int64(len(disk)), device.Size())
+	buf := make([]byte, 7)
+	_, err = device.ReadAt(buf, 5*4096)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(buf))
+}
+
+// mustOpen opens path for the duration of the test
+func mustOpen(t *testing.T, path string) *os.File {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
@@ -0,0 +1,134 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNoStoredHash is returned when verifying an image whose format does not
+// record a hash of the media
+var ErrNoStoredHash = errors.New("image has no stored hash")
+
+// Image is a whole-disk image opened for random access. Container formats
+// such as UDIF are unwrapped, so reads see the raw disk.
+type Image interface {
+	io.ReaderAt
+	io.Closer
+
+	// Size returns the size of the disk in bytes
+	Size() int64
+
+	// Format names the image format: "raw", "split-raw", "udif",
+	// "sparseimage", "sparsebundle", "encrypted", "encrypted-udif" or "ewf"
+	Format() string
+
+	// Verify hashes the whole disk and compares it with the hash recorded by
+	// the acquiring tool. Formats without one fail with ErrNoStoredHash.
+	Verify() error
+}
+
+// OpenImage opens the disk image or block device at path, detecting its
+// format from its contents. Encrypted images fail with ErrPasswordRequired.
+func OpenImage(path string) (Image, error) {
+	return OpenImageWithPassword(path, "")
+}
+
+// OpenImageWithPassword is OpenImage for images that may be encrypted, which
+// are unlocked with password
+func OpenImageWithPassword(path, password string) (Image, error) {
+	if IsSparseBundle(path) {
+		bundle, err := OpenSparseBundle(path)
+		if err != nil {
+			return nil, err
+		}
+		return &formatImage{ReaderAt: bundle, closer: bundle, size: bundle.Size(), format: "sparsebundle"}, nil
+	}
+
+	if IsSplitRaw(path) {
+		split, err := OpenSplitRaw(path)
+		if err != nil {
+			return nil, err
+		}
+		return &formatImage{ReaderAt: split, closer: split, size: split.Size(), format: "split-raw"}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+
+	// Block devices report a zero size from Stat, so seek instead
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to determine image size: %w", err)
+	}
+
+	if IsEWF(file) {
+		file.Close()
+		ewf, err := OpenEWF(path)
+		if err != nil {
+			return nil, err
+		}
+		return &formatImage{ReaderAt: ewf, closer: ewf, size: ewf.Size(), format: "ewf", verify: ewf.Verify}, nil
+	}
+
+	if IsSparseImage(file) {
+		sparse, err := OpenSparseImage(file, size)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &formatImage{ReaderAt: sparse, closer: file, size: sparse.Size(), format: "sparseimage"}, nil
+	}
+
+	if IsEncryptedImage(file, size) {
+		encrypted, err := OpenEncryptedImage(file, size, password)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if !IsUDIF(encrypted, encrypted.Size()) {
+			return &formatImage{ReaderAt: encrypted, closer: file, size: encrypted.Size(), format: "encrypted"}, nil
+		}
+		udif, err := OpenUDIF(encrypted, encrypted.Size())
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &formatImage{ReaderAt: udif, closer: file, size: udif.Size(), format: "encrypted-udif"}, nil
+	}
+
+	if IsUDIF(file, size) {
+		udif, err := OpenUDIF(file, size)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &formatImage{ReaderAt: udif, closer: file, size: udif.Size(), format: "udif"}, nil
+	}
+
+	return &formatImage{ReaderAt: file, closer: file, size: size, format: "raw"}, nil
+}
+
+// formatImage pairs a decoded image with the file backing it
+type formatImage struct {
+	io.ReaderAt
+	closer io.Closer
+	size   int64
+	format string
+	verify func() error // nil when the format stores no hash
+}
+
+func (i *formatImage) Size() int64    { return i.size }
+func (i *formatImage) Format() string { return i.format }
+func (i *formatImage) Close() error   { return i.closer.Close() }
+
+func (i *formatImage) Verify() error {
+	if i.verify == nil {
+		return fmt.Errorf("%s image: %w", i.format, ErrNoStoredHash)
+	}
+	return i.verify()
+}
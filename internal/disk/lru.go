@@ -0,0 +1,63 @@
+package disk
+
+import "container/list"
+
+// lruCache is a least recently used cache. It is not safe for concurrent use.
+type lruCache[K comparable, V any] struct {
+	capacity int
+	order    *list.List
+	entries  map[K]*list.Element
+	evict    func(K, V)
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// newLRUCache returns a cache holding up to capacity entries. evict, when
+// not nil, is called for every entry dropped from the cache.
+func newLRUCache[K comparable, V any](capacity int, evict func(K, V)) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[K]*list.Element),
+		evict:    evict,
+	}
+}
+
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+func (c *lruCache[K, V]) put(key K, value V) {
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// clear drops every entry
+func (c *lruCache[K, V]) clear() {
+	for c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lruCache[K, V]) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*lruEntry[K, V])
+	delete(c.entries, entry.key)
+	if c.evict != nil {
+		c.evict(entry.key, entry.value)
+	}
+}
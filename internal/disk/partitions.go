@@ -0,0 +1,555 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// PartitionScheme identifies the partition table a partition was found in
+type PartitionScheme string
+
+const (
+	SchemeNone PartitionScheme = "none"
+	SchemeGPT  PartitionScheme = "gpt"
+	SchemeMBR  PartitionScheme = "mbr"
+)
+
+// Well known GPT partition type GUIDs found on Mac disks
+const (
+	GPTTypeEFISystem       = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
+	GPTTypeAPFS            = types.ApfsGptPartitionUUID
+	GPTTypeHFSPlus         = "48465300-0000-11AA-AA11-00306543ECAC"
+	GPTTypeAppleBoot       = "426F6F74-0000-11AA-AA11-00306543ECAC"
+	GPTTypeCoreStorage     = "53746F72-6167-11AA-AA11-00306543ECAC"
+	GPTTypeBasicData       = "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"
+	GPTTypeMicrosoftRsvd   = "E3C9E316-0B5C-4DB8-817D-F92DF00215AE"
+	GPTTypeWindowsRecovery = "DE94BBA4-06D1-4D40-A16A-BFD50179D6AC"
+	GPTTypeLinux           = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+)
+
+// MBR partition types with special meaning to the scanner
+const (
+	mbrTypeEmpty         = 0x00
+	mbrTypeExtendedCHS   = 0x05
+	mbrTypeExtendedLBA   = 0x0F
+	mbrTypeExtendedLinux = 0x85
+	mbrTypeProtective    = 0xEE
+)
+
+const (
+	mbrSignatureOffset = 510
+	mbrEntriesOffset   = 446
+	mbrEntrySize       = 16
+	mbrSectorSize      = 512
+
+	gptSignature      = "EFI PART"
+	gptMinHeaderSize  = 92
+	gptMaxEntryBytes  = 4 * 1024 * 1024 // sanity limit for the entry array
+	maxLogicalEntries = 128             // guards against EBR chain loops
+)
+
+// gptTypeNames maps GPT partition type GUIDs to readable names
+var gptTypeNames = map[string]string{
+	GPTTypeEFISystem:       "EFI System",
+	GPTTypeAPFS:            "Apple APFS",
+	GPTTypeHFSPlus:         "Apple HFS+",
+	GPTTypeAppleBoot:       "Apple Boot",
+	GPTTypeCoreStorage:     "Apple Core Storage",
+	GPTTypeBasicData:       "Microsoft Basic Data",
+	GPTTypeMicrosoftRsvd:   "Microsoft Reserved",
+	GPTTypeWindowsRecovery: "Windows Recovery",
+	GPTTypeLinux:           "Linux Filesystem",
+}
+
+// mbrTypeNames maps MBR partition type bytes to readable names
+var mbrTypeNames = map[byte]string{
+	0x01:                 "FAT12",
+	0x04:                 "FAT16",
+	mbrTypeExtendedCHS:   "Extended",
+	0x06:                 "FAT16",
+	0x07:                 "NTFS/exFAT",
+	0x0B:                 "FAT32",
+	0x0C:                 "FAT32 (LBA)",
+	0x0E:                 "FAT16 (LBA)",
+	mbrTypeExtendedLBA:   "Extended (LBA)",
+	0x27:                 "Windows Recovery",
+	0x82:                 "Linux Swap",
+	0x83:                 "Linux",
+	mbrTypeExtendedLinux: "Linux Extended",
+	0xAB:                 "Apple Boot",
+	0xAF:                 "Apple HFS+",
+	mbrTypeProtective:    "GPT Protective",
+	0xEF:                 "EFI System",
+}
+
+// Partition is an entry of a GPT or MBR partition table
+type Partition struct {
+	// Index is the one-based entry number within its table. Logical MBR
+	// partitions are numbered from 5, as Linux does.
+	Index  int
+	Scheme PartitionScheme
+
+	// Type is the partition type GUID for GPT entries, or the type byte
+	// formatted as "0xAF" for MBR entries
+	Type     string
+	TypeName string
+
+	// GUID is the unique partition GUID of GPT entries
+	GUID       string
+	Name       string
+	Attributes uint64
+
+	// Offset and Size are in bytes from the start of the disk
+	Offset int64
+	Size   int64
+}
+
+// IsAPFS reports whether the partition type marks an APFS container
+func (p *Partition) IsAPFS() bool {
+	return p.Scheme == SchemeGPT && strings.EqualFold(p.Type, GPTTypeAPFS)
+}
+
+// PartitionTable describes the partitioning of a whole disk image
+type PartitionTable struct {
+	Scheme PartitionScheme
+
+	// SectorSize is the logical block size the table was found with
+	SectorSize int64
+
+	// DiskGUID is the GPT disk identifier
+	DiskGUID string
+
+	// HybridMBR is set when the protective MBR also describes partitions
+	HybridMBR bool
+
+	// Partitions are the GPT entries followed by MBR entries that do not
+	// mirror a GPT entry, in table order
+	Partitions []Partition
+
+	// Warnings records recoverable problems such as a corrupt primary GPT
+	// header that was replaced by the backup copy
+	Warnings []error
+
+	diskSize int64
+}
+
+// ContainerLocation is an APFS container found on a disk
+type ContainerLocation struct {
+	// Partition is the partition holding the container, or nil when the
+	// container starts at the beginning of an unpartitioned image
+	Partition *Partition
+
+	Offset int64
+	Size   int64
+
+	// BlockSize, BlockCount and UUID are read from the container superblock
+	BlockSize  uint32
+	BlockCount uint64
+	UUID       types.UUID
+
+	// Reader exposes the container as if it started at offset zero, ready
+	// for services.NewContainerReaderFromDevice
+	Reader *io.SectionReader
+}
+
+// ScanPartitions reads the GPT and MBR partition tables of a disk of size
+// bytes. A disk without either table is reported with SchemeNone.
+func ScanPartitions(r io.ReaderAt, size int64) (*PartitionTable, error) {
+	table := &PartitionTable{Scheme: SchemeNone, SectorSize: mbrSectorSize, diskSize: size}
+
+	mbr, err := readMBR(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// GPT disks use 512 byte sectors, or 4096 on advanced format media
+	for _, sectorSize := range []int64{512, 4096} {
+		gpt, warnings, err := readGPT(r, size, sectorSize)
+		if err != nil {
+			// Treat the disk as unpartitioned so containers at the
+			// start of the disk can still be found
+			table.Warnings = append(table.Warnings, err)
+			continue
+		}
+		if gpt == nil {
+			continue
+		}
+
+		table.Scheme = SchemeGPT
+		table.SectorSize = sectorSize
+		table.DiskGUID = gpt.diskGUID
+		table.Partitions = gpt.partitions
+		table.Warnings = append(table.Warnings, warnings...)
+		break
+	}
+
+	if table.Scheme == SchemeNone {
+		if len(mbr) > 0 {
+			table.Scheme = SchemeMBR
+			table.Partitions = mbr
+		}
+		return table, nil
+	}
+
+	// A hybrid MBR mirrors some GPT partitions next to the protective entry
+	// so that legacy systems such as Boot Camp can see them. Only entries
+	// that do not match a GPT partition add information.
+	for _, p := range mbr {
+		if p.Type == formatMBRType(mbrTypeProtective) {
+			continue
+		}
+		table.HybridMBR = true
+		if !table.covers(p) {
+			table.Partitions = append(table.Partitions, p)
+		}
+	}
+
+	return table, nil
+}
+
+// covers reports whether a GPT partition has the extent of p
+func (t *PartitionTable) covers(p Partition) bool {
+	for _, q := range t.Partitions {
+		if q.Scheme == SchemeGPT && q.Offset == p.Offset && q.Size == p.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// FindContainers returns every APFS container on a disk of size bytes. Each
+// partition is checked for the container superblock magic, so containers in
+// partitions with an unexpected type are found as well. An unpartitioned image
+// is checked at offset zero.
+func FindContainers(r io.ReaderAt, size int64) ([]ContainerLocation, error) {
+	table, err := ScanPartitions(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return table.Containers(r)
+}
+
+// Containers returns the APFS containers in the partitions of the table
+func (t *PartitionTable) Containers(r io.ReaderAt) ([]ContainerLocation, error) {
+	var containers []ContainerLocation
+
+	if len(t.Partitions) == 0 {
+		loc, err := probeContainer(r, 0, t.diskSize)
+		if err != nil {
+			return nil, err
+		}
+		if loc != nil {
+			containers = append(containers, *loc)
+		}
+		return containers, nil
+	}
+
+	for i := range t.Partitions {
+		p := &t.Partitions[i]
+		loc, err := probeContainer(r, p.Offset, p.Size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to probe partition %d: %w", p.Index, err)
+		}
+		if loc == nil {
+			if p.IsAPFS() {
+				t.Warnings = append(t.Warnings, fmt.Errorf("partition %d has the APFS type but no container superblock", p.Index))
+			}
+			continue
+		}
+		loc.Partition = p
+		containers = append(containers, *loc)
+	}
+
+	return containers, nil
+}
+
+// probeContainer checks for a container superblock at off and describes the
+// container when one is present
+func probeContainer(r io.ReaderAt, off, size int64) (*ContainerLocation, error) {
+	const headerSize = 88 // through nx_uuid
+	if size < headerSize {
+		return nil, nil
+	}
+
+	buf := make([]byte, headerSize)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint32(buf[types.APFSMagicOffset:]) != types.NxMagic {
+		return nil, nil
+	}
+
+	loc := &ContainerLocation{
+		Offset:     off,
+		Size:       size,
+		BlockSize:  binary.LittleEndian.Uint32(buf[36:40]),
+		BlockCount: binary.LittleEndian.Uint64(buf[40:48]),
+		Reader:     io.NewSectionReader(r, off, size),
+	}
+	copy(loc.UUID[:], buf[72:88])
+	return loc, nil
+}
+
+// readMBR returns the primary and logical partitions of the MBR, or nil when
+// sector zero holds no MBR signature
+func readMBR(r io.ReaderAt, size int64) ([]Partition, error) {
+	sector, err := readSector(r, 0, mbrSectorSize)
+	if err != nil || sector == nil {
+		return nil, err
+	}
+	if sector[mbrSignatureOffset] != 0x55 || sector[mbrSignatureOffset+1] != 0xAA {
+		return nil, nil
+	}
+
+	var partitions []Partition
+	for i := 0; i < 4; i++ {
+		entry := sector[mbrEntriesOffset+i*mbrEntrySize : mbrEntriesOffset+(i+1)*mbrEntrySize]
+		typ := entry[4]
+		start := int64(binary.LittleEndian.Uint32(entry[8:12]))
+		count := int64(binary.LittleEndian.Uint32(entry[12:16]))
+		if typ == mbrTypeEmpty || count == 0 {
+			continue
+		}
+
+		partitions = append(partitions, newMBRPartition(i+1, typ, start, count))
+
+		if isExtended(typ) {
+			logical, err := readLogicalPartitions(r, size, start)
+			if err != nil {
+				return nil, err
+			}
+			partitions = append(partitions, logical...)
+		}
+	}
+
+	return partitions, nil
+}
+
+// readLogicalPartitions follows the chain of extended boot records of the
+// extended partition starting at sector base
+func readLogicalPartitions(r io.ReaderAt, size, base int64) ([]Partition, error) {
+	var partitions []Partition
+
+	seen := make(map[int64]bool)
+	ebr := base
+	for index := 5; len(partitions) < maxLogicalEntries; index++ {
+		if seen[ebr] || ebr*mbrSectorSize >= size {
+			break
+		}
+		seen[ebr] = true
+
+		sector, err := readSector(r, ebr*mbrSectorSize, mbrSectorSize)
+		if err != nil {
+			return nil, err
+		}
+		if sector == nil || sector[mbrSignatureOffset] != 0x55 || sector[mbrSignatureOffset+1] != 0xAA {
+			break
+		}
+
+		// The first entry is relative to this EBR, the second links to the
+		// next EBR relative to the start of the extended partition
+		entry := sector[mbrEntriesOffset : mbrEntriesOffset+mbrEntrySize]
+		if typ := entry[4]; typ != mbrTypeEmpty {
+			start := ebr + int64(binary.LittleEndian.Uint32(entry[8:12]))
+			count := int64(binary.LittleEndian.Uint32(entry[12:16]))
+			partitions = append(partitions, newMBRPartition(index, typ, start, count))
+		}
+
+		next := sector[mbrEntriesOffset+mbrEntrySize : mbrEntriesOffset+2*mbrEntrySize]
+		if !isExtended(next[4]) {
+			break
+		}
+		ebr = base + int64(binary.LittleEndian.Uint32(next[8:12]))
+	}
+
+	return partitions, nil
+}
+
+func newMBRPartition(index int, typ byte, start, count int64) Partition {
+	return Partition{
+		Index:    index,
+		Scheme:   SchemeMBR,
+		Type:     formatMBRType(typ),
+		TypeName: mbrTypeNames[typ],
+		Offset:   start * mbrSectorSize,
+		Size:     count * mbrSectorSize,
+	}
+}
+
+func isExtended(typ byte) bool {
+	return typ == mbrTypeExtendedCHS || typ == mbrTypeExtendedLBA || typ == mbrTypeExtendedLinux
+}
+
+func formatMBRType(typ byte) string {
+	return fmt.Sprintf("0x%02X", typ)
+}
+
+// gptTable is a parsed GPT header and entry array
+type gptTable struct {
+	diskGUID   string
+	partitions []Partition
+}
+
+// readGPT reads the GPT of a disk with the given sector size. The backup
+// header at the end of the disk is used when the primary header or its entry
+// array fails its checksum. A nil table means no GPT was found.
+func readGPT(r io.ReaderAt, size, sectorSize int64) (*gptTable, []error, error) {
+	primary, primaryErr := readGPTHeader(r, sectorSize, sectorSize)
+	if primary == nil && primaryErr == nil {
+		return nil, nil, nil
+	}
+	if primaryErr == nil {
+		table, err := readGPTEntries(r, primary, sectorSize)
+		if err == nil {
+			return table, nil, nil
+		}
+		primaryErr = err
+	}
+
+	warnings := []error{fmt.Errorf("primary GPT is damaged: %w", primaryErr)}
+
+	// The backup header normally lives in the last sector; fall back to
+	// the location recorded in the primary header for truncated images
+	candidates := []int64{size/sectorSize - 1}
+	if primary != nil {
+		candidates = append(candidates, int64(binary.LittleEndian.Uint64(primary[32:40])))
+	}
+	for _, lba := range candidates {
+		if lba <= 1 || lba*sectorSize >= size {
+			continue
+		}
+		backup, err := readGPTHeader(r, lba*sectorSize, sectorSize)
+		if backup == nil || err != nil {
+			continue
+		}
+		table, err := readGPTEntries(r, backup, sectorSize)
+		if err != nil {
+			continue
+		}
+		warnings = append(warnings, fmt.Errorf("using backup GPT header at LBA %d", lba))
+		return table, warnings, nil
+	}
+
+	return nil, nil, fmt.Errorf("GPT is damaged and no usable backup was found: %w", primaryErr)
+}
+
+// readGPTHeader returns the header sector at off. A nil header and nil error
+// mean the sector holds no GPT signature.
+func readGPTHeader(r io.ReaderAt, off, sectorSize int64) ([]byte, error) {
+	header, err := readSector(r, off, sectorSize)
+	if err != nil || header == nil {
+		return nil, err
+	}
+	if string(header[:8]) != gptSignature {
+		return nil, nil
+	}
+
+	headerSize := binary.LittleEndian.Uint32(header[12:16])
+	if headerSize < gptMinHeaderSize || int64(headerSize) > sectorSize {
+		return header, fmt.Errorf("invalid GPT header size %d", headerSize)
+	}
+
+	sum := binary.LittleEndian.Uint32(header[16:20])
+	check := make([]byte, headerSize)
+	copy(check, header[:headerSize])
+	binary.LittleEndian.PutUint32(check[16:20], 0)
+	if crc32.ChecksumIEEE(check) != sum {
+		return header, fmt.Errorf("GPT header checksum mismatch")
+	}
+
+	return header, nil
+}
+
+// readGPTEntries reads and verifies the partition entry array of header
+func readGPTEntries(r io.ReaderAt, header []byte, sectorSize int64) (*gptTable, error) {
+	entriesLBA := int64(binary.LittleEndian.Uint64(header[72:80]))
+	count := int64(binary.LittleEndian.Uint32(header[80:84]))
+	entrySize := int64(binary.LittleEndian.Uint32(header[84:88]))
+	sum := binary.LittleEndian.Uint32(header[88:92])
+
+	if entrySize < types.GPTEntrySize || entrySize%8 != 0 {
+		return nil, fmt.Errorf("invalid GPT entry size %d", entrySize)
+	}
+	if count*entrySize > gptMaxEntryBytes {
+		return nil, fmt.Errorf("GPT entry array of %d entries is too large", count)
+	}
+
+	entries := make([]byte, count*entrySize)
+	if _, err := r.ReadAt(entries, entriesLBA*sectorSize); err != nil {
+		return nil, fmt.Errorf("failed to read GPT entries: %w", err)
+	}
+	if crc32.ChecksumIEEE(entries) != sum {
+		return nil, fmt.Errorf("GPT entry array checksum mismatch")
+	}
+
+	table := &gptTable{diskGUID: formatGUID(header[56:72])}
+	for i := int64(0); i < count; i++ {
+		entry := entries[i*entrySize : (i+1)*entrySize]
+		if bytes.Equal(entry[:16], make([]byte, 16)) {
+			continue
+		}
+
+		first := int64(binary.LittleEndian.Uint64(entry[32:40]))
+		last := int64(binary.LittleEndian.Uint64(entry[40:48]))
+		if last < first {
+			continue
+		}
+
+		typ := formatGUID(entry[:16])
+		table.partitions = append(table.partitions, Partition{
+			Index:      int(i) + 1,
+			Scheme:     SchemeGPT,
+			Type:       typ,
+			TypeName:   gptTypeNames[typ],
+			GUID:       formatGUID(entry[16:32]),
+			Name:       decodeUTF16LE(entry[56:128]),
+			Attributes: binary.LittleEndian.Uint64(entry[48:56]),
+			Offset:     first * sectorSize,
+			Size:       (last - first + 1) * sectorSize,
+		})
+	}
+
+	return table, nil
+}
+
+// readSector reads n bytes at off, returning nil when the disk is too short
+func readSector(r io.ReaderAt, off, n int64) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read sector at offset %d: %w", off, err)
+	}
+	return buf, nil
+}
+
+// formatGUID renders a GPT GUID, whose first three fields are little-endian
+func formatGUID(g []byte) string {
+	return fmt.Sprintf("%02X%02X%02X%02X-%02X%02X-%02X%02X-%02X%02X-%02X%02X%02X%02X%02X%02X",
+		g[3], g[2], g[1], g[0], g[5], g[4], g[7], g[6], g[8], g[9], g[10], g[11], g[12], g[13], g[14], g[15])
+}
+
+// decodeUTF16LE decodes a NUL terminated UTF-16LE partition name
+func decodeUTF16LE(b []byte) string {
+	u16s := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		v := binary.LittleEndian.Uint16(b[i:])
+		if v == 0 {
+			break
+		}
+		u16s = append(u16s, v)
+	}
+	return string(utf16.Decode(u16s))
+}
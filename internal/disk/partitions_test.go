@@ -0,0 +1,247 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+const testSector = 512
+
+// testGPTEntry describes a partition written by buildGPTDisk
+type testGPTEntry struct {
+	typeGUID    string
+	name        string
+	first, last int64
+}
+
+// parseGUID encodes a GUID string in the mixed-endian GPT layout
+func parseGUID(t *testing.T, s string) []byte {
+	t.Helper()
+	var raw []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '-' {
+			continue
+		}
+		var b byte
+		for _, c := range s[i : i+2] {
+			b <<= 4
+			switch {
+			case c >= '0' && c <= '9':
+				b |= byte(c - '0')
+			case c >= 'A' && c <= 'F':
+				b |= byte(c - 'A' + 10)
+			}
+		}
+		raw = append(raw, b)
+		i++
+	}
+	require.Len(t, raw, 16)
+	return []byte{raw[3], raw[2], raw[1], raw[0], raw[5], raw[4], raw[7], raw[6],
+		raw[8], raw[9], raw[10], raw[11], raw[12], raw[13], raw[14], raw[15]}
+}
+
+// writeGPTHeader writes a GPT header at lba whose entry array is at entriesLBA
+func writeGPTHeader(disk []byte, lba, altLBA, entriesLBA int64, entries []byte) {
+	h := disk[lba*testSector : (lba+1)*testSector]
+	copy(h, gptSignature)
+	binary.LittleEndian.PutUint32(h[8:], 0x00010000)
+	binary.LittleEndian.PutUint32(h[12:], gptMinHeaderSize)
+	binary.LittleEndian.PutUint64(h[24:], uint64(lba))
+	binary.LittleEndian.PutUint64(h[32:], uint64(altLBA))
+	copy(h[56:72], bytes.Repeat([]byte{0xAB}, 16))
+	binary.LittleEndian.PutUint64(h[72:], uint64(entriesLBA))
+	binary.LittleEndian.PutUint32(h[80:], 128)
+	binary.LittleEndian.PutUint32(h[84:], types.GPTEntrySize)
+	binary.LittleEndian.PutUint32(h[88:], crc32.ChecksumIEEE(entries))
+	binary.LittleEndian.PutUint32(h[16:], crc32.ChecksumIEEE(h[:gptMinHeaderSize]))
+	copy(disk[entriesLBA*testSector:], entries)
+}
+
+// buildGPTDisk returns a disk of sectors 512 byte sectors with a protective
+// MBR and primary and backup GPTs
+func buildGPTDisk(t *testing.T, sectors int64, parts []testGPTEntry) []byte {
+	t.Helper()
+	disk := make([]byte, sectors*testSector)
+
+	writeMBREntry(disk, 0, mbrTypeProtective, 1, sectors-1)
+
+	entries := make([]byte, 128*types.GPTEntrySize)
+	for i, p := range parts {
+		e := entries[i*types.GPTEntrySize:]
+		copy(e[0:16], parseGUID(t, p.typeGUID))
+		e[16] = byte(i + 1)
+		binary.LittleEndian.PutUint64(e[32:], uint64(p.first))
+		binary.LittleEndian.PutUint64(e[40:], uint64(p.last))
+		for j, u := range utf16.Encode([]rune(p.name)) {
+			binary.LittleEndian.PutUint16(e[56+2*j:], u)
+		}
+	}
+
+	last := sectors - 1
+	writeGPTHeader(disk, 1, last, 2, entries)
+	writeGPTHeader(disk, last, 1, last-32, entries)
+	return disk
+}
+
+// writeMBREntry fills primary MBR entry i and the boot signature
+func writeMBREntry(disk []byte, i int, typ byte, start, count int64) {
+	e := disk[mbrEntriesOffset+i*mbrEntrySize:]
+	e[4] = typ
+	binary.LittleEndian.PutUint32(e[8:], uint32(start))
+	binary.LittleEndian.PutUint32(e[12:], uint32(count))
+	disk[mbrSignatureOffset], disk[mbrSignatureOffset+1] = 0x55, 0xAA
+}
+
+// writeNXSuperblock places a container superblock magic at off
+func writeNXSuperblock(disk []byte, off int64, blockCount uint64) {
+	binary.LittleEndian.PutUint32(disk[off+types.APFSMagicOffset:], types.NxMagic)
+	binary.LittleEndian.PutUint32(disk[off+36:], 4096)
+	binary.LittleEndian.PutUint64(disk[off+40:], blockCount)
+	disk[off+72] = 0x42
+}
+
+func TestScanPartitionsGPT(t *testing.T) {
+	disk := buildGPTDisk(t, 2048, []testGPTEntry{
+		{GPTTypeEFISystem, "EFI System Partition", 40, 199},
+		{GPTTypeAPFS, "Macintosh HD", 200, 999},
+		{GPTTypeAppleBoot, "Recovery HD", 1000, 1199},
+		{GPTTypeAPFS, "Data", 1200, 1999},
+	})
+	writeNXSuperblock(disk, 200*testSector, 100)
+	writeNXSuperblock(disk, 1200*testSector, 100)
+	r := bytes.NewReader(disk)
+
+	table, err := ScanPartitions(r, int64(len(disk)))
+	require.NoError(t, err)
+	assert.Equal(t, SchemeGPT, table.Scheme)
+	assert.Equal(t, int64(512), table.SectorSize)
+	assert.False(t, table.HybridMBR)
+	assert.Empty(t, table.Warnings)
+	require.Len(t, table.Partitions, 4)
+
+	efi := table.Partitions[0]
+	assert.Equal(t, 1, efi.Index)
+	assert.Equal(t, "EFI System", efi.TypeName)
+	assert.Equal(t, "EFI System Partition", efi.Name)
+	assert.Equal(t, int64(40*testSector), efi.Offset)
+	assert.Equal(t, int64(160*testSector), efi.Size)
+	assert.True(t, table.Partitions[1].IsAPFS())
+	assert.False(t, table.Partitions[2].IsAPFS())
+
+	containers, err := table.Containers(r)
+	require.NoError(t, err)
+	require.Len(t, containers, 2)
+	assert.Equal(t, "Macintosh HD", containers[0].Partition.Name)
+	assert.Equal(t, "Data", containers[1].Partition.Name)
+	assert.Equal(t, int64(1200*testSector), containers[1].Offset)
+	assert.Equal(t, uint32(4096), containers[1].BlockSize)
+	assert.Equal(t, uint64(100), containers[1].BlockCount)
+	assert.Equal(t, byte(0x42), containers[1].UUID[0])
+
+	// The section reader starts at the container superblock
+	magic := make([]byte, 4)
+	_, err = containers[1].Reader.ReadAt(magic, types.APFSMagicOffset)
+	require.NoError(t, err)
+	assert.Equal(t, types.NxMagic, binary.LittleEndian.Uint32(magic))
+	assert.Equal(t, int64(800*testSector), containers[1].Reader.Size())
+}
+
+func TestScanPartitionsBackupGPT(t *testing.T) {
+	disk := buildGPTDisk(t, 1024, []testGPTEntry{{GPTTypeAPFS, "Macintosh HD", 40, 900}})
+	writeNXSuperblock(disk, 40*testSector, 10)
+	disk[testSector+20] ^= 0xFF // corrupt the primary header's reserved field
+
+	table, err := ScanPartitions(bytes.NewReader(disk), int64(len(disk)))
+	require.NoError(t, err)
+	assert.Equal(t, SchemeGPT, table.Scheme)
+	require.Len(t, table.Partitions, 1)
+	assert.Len(t, table.Warnings, 2)
+
+	containers, err := table.Containers(bytes.NewReader(disk))
+	require.NoError(t, err)
+	require.Len(t, containers, 1)
+	assert.Equal(t, int64(40*testSector), containers[0].Offset)
+}
+
+func TestScanPartitionsHybridMBR(t *testing.T) {
+	disk := buildGPTDisk(t, 2048, []testGPTEntry{
+		{GPTTypeEFISystem, "EFI", 40, 199},
+		{GPTTypeAPFS, "Macintosh HD", 200, 999},
+		{GPTTypeBasicData, "BOOTCAMP", 1000, 1999},
+	})
+	writeMBREntry(disk, 0, mbrTypeProtective, 1, 199)
+	writeMBREntry(disk, 1, 0x07, 1000, 1000) // mirrors BOOTCAMP
+	writeMBREntry(disk, 2, 0x0C, 2000, 40)   // only in the MBR
+
+	table, err := ScanPartitions(bytes.NewReader(disk), int64(len(disk)))
+	require.NoError(t, err)
+	assert.True(t, table.HybridMBR)
+	require.Len(t, table.Partitions, 4)
+
+	extra := table.Partitions[3]
+	assert.Equal(t, SchemeMBR, extra.Scheme)
+	assert.Equal(t, 3, extra.Index)
+	assert.Equal(t, "0x0C", extra.Type)
+	assert.Equal(t, "FAT32 (LBA)", extra.TypeName)
+	assert.Equal(t, int64(2000*testSector), extra.Offset)
+}
+
+func TestScanPartitionsMBRWithLogicalPartitions(t *testing.T) {
+	disk := make([]byte, 4096*testSector)
+	writeMBREntry(disk, 0, 0x83, 64, 1000)
+	writeMBREntry(disk, 1, mbrTypeExtendedLBA, 2048, 2048)
+
+	// Two logical partitions; the second holds an APFS container
+	ebr := disk[2048*testSector:]
+	writeMBREntry(ebr, 0, 0x83, 64, 500)
+	writeMBREntry(ebr, 1, mbrTypeExtendedLBA, 1024, 1024)
+	ebr2 := disk[(2048+1024)*testSector:]
+	writeMBREntry(ebr2, 0, 0xAF, 64, 900)
+	writeNXSuperblock(disk, (2048+1024+64)*testSector, 10)
+
+	table, err := ScanPartitions(bytes.NewReader(disk), int64(len(disk)))
+	require.NoError(t, err)
+	assert.Equal(t, SchemeMBR, table.Scheme)
+	require.Len(t, table.Partitions, 4)
+	assert.Equal(t, 5, table.Partitions[2].Index)
+	assert.Equal(t, int64((2048+64)*testSector), table.Partitions[2].Offset)
+	assert.Equal(t, 6, table.Partitions[3].Index)
+
+	containers, err := table.Containers(bytes.NewReader(disk))
+	require.NoError(t, err)
+	require.Len(t, containers, 1)
+	assert.Equal(t, 6, containers[0].Partition.Index)
+}
+
+func TestFindContainersUnpartitioned(t *testing.T) {
+	disk := make([]byte, 64*1024)
+	writeNXSuperblock(disk, 0, 16)
+
+	containers, err := FindContainers(bytes.NewReader(disk), int64(len(disk)))
+	require.NoError(t, err)
+	require.Len(t, containers, 1)
+	assert.Nil(t, containers[0].Partition)
+	assert.Equal(t, int64(len(disk)), containers[0].Size)
+
+	containers, err = FindContainers(bytes.NewReader(make([]byte, 64*1024)), 64*1024)
+	require.NoError(t, err)
+	assert.Empty(t, containers)
+
+	// A disk shorter than a sector is simply empty
+	containers, err = FindContainers(io.NewSectionReader(bytes.NewReader(nil), 0, 0), 0)
+	require.NoError(t, err)
+	assert.Empty(t, containers)
+}
+
+func TestFormatGUID(t *testing.T) {
+	assert.Equal(t, GPTTypeAPFS, formatGUID(parseGUID(t, GPTTypeAPFS)))
+}
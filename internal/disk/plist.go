@@ -0,0 +1,147 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parsePlist decodes an XML property list into maps, slices, strings, byte
+// slices, int64s, float64s and bools. Dates are returned as strings.
+func parsePlist(data []byte) (any, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("no plist element: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "plist" {
+			value, err := parsePlistValue(dec, nil)
+			if err != nil {
+				return nil, err
+			}
+			return value, nil
+		}
+	}
+}
+
+// parsePlistValue decodes the next value element, or the element start when
+// it has already been consumed
+func parsePlistValue(dec *xml.Decoder, start *xml.StartElement) (any, error) {
+	if start == nil {
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := tok.(xml.StartElement); ok {
+				start = &s
+				break
+			}
+			if _, ok := tok.(xml.EndElement); ok {
+				return nil, io.EOF
+			}
+		}
+	}
+
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]any)
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.EndElement:
+				return dict, nil
+			case xml.StartElement:
+				if t.Name.Local != "key" {
+					return nil, fmt.Errorf("expected key in dict, found %s", t.Name.Local)
+				}
+				key, err := plistText(dec)
+				if err != nil {
+					return nil, err
+				}
+				value, err := parsePlistValue(dec, nil)
+				if err != nil {
+					return nil, fmt.Errorf("value of key %q: %w", key, err)
+				}
+				dict[key] = value
+			}
+		}
+	case "array":
+		var array []any
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.EndElement:
+				return array, nil
+			case xml.StartElement:
+				value, err := parsePlistValue(dec, &t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			}
+		}
+	case "true", "false":
+		if err := dec.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	text, err := plistText(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch start.Name.Local {
+	case "string", "date":
+		return text, nil
+	case "data":
+		// Data elements are wrapped and indented with whitespace
+		clean := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+				return -1
+			}
+			return r
+		}, text)
+		return base64.StdEncoding.DecodeString(clean)
+	case "integer":
+		return strconv.ParseInt(strings.TrimSpace(text), 0, 64)
+	case "real":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	}
+	return nil, fmt.Errorf("unsupported plist element %s", start.Name.Local)
+}
+
+// plistText returns the character data of the current element and consumes
+// its end tag
+func plistText(dec *xml.Decoder) (string, error) {
+	var sb strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.EndElement:
+			return sb.String(), nil
+		case xml.StartElement:
+			return "", fmt.Errorf("unexpected element %s in text", t.Name.Local)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package disk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Sparse image (.sparseimage) header fields. The 64 byte header is followed
+// by a table mapping each band stored in the file to its band number on the
+// disk; the bands follow, aligned to 4 KiB. All fields are big-endian.
+const (
+	sparseImageMagic      = "sprs"
+	sparseImageHeaderSize = 64
+	sparseImageAlign      = 4096
+)
+
+// Sparse bundle (.sparsebundle) layout
+const (
+	sparseBundleInfo       = "Info.plist"
+	sparseBundleInfoBackup = "Info.bckup"
+	sparseBundleBands      = "bands"
+	sparseBundleType       = "com.apple.diskimage.sparsebundle"
+
+	// maxOpenBands bounds the band files a sparse bundle keeps open
+	maxOpenBands = 32
+)
+
+// ErrNotSparseImage is returned by OpenSparseImage when the header signature
+// is missing
+var ErrNotSparseImage = errors.New("not a sparse disk image")
+
+// SparseImage presents the disk inside a single-file .sparseimage. Bands
+// that were never written read as zeros.
+type SparseImage struct {
+	r        io.ReaderAt
+	size     int64
+	bandSize int64
+
+	// bands maps a disk band number to its offset in the file, or -1
+	bands []int64
+}
+
+// IsSparseImage reports whether r starts with a sparse image header
+func IsSparseImage(r io.ReaderAt) bool {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return false
+	}
+	return string(magic) == sparseImageMagic
+}
+
+// OpenSparseImage parses the header and band table of the sparse image in r,
+// which is size bytes long
+func OpenSparseImage(r io.ReaderAt, size int64) (*SparseImage, error) {
+	header := make([]byte, sparseImageHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("failed to read sparse image header: %w", err)
+	}
+	if string(header[0:4]) != sparseImageMagic {
+		return nil, ErrNotSparseImage
+	}
+
+	sectorsPerBand := int64(binary.BigEndian.Uint32(header[8:12]))
+	sectors := int64(binary.BigEndian.Uint32(header[16:20]))
+	if sectorsPerBand == 0 {
+		return nil, fmt.Errorf("sparse image has a zero band size")
+	}
+
+	img := &SparseImage{
+		r:        r,
+		size:     sectors * 512,
+		bandSize: sectorsPerBand * 512,
+	}
+	count := (img.size + img.bandSize - 1) / img.bandSize
+
+	table := make([]byte, count*4)
+	if _, err := r.ReadAt(table, sparseImageHeaderSize); err != nil {
+		return nil, fmt.Errorf("failed to read sparse image band table: %w", err)
+	}
+	dataStart := alignUp(sparseImageHeaderSize+int64(len(table)), sparseImageAlign)
+
+	img.bands = make([]int64, count)
+	for i := range img.bands {
+		img.bands[i] = -1
+	}
+	for i := int64(0); i < count; i++ {
+		// Entry i holds the one-based disk band stored in file slot i
+		band := int64(binary.BigEndian.Uint32(table[i*4:]))
+		if band == 0 {
+			continue
+		}
+		if band > count {
+			return nil, fmt.Errorf("sparse image band table entry %d names band %d of %d", i, band, count)
+		}
+		img.bands[band-1] = dataStart + i*img.bandSize
+	}
+
+	return img, nil
+}
+
+// Size returns the size of the disk inside the image
+func (s *SparseImage) Size() int64 {
+	return s.size
+}
+
+// ReadAt reads from the disk inside the image
+func (s *SparseImage) ReadAt(p []byte, off int64) (int, error) {
+	return readBands(p, off, s.size, s.bandSize, func(band int64, dst []byte, bandOff int64) error {
+		fileOff := s.bands[band]
+		if fileOff < 0 {
+			clear(dst)
+			return nil
+		}
+		n, err := s.r.ReadAt(dst, fileOff+bandOff)
+		if err == io.EOF {
+			// The last band is only stored up to the data written to it
+			clear(dst[n:])
+			return nil
+		}
+		return err
+	})
+}
+
+// SparseBundle presents the disk inside a .sparsebundle directory, whose
+// bands are stored as separate files named by their hexadecimal index.
+// Missing bands and the unwritten tail of a band read as zeros.
+type SparseBundle struct {
+	dir      string
+	size     int64
+	bandSize int64
+
+	mu    sync.Mutex
+	files *lruCache[int64, *os.File]
+}
+
+// IsSparseBundle reports whether path is a sparse bundle directory
+func IsSparseBundle(path string) bool {
+	info, err := os.Stat(filepath.Join(path, sparseBundleInfo))
+	if err != nil {
+		info, err = os.Stat(filepath.Join(path, sparseBundleInfoBackup))
+	}
+	return err == nil && info.Mode().IsRegular()
+}
+
+// OpenSparseBundle reads the Info.plist of the sparse bundle at dir
+func OpenSparseBundle(dir string) (*SparseBundle, error) {
+	data, err := os.ReadFile(filepath.Join(dir, sparseBundleInfo))
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = os.ReadFile(filepath.Join(dir, sparseBundleInfoBackup))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sparse bundle info: %w", err)
+	}
+
+	root, err := parsePlist(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sparse bundle info: %w", err)
+	}
+	info, _ := root.(map[string]any)
+	if kind, _ := info["diskimage-bundle-type"].(string); kind != sparseBundleType {
+		return nil, fmt.Errorf("unsupported disk image bundle type %q", kind)
+	}
+
+	size, _ := info["size"].(int64)
+	bandSize, _ := info["band-size"].(int64)
+	if size <= 0 || bandSize <= 0 {
+		return nil, fmt.Errorf("sparse bundle info has invalid size %d or band size %d", size, bandSize)
+	}
+
+	b := &SparseBundle{dir: dir, size: size, bandSize: bandSize}
+	b.files = newLRUCache[int64, *os.File](maxOpenBands, func(_ int64, f *os.File) {
+		if f != nil {
+			f.Close()
+		}
+	})
+	return b, nil
+}
+
+// Size returns the size of the disk inside the bundle
+func (b *SparseBundle) Size() int64 {
+	return b.size
+}
+
+// ReadAt reads from the disk inside the bundle
+func (b *SparseBundle) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return readBands(p, off, b.size, b.bandSize, func(band int64, dst []byte, bandOff int64) error {
+		f, err := b.band(band)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			clear(dst)
+			return nil
+		}
+		n, err := f.ReadAt(dst, bandOff)
+		if err == io.EOF {
+			clear(dst[n:])
+			return nil
+		}
+		return err
+	})
+}
+
+// band returns the open file of a band, or nil when the band was never
+// written
+func (b *SparseBundle) band(band int64) (*os.File, error) {
+	if f, ok := b.files.get(band); ok {
+		return f, nil
+	}
+
+	name := filepath.Join(b.dir, sparseBundleBands, strconv.FormatInt(band, 16))
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open band %x: %w", band, err)
+	}
+	b.files.put(band, f)
+	return f, nil
+}
+
+// Close closes the band files
+func (b *SparseBundle) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files.clear()
+	return nil
+}
+
+// readBands splits a read into per-band reads
+func readBands(p []byte, off, size, bandSize int64, read func(band int64, dst []byte, bandOff int64) error) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	var eof error
+	if remaining := size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+		eof = io.EOF
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		band, bandOff := pos/bandSize, pos%bandSize
+		dst := p[n : n+int(min(int64(len(p)-n), bandSize-bandOff))]
+		if err := read(band, dst, bandOff); err != nil {
+			return n, err
+		}
+		n += len(dst)
+	}
+	return n, eof
+}
+
+func alignUp(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
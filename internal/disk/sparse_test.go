@@ -0,0 +1,184 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBand returns band-sized content identifying band i
+func testBand(i, size int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("band %04d ", i)), size/10+1)[:size]
+}
+
+// buildSparseImage writes a sparse image of bands bands whose stored bands
+// appear in the file in the given order. The last stored band may be short.
+func buildSparseImage(t *testing.T, bands int, sectorsPerBand int, stored []int, lastLength int) []byte {
+	t.Helper()
+	bandSize := sectorsPerBand * 512
+
+	header := make([]byte, sparseImageHeaderSize+4*bands)
+	copy(header, sparseImageMagic)
+	binary.BigEndian.PutUint32(header[4:], 3)
+	binary.BigEndian.PutUint32(header[8:], uint32(sectorsPerBand))
+	binary.BigEndian.PutUint32(header[12:], 1)
+	binary.BigEndian.PutUint32(header[16:], uint32(bands*sectorsPerBand))
+	for slot, band := range stored {
+		binary.BigEndian.PutUint32(header[sparseImageHeaderSize+4*slot:], uint32(band+1))
+	}
+
+	img := make([]byte, alignUp(int64(len(header)), sparseImageAlign))
+	copy(img, header)
+	for slot, band := range stored {
+		data := testBand(band, bandSize)
+		if slot == len(stored)-1 && lastLength > 0 {
+			data = data[:lastLength]
+		}
+		img = append(img, data...)
+	}
+	return img
+}
+
+func TestSparseImage(t *testing.T) {
+	const bandSize = 8 * 512
+	img := buildSparseImage(t, 6, 8, []int{3, 0, 5}, 100)
+	require.True(t, IsSparseImage(bytes.NewReader(img)))
+
+	s, err := OpenSparseImage(bytes.NewReader(img), int64(len(img)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(6*bandSize), s.Size())
+
+	got, err := io.ReadAll(io.NewSectionReader(s, 0, s.Size()))
+	require.NoError(t, err)
+
+	want := make([]byte, 6*bandSize)
+	copy(want[0:], testBand(0, bandSize))
+	copy(want[3*bandSize:], testBand(3, bandSize))
+	copy(want[5*bandSize:], testBand(5, bandSize)[:100])
+	assert.True(t, bytes.Equal(want, got))
+
+	// A read spanning an allocated and an unallocated band
+	buf := make([]byte, 200)
+	_, err = s.ReadAt(buf, 4*bandSize-100)
+	require.NoError(t, err)
+	assert.Equal(t, want[4*bandSize-100:4*bandSize+100], buf)
+}
+
+func TestSparseImageLargeBandTable(t *testing.T) {
+	// More bands than fit in the first 4 KiB push the data area back
+	img := buildSparseImage(t, 1500, 1, []int{1499}, 0)
+	s, err := OpenSparseImage(bytes.NewReader(img), int64(len(img)))
+	require.NoError(t, err)
+
+	buf := make([]byte, 512)
+	_, err = s.ReadAt(buf, 1499*512)
+	require.NoError(t, err)
+	assert.Equal(t, testBand(1499, 512), buf)
+}
+
+func TestSparseImageRejectsBadTable(t *testing.T) {
+	img := buildSparseImage(t, 2, 8, []int{0}, 0)
+	binary.BigEndian.PutUint32(img[sparseImageHeaderSize:], 9)
+	_, err := OpenSparseImage(bytes.NewReader(img), int64(len(img)))
+	assert.Error(t, err)
+
+	_, err = OpenSparseImage(bytes.NewReader(make([]byte, 4096)), 4096)
+	assert.ErrorIs(t, err, ErrNotSparseImage)
+}
+
+// buildSparseBundle writes a bundle whose written bands are given by index
+func buildSparseBundle(t *testing.T, size, bandSize int64, bands map[int64][]byte) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "image.sparsebundle")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, sparseBundleBands), 0o755))
+
+	info := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleInfoDictionaryVersion</key>
+	<string>6.0</string>
+	<key>band-size</key>
+	<integer>%d</integer>
+	<key>bundle-backingstore-version</key>
+	<integer>1</integer>
+	<key>diskimage-bundle-type</key>
+	<string>com.apple.diskimage.sparsebundle</string>
+	<key>size</key>
+	<integer>%d</integer>
+</dict>
+</plist>
+`, bandSize, size)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, sparseBundleInfo), []byte(info), 0o644))
+
+	for index, data := range bands {
+		name := filepath.Join(dir, sparseBundleBands, fmt.Sprintf("%x", index))
+		require.NoError(t, os.WriteFile(name, data, 0o644))
+	}
+	return dir
+}
+
+func TestSparseBundle(t *testing.T) {
+	const bandSize = 4096
+	dir := buildSparseBundle(t, 20*bandSize, bandSize, map[int64][]byte{
+		0:  testBand(0, bandSize),
+		10: testBand(10, bandSize)[:1000], // partly written band
+		19: testBand(19, bandSize),
+	})
+	require.True(t, IsSparseBundle(dir))
+
+	b, err := OpenSparseBundle(dir)
+	require.NoError(t, err)
+	defer b.Close()
+	assert.Equal(t, int64(20*bandSize), b.Size())
+
+	got, err := io.ReadAll(io.NewSectionReader(b, 0, b.Size()))
+	require.NoError(t, err)
+
+	want := make([]byte, 20*bandSize)
+	copy(want, testBand(0, bandSize))
+	copy(want[10*bandSize:], testBand(10, bandSize)[:1000])
+	copy(want[19*bandSize:], testBand(19, bandSize))
+	assert.True(t, bytes.Equal(want, got))
+}
+
+func TestOpenImageSparseFormats(t *testing.T) {
+	disk := make([]byte, 8*4096)
+	writeNXSuperblock(disk, 0, 8)
+	copy(disk[5*4096:], "payload")
+
+	bands := make(map[int64][]byte)
+	for i := int64(0); i < 8; i++ {
+		if band := disk[i*4096 : (i+1)*4096]; !bytes.Equal(band, make([]byte, 4096)) {
+			bands[i] = band
+		}
+	}
+	bundle := buildSparseBundle(t, int64(len(disk)), 4096, bands)
+
+	sparse := buildSparseImage(t, 8, 8, []int{0}, 0)
+	copy(sparse[sparseImageAlign:], disk[:4096])
+	binary.BigEndian.PutUint32(sparse[sparseImageHeaderSize+4:], 6)
+	sparse = append(sparse, disk[5*4096:6*4096]...)
+	sparsePath := filepath.Join(t.TempDir(), "image.sparseimage")
+	require.NoError(t, os.WriteFile(sparsePath, sparse, 0o644))
+
+	for path, format := range map[string]string{bundle: "sparsebundle", sparsePath: "sparseimage"} {
+		device, err := OpenDMG(path, &DMGConfig{AutoDetectAPFS: true, CacheSize: 1})
+		require.NoError(t, err, format)
+
+		assert.Equal(t, format, device.Format())
+		assert.Equal(t, int64(len(disk)), device.Size())
+		buf := make([]byte, 7)
+		_, err = device.ReadAt(buf, 5*4096)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(buf), format)
+		require.NoError(t, device.Close())
+	}
+}
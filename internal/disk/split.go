@@ -0,0 +1,112 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// SplitRawImage presents a raw image split into numbered segment files,
+// such as image.001, image.002 and so on, as one device
+type SplitRawImage struct {
+	segments []*os.File
+
+	// starts holds the media offset at which each segment begins
+	starts []int64
+	size   int64
+}
+
+// IsSplitRaw reports whether path names the first segment of a split raw
+// image: its extension is a number of at least three digits, zero or one
+func IsSplitRaw(path string) bool {
+	ext := filepath.Ext(path)
+	if len(ext) < 4 {
+		return false
+	}
+	n, err := strconv.ParseUint(ext[1:], 10, 32)
+	return err == nil && n <= 1
+}
+
+// OpenSplitRaw opens the split raw image whose first segment is at path,
+// followed by every consecutively numbered segment that exists
+func OpenSplitRaw(path string) (*SplitRawImage, error) {
+	ext := filepath.Ext(path)
+	base := path[:len(path)-len(ext)]
+	first, err := strconv.Atoi(ext[1:])
+	if err != nil {
+		return nil, fmt.Errorf("split raw segment %s does not have a numeric extension", path)
+	}
+
+	img := &SplitRawImage{}
+	for n := first; ; n++ {
+		name := fmt.Sprintf("%s.%0*d", base, len(ext)-1, n)
+		file, err := os.Open(name)
+		if errors.Is(err, os.ErrNotExist) && n > first {
+			break
+		}
+		if err != nil {
+			img.Close()
+			return nil, fmt.Errorf("failed to open split raw segment: %w", err)
+		}
+
+		size, err := file.Seek(0, io.SeekEnd)
+		if err != nil {
+			file.Close()
+			img.Close()
+			return nil, fmt.Errorf("failed to determine size of %s: %w", name, err)
+		}
+		img.segments = append(img.segments, file)
+		img.starts = append(img.starts, img.size)
+		img.size += size
+	}
+	return img, nil
+}
+
+// Size returns the combined size of the segments
+func (s *SplitRawImage) Size() int64 {
+	return s.size
+}
+
+// ReadAt reads across segment boundaries as if the segments were one file
+func (s *SplitRawImage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= s.size {
+			return n, io.EOF
+		}
+		i := sort.Search(len(s.starts), func(i int) bool { return s.starts[i] > pos }) - 1
+		end := s.size
+		if i+1 < len(s.starts) {
+			end = s.starts[i+1]
+		}
+
+		dst := p[n : n+int(min(int64(len(p)-n), end-pos))]
+		m, err := s.segments[i].ReadAt(dst, pos-s.starts[i])
+		n += m
+		if err != nil && !(err == io.EOF && m == len(dst)) {
+			return n, fmt.Errorf("failed to read split raw segment %d: %w", i, err)
+		}
+	}
+	return n, nil
+}
+
+// Close closes the segment files
+func (s *SplitRawImage) Close() error {
+	var first error
+	for _, f := range s.segments {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.segments = nil
+	return first
+}
@@ -0,0 +1,84 @@
+package disk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSplitRaw splits data into segments of the given sizes, numbered from
+// first, and returns the path of the first segment
+func writeSplitRaw(t *testing.T, data []byte, first int, sizes ...int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i, size := range sizes {
+		name := filepath.Join(dir, fmt.Sprintf("image.%03d", first+i))
+		require.NoError(t, os.WriteFile(name, data[:size], 0o644))
+		data = data[size:]
+	}
+	return filepath.Join(dir, fmt.Sprintf("image.%03d", first))
+}
+
+func TestSplitRawImage(t *testing.T) {
+	data := ewfMedia(10000)
+	path := writeSplitRaw(t, data, 1, 4096, 1000, 0, 4904)
+	require.True(t, IsSplitRaw(path))
+
+	s, err := OpenSplitRaw(path)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Len(t, s.segments, 4)
+	assert.Equal(t, int64(len(data)), s.Size())
+
+	got, err := io.ReadAll(io.NewSectionReader(s, 0, s.Size()))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// A read spanning three segments, one of them empty
+	buf := make([]byte, 1200)
+	n, err := s.ReadAt(buf, 4000)
+	require.NoError(t, err)
+	assert.Equal(t, 1200, n)
+	assert.Equal(t, data[4000:5200], buf)
+
+	n, err = s.ReadAt(buf, int64(len(data))-100)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 100, n)
+}
+
+func TestIsSplitRaw(t *testing.T) {
+	for path, want := range map[string]bool{
+		"disk.000":  true,
+		"disk.001":  true,
+		"disk.0001": true,
+		"disk.002":  false,
+		"disk.01":   false,
+		"disk.E01":  false,
+		"disk.dmg":  false,
+	} {
+		assert.Equal(t, want, IsSplitRaw(path), path)
+	}
+}
+
+func TestOpenDMGSplitRaw(t *testing.T) {
+	disk := make([]byte, 8*4096)
+	writeNXSuperblock(disk, 0, 8)
+	copy(disk[5*4096:], "payload")
+	path := writeSplitRaw(t, disk, 0, 3*4096, 2*4096+4093, 3*4096-4093)
+
+	device, err := OpenDMG(path, &DMGConfig{AutoDetectAPFS: true, CacheSize: 1})
+	require.NoError(t, err)
+	defer device.Close()
+
+	assert.Equal(t, "split-raw", device.Format())
+	assert.Equal(t, int64(len(disk)), device.Size())
+	buf := make([]byte, 7)
+	_, err = device.ReadAt(buf, 5*4096)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(buf))
+}
@@ -0,0 +1,482 @@
+package disk
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/deploymenttheory/go-apfs/internal/compression"
+	"github.com/ulikunitz/xz"
+)
+
+// UDIF (Universal Disk Image Format) structures. A UDIF image is a data fork
+// of chunks followed by a property list describing them and a 512 byte "koly"
+// trailer. All fields are big-endian.
+const (
+	udifTrailerSize  = 512
+	udifTrailerMagic = "koly"
+	udifBlkxMagic    = "mish"
+	udifSectorSize   = 512
+	udifBlkxHeader   = 204
+	udifChunkSize    = 40
+
+	// maxUDIFChunkSectors bounds the uncompressed size of one chunk so a
+	// corrupt table cannot trigger a huge allocation
+	maxUDIFChunkSectors = 64 * 1024
+
+	// defaultUDIFCacheChunks is the number of decompressed chunks kept
+	defaultUDIFCacheChunks = 64
+)
+
+// UDIF chunk types
+const (
+	udifChunkZero    = 0x00000000
+	udifChunkRaw     = 0x00000001
+	udifChunkIgnore  = 0x00000002
+	udifChunkADC     = 0x80000004
+	udifChunkZlib    = 0x80000005
+	udifChunkBzip2   = 0x80000006
+	udifChunkLZFSE   = 0x80000007
+	udifChunkLZMA    = 0x80000008
+	udifChunkComment = 0x7FFFFFFE
+	udifChunkEnd     = 0xFFFFFFFF
+)
+
+// ErrNotUDIF is returned by OpenUDIF when the image has no koly trailer
+var ErrNotUDIF = errors.New("not a UDIF disk image")
+
+// udifChunk is a run of sectors stored with a single compression method.
+// Sector and byte offsets are absolute within the image and the file.
+type udifChunk struct {
+	kind       uint32
+	sector     int64
+	sectors    int64
+	dataOffset int64
+	dataLength int64
+}
+
+// UDIFImage presents the disk inside a UDIF image (UDRO, UDZO, UDBZ, ULFO,
+// ULMO and friends) as a random access device. Compressed chunks are
+// decompressed on demand and kept in a small cache.
+type UDIFImage struct {
+	r      io.ReaderAt
+	size   int64
+	chunks []udifChunk
+
+	mu    sync.Mutex
+	cache *lruCache[int, []byte]
+}
+
+// IsUDIF reports whether the file of size bytes ends with a koly trailer
+func IsUDIF(r io.ReaderAt, size int64) bool {
+	if size < udifTrailerSize {
+		return false
+	}
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, size-udifTrailerSize); err != nil {
+		return false
+	}
+	return string(magic) == udifTrailerMagic
+}
+
+// OpenUDIF parses the trailer and block tables of the UDIF image in r, which
+// is size bytes long
+func OpenUDIF(r io.ReaderAt, size int64) (*UDIFImage, error) {
+	if !IsUDIF(r, size) {
+		return nil, ErrNotUDIF
+	}
+
+	trailer := make([]byte, udifTrailerSize)
+	if _, err := r.ReadAt(trailer, size-udifTrailerSize); err != nil {
+		return nil, fmt.Errorf("failed to read UDIF trailer: %w", err)
+	}
+
+	dataForkOffset := int64(binary.BigEndian.Uint64(trailer[24:32]))
+	rsrcOffset := int64(binary.BigEndian.Uint64(trailer[40:48]))
+	rsrcLength := int64(binary.BigEndian.Uint64(trailer[48:56]))
+	xmlOffset := int64(binary.BigEndian.Uint64(trailer[216:224]))
+	xmlLength := int64(binary.BigEndian.Uint64(trailer[224:232]))
+	sectorCount := int64(binary.BigEndian.Uint64(trailer[492:500]))
+
+	var tables [][]byte
+	var err error
+	switch {
+	case xmlLength > 0:
+		tables, err = readUDIFPlist(r, size, xmlOffset, xmlLength)
+	case rsrcLength > 0:
+		tables, err = readUDIFResourceFork(r, size, rsrcOffset, rsrcLength)
+	default:
+		err = fmt.Errorf("UDIF image has no block tables")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	img := &UDIFImage{r: r, cache: newLRUCache[int, []byte](defaultUDIFCacheChunks, nil)}
+	for i, table := range tables {
+		chunks, err := parseBlkx(table, dataForkOffset)
+		if err != nil {
+			return nil, fmt.Errorf("blkx table %d: %w", i, err)
+		}
+		img.chunks = append(img.chunks, chunks...)
+	}
+	sort.Slice(img.chunks, func(i, j int) bool { return img.chunks[i].sector < img.chunks[j].sector })
+
+	img.size = sectorCount * udifSectorSize
+	if n := len(img.chunks); n > 0 {
+		if end := (img.chunks[n-1].sector + img.chunks[n-1].sectors) * udifSectorSize; end > img.size {
+			img.size = end
+		}
+	}
+
+	return img, nil
+}
+
+// readUDIFPlist extracts the mish tables from the XML property list
+func readUDIFPlist(r io.ReaderAt, size, off, length int64) ([][]byte, error) {
+	if off < 0 || length > size || off+length > size {
+		return nil, fmt.Errorf("UDIF property list lies outside the image")
+	}
+
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, off); err != nil {
+		return nil, fmt.Errorf("failed to read UDIF property list: %w", err)
+	}
+
+	root, err := parsePlist(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse UDIF property list: %w", err)
+	}
+
+	dict, _ := root.(map[string]any)
+	forks, _ := dict["resource-fork"].(map[string]any)
+	blkx, _ := forks["blkx"].([]any)
+	if len(blkx) == 0 {
+		return nil, fmt.Errorf("UDIF property list has no blkx entries")
+	}
+
+	var tables [][]byte
+	for _, entry := range blkx {
+		dict, _ := entry.(map[string]any)
+		if table, ok := dict["Data"].([]byte); ok {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+// readUDIFResourceFork extracts the blkx resources from a classic resource
+// fork, used by images written before the XML property list was introduced
+func readUDIFResourceFork(r io.ReaderAt, size, off, length int64) ([][]byte, error) {
+	if off < 0 || length > size || off+length > size {
+		return nil, fmt.Errorf("UDIF resource fork lies outside the image")
+	}
+
+	fork := make([]byte, length)
+	if _, err := r.ReadAt(fork, off); err != nil {
+		return nil, fmt.Errorf("failed to read UDIF resource fork: %w", err)
+	}
+
+	resources, err := parseResourceFork(fork)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse UDIF resource fork: %w", err)
+	}
+	if len(resources["blkx"]) == 0 {
+		return nil, fmt.Errorf("UDIF resource fork has no blkx resources")
+	}
+	return resources["blkx"], nil
+}
+
+// parseResourceFork returns the resources of a Mac resource fork by type
+func parseResourceFork(fork []byte) (map[string][][]byte, error) {
+	if len(fork) < 16 {
+		return nil, fmt.Errorf("resource fork header truncated")
+	}
+	dataOffset := int(binary.BigEndian.Uint32(fork[0:4]))
+	mapOffset := int(binary.BigEndian.Uint32(fork[4:8]))
+	if mapOffset < 0 || mapOffset+30 > len(fork) {
+		return nil, fmt.Errorf("resource map lies outside the fork")
+	}
+
+	resMap := fork[mapOffset:]
+	typeList := int(binary.BigEndian.Uint16(resMap[24:26]))
+	if typeList+2 > len(resMap) {
+		return nil, fmt.Errorf("resource type list lies outside the map")
+	}
+	typeData := resMap[typeList:]
+	numTypes := int(binary.BigEndian.Uint16(typeData[0:2])) + 1
+
+	resources := make(map[string][][]byte)
+	for i := 0; i < numTypes; i++ {
+		entry := 2 + i*8
+		if entry+8 > len(typeData) {
+			return nil, fmt.Errorf("resource type list truncated")
+		}
+		name := string(typeData[entry : entry+4])
+		count := int(binary.BigEndian.Uint16(typeData[entry+4:entry+6])) + 1
+		refList := int(binary.BigEndian.Uint16(typeData[entry+6 : entry+8]))
+
+		for j := 0; j < count; j++ {
+			ref := refList + j*12
+			if ref+12 > len(typeData) {
+				return nil, fmt.Errorf("resource reference list truncated")
+			}
+			off := dataOffset + int(binary.BigEndian.Uint32(typeData[ref+4:ref+8])&0x00FFFFFF)
+			if off+4 > len(fork) {
+				return nil, fmt.Errorf("resource data lies outside the fork")
+			}
+			n := int(binary.BigEndian.Uint32(fork[off : off+4]))
+			if n < 0 || off+4+n > len(fork) {
+				return nil, fmt.Errorf("resource data lies outside the fork")
+			}
+			resources[name] = append(resources[name], fork[off+4:off+4+n])
+		}
+	}
+	return resources, nil
+}
+
+// parseBlkx decodes a mish block table into chunks with absolute offsets
+func parseBlkx(table []byte, dataForkOffset int64) ([]udifChunk, error) {
+	if len(table) < udifBlkxHeader || string(table[0:4]) != udifBlkxMagic {
+		return nil, fmt.Errorf("invalid mish header")
+	}
+
+	firstSector := int64(binary.BigEndian.Uint64(table[8:16]))
+	dataStart := dataForkOffset + int64(binary.BigEndian.Uint64(table[24:32]))
+	count := int(binary.BigEndian.Uint32(table[200:204]))
+	if udifBlkxHeader+count*udifChunkSize > len(table) {
+		return nil, fmt.Errorf("mish table declares %d chunks but holds %d", count, (len(table)-udifBlkxHeader)/udifChunkSize)
+	}
+
+	var chunks []udifChunk
+	for i := 0; i < count; i++ {
+		raw := table[udifBlkxHeader+i*udifChunkSize:]
+		chunk := udifChunk{
+			kind:       binary.BigEndian.Uint32(raw[0:4]),
+			sector:     firstSector + int64(binary.BigEndian.Uint64(raw[8:16])),
+			sectors:    int64(binary.BigEndian.Uint64(raw[16:24])),
+			dataOffset: dataStart + int64(binary.BigEndian.Uint64(raw[24:32])),
+			dataLength: int64(binary.BigEndian.Uint64(raw[32:40])),
+		}
+
+		switch chunk.kind {
+		case udifChunkComment:
+			continue
+		case udifChunkEnd:
+			return chunks, nil
+		}
+		if chunk.sectors <= 0 {
+			continue
+		}
+		if chunk.sectors > maxUDIFChunkSectors {
+			return nil, fmt.Errorf("chunk %d spans %d sectors", i, chunk.sectors)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// Size returns the size of the disk inside the image
+func (u *UDIFImage) Size() int64 {
+	return u.size
+}
+
+// ReadAt reads from the disk inside the image. Sectors not described by any
+// chunk read as zeros.
+func (u *UDIFImage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= u.size {
+		return 0, io.EOF
+	}
+
+	var err error
+	if remaining := u.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+		err = io.EOF
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		sector := pos / udifSectorSize
+
+		i := sort.Search(len(u.chunks), func(i int) bool {
+			return u.chunks[i].sector+u.chunks[i].sectors > sector
+		})
+		if i == len(u.chunks) || u.chunks[i].sector > sector {
+			// A gap between chunks reads as zeros up to the next chunk
+			end := int64(len(p))
+			if i < len(u.chunks) {
+				end = min(end, u.chunks[i].sector*udifSectorSize-off)
+			}
+			clear(p[n:end])
+			n = int(end)
+			continue
+		}
+
+		chunk := &u.chunks[i]
+		start := chunk.sector * udifSectorSize
+		want := min(int64(len(p)-n), (chunk.sector+chunk.sectors)*udifSectorSize-pos)
+		dst := p[n : n+int(want)]
+
+		if err := u.readChunk(i, chunk, dst, pos-start); err != nil {
+			return n, err
+		}
+		n += len(dst)
+	}
+
+	return n, err
+}
+
+// readChunk fills dst with the bytes at off within a chunk
+func (u *UDIFImage) readChunk(index int, chunk *udifChunk, dst []byte, off int64) error {
+	switch chunk.kind {
+	case udifChunkZero, udifChunkIgnore:
+		clear(dst)
+		return nil
+	case udifChunkRaw:
+		// Raw chunks shorter than their sector span are zero padded
+		n := min(int64(len(dst)), max(chunk.dataLength-off, 0))
+		if n > 0 {
+			if _, err := u.r.ReadAt(dst[:n], chunk.dataOffset+off); err != nil {
+				return fmt.Errorf("failed to read raw chunk: %w", err)
+			}
+		}
+		clear(dst[n:])
+		return nil
+	}
+
+	data, err := u.decompressedChunk(index, chunk)
+	if err != nil {
+		return err
+	}
+	copy(dst, data[off:])
+	return nil
+}
+
+// decompressedChunk returns a chunk's decompressed sectors, from the cache
+// when possible
+func (u *UDIFImage) decompressedChunk(index int, chunk *udifChunk) ([]byte, error) {
+	u.mu.Lock()
+	data, ok := u.cache.get(index)
+	u.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	compressed := make([]byte, chunk.dataLength)
+	if _, err := u.r.ReadAt(compressed, chunk.dataOffset); err != nil {
+		return nil, fmt.Errorf("failed to read chunk at offset %d: %w", chunk.dataOffset, err)
+	}
+
+	data = make([]byte, chunk.sectors*udifSectorSize)
+	if err := decompressUDIFChunk(chunk.kind, compressed, data); err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk at sector %d: %w", chunk.sector, err)
+	}
+
+	u.mu.Lock()
+	u.cache.put(index, data)
+	u.mu.Unlock()
+	return data, nil
+}
+
+// decompressUDIFChunk decodes src into dst, which has the chunk's
+// uncompressed size
+func decompressUDIFChunk(kind uint32, src, dst []byte) error {
+	var r io.Reader
+	switch kind {
+	case udifChunkZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	case udifChunkBzip2:
+		r = bzip2.NewReader(bytes.NewReader(src))
+	case udifChunkLZMA:
+		xr, err := xz.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return err
+		}
+		r = xr
+	case udifChunkADC:
+		n, err := decompressADC(src, dst)
+		if err != nil {
+			return err
+		}
+		clear(dst[n:])
+		return nil
+	case udifChunkLZFSE:
+		r = compression.NewLZFSEReader(bytes.NewReader(src))
+	default:
+		return fmt.Errorf("unsupported chunk type 0x%08x", kind)
+	}
+
+	// The last chunk of a partition may decode to fewer bytes than its
+	// sector span; the remainder reads as zeros
+	n, err := io.ReadFull(r, dst)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		clear(dst[n:])
+		return nil
+	}
+	return err
+}
+
+// decompressADC decodes Apple Data Compression, an LZ77 variant used by
+// early UDCO images, returning the number of bytes written to dst
+func decompressADC(src, dst []byte) (int, error) {
+	in, out := 0, 0
+	for in < len(src) {
+		b := src[in]
+
+		var length, offset int
+		switch {
+		case b&0x80 != 0:
+			// Literal run
+			length = int(b&0x7F) + 1
+			if in+1+length > len(src) || out+length > len(dst) {
+				return out, fmt.Errorf("adc literal overruns buffer")
+			}
+			copy(dst[out:], src[in+1:in+1+length])
+			in += 1 + length
+			out += length
+			continue
+		case b&0x40 != 0:
+			// Three byte back reference
+			if in+3 > len(src) {
+				return out, fmt.Errorf("adc reference truncated")
+			}
+			length = int(b&0x3F) + 4
+			offset = int(binary.BigEndian.Uint16(src[in+1 : in+3]))
+			in += 3
+		default:
+			// Two byte back reference
+			if in+2 > len(src) {
+				return out, fmt.Errorf("adc reference truncated")
+			}
+			length = int(b&0x3C)>>2 + 3
+			offset = int(b&0x03)<<8 | int(src[in+1])
+			in += 2
+		}
+
+		from := out - offset - 1
+		if from < 0 || out+length > len(dst) {
+			return out, fmt.Errorf("adc reference outside the output")
+		}
+		// Byte by byte, since references may overlap the bytes being written
+		for i := 0; i < length; i++ {
+			dst[out+i] = dst[from+i]
+		}
+		out += length
+	}
+	return out, nil
+}
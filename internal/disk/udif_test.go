@@ -0,0 +1,361 @@
+package disk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+// bzip2Chunk is 4096 bytes of "bzip2 chunk " compressed with bzip2, since the
+// standard library has no bzip2 encoder
+const bzip2Chunk = "425a6839314159265359627aa5c10001ff99804000100018694210200072201a69a029546834f53c924e64936a49a492772498a49f492649262926492629278bb9229c2848313d52e080"
+
+// testChunk is a chunk written by buildUDIF
+type testChunk struct {
+	kind    uint32
+	sectors int64
+	data    []byte // stored bytes
+}
+
+// buildBlkx encodes a mish table for chunks stored from dataOffset
+func buildBlkx(firstSector, dataOffset int64, chunks []testChunk) []byte {
+	table := make([]byte, udifBlkxHeader+(len(chunks)+1)*udifChunkSize)
+	copy(table, udifBlkxMagic)
+	binary.BigEndian.PutUint32(table[4:], 1)
+	binary.BigEndian.PutUint64(table[8:], uint64(firstSector))
+	binary.BigEndian.PutUint64(table[24:], uint64(dataOffset))
+	binary.BigEndian.PutUint32(table[200:], uint32(len(chunks)+1))
+
+	var sector, offset int64
+	for i, c := range append(chunks, testChunk{kind: udifChunkEnd}) {
+		raw := table[udifBlkxHeader+i*udifChunkSize:]
+		binary.BigEndian.PutUint32(raw[0:], c.kind)
+		binary.BigEndian.PutUint64(raw[8:], uint64(sector))
+		binary.BigEndian.PutUint64(raw[16:], uint64(c.sectors))
+		binary.BigEndian.PutUint64(raw[24:], uint64(offset))
+		binary.BigEndian.PutUint64(raw[32:], uint64(len(c.data)))
+		sector += c.sectors
+		offset += int64(len(c.data))
+	}
+	return table
+}
+
+// buildUDIF returns a UDIF image holding one partition table per element of
+// parts, laid out back to back. With xmlPlist false the tables are stored in
+// a classic resource fork.
+func buildUDIF(t *testing.T, parts [][]testChunk, xmlPlist bool) []byte {
+	t.Helper()
+	var image bytes.Buffer
+	var tables [][]byte
+	var sector int64
+	for _, chunks := range parts {
+		tables = append(tables, buildBlkx(sector, int64(image.Len()), chunks))
+		for _, c := range chunks {
+			image.Write(c.data)
+			sector += c.sectors
+		}
+	}
+	dataLength := image.Len()
+
+	var metaOffset, metaLength int
+	if xmlPlist {
+		var sb strings.Builder
+		sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>resource-fork</key>
+	<dict>
+		<key>blkx</key>
+		<array>
+`)
+		for i, table := range tables {
+			fmt.Fprintf(&sb, "\t\t\t<dict>\n\t\t\t\t<key>Attributes</key>\n\t\t\t\t<string>0x0050</string>\n")
+			fmt.Fprintf(&sb, "\t\t\t\t<key>Data</key>\n\t\t\t\t<data>\n")
+			encoded := base64.StdEncoding.EncodeToString(table)
+			for len(encoded) > 52 {
+				fmt.Fprintf(&sb, "\t\t\t\t%s\n", encoded[:52])
+				encoded = encoded[52:]
+			}
+			fmt.Fprintf(&sb, "\t\t\t\t%s\n\t\t\t\t</data>\n", encoded)
+			fmt.Fprintf(&sb, "\t\t\t\t<key>ID</key>\n\t\t\t\t<string>%d</string>\n\t\t\t\t<key>Name</key>\n\t\t\t\t<string>part %d</string>\n\t\t\t</dict>\n", i-1, i)
+		}
+		sb.WriteString("\t\t</array>\n\t\t<key>plst</key>\n\t\t<array/>\n\t</dict>\n\t<key>empty</key>\n\t<true/>\n</dict>\n</plist>\n")
+		metaOffset, metaLength = image.Len(), sb.Len()
+		image.WriteString(sb.String())
+	} else {
+		fork := buildResourceFork(map[string][][]byte{"blkx": tables, "plst": {{0}}})
+		metaOffset, metaLength = image.Len(), len(fork)
+		image.Write(fork)
+	}
+
+	trailer := make([]byte, udifTrailerSize)
+	copy(trailer, udifTrailerMagic)
+	binary.BigEndian.PutUint32(trailer[4:], 4)
+	binary.BigEndian.PutUint32(trailer[8:], udifTrailerSize)
+	binary.BigEndian.PutUint64(trailer[32:], uint64(dataLength))
+	if xmlPlist {
+		binary.BigEndian.PutUint64(trailer[216:], uint64(metaOffset))
+		binary.BigEndian.PutUint64(trailer[224:], uint64(metaLength))
+	} else {
+		binary.BigEndian.PutUint64(trailer[40:], uint64(metaOffset))
+		binary.BigEndian.PutUint64(trailer[48:], uint64(metaLength))
+	}
+	binary.BigEndian.PutUint64(trailer[492:], uint64(sector))
+	image.Write(trailer)
+
+	return image.Bytes()
+}
+
+// buildResourceFork encodes resources in the classic resource fork layout
+func buildResourceFork(resources map[string][][]byte) []byte {
+	names := []string{"blkx", "plst"}
+
+	var data bytes.Buffer
+	type ref struct{ offset int }
+	refs := make(map[string][]ref)
+	for _, name := range names {
+		for _, r := range resources[name] {
+			refs[name] = append(refs[name], ref{data.Len()})
+			binary.Write(&data, binary.BigEndian, uint32(len(r)))
+			data.Write(r)
+		}
+	}
+
+	// Map: 16 byte header copy, handle, file ref, attributes, two offsets,
+	// then the type list and reference lists
+	typeList := make([]byte, 2+8*len(names))
+	binary.BigEndian.PutUint16(typeList, uint16(len(names)-1))
+	var refList []byte
+	for i, name := range names {
+		entry := typeList[2+8*i:]
+		copy(entry, name)
+		binary.BigEndian.PutUint16(entry[4:], uint16(len(refs[name])-1))
+		binary.BigEndian.PutUint16(entry[6:], uint16(len(typeList)+len(refList)))
+		for j, r := range refs[name] {
+			e := make([]byte, 12)
+			binary.BigEndian.PutUint16(e, uint16(128+j))
+			binary.BigEndian.PutUint16(e[2:], 0xFFFF)
+			binary.BigEndian.PutUint32(e[4:], uint32(r.offset))
+			refList = append(refList, e...)
+		}
+	}
+	resMap := make([]byte, 28)
+	binary.BigEndian.PutUint16(resMap[24:], 28)
+	resMap = append(append(resMap, typeList...), refList...)
+
+	fork := make([]byte, 256)
+	binary.BigEndian.PutUint32(fork[0:], 256)
+	binary.BigEndian.PutUint32(fork[4:], uint32(256+data.Len()))
+	binary.BigEndian.PutUint32(fork[8:], uint32(data.Len()))
+	binary.BigEndian.PutUint32(fork[12:], uint32(len(resMap)))
+	return append(append(fork, data.Bytes()...), resMap...)
+}
+
+// encodeADC encodes a repeating four byte pattern of n bytes with literal,
+// two byte and three byte ADC codes
+func encodeADC(pattern string, n int) []byte {
+	out := append([]byte{0x80 | byte(len(pattern)-1)}, pattern...)
+	written := len(pattern)
+
+	out = append(out, byte((18-3)<<2), 3) // two byte reference, length 18
+	written += 18
+
+	for written < n {
+		length := min(n-written, 67)
+		out = append(out, 0x40|byte(length-4), 0, 3)
+		written += length
+	}
+	return out
+}
+
+// lzfseChunk returns an LZFSE stream of n bytes repeating a 16 byte pattern:
+// an uncompressed block holding the pattern, then an LZVN block of matches
+// reaching back into it
+func lzfseChunk(pattern string, n int) []byte {
+	lzvn := []byte{0x3f, 16, 0} // ten bytes from distance 16
+	for remaining := n - 16 - 10; remaining > 0; {
+		m := min(remaining, 271)
+		if m >= 16 {
+			lzvn = append(lzvn, 0xf0, byte(m-16))
+		} else {
+			lzvn = append(lzvn, 0xf0|byte(m))
+		}
+		remaining -= m
+	}
+	lzvn = append(lzvn, 0x06, 0, 0, 0, 0, 0, 0, 0)
+
+	out := binary.LittleEndian.AppendUint32([]byte("bvx-"), uint32(len(pattern)))
+	out = append(out, pattern...)
+	out = append(out, "bvxn"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(n-len(pattern)))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(lzvn)))
+	out = append(out, lzvn...)
+	return append(out, "bvx$"...)
+}
+
+func zlibChunk(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func xzChunk(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	xw, err := xz.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = xw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, xw.Close())
+	return buf.Bytes()
+}
+
+// udifFixture returns a two partition UDIF image using every supported chunk
+// type, and the disk it encodes
+func udifFixture(t *testing.T, xmlPlist bool) ([]byte, []byte) {
+	raw := bytes.Repeat([]byte("raw sector data!"), 256)
+	zlibData := bytes.Repeat([]byte("zlib chunk "), 400)[:4096]
+	bzData := bytes.Repeat([]byte("bzip2 chunk "), 400)[:4096]
+	lzmaData := bytes.Repeat([]byte("lzma chunk "), 400)[:4096]
+	adcData := bytes.Repeat([]byte("ABCD"), 1024)
+	lzfseData := bytes.Repeat([]byte("lzfse chunk 0123"), 256)
+	short := []byte("short last chunk")
+	bz, err := hex.DecodeString(bzip2Chunk)
+	require.NoError(t, err)
+
+	img := buildUDIF(t, [][]testChunk{
+		{
+			{kind: udifChunkComment, sectors: 0},
+			{kind: udifChunkRaw, sectors: 8, data: raw},
+			{kind: udifChunkZlib, sectors: 8, data: zlibChunk(t, zlibData)},
+			{kind: udifChunkZero, sectors: 8},
+			{kind: udifChunkBzip2, sectors: 8, data: bz},
+		},
+		{
+			{kind: udifChunkLZMA, sectors: 8, data: xzChunk(t, lzmaData)},
+			{kind: udifChunkADC, sectors: 8, data: encodeADC("ABCD", 4096)},
+			{kind: udifChunkLZFSE, sectors: 8, data: lzfseChunk("lzfse chunk 0123", 4096)},
+			{kind: udifChunkIgnore, sectors: 4},
+			{kind: udifChunkZlib, sectors: 1, data: zlibChunk(t, short)},
+		},
+	}, xmlPlist)
+
+	want := bytes.Join([][]byte{raw, zlibData, make([]byte, 4096), bzData, lzmaData, adcData, lzfseData, make([]byte, 2048), short, make([]byte, 512-len(short))}, nil)
+	return img, want
+}
+
+func TestUDIFReadAt(t *testing.T) {
+	for _, xmlPlist := range []bool{true, false} {
+		t.Run(fmt.Sprintf("xml=%t", xmlPlist), func(t *testing.T) {
+			img, want := udifFixture(t, xmlPlist)
+			require.True(t, IsUDIF(bytes.NewReader(img), int64(len(img))))
+
+			u, err := OpenUDIF(bytes.NewReader(img), int64(len(img)))
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(want)), u.Size())
+
+			got, err := io.ReadAll(io.NewSectionReader(u, 0, u.Size()))
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(want, got))
+
+			// Reads spanning chunk boundaries, and reads past the end
+			buf := make([]byte, 5000)
+			n, err := u.ReadAt(buf, 4000)
+			require.NoError(t, err)
+			assert.Equal(t, want[4000:9000], buf[:n])
+
+			n, err = u.ReadAt(buf, u.Size()-100)
+			assert.Equal(t, io.EOF, err)
+			assert.Equal(t, 100, n)
+			assert.Equal(t, want[len(want)-100:], buf[:n])
+		})
+	}
+}
+
+func TestUDIFRejectsOtherImages(t *testing.T) {
+	_, err := OpenUDIF(bytes.NewReader(make([]byte, 4096)), 4096)
+	assert.ErrorIs(t, err, ErrNotUDIF)
+
+	img, _ := udifFixture(t, true)
+	// Corrupt the first mish signature in the property list's data
+	corrupt := bytes.Replace(img, []byte("bWlzaA"), []byte("AAAAAA"), 1)
+	_, err = OpenUDIF(bytes.NewReader(corrupt), int64(len(corrupt)))
+	assert.Error(t, err)
+}
+
+func TestUDIFUnsupportedChunk(t *testing.T) {
+	img := buildUDIF(t, [][]testChunk{{{kind: 0x80000009, sectors: 1, data: []byte("data")}}}, true)
+	u, err := OpenUDIF(bytes.NewReader(img), int64(len(img)))
+	require.NoError(t, err)
+
+	_, err = u.ReadAt(make([]byte, 512), 0)
+	assert.ErrorContains(t, err, "unsupported chunk type 0x80000009")
+}
+
+func TestOpenDMGCompressed(t *testing.T) {
+	disk := make([]byte, 64*1024)
+	writeNXSuperblock(disk, 0, 16)
+	copy(disk[8192:], "payload")
+
+	img := buildUDIF(t, [][]testChunk{{
+		{kind: udifChunkZlib, sectors: 64, data: zlibChunk(t, disk[:32*1024])},
+		{kind: udifChunkZlib, sectors: 64, data: zlibChunk(t, disk[32*1024:])},
+	}}, true)
+	path := filepath.Join(t.TempDir(), "image.dmg")
+	require.NoError(t, os.WriteFile(path, img, 0o644))
+
+	device, err := OpenDMG(path, &DMGConfig{AutoDetectAPFS: true, CacheSize: 1})
+	require.NoError(t, err)
+	defer device.Close()
+
+	assert.Equal(t, "udif", device.Format())
+	assert.Equal(t, int64(len(disk)), device.Size())
+	buf := make([]byte, 7)
+	_, err = device.ReadAt(buf, 8192)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(buf))
+}
+
+func TestDecompressADC(t *testing.T) {
+	dst := make([]byte, 100)
+	n, err := decompressADC(encodeADC("ABCD", 100), dst)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, strings.Repeat("ABCD", 25), string(dst))
+
+	// A reference before the start of the output is rejected
+	_, err = decompressADC([]byte{0x40, 0x00, 0x05}, dst)
+	assert.Error(t, err)
+}
+
+func TestParsePlist(t *testing.T) {
+	value, err := parsePlist([]byte(`<?xml version="1.0"?><plist version="1.0"><dict>
+		<key>n</key><integer>42</integer>
+		<key>s</key><string>text</string>
+		<key>d</key><data>aGVs
+		bG8=</data>
+		<key>a</key><array><true/><real>1.5</real><dict/></array>
+	</dict></plist>`))
+	require.NoError(t, err)
+
+	dict := value.(map[string]any)
+	assert.Equal(t, int64(42), dict["n"])
+	assert.Equal(t, "text", dict["s"])
+	assert.Equal(t, []byte("hello"), dict["d"])
+	assert.Equal(t, []any{true, 1.5, map[string]any{}}, dict["a"])
+}
@@ -65,6 +65,9 @@ type InodeReader interface {
 	// HasResourceFork checks if the inode has a resource fork
 	HasResourceFork() bool
 
+	// BSDFlags returns the inode's BSD flags, such as UF_COMPRESSED
+	BSDFlags() uint32
+
 	// Size returns the file size
 	Size() uint64
 }
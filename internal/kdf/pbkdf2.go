@@ -0,0 +1,39 @@
+// Package kdf implements the key derivation functions used by encrypted
+// APFS volumes and disk images.
+package kdf
+
+import (
+	"crypto/hmac"
+	"hash"
+)
+
+// PBKDF2 derives a keyLen byte key from password and salt using PBKDF2 with
+// HMAC over the hash function h, as specified in RFC 2898
+func PBKDF2(h func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	blockCount := (keyLen + hashLen - 1) / hashLen
+
+	result := make([]byte, 0, blockCount*hashLen)
+	u := make([]byte, 0, hashLen)
+	t := make([]byte, hashLen)
+	for block := 1; block <= blockCount; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		result = append(result, t...)
+	}
+
+	return result[:keyLen]
+}
@@ -0,0 +1,24 @@
+package kdf
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 6070 test vectors for HMAC-SHA1
+	assert.Equal(t, "0c60c80f961f0e71f3a9b524af6012062fe037a6",
+		hex.EncodeToString(PBKDF2(sha1.New, []byte("password"), []byte("salt"), 1, 20)))
+	assert.Equal(t, "4b007901b765489abead49d926f721d065a429c1",
+		hex.EncodeToString(PBKDF2(sha1.New, []byte("password"), []byte("salt"), 4096, 20)))
+	assert.Equal(t, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038",
+		hex.EncodeToString(PBKDF2(sha1.New, []byte("passwordPASSWORDpassword"), []byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"), 4096, 25)))
+
+	// HMAC-SHA256, with a key longer than one hash block
+	assert.Equal(t, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b4dbf3a2f3dad3377264bb7b8e8330d4efc7451418617dabef683735361cdc18c",
+		hex.EncodeToString(PBKDF2(sha256.New, []byte("password"), []byte("salt"), 1, 64)))
+}
@@ -0,0 +1,109 @@
+package ninep
+
+import (
+	"io/fs"
+	"time"
+)
+
+// Unix file type and permission bits reported by Rgetattr
+const (
+	modeTypeFifo    = 0o010000
+	modeTypeChar    = 0o020000
+	modeTypeDir     = 0o040000
+	modeTypeBlock   = 0o060000
+	modeTypeFile    = 0o100000
+	modeTypeSymlink = 0o120000
+	modeTypeSocket  = 0o140000
+	modeSetuid      = 0o4000
+	modeSetgid      = 0o2000
+	modeSticky      = 0o1000
+)
+
+// attr is the subset of inode metadata carried by Rgetattr
+type attr struct {
+	mode                       uint32
+	uid, gid                   uint32
+	nlink                      uint64
+	atime, mtime, ctime, btime time.Time
+}
+
+// newAttr collects the attributes of a file, preferring the raw APFS
+// inode fields when the FileInfo carries them
+func newAttr(info fs.FileInfo) attr {
+	a := attr{
+		mode:  unixMode(info.Mode()),
+		nlink: 1,
+		atime: info.ModTime(),
+		mtime: info.ModTime(),
+		ctime: info.ModTime(),
+	}
+	if info.IsDir() {
+		a.nlink = 2
+	}
+
+	inode, ok := info.Sys().(inodeInfo)
+	if !ok {
+		return a
+	}
+	if raw := inode.RawMode(); raw != 0 {
+		a.mode = uint32(raw)
+	}
+	a.uid = inode.UID()
+	a.gid = inode.GID()
+	if n := inode.Nlink(); n != 0 {
+		a.nlink = uint64(n)
+	}
+	if t := inode.AccessTime(); !t.IsZero() {
+		a.atime = t
+	}
+	if t := inode.ChangeTime(); !t.IsZero() {
+		a.ctime = t
+	}
+	a.btime = inode.BirthTime()
+	return a
+}
+
+// unixMode converts an fs.FileMode to st_mode bits
+func unixMode(mode fs.FileMode) uint32 {
+	m := uint32(mode.Perm())
+
+	switch {
+	case mode.IsDir():
+		m |= modeTypeDir
+	case mode&fs.ModeSymlink != 0:
+		m |= modeTypeSymlink
+	case mode&fs.ModeNamedPipe != 0:
+		m |= modeTypeFifo
+	case mode&fs.ModeSocket != 0:
+		m |= modeTypeSocket
+	case mode&fs.ModeCharDevice != 0:
+		m |= modeTypeChar
+	case mode&fs.ModeDevice != 0:
+		m |= modeTypeBlock
+	default:
+		m |= modeTypeFile
+	}
+
+	if mode&fs.ModeSetuid != 0 {
+		m |= modeSetuid
+	}
+	if mode&fs.ModeSetgid != 0 {
+		m |= modeSetgid
+	}
+	if mode&fs.ModeSticky != 0 {
+		m |= modeSticky
+	}
+	return m
+}
+
+// timespec is a time split into seconds and nanoseconds
+type timespec struct {
+	sec, nsec uint64
+}
+
+func toTimespec(t time.Time) timespec {
+	if t.IsZero() {
+		return timespec{}
+	}
+	return timespec{sec: uint64(t.Unix()), nsec: uint64(t.Nanosecond())}
+}
@@ -0,0 +1,71 @@
+package ninep
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// FileSystem is the read-only tree exported by the server. Names are
+// absolute, slash separated paths; "/" is the root of the export. Files
+// returned by Open must implement io.ReaderAt.
+type FileSystem interface {
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Open(name string) (fs.File, error)
+	Readlink(name string) (string, error)
+	Xattrs(name string) (map[string][]byte, error)
+}
+
+// inodeInfo is the APFS-specific metadata carried by *apfs.FileInfo. When
+// a FileInfo's Sys value implements it the inode number becomes the qid path,
+// giving clients stable file handles.
+type inodeInfo interface {
+	Inode() uint64
+	RawMode() uint16
+	UID() uint32
+	GID() uint32
+	Nlink() uint32
+	AccessTime() time.Time
+	ChangeTime() time.Time
+	BirthTime() time.Time
+}
+
+// volumeFS exports an apfs.Volume
+type volumeFS struct {
+	vol *apfs.Volume
+}
+
+// NewVolumeFileSystem exports an APFS volume or snapshot
+func NewVolumeFileSystem(vol *apfs.Volume) FileSystem {
+	return &volumeFS{vol: vol}
+}
+
+func (v *volumeFS) Lstat(name string) (fs.FileInfo, error) {
+	info, err := v.vol.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (v *volumeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return v.vol.ReadDir(name)
+}
+
+func (v *volumeFS) Open(name string) (fs.File, error) {
+	f, err := v.vol.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (v *volumeFS) Readlink(name string) (string, error) {
+	return v.vol.Readlink(name)
+}
+
+func (v *volumeFS) Xattrs(name string) (map[string][]byte, error) {
+	return v.vol.Xattrs(name)
+}
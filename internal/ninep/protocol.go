@@ -0,0 +1,245 @@
+package ninep
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version is the protocol version negotiated by the server
+const Version = "9P2000.L"
+
+// DefaultMsize is the largest message size the server accepts
+const DefaultMsize = 256 * 1024
+
+// headerSize is size[4] type[1] tag[2]
+const headerSize = 7
+
+// ioHeaderSize is the overhead of an Rread or Rreaddir message before its data
+const ioHeaderSize = headerSize + 4
+
+// Message types of 9P2000.L. Only the T-message numbers are listed; each
+// R-message is the T-message number plus one.
+const (
+	msgRlerror      = 7
+	msgTstatfs      = 8
+	msgTlopen       = 12
+	msgTlcreate     = 14
+	msgTsymlink     = 16
+	msgTmknod       = 18
+	msgTrename      = 20
+	msgTreadlink    = 22
+	msgTgetattr     = 24
+	msgTsetattr     = 26
+	msgTxattrwalk   = 30
+	msgTxattrcreate = 32
+	msgTreaddir     = 40
+	msgTfsync       = 50
+	msgTlock        = 52
+	msgTgetlock     = 54
+	msgTlink        = 70
+	msgTmkdir       = 72
+	msgTrenameat    = 74
+	msgTunlinkat    = 76
+	msgTversion     = 100
+	msgTattach      = 104
+	msgTflush       = 108
+	msgTwalk        = 110
+	msgTread        = 116
+	msgTwrite       = 118
+	msgTclunk       = 120
+	msgTremove      = 122
+)
+
+// Qid types
+const (
+	qidTypeDir     = 0x80
+	qidTypeSymlink = 0x02
+	qidTypeFile    = 0x00
+)
+
+// Linux errno values carried by Rlerror. 9P2000.L always uses the Linux
+// numbering regardless of the platform the server runs on.
+const (
+	errnoENOENT  = 2
+	errnoEIO     = 5
+	errnoEBADF   = 9
+	errnoENOTDIR = 20
+	errnoEISDIR  = 21
+	errnoEINVAL  = 22
+	errnoEROFS   = 30
+	errnoENOSYS  = 38
+	errnoENODATA = 61
+	errnoEPROTO  = 71
+)
+
+// Getattr validity mask bits
+const (
+	getattrBasic = 0x000007ff
+	getattrBtime = 0x00000800
+)
+
+// Values reported by Rstatfs and Rgetattr
+const (
+	v9fsMagic = 0x01021997
+	blockSize = 4096
+)
+
+// Values used in Rlock and Rgetlock
+const (
+	lockSuccess    = 0
+	lockTypeUnlock = 2
+)
+
+// Open flags checked by Tlopen
+const (
+	openAccessMask = 0x3
+	openTrunc      = 0x200
+	openCreat      = 0x40
+)
+
+// Directory entry types reported by Treaddir, as in dirent d_type
+const (
+	direntUnknown = 0
+	direntFifo    = 1
+	direntChar    = 2
+	direntDir     = 4
+	direntBlock   = 6
+	direntFile    = 8
+	direntSymlink = 10
+	direntSocket  = 12
+)
+
+// errShortMessage reports a message that ended before all of its fields were read
+var errShortMessage = errors.New("9p: short message")
+
+// Qid is the server's unique identification of a file
+type Qid struct {
+	Type    uint8
+	Version uint32
+	Path    uint64
+}
+
+// qidSize is the encoded size of a Qid
+const qidSize = 13
+
+// encoder builds a single 9P message
+type encoder struct {
+	buf []byte
+}
+
+// newEncoder starts a message of the given type and tag
+func newEncoder(msgType uint8, tag uint16) *encoder {
+	e := &encoder{buf: make([]byte, headerSize, 64)}
+	e.buf[4] = msgType
+	binary.LittleEndian.PutUint16(e.buf[5:], tag)
+	return e
+}
+
+func (e *encoder) u8(v uint8) { e.buf = append(e.buf, v) }
+
+func (e *encoder) u16(v uint16) { e.buf = binary.LittleEndian.AppendUint16(e.buf, v) }
+
+func (e *encoder) u32(v uint32) { e.buf = binary.LittleEndian.AppendUint32(e.buf, v) }
+
+func (e *encoder) u64(v uint64) { e.buf = binary.LittleEndian.AppendUint64(e.buf, v) }
+
+func (e *encoder) str(s string) {
+	e.u16(uint16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) qid(q Qid) {
+	e.u8(q.Type)
+	e.u32(q.Version)
+	e.u64(q.Path)
+}
+
+func (e *encoder) data(b []byte) {
+	e.u32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// bytes finalises the size field and returns the encoded message
+func (e *encoder) bytes() []byte {
+	binary.LittleEndian.PutUint32(e.buf[0:], uint32(len(e.buf)))
+	return e.buf
+}
+
+// decoder reads the fields of a single 9P message. The first read past the
+// end of the message records errShortMessage and all later reads return zero.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errShortMessage
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) str() string {
+	n := d.u16()
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) qid() Qid {
+	return Qid{Type: d.u8(), Version: d.u32(), Path: d.u64()}
+}
+
+// readMessage reads one message from r and returns its type, tag and body
+func readMessage(r io.Reader, msize uint32) (uint8, uint16, []byte, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, 0, nil, err
+	}
+
+	size := binary.LittleEndian.Uint32(hdr[0:])
+	if size < headerSize || size > msize {
+		return 0, 0, nil, fmt.Errorf("9p: invalid message size %d", size)
+	}
+
+	body := make([]byte, size-headerSize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return hdr[4], binary.LittleEndian.Uint16(hdr[5:]), body, nil
+}
@@ -0,0 +1,723 @@
+package ninep
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// maxWalkElements is the largest number of names accepted by one Twalk
+const maxWalkElements = 16
+
+// errno is a Linux error number returned to the client in an Rlerror
+type errno uint32
+
+func (e errno) Error() string { return fmt.Sprintf("9p: errno %d", uint32(e)) }
+
+// Server exports a FileSystem read-only over 9P2000.L. Linux clients can
+// mount it with:
+//
+//	mount -t 9p -o trans=tcp,port=<port>,version=9p2000.L,ro <host> <dir>
+type Server struct {
+	fsys  FileSystem
+	msize uint32
+
+	// ErrorLog receives connection errors; the standard logger is used when nil
+	ErrorLog *log.Logger
+}
+
+// NewServer creates a Server exporting fsys
+func NewServer(fsys FileSystem) *Server {
+	return &Server{fsys: fsys, msize: DefaultMsize}
+}
+
+// Serve accepts connections on ln and serves each of them until ln is closed
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(conn); err != nil {
+				s.logf("9p: %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn serves a single client connection until it is closed
+func (s *Server) ServeConn(rw io.ReadWriteCloser) error {
+	c := &conn{server: s, msize: s.msize, fids: map[uint32]*fid{}}
+	defer rw.Close()
+	defer c.clunkAll()
+
+	for {
+		msgType, tag, body, err := readMessage(rw, c.msize)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if _, err := rw.Write(c.handle(msgType, tag, body)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// fid is the server side state of a client file identifier
+type fid struct {
+	path string
+	info fs.FileInfo
+	qid  Qid
+
+	opened  bool
+	file    io.ReaderAt
+	closer  io.Closer
+	entries []dirent
+
+	xattr   []byte
+	isXattr bool
+}
+
+// dirent is a directory entry prepared for Rreaddir
+type dirent struct {
+	qid  Qid
+	typ  uint8
+	name string
+}
+
+// conn holds the per-connection session state
+type conn struct {
+	server *Server
+	msize  uint32
+	fids   map[uint32]*fid
+}
+
+// handle processes one T-message and returns the encoded reply
+func (c *conn) handle(msgType uint8, tag uint16, body []byte) []byte {
+	d := &decoder{buf: body}
+	r := newEncoder(msgType+1, tag)
+
+	var err error
+	switch msgType {
+	case msgTversion:
+		err = c.version(d, r)
+	case msgTattach:
+		err = c.attach(d, r)
+	case msgTwalk:
+		err = c.walk(d, r)
+	case msgTlopen:
+		err = c.lopen(d, r)
+	case msgTread:
+		err = c.read(d, r)
+	case msgTreaddir:
+		err = c.readdir(d, r)
+	case msgTgetattr:
+		err = c.getattr(d, r)
+	case msgTreadlink:
+		err = c.readlink(d, r)
+	case msgTxattrwalk:
+		err = c.xattrwalk(d, r)
+	case msgTstatfs:
+		err = c.statfs(d, r)
+	case msgTclunk:
+		err = c.clunk(d)
+	case msgTremove:
+		// Tremove clunks the fid even when the removal fails
+		if err = c.clunk(d); err == nil {
+			err = errno(errnoEROFS)
+		}
+	case msgTlock:
+		err = c.lock(d, r)
+	case msgTgetlock:
+		err = c.getlock(d, r)
+	case msgTflush, msgTfsync:
+	case msgTlcreate, msgTsymlink, msgTmknod, msgTrename, msgTsetattr, msgTxattrcreate,
+		msgTlink, msgTmkdir, msgTrenameat, msgTunlinkat, msgTwrite:
+		err = errno(errnoEROFS)
+	default:
+		err = errno(errnoENOSYS)
+	}
+
+	if err != nil {
+		r = newEncoder(msgRlerror, tag)
+		r.u32(uint32(toErrno(err)))
+	}
+	return r.bytes()
+}
+
+// toErrno maps an error from the file system to a Linux errno
+func toErrno(err error) errno {
+	var e errno
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, errShortMessage):
+		return errnoEPROTO
+	case errors.Is(err, fs.ErrNotExist):
+		return errnoENOENT
+	case errors.Is(err, apfs.ErrNotDir):
+		return errnoENOTDIR
+	case errors.Is(err, apfs.ErrIsDir):
+		return errnoEISDIR
+	case errors.Is(err, apfs.ErrNoXattr):
+		return errnoENODATA
+	case errors.Is(err, apfs.ErrNotSymlink), errors.Is(err, fs.ErrInvalid):
+		return errnoEINVAL
+	default:
+		return errnoEIO
+	}
+}
+
+// lookup returns the state of an existing fid
+func (c *conn) lookup(id uint32) (*fid, error) {
+	f, ok := c.fids[id]
+	if !ok {
+		return nil, errno(errnoEBADF)
+	}
+	return f, nil
+}
+
+// stat returns the metadata and qid of a path in the export
+func (c *conn) stat(p string) (fs.FileInfo, Qid, error) {
+	info, err := c.server.fsys.Lstat(p)
+	if err != nil {
+		return nil, Qid{}, err
+	}
+	return info, newQid(p, info), nil
+}
+
+// clunkAll releases every fid of the session
+func (c *conn) clunkAll() {
+	for id, f := range c.fids {
+		f.close()
+		delete(c.fids, id)
+	}
+}
+
+func (f *fid) close() {
+	if f.closer != nil {
+		f.closer.Close()
+		f.closer = nil
+	}
+}
+
+func (c *conn) version(d *decoder, r *encoder) error {
+	msize := d.u32()
+	version := d.str()
+	if d.err != nil {
+		return d.err
+	}
+
+	c.clunkAll()
+
+	if msize > c.server.msize {
+		msize = c.server.msize
+	}
+	if msize < ioHeaderSize+qidSize {
+		return errno(errnoEINVAL)
+	}
+	c.msize = msize
+
+	r.u32(msize)
+	if strings.HasPrefix(version, Version) {
+		r.str(Version)
+	} else {
+		r.str("unknown")
+	}
+	return nil
+}
+
+func (c *conn) attach(d *decoder, r *encoder) error {
+	id := d.u32()
+	d.u32() // afid, authentication is not supported
+	d.str() // uname
+	d.str() // aname
+	d.u32() // n_uname
+	if d.err != nil {
+		return d.err
+	}
+	if _, ok := c.fids[id]; ok {
+		return errno(errnoEBADF)
+	}
+
+	info, qid, err := c.stat("/")
+	if err != nil {
+		return err
+	}
+	c.fids[id] = &fid{path: "/", info: info, qid: qid}
+
+	r.qid(qid)
+	return nil
+}
+
+func (c *conn) walk(d *decoder, r *encoder) error {
+	id := d.u32()
+	newID := d.u32()
+	n := d.u16()
+	names := make([]string, 0, n)
+	for i := 0; i < int(n); i++ {
+		names = append(names, d.str())
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if n > maxWalkElements {
+		return errno(errnoEINVAL)
+	}
+
+	f, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	if f.opened || f.isXattr {
+		return errno(errnoEBADF)
+	}
+	if _, ok := c.fids[newID]; ok && newID != id {
+		return errno(errnoEBADF)
+	}
+
+	p, info, qid := f.path, f.info, f.qid
+	var qids []Qid
+	for i, name := range names {
+		if name == "" || strings.Contains(name, "/") {
+			err = errno(errnoEINVAL)
+		} else if !info.IsDir() {
+			err = errno(errnoENOTDIR)
+		} else {
+			next := path.Join(p, name)
+			if name == ".." && p == "/" {
+				next = "/"
+			}
+			var nextInfo fs.FileInfo
+			if nextInfo, qid, err = c.stat(next); err == nil {
+				p, info = next, nextInfo
+			}
+		}
+
+		if err != nil {
+			// A failure on the first element is an error; later failures
+			// return the qids walked so far and leave newfid unused
+			if i == 0 {
+				return err
+			}
+			break
+		}
+		qids = append(qids, qid)
+	}
+
+	if len(qids) == len(names) {
+		if newID == id {
+			f.path, f.info, f.qid = p, info, qid
+		} else {
+			c.fids[newID] = &fid{path: p, info: info, qid: qid}
+		}
+	}
+
+	r.u16(uint16(len(qids)))
+	for _, q := range qids {
+		r.qid(q)
+	}
+	return nil
+}
+
+func (c *conn) lopen(d *decoder, r *encoder) error {
+	id := d.u32()
+	flags := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+
+	f, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	if f.opened || f.isXattr {
+		return errno(errnoEBADF)
+	}
+	if flags&openAccessMask != 0 || flags&(openTrunc|openCreat) != 0 {
+		return errno(errnoEROFS)
+	}
+
+	if !f.info.IsDir() {
+		file, err := c.server.fsys.Open(f.path)
+		if err != nil {
+			return err
+		}
+		ra, ok := file.(io.ReaderAt)
+		if !ok {
+			file.Close()
+			return errno(errnoEIO)
+		}
+		f.file, f.closer = ra, file
+	}
+	f.opened = true
+
+	r.qid(f.qid)
+	r.u32(c.msize - ioHeaderSize)
+	return nil
+}
+
+func (c *conn) read(d *decoder, r *encoder) error {
+	id := d.u32()
+	offset := d.u64()
+	count := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+
+	f, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	if max := c.msize - ioHeaderSize; count > max {
+		count = max
+	}
+
+	if f.isXattr {
+		if offset >= uint64(len(f.xattr)) {
+			r.data(nil)
+			return nil
+		}
+		end := offset + uint64(count)
+		if end > uint64(len(f.xattr)) {
+			end = uint64(len(f.xattr))
+		}
+		r.data(f.xattr[offset:end])
+		return nil
+	}
+
+	if !f.opened {
+		return errno(errnoEBADF)
+	}
+	if f.file == nil {
+		return errno(errnoEISDIR)
+	}
+
+	buf := make([]byte, count)
+	n, err := f.file.ReadAt(buf, int64(offset))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	r.data(buf[:n])
+	return nil
+}
+
+func (c *conn) readdir(d *decoder, r *encoder) error {
+	id := d.u32()
+	offset := d.u64()
+	count := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+
+	f, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	if !f.opened {
+		return errno(errnoEBADF)
+	}
+	if !f.info.IsDir() {
+		return errno(errnoENOTDIR)
+	}
+	if max := c.msize - ioHeaderSize; count > max {
+		count = max
+	}
+
+	if offset == 0 || f.entries == nil {
+		if f.entries, err = c.directoryEntries(f); err != nil {
+			return err
+		}
+	}
+
+	// The offset of each entry is the cookie for the entry that follows it
+	data := &encoder{}
+	for i := offset; i < uint64(len(f.entries)); i++ {
+		entry := f.entries[i]
+		if len(data.buf)+qidSize+8+1+2+len(entry.name) > int(count) {
+			break
+		}
+		data.qid(entry.qid)
+		data.u64(i + 1)
+		data.u8(entry.typ)
+		data.str(entry.name)
+	}
+
+	r.data(data.buf)
+	return nil
+}
+
+// directoryEntries lists a directory, including its "." and ".." entries
+func (c *conn) directoryEntries(f *fid) ([]dirent, error) {
+	list, err := c.server.fsys.ReadDir(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := f.qid
+	if f.path != "/" {
+		if _, qid, err := c.stat(path.Dir(f.path)); err == nil {
+			parent = qid
+		}
+	}
+
+	entries := []dirent{
+		{qid: f.qid, typ: direntDir, name: "."},
+		{qid: parent, typ: direntDir, name: ".."},
+	}
+	for _, entry := range list {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, dirent{
+			qid:  newQid(path.Join(f.path, entry.Name()), info),
+			typ:  direntType(info.Mode()),
+			name: entry.Name(),
+		})
+	}
+	return entries, nil
+}
+
+func (c *conn) getattr(d *decoder, r *encoder) error {
+	id := d.u32()
+	d.u64() // request_mask, everything available is always returned
+	if d.err != nil {
+		return d.err
+	}
+
+	f, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	a := newAttr(f.info)
+	valid := uint64(getattrBasic)
+	if !a.btime.IsZero() {
+		valid |= getattrBtime
+	}
+
+	r.u64(valid)
+	r.qid(f.qid)
+	r.u32(a.mode)
+	r.u32(a.uid)
+	r.u32(a.gid)
+	r.u64(a.nlink)
+	r.u64(0) // rdev
+	r.u64(uint64(f.info.Size()))
+	r.u64(blockSize)
+	r.u64((uint64(f.info.Size()) + 511) / 512)
+	for _, t := range []timespec{toTimespec(a.atime), toTimespec(a.mtime), toTimespec(a.ctime), toTimespec(a.btime)} {
+		r.u64(t.sec)
+		r.u64(t.nsec)
+	}
+	r.u64(0) // gen
+	r.u64(0) // data_version
+	return nil
+}
+
+func (c *conn) readlink(d *decoder, r *encoder) error {
+	id := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+
+	f, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	target, err := c.server.fsys.Readlink(f.path)
+	if err != nil {
+		return err
+	}
+	r.str(target)
+	return nil
+}
+
+func (c *conn) xattrwalk(d *decoder, r *encoder) error {
+	id := d.u32()
+	newID := d.u32()
+	name := d.str()
+	if d.err != nil {
+		return d.err
+	}
+
+	f, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.fids[newID]; ok && newID != id {
+		return errno(errnoEBADF)
+	}
+
+	attrs, err := c.server.fsys.Xattrs(f.path)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if name == "" {
+		// An empty name lists the attribute names, each NUL terminated
+		names := make([]string, 0, len(attrs))
+		for n := range attrs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			data = append(data, n...)
+			data = append(data, 0)
+		}
+	} else {
+		value, ok := attrs[name]
+		if !ok {
+			return errno(errnoENODATA)
+		}
+		data = value
+	}
+
+	if newID == id {
+		f.close()
+	}
+	c.fids[newID] = &fid{path: f.path, info: f.info, qid: f.qid, xattr: data, isXattr: true}
+
+	r.u64(uint64(len(data)))
+	return nil
+}
+
+func (c *conn) statfs(d *decoder, r *encoder) error {
+	id := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := c.lookup(id); err != nil {
+		return err
+	}
+
+	r.u32(v9fsMagic)
+	r.u32(blockSize)
+	r.u64(0) // blocks
+	r.u64(0) // bfree
+	r.u64(0) // bavail
+	r.u64(0) // files
+	r.u64(0) // ffree
+	r.u64(0) // fsid
+	r.u32(255)
+	return nil
+}
+
+func (c *conn) clunk(d *decoder) error {
+	id := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+
+	f, err := c.lookup(id)
+	if err != nil {
+		return err
+	}
+	f.close()
+	delete(c.fids, id)
+	return nil
+}
+
+// lock grants every advisory lock; nothing in the export can change
+func (c *conn) lock(d *decoder, r *encoder) error {
+	id := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := c.lookup(id); err != nil {
+		return err
+	}
+	r.u8(lockSuccess)
+	return nil
+}
+
+// getlock reports that no conflicting lock is held
+func (c *conn) getlock(d *decoder, r *encoder) error {
+	id := d.u32()
+	d.u8() // type
+	start := d.u64()
+	length := d.u64()
+	procID := d.u32()
+	clientID := d.str()
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := c.lookup(id); err != nil {
+		return err
+	}
+
+	r.u8(lockTypeUnlock)
+	r.u64(start)
+	r.u64(length)
+	r.u32(procID)
+	r.str(clientID)
+	return nil
+}
+
+// newQid builds the qid of a file. The inode number is used as the qid path
+// when available so that handles stay stable across walks and sessions.
+func newQid(p string, info fs.FileInfo) Qid {
+	q := Qid{Type: qidTypeFile}
+	switch {
+	case info.IsDir():
+		q.Type = qidTypeDir
+	case info.Mode()&fs.ModeSymlink != 0:
+		q.Type = qidTypeSymlink
+	}
+
+	if inode, ok := info.Sys().(inodeInfo); ok && inode.Inode() != 0 {
+		q.Path = inode.Inode()
+		return q
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(p))
+	q.Path = h.Sum64()
+	return q
+}
+
+// direntType converts a file mode to a dirent d_type value
+func direntType(mode fs.FileMode) uint8 {
+	switch {
+	case mode.IsDir():
+		return direntDir
+	case mode.IsRegular():
+		return direntFile
+	case mode&fs.ModeSymlink != 0:
+		return direntSymlink
+	case mode&fs.ModeNamedPipe != 0:
+		return direntFifo
+	case mode&fs.ModeSocket != 0:
+		return direntSocket
+	case mode&fs.ModeCharDevice != 0:
+		return direntChar
+	case mode&fs.ModeDevice != 0:
+		return direntBlock
+	default:
+		return direntUnknown
+	}
+}
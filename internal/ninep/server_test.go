@@ -0,0 +1,399 @@
+package ninep
+
+import (
+	"io/fs"
+	"net"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInode supplies APFS inode metadata through fs.FileInfo.Sys
+type fakeInode struct {
+	inode uint64
+}
+
+func (f fakeInode) Inode() uint64         { return f.inode }
+func (f fakeInode) RawMode() uint16       { return 0o100640 }
+func (f fakeInode) UID() uint32           { return 501 }
+func (f fakeInode) GID() uint32           { return 20 }
+func (f fakeInode) Nlink() uint32         { return 2 }
+func (f fakeInode) AccessTime() time.Time { return time.Time{} }
+func (f fakeInode) ChangeTime() time.Time { return time.Unix(1700000100, 0) }
+func (f fakeInode) BirthTime() time.Time  { return time.Unix(1690000000, 5) }
+
+// mapFileSystem exports an fstest.MapFS. Symlink targets are stored as the
+// file data.
+type mapFileSystem struct {
+	fsys   fstest.MapFS
+	xattrs map[string]map[string][]byte
+}
+
+func (m *mapFileSystem) name(p string) string {
+	if p == "/" {
+		return "."
+	}
+	return strings.TrimPrefix(p, "/")
+}
+
+// Lstat reads the entry from its parent directory so symlinks are not followed
+func (m *mapFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	if name == "/" {
+		return fs.Stat(m.fsys, ".")
+	}
+	entries, err := fs.ReadDir(m.fsys, m.name(path.Dir(name)))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Name() == path.Base(name) {
+			return entry.Info()
+		}
+	}
+	return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *mapFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(m.fsys, m.name(name))
+}
+
+func (m *mapFileSystem) Open(name string) (fs.File, error) {
+	return m.fsys.Open(m.name(name))
+}
+
+func (m *mapFileSystem) Readlink(name string) (string, error) {
+	return string(m.fsys[m.name(name)].Data), nil
+}
+
+func (m *mapFileSystem) Xattrs(name string) (map[string][]byte, error) {
+	return m.xattrs[name], nil
+}
+
+// client is a minimal in-process 9P2000.L client
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	tag  uint16
+}
+
+func newTestClient(t *testing.T) *client {
+	t.Helper()
+	mtime := time.Unix(1700000000, 0)
+
+	fsys := &mapFileSystem{
+		fsys: fstest.MapFS{
+			"docs":            {Mode: fs.ModeDir | 0o755, ModTime: mtime},
+			"docs/readme.txt": {Data: []byte("hello, apfs"), Mode: 0o644, ModTime: mtime, Sys: fakeInode{inode: 18}},
+			"latest":          {Data: []byte("docs/readme.txt"), Mode: fs.ModeSymlink | 0o777, ModTime: mtime},
+		},
+		xattrs: map[string]map[string][]byte{
+			"/docs/readme.txt": {
+				"com.apple.quarantine": []byte("0081;"),
+				"com.apple.metadata":   []byte("bplist"),
+			},
+		},
+	}
+
+	serverConn, clientConn := net.Pipe()
+	srv := NewServer(fsys)
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeConn(serverConn) }()
+	t.Cleanup(func() {
+		clientConn.Close()
+		assert.NoError(t, <-done)
+	})
+
+	c := &client{t: t, conn: clientConn}
+	r := c.rpc(msgTversion, func(e *encoder) {
+		e.u32(8192)
+		e.str(Version)
+	})
+	assert.Equal(t, uint32(8192), r.u32())
+	assert.Equal(t, Version, r.str())
+	return c
+}
+
+// call sends a T-message and returns the reply type and a decoder for its body
+func (c *client) call(msgType uint8, build func(e *encoder)) (uint8, *decoder) {
+	c.t.Helper()
+	c.tag++
+	e := newEncoder(msgType, c.tag)
+	build(e)
+	_, err := c.conn.Write(e.bytes())
+	require.NoError(c.t, err)
+
+	rtype, tag, body, err := readMessage(c.conn, DefaultMsize)
+	require.NoError(c.t, err)
+	require.Equal(c.t, c.tag, tag)
+	return rtype, &decoder{buf: body}
+}
+
+// rpc sends a T-message and requires a successful reply
+func (c *client) rpc(msgType uint8, build func(e *encoder)) *decoder {
+	c.t.Helper()
+	rtype, d := c.call(msgType, build)
+	if rtype == msgRlerror {
+		c.t.Fatalf("message %d failed with errno %d", msgType, d.u32())
+	}
+	require.Equal(c.t, msgType+1, rtype)
+	return d
+}
+
+// errno sends a T-message and returns the errno of the expected Rlerror
+func (c *client) errno(msgType uint8, build func(e *encoder)) uint32 {
+	c.t.Helper()
+	rtype, d := c.call(msgType, build)
+	require.Equal(c.t, uint8(msgRlerror), rtype)
+	return d.u32()
+}
+
+func (c *client) attach(fid uint32) Qid {
+	return c.rpc(msgTattach, func(e *encoder) {
+		e.u32(fid)
+		e.u32(0xffffffff)
+		e.str("root")
+		e.str("")
+		e.u32(0)
+	}).qid()
+}
+
+func (c *client) walk(fid, newfid uint32, names ...string) []Qid {
+	d := c.rpc(msgTwalk, func(e *encoder) {
+		e.u32(fid)
+		e.u32(newfid)
+		e.u16(uint16(len(names)))
+		for _, n := range names {
+			e.str(n)
+		}
+	})
+	qids := make([]Qid, d.u16())
+	for i := range qids {
+		qids[i] = d.qid()
+	}
+	return qids
+}
+
+func (c *client) open(fid uint32) {
+	c.rpc(msgTlopen, func(e *encoder) {
+		e.u32(fid)
+		e.u32(0)
+	})
+}
+
+func (c *client) read(fid uint32, offset uint64, count uint32) []byte {
+	d := c.rpc(msgTread, func(e *encoder) {
+		e.u32(fid)
+		e.u64(offset)
+		e.u32(count)
+	})
+	return d.next(int(d.u32()))
+}
+
+func (c *client) clunk(fid uint32) {
+	c.rpc(msgTclunk, func(e *encoder) { e.u32(fid) })
+}
+
+func TestWalkAndStableQids(t *testing.T) {
+	c := newTestClient(t)
+
+	root := c.attach(1)
+	assert.Equal(t, uint8(qidTypeDir), root.Type)
+
+	qids := c.walk(1, 2, "docs", "readme.txt")
+	require.Len(t, qids, 2)
+	assert.Equal(t, uint8(qidTypeDir), qids[0].Type)
+	assert.Equal(t, uint64(18), qids[1].Path)
+
+	again := c.walk(1, 3, "docs", "..", "docs", "readme.txt")
+	require.Len(t, again, 4)
+	assert.Equal(t, root, again[1])
+	assert.Equal(t, qids[1], again[3])
+
+	link := c.walk(1, 4, "latest")
+	assert.Equal(t, uint8(qidTypeSymlink), link[0].Type)
+
+	// A partial walk reports the qids walked so far and leaves newfid unused
+	partial := c.walk(1, 5, "docs", "missing")
+	assert.Len(t, partial, 1)
+	assert.Equal(t, uint32(errnoEBADF), c.errno(msgTclunk, func(e *encoder) { e.u32(5) }))
+
+	assert.Equal(t, uint32(errnoENOENT), c.errno(msgTwalk, func(e *encoder) {
+		e.u32(1)
+		e.u32(6)
+		e.u16(1)
+		e.str("missing")
+	}))
+}
+
+func TestReadFile(t *testing.T) {
+	c := newTestClient(t)
+	c.attach(1)
+	c.walk(1, 2, "docs", "readme.txt")
+	c.open(2)
+
+	assert.Equal(t, "hello", string(c.read(2, 0, 5)))
+	assert.Equal(t, "apfs", string(c.read(2, 7, 100)))
+	assert.Empty(t, c.read(2, 11, 100))
+	c.clunk(2)
+
+	assert.Equal(t, uint32(errnoEBADF), c.errno(msgTread, func(e *encoder) {
+		e.u32(2)
+		e.u64(0)
+		e.u32(5)
+	}))
+}
+
+func TestReaddir(t *testing.T) {
+	c := newTestClient(t)
+	c.attach(1)
+	c.walk(1, 2)
+	c.open(2)
+
+	var names []string
+	var types []uint8
+	offset := uint64(0)
+	for {
+		// A small count forces the listing to span several messages
+		d := c.rpc(msgTreaddir, func(e *encoder) {
+			e.u32(2)
+			e.u64(offset)
+			e.u32(40)
+		})
+		data := &decoder{buf: d.next(int(d.u32()))}
+		if len(data.buf) == 0 {
+			break
+		}
+		for len(data.buf) > 0 {
+			data.qid()
+			offset = data.u64()
+			types = append(types, data.u8())
+			names = append(names, data.str())
+		}
+		require.NoError(t, data.err)
+	}
+
+	assert.Equal(t, []string{".", "..", "docs", "latest"}, names)
+	assert.Equal(t, []uint8{direntDir, direntDir, direntDir, direntSymlink}, types)
+}
+
+func TestGetattr(t *testing.T) {
+	c := newTestClient(t)
+	c.attach(1)
+	c.walk(1, 2, "docs", "readme.txt")
+
+	d := c.rpc(msgTgetattr, func(e *encoder) {
+		e.u32(2)
+		e.u64(getattrBasic | getattrBtime)
+	})
+	assert.Equal(t, uint64(getattrBasic|getattrBtime), d.u64())
+	assert.Equal(t, uint64(18), d.qid().Path)
+	assert.Equal(t, uint32(0o100640), d.u32())
+	assert.Equal(t, uint32(501), d.u32())
+	assert.Equal(t, uint32(20), d.u32())
+	assert.Equal(t, uint64(2), d.u64())
+	d.u64() // rdev
+	assert.Equal(t, uint64(11), d.u64())
+	d.u64() // blksize
+	assert.Equal(t, uint64(1), d.u64())
+	assert.Equal(t, uint64(1700000000), d.u64()) // atime falls back to mtime
+	d.u64()
+	assert.Equal(t, uint64(1700000000), d.u64())
+	d.u64()
+	assert.Equal(t, uint64(1700000100), d.u64())
+	d.u64()
+	assert.Equal(t, uint64(1690000000), d.u64())
+	assert.Equal(t, uint64(5), d.u64())
+	require.NoError(t, d.err)
+
+	c.walk(1, 3, "docs")
+	d = c.rpc(msgTgetattr, func(e *encoder) {
+		e.u32(3)
+		e.u64(getattrBasic)
+	})
+	assert.Equal(t, uint64(getattrBasic), d.u64())
+	d.qid()
+	assert.Equal(t, uint32(0o040755), d.u32())
+}
+
+func TestReadlink(t *testing.T) {
+	c := newTestClient(t)
+	c.attach(1)
+	c.walk(1, 2, "latest")
+
+	d := c.rpc(msgTreadlink, func(e *encoder) { e.u32(2) })
+	assert.Equal(t, "docs/readme.txt", d.str())
+}
+
+func TestXattrs(t *testing.T) {
+	c := newTestClient(t)
+	c.attach(1)
+	c.walk(1, 2, "docs", "readme.txt")
+
+	xattrwalk := func(newfid uint32, name string) uint64 {
+		return c.rpc(msgTxattrwalk, func(e *encoder) {
+			e.u32(2)
+			e.u32(newfid)
+			e.str(name)
+		}).u64()
+	}
+
+	size := xattrwalk(3, "")
+	list := c.read(3, 0, uint32(size))
+	assert.Equal(t, "com.apple.metadata\x00com.apple.quarantine\x00", string(list))
+	c.clunk(3)
+
+	size = xattrwalk(4, "com.apple.quarantine")
+	assert.Equal(t, uint64(5), size)
+	assert.Equal(t, []byte("0081;"), c.read(4, 0, 100))
+
+	assert.Equal(t, uint32(errnoENODATA), c.errno(msgTxattrwalk, func(e *encoder) {
+		e.u32(2)
+		e.u32(5)
+		e.str("user.missing")
+	}))
+}
+
+func TestReadOnly(t *testing.T) {
+	c := newTestClient(t)
+	c.attach(1)
+	c.walk(1, 2, "docs", "readme.txt")
+
+	assert.Equal(t, uint32(errnoEROFS), c.errno(msgTlopen, func(e *encoder) {
+		e.u32(2)
+		e.u32(1) // O_WRONLY
+	}))
+	assert.Equal(t, uint32(errnoEROFS), c.errno(msgTmkdir, func(e *encoder) {
+		e.u32(1)
+		e.str("new")
+		e.u32(0o755)
+		e.u32(0)
+	}))
+	assert.Equal(t, uint32(errnoEROFS), c.errno(msgTremove, func(e *encoder) { e.u32(2) }))
+
+	// Tremove clunks the fid even though the removal failed
+	assert.Equal(t, uint32(errnoEBADF), c.errno(msgTclunk, func(e *encoder) { e.u32(2) }))
+}
+
+func TestNewQidFallsBackToPathHash(t *testing.T) {
+	info, err := fs.Stat(fstest.MapFS{"a": {Data: []byte("x")}}, "a")
+	require.NoError(t, err)
+
+	q1 := newQid("/a", info)
+	q2 := newQid("/a", info)
+	q3 := newQid("/b", info)
+	assert.Equal(t, q1, q2)
+	assert.NotEqual(t, q1.Path, q3.Path)
+}
+
+func TestShortMessage(t *testing.T) {
+	c := newTestClient(t)
+
+	rtype, d := c.call(msgTattach, func(e *encoder) { e.u16(1) })
+	assert.Equal(t, uint8(msgRlerror), rtype)
+	assert.Equal(t, uint32(errnoEPROTO), d.u32())
+}
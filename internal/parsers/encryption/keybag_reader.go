@@ -120,11 +120,12 @@ func parseKeybagEntries(data []byte, count uint16, endian binary.ByteOrder) ([]t
 				i, entry.KeKeylen, types.ApfsVolKeybagEntryMaxSize)
 		}
 
-		// Parse key data if available
+		// Parse key data if available. Each entry is padded to a multiple
+		// of 16 bytes.
 		if offset+int(entry.KeKeylen) <= len(data) {
 			entry.KeKeydata = make([]byte, entry.KeKeylen)
 			copy(entry.KeKeydata, data[offset:offset+int(entry.KeKeylen)])
-			offset += int(entry.KeKeylen)
+			offset += (int(entry.KeKeylen) + 15) &^ 15
 		}
 
 		entries = append(entries, entry)
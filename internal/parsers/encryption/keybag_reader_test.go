@@ -14,7 +14,7 @@ func createTestKeybagData(version uint16, entries []TestKeybagEntry, endian bina
 	totalDataSize := 0
 
 	for _, entry := range entries {
-		entrySize := 16 + 2 + 2 + 4 + len(entry.KeyData) // UUID + tag + keylen + padding + data
+		entrySize := 16 + 2 + 2 + 4 + (len(entry.KeyData)+15)&^15 // UUID + tag + keylen + padding + data padded to 16 bytes
 		totalDataSize += entrySize
 	}
 
@@ -51,7 +51,7 @@ func createTestKeybagData(version uint16, entries []TestKeybagEntry, endian bina
 
 		// Key data
 		copy(data[offset:offset+len(entry.KeyData)], entry.KeyData)
-		offset += len(entry.KeyData)
+		offset += (len(entry.KeyData) + 15) &^ 15
 	}
 
 	return data
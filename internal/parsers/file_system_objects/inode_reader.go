@@ -195,6 +195,10 @@ func (ir *inodeReader) HasResourceFork() bool {
 	return false
 }
 
+func (ir *inodeReader) BSDFlags() uint32 {
+	return ir.value.BsdFlags
+}
+
 func (ir *inodeReader) Size() uint64 {
 	return ir.value.UncompressedSize
 }
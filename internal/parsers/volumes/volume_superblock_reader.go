@@ -83,11 +83,10 @@ func parseVolumeSuperblock(data []byte, endian binary.ByteOrder) (*types.ApfsSup
 	offset += 8
 
 	// Parse metadata crypto structure (20 bytes, not 112!)
-	// struct wrapped_meta_crypto_state { 
+	// struct wrapped_meta_crypto_state {
 	//   uint16_t major_version; uint16_t minor_version; uint32_t cpflags;
 	//   uint32_t persistent_class; uint32_t key_os_version; uint16_t key_revision; uint16_t unused;
 	// }
-	fmt.Printf("DEBUG: Parsing crypto structure at offset %d\n", offset)
 	offset += 20 // Skip wrapped_meta_crypto_state_t (20 bytes, not 112)
 
 	// Parse tree types
@@ -101,22 +100,16 @@ func parseVolumeSuperblock(data []byte, endian binary.ByteOrder) (*types.ApfsSup
 	offset += 4
 
 	// Parse OIDs at their correct locations according to APFS spec
-	fmt.Printf("DEBUG: Parsing OIDs starting from offset %d\n", offset)
-	
 	sb.ApfsOmapOid = types.OidT(endian.Uint64(data[offset : offset+8]))
-	fmt.Printf("DEBUG: ApfsOmapOid at offset %d = %d\n", offset, sb.ApfsOmapOid)
 	offset += 8
 
 	sb.ApfsRootTreeOid = types.OidT(endian.Uint64(data[offset : offset+8]))
-	fmt.Printf("DEBUG: ApfsRootTreeOid at offset %d = %d\n", offset, sb.ApfsRootTreeOid)
 	offset += 8
 
 	sb.ApfsExtentrefTreeOid = types.OidT(endian.Uint64(data[offset : offset+8]))
-	fmt.Printf("DEBUG: ApfsExtentrefTreeOid at offset %d = %d\n", offset, sb.ApfsExtentrefTreeOid)
 	offset += 8
 
 	sb.ApfsSnapMetaTreeOid = types.OidT(endian.Uint64(data[offset : offset+8]))
-	fmt.Printf("DEBUG: ApfsSnapMetaTreeOid at offset %d = %d\n", offset, sb.ApfsSnapMetaTreeOid)
 	offset += 8
 
 	// Parse revert fields
@@ -132,11 +125,9 @@ func parseVolumeSuperblock(data []byte, endian binary.ByteOrder) (*types.ApfsSup
 
 	// Parse file/directory/symlink counts
 	sb.ApfsNumFiles = endian.Uint64(data[offset : offset+8])
-	fmt.Printf("DEBUG: ApfsNumFiles at offset %d = %d\n", offset, sb.ApfsNumFiles)
 	offset += 8
 
 	sb.ApfsNumDirectories = endian.Uint64(data[offset : offset+8])
-	fmt.Printf("DEBUG: ApfsNumDirectories at offset %d = %d\n", offset, sb.ApfsNumDirectories)
 	offset += 8
 
 	sb.ApfsNumSymlinks = endian.Uint64(data[offset : offset+8])
@@ -168,33 +159,72 @@ func parseVolumeSuperblock(data []byte, endian binary.ByteOrder) (*types.ApfsSup
 	sb.ApfsFsFlags = endian.Uint64(data[offset : offset+8])
 	offset += 8
 
-	// Parse formatted by timestamp (ApfsModifiedByT structure - 8 bytes)
-	offset += 8
+	// Parse formatted by and modification history (apfs_modified_by_t, 48 bytes each)
+	sb.ApfsFormattedBy = parseModifiedBy(data[offset:offset+48], endian)
+	offset += 48
 
-	// Parse modification history - array of ApfsModifiedByT (APFS_MAX_HIST = 8 entries, 8 bytes each)
-	offset += types.ApfsMaxHist * 8
+	for i := 0; i < types.ApfsMaxHist; i++ {
+		sb.ApfsModifiedBy[i] = parseModifiedBy(data[offset:offset+48], endian)
+		offset += 48
+	}
 
 	// Parse volume name
-	if offset+types.ApfsVolnameLen <= len(data) {
-		copy(sb.ApfsVolname[:], data[offset:offset+types.ApfsVolnameLen])
-		offset += types.ApfsVolnameLen
-	}
+	copy(sb.ApfsVolname[:], data[offset:offset+types.ApfsVolnameLen])
+	offset += types.ApfsVolnameLen
 
-	// Parse next document ID
-	if offset+4 <= len(data) {
-		sb.ApfsNextDocId = endian.Uint32(data[offset : offset+4])
-		offset += 4
-	}
+	// Parse next document ID and role
+	sb.ApfsNextDocId = endian.Uint32(data[offset : offset+4])
+	offset += 4
+
+	sb.ApfsRole = endian.Uint16(data[offset : offset+2])
+	offset += 2
+
+	sb.Reserved = endian.Uint16(data[offset : offset+2])
+	offset += 2
 
-	// Parse additional fields if present
-	if offset+2 <= len(data) {
-		sb.ApfsRole = endian.Uint16(data[offset : offset+2])
-		offset += 2
+	// Parse trailing fields added in later APFS versions
+	if offset+76 > len(data) {
+		return sb, nil
 	}
 
+	sb.ApfsRootToXid = types.XidT(endian.Uint64(data[offset : offset+8]))
+	offset += 8
+
+	sb.ApfsErStateOid = types.OidT(endian.Uint64(data[offset : offset+8]))
+	offset += 8
+
+	sb.ApfsCloneinfoIdEpoch = endian.Uint64(data[offset : offset+8])
+	offset += 8
+
+	sb.ApfsCloneinfoXid = endian.Uint64(data[offset : offset+8])
+	offset += 8
+
+	sb.ApfsSnapMetaExtOid = types.OidT(endian.Uint64(data[offset : offset+8]))
+	offset += 8
+
+	copy(sb.ApfsVolumeGroupId[:], data[offset:offset+16])
+	offset += 16
+
+	sb.ApfsIntegrityMetaOid = types.OidT(endian.Uint64(data[offset : offset+8]))
+	offset += 8
+
+	sb.ApfsFextTreeOid = types.OidT(endian.Uint64(data[offset : offset+8]))
+	offset += 8
+
+	sb.ApfsFextTreeType = endian.Uint32(data[offset : offset+4])
+
 	return sb, nil
 }
 
+// parseModifiedBy parses an apfs_modified_by_t entry
+func parseModifiedBy(data []byte, endian binary.ByteOrder) types.ApfsModifiedByT {
+	var m types.ApfsModifiedByT
+	copy(m.Id[:], data[0:types.ApfsModifiedNamelen])
+	m.Timestamp = endian.Uint64(data[32:40])
+	m.LastXid = types.XidT(endian.Uint64(data[40:48]))
+	return m
+}
+
 // GetSuperblock returns the parsed superblock
 func (vsr *volumeSuperblockReader) GetSuperblock() *types.ApfsSuperblockT {
 	return vsr.superblock
@@ -0,0 +1,54 @@
+package volumes
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// Test that fields after the modification history are read from their spec offsets
+func TestVolumeSuperblockReader_FieldOffsets(t *testing.T) {
+	data := make([]byte, 4096)
+	copy(data[32:36], "APSB")
+	binary.LittleEndian.PutUint64(data[0x88:], 1026)    // apfs_root_tree_oid
+	binary.LittleEndian.PutUint64(data[0xB8:], 12)      // apfs_num_files
+	copy(data[0x110:], "newfs_apfs (2332.1)")           // apfs_formatted_by.id
+	binary.LittleEndian.PutUint64(data[0x138:], 99)     // apfs_formatted_by.last_xid
+	copy(data[0x2C0:], "Macintosh HD")                  // apfs_volname
+	binary.LittleEndian.PutUint16(data[0x3C4:], 0x0040) // apfs_role
+	binary.LittleEndian.PutUint64(data[0x3C8:], 7)      // apfs_root_to_xid
+
+	reader, err := NewVolumeSuperblockReader(data, binary.LittleEndian)
+	if err != nil {
+		t.Fatalf("NewVolumeSuperblockReader failed: %v", err)
+	}
+	sb := reader.GetSuperblock()
+
+	if sb.ApfsRootTreeOid != 1026 {
+		t.Errorf("root tree OID: got %d, want 1026", sb.ApfsRootTreeOid)
+	}
+	if sb.ApfsNumFiles != 12 {
+		t.Errorf("file count: got %d, want 12", sb.ApfsNumFiles)
+	}
+	if sb.ApfsFormattedBy.LastXid != 99 {
+		t.Errorf("formatted by last XID: got %d, want 99", sb.ApfsFormattedBy.LastXid)
+	}
+	if name := NewVolumeIdentity(sb).Name(); name != "Macintosh HD" {
+		t.Errorf("volume name: got %q, want %q", name, "Macintosh HD")
+	}
+	if sb.ApfsRole != types.ApfsVolRoleData {
+		t.Errorf("role: got %#x, want %#x", sb.ApfsRole, types.ApfsVolRoleData)
+	}
+	if sb.ApfsRootToXid != 7 {
+		t.Errorf("root to XID: got %d, want 7", sb.ApfsRootToXid)
+	}
+}
+
+// Test that an invalid magic is rejected
+func TestVolumeSuperblockReader_InvalidMagic(t *testing.T) {
+	data := make([]byte, 4096)
+	if _, err := NewVolumeSuperblockReader(data, binary.LittleEndian); err == nil {
+		t.Error("expected error for missing APSB magic")
+	}
+}
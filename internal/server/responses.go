@@ -0,0 +1,203 @@
+package server
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// inodeInfo is the APFS-specific metadata carried by *apfs.FileInfo
+type inodeInfo interface {
+	Inode() uint64
+	ParentInode() uint64
+	RawMode() uint16
+	UID() uint32
+	GID() uint32
+	Nlink() uint32
+	Flags() uint32
+	AccessTime() time.Time
+	ChangeTime() time.Time
+	BirthTime() time.Time
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type containerResponse struct {
+	Name        string           `json:"name"`
+	UUID        string           `json:"uuid"`
+	BlockSize   uint32           `json:"block_size"`
+	BlockCount  uint64           `json:"block_count"`
+	Size        uint64           `json:"size"`
+	NextXID     uint64           `json:"next_xid"`
+	VolumeCount int              `json:"volume_count"`
+	Volumes     []volumeResponse `json:"volumes,omitempty"`
+}
+
+type volumeResponse struct {
+	Index                    int    `json:"index"`
+	Name                     string `json:"name"`
+	Role                     string `json:"role"`
+	UUID                     string `json:"uuid"`
+	Files                    uint64 `json:"files"`
+	Directories              uint64 `json:"directories"`
+	Symlinks                 uint64 `json:"symlinks"`
+	Snapshots                uint64 `json:"snapshots"`
+	CaseInsensitive          bool   `json:"case_insensitive"`
+	NormalizationInsensitive bool   `json:"normalization_insensitive"`
+	Encrypted                bool   `json:"encrypted"`
+}
+
+type snapshotResponse struct {
+	XID       uint64    `json:"xid"`
+	Name      string    `json:"name"`
+	Created   time.Time `json:"created"`
+	Changed   time.Time `json:"changed"`
+	Files     uint64    `json:"files"`
+	Size      uint64    `json:"size"`
+	Dataless  bool      `json:"dataless"`
+	ParentXID uint64    `json:"parent_xid,omitempty"`
+}
+
+type entryResponse struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Mode    string    `json:"mode"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Inode   uint64    `json:"inode,omitempty"`
+}
+
+type directoryResponse struct {
+	Path    string          `json:"path"`
+	Entries []entryResponse `json:"entries"`
+}
+
+type inodeResponse struct {
+	entryResponse
+	ParentInode uint64     `json:"parent_inode,omitempty"`
+	RawMode     uint16     `json:"raw_mode,omitempty"`
+	UID         uint32     `json:"uid"`
+	GID         uint32     `json:"gid"`
+	Nlink       uint32     `json:"nlink,omitempty"`
+	Flags       uint32     `json:"flags"`
+	AccessTime  *time.Time `json:"atime,omitempty"`
+	ChangeTime  *time.Time `json:"ctime,omitempty"`
+	BirthTime   *time.Time `json:"btime,omitempty"`
+}
+
+func newContainerResponse(c *Container) containerResponse {
+	return containerResponse{
+		Name:        c.Name,
+		UUID:        c.Info.UUID.String(),
+		BlockSize:   c.Info.BlockSize,
+		BlockCount:  c.Info.BlockCount,
+		Size:        c.Info.Size,
+		NextXID:     c.Info.NextXID,
+		VolumeCount: len(c.Volumes),
+	}
+}
+
+func newVolumeResponses(vols []Volume) []volumeResponse {
+	resp := make([]volumeResponse, 0, len(vols))
+	for _, vol := range vols {
+		resp = append(resp, newVolumeResponse(vol.Info))
+	}
+	return resp
+}
+
+func newVolumeResponse(info apfs.VolumeInfo) volumeResponse {
+	return volumeResponse{
+		Index:                    info.Index,
+		Name:                     info.Name,
+		Role:                     info.Role,
+		UUID:                     info.UUID.String(),
+		Files:                    info.Files,
+		Directories:              info.Directories,
+		Symlinks:                 info.Symlinks,
+		Snapshots:                info.Snapshots,
+		CaseInsensitive:          info.CaseInsensitive,
+		NormalizationInsensitive: info.NormalizationInsensitive,
+		Encrypted:                info.Encrypted,
+	}
+}
+
+func newSnapshotResponse(snap apfs.Snapshot) snapshotResponse {
+	return snapshotResponse{
+		XID:       snap.XID,
+		Name:      snap.Name,
+		Created:   snap.Created,
+		Changed:   snap.Changed,
+		Files:     snap.Files,
+		Size:      snap.Size,
+		Dataless:  snap.Dataless,
+		ParentXID: snap.ParentXID,
+	}
+}
+
+func newEntryResponse(name string, info fs.FileInfo) entryResponse {
+	entry := entryResponse{
+		Name:    info.Name(),
+		Path:    name,
+		Type:    fileType(info.Mode()),
+		Mode:    info.Mode().String(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if inode, ok := info.Sys().(inodeInfo); ok {
+		entry.Inode = inode.Inode()
+	}
+	return entry
+}
+
+func newInodeResponse(name string, info fs.FileInfo) inodeResponse {
+	resp := inodeResponse{entryResponse: newEntryResponse(name, info)}
+
+	inode, ok := info.Sys().(inodeInfo)
+	if !ok {
+		return resp
+	}
+	resp.ParentInode = inode.ParentInode()
+	resp.RawMode = inode.RawMode()
+	resp.UID = inode.UID()
+	resp.GID = inode.GID()
+	resp.Nlink = inode.Nlink()
+	resp.Flags = inode.Flags()
+	resp.AccessTime = optionalTime(inode.AccessTime())
+	resp.ChangeTime = optionalTime(inode.ChangeTime())
+	resp.BirthTime = optionalTime(inode.BirthTime())
+	return resp
+}
+
+// fileType names the type bits of a file mode
+func fileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode.IsRegular():
+		return "file"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "char_device"
+	case mode&fs.ModeDevice != 0:
+		return "block_device"
+	default:
+		return "unknown"
+	}
+}
+
+// optionalTime returns nil for the zero time so it is omitted from responses
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+)
+
+// Container is an opened APFS container published by the server
+type Container struct {
+	Name    string
+	Info    apfs.ContainerInfo
+	Volumes []Volume
+}
+
+// Volume is a volume published by the server. Snapshots and OpenSnapshot are
+// optional; when nil the volume is reported as having no snapshots.
+type Volume struct {
+	Info         apfs.VolumeInfo
+	FS           fs.FS
+	Snapshots    func() ([]apfs.Snapshot, error)
+	OpenSnapshot func(name string) (fs.FS, error)
+}
+
+// NewContainer describes an opened container and its volumes for the server
+func NewContainer(name string, c *apfs.Container) (Container, error) {
+	vols, err := c.Volumes()
+	if err != nil {
+		return Container{}, err
+	}
+
+	container := Container{Name: name, Info: c.Info()}
+	for _, vol := range vols {
+		container.Volumes = append(container.Volumes, NewVolume(vol))
+	}
+	return container, nil
+}
+
+// NewVolume describes an opened volume for the server
+func NewVolume(vol *apfs.Volume) Volume {
+	return Volume{
+		Info:      vol.Info(),
+		FS:        vol.FS(),
+		Snapshots: vol.Snapshots,
+		OpenSnapshot: func(name string) (fs.FS, error) {
+			snap, err := vol.Snapshot(name)
+			if err != nil {
+				return nil, err
+			}
+			return snap.FS(), nil
+		},
+	}
+}
+
+// Server serves a read-only JSON API and file downloads for a set of containers
+type Server struct {
+	containers []Container
+	mux        *http.ServeMux
+}
+
+// New creates a Server publishing the given containers
+func New(containers []Container) *Server {
+	s := &Server{containers: containers, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("GET /api/containers", s.handleContainers)
+	s.mux.HandleFunc("GET /api/containers/{container}", s.handleContainer)
+	s.mux.HandleFunc("GET /api/containers/{container}/volumes", s.handleVolumes)
+	s.mux.HandleFunc("GET /api/containers/{container}/volumes/{volume}", s.handleVolume)
+	s.mux.HandleFunc("GET /api/containers/{container}/volumes/{volume}/snapshots", s.handleSnapshots)
+	s.mux.HandleFunc("GET /api/containers/{container}/volumes/{volume}/ls/{path...}", s.handleList)
+	s.mux.HandleFunc("GET /api/containers/{container}/volumes/{volume}/stat/{path...}", s.handleStat)
+	s.mux.HandleFunc("GET /api/containers/{container}/volumes/{volume}/download/{path...}", s.handleDownload)
+
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// httpError carries an HTTP status code alongside an error message
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+
+func (e *httpError) Unwrap() error { return e.err }
+
+// notFound returns an error reported with status 404
+func notFound(format string, args ...any) error {
+	return &httpError{status: http.StatusNotFound, err: fmt.Errorf(format, args...)}
+}
+
+// writeJSON writes v as an indented JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError writes err as a JSON error response with a matching status code
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	var he *httpError
+	switch {
+	case errors.As(err, &he):
+		status = he.status
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, apfs.ErrSnapshotNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, fs.ErrInvalid), errors.Is(err, apfs.ErrNotDir), errors.Is(err, apfs.ErrIsDir):
+		status = http.StatusBadRequest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+// container looks up a container by name or index
+func (s *Server) container(r *http.Request) (*Container, error) {
+	id := r.PathValue("container")
+	for i := range s.containers {
+		if s.containers[i].Name == id {
+			return &s.containers[i], nil
+		}
+	}
+	if i, err := strconv.Atoi(id); err == nil && i >= 0 && i < len(s.containers) {
+		return &s.containers[i], nil
+	}
+	return nil, notFound("container %q not found", id)
+}
+
+// volume looks up a volume by name or index within the requested container
+func (s *Server) volume(r *http.Request) (*Volume, error) {
+	c, err := s.container(r)
+	if err != nil {
+		return nil, err
+	}
+
+	id := r.PathValue("volume")
+	for i := range c.Volumes {
+		if c.Volumes[i].Info.Name == id {
+			return &c.Volumes[i], nil
+		}
+	}
+	if i, err := strconv.Atoi(id); err == nil && i >= 0 && i < len(c.Volumes) {
+		return &c.Volumes[i], nil
+	}
+	return nil, notFound("volume %q not found", id)
+}
+
+// fileSystem returns the file system of the requested volume, or of one of
+// its snapshots when the snapshot query parameter is set
+func (s *Server) fileSystem(r *http.Request) (fs.FS, error) {
+	vol, err := s.volume(r)
+	if err != nil {
+		return nil, err
+	}
+
+	name := r.URL.Query().Get("snapshot")
+	if name == "" {
+		return vol.FS, nil
+	}
+	if vol.OpenSnapshot == nil {
+		return nil, notFound("snapshot %q not found", name)
+	}
+	return vol.OpenSnapshot(name)
+}
+
+// requestPath returns the io/fs name addressed by the request
+func requestPath(r *http.Request) string {
+	p := r.PathValue("path")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func (s *Server) handleContainers(w http.ResponseWriter, r *http.Request) {
+	resp := make([]containerResponse, 0, len(s.containers))
+	for i := range s.containers {
+		resp = append(resp, newContainerResponse(&s.containers[i]))
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleContainer(w http.ResponseWriter, r *http.Request) {
+	c, err := s.container(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := newContainerResponse(c)
+	resp.Volumes = newVolumeResponses(c.Volumes)
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleVolumes(w http.ResponseWriter, r *http.Request) {
+	c, err := s.container(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, newVolumeResponses(c.Volumes))
+}
+
+func (s *Server) handleVolume(w http.ResponseWriter, r *http.Request) {
+	vol, err := s.volume(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, newVolumeResponse(vol.Info))
+}
+
+func (s *Server) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	vol, err := s.volume(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := []snapshotResponse{}
+	if vol.Snapshots != nil {
+		snapshots, err := vol.Snapshots()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, snap := range snapshots {
+			resp = append(resp, newSnapshotResponse(snap))
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	fsys, err := s.fileSystem(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	name := requestPath(r)
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := directoryResponse{Path: name, Entries: make([]entryResponse, 0, len(entries))}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		resp.Entries = append(resp.Entries, newEntryResponse(path.Join(name, entry.Name()), info))
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleStat(w http.ResponseWriter, r *http.Request) {
+	fsys, err := s.fileSystem(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	name := requestPath(r)
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, newInodeResponse(name, info))
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	fsys, err := s.fileSystem(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	name := requestPath(r)
+	f, err := fsys.Open(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		writeError(w, &fs.PathError{Op: "download", Path: name, Err: fs.ErrInvalid})
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		writeError(w, fmt.Errorf("%s does not support random access", name))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	http.ServeContent(w, r, info.Name(), info.ModTime().In(time.UTC), content)
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/apfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInode supplies APFS metadata through fs.FileInfo.Sys
+type fakeInode struct {
+	inode uint64
+}
+
+func (f fakeInode) Inode() uint64         { return f.inode }
+func (f fakeInode) ParentInode() uint64   { return 2 }
+func (f fakeInode) RawMode() uint16       { return 0o100644 }
+func (f fakeInode) UID() uint32           { return 501 }
+func (f fakeInode) GID() uint32           { return 20 }
+func (f fakeInode) Nlink() uint32         { return 1 }
+func (f fakeInode) Flags() uint32         { return 0 }
+func (f fakeInode) AccessTime() time.Time { return time.Time{} }
+func (f fakeInode) ChangeTime() time.Time { return time.Unix(1700000100, 0).UTC() }
+func (f fakeInode) BirthTime() time.Time  { return time.Unix(1690000000, 0).UTC() }
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mtime := time.Unix(1700000000, 0).UTC()
+
+	live := fstest.MapFS{
+		"docs":            {Mode: fs.ModeDir | 0o755, ModTime: mtime},
+		"docs/readme.txt": {Data: []byte("hello, apfs"), Mode: 0o644, ModTime: mtime, Sys: fakeInode{inode: 18}},
+		"latest":          {Data: []byte("docs/readme.txt"), Mode: fs.ModeSymlink | 0o755, ModTime: mtime},
+	}
+	nightly := fstest.MapFS{
+		"old.txt": {Data: []byte("old"), Mode: 0o644, ModTime: mtime},
+	}
+
+	containers := []Container{{
+		Name: "disk.dmg",
+		Info: apfs.ContainerInfo{BlockSize: 4096, BlockCount: 256, Size: 4096 * 256, NextXID: 42, VolumeCount: 1},
+		Volumes: []Volume{{
+			Info: apfs.VolumeInfo{Name: "Data", Role: "Data", Files: 2, Directories: 1, Snapshots: 1},
+			FS:   live,
+			Snapshots: func() ([]apfs.Snapshot, error) {
+				return []apfs.Snapshot{{XID: 40, Name: "nightly", Files: 1, Created: mtime}}, nil
+			},
+			OpenSnapshot: func(name string) (fs.FS, error) {
+				if name != "nightly" {
+					return nil, apfs.ErrSnapshotNotFound
+				}
+				return nightly, nil
+			},
+		}},
+	}}
+
+	srv := httptest.NewServer(New(containers))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// getJSON fetches url, checks the status code and decodes the body into v
+func getJSON(t *testing.T, url string, status int, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, status, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+func TestContainersAndVolumes(t *testing.T) {
+	srv := newTestServer(t)
+
+	var containers []containerResponse
+	getJSON(t, srv.URL+"/api/containers", http.StatusOK, &containers)
+	require.Len(t, containers, 1)
+	assert.Equal(t, "disk.dmg", containers[0].Name)
+	assert.Equal(t, uint32(4096), containers[0].BlockSize)
+	assert.Equal(t, 1, containers[0].VolumeCount)
+
+	var container containerResponse
+	getJSON(t, srv.URL+"/api/containers/0", http.StatusOK, &container)
+	require.Len(t, container.Volumes, 1)
+	assert.Equal(t, "Data", container.Volumes[0].Name)
+
+	var volume volumeResponse
+	getJSON(t, srv.URL+"/api/containers/disk.dmg/volumes/Data", http.StatusOK, &volume)
+	assert.Equal(t, uint64(2), volume.Files)
+
+	var errResp errorResponse
+	getJSON(t, srv.URL+"/api/containers/disk.dmg/volumes/Preboot", http.StatusNotFound, &errResp)
+	assert.Contains(t, errResp.Error, "Preboot")
+
+	getJSON(t, srv.URL+"/api/containers/other.dmg", http.StatusNotFound, &errResp)
+}
+
+func TestListDirectory(t *testing.T) {
+	srv := newTestServer(t)
+
+	var root directoryResponse
+	getJSON(t, srv.URL+"/api/containers/0/volumes/0/ls/", http.StatusOK, &root)
+	assert.Equal(t, ".", root.Path)
+	require.Len(t, root.Entries, 2)
+	assert.Equal(t, "docs", root.Entries[0].Name)
+	assert.Equal(t, "directory", root.Entries[0].Type)
+	assert.Equal(t, "symlink", root.Entries[1].Type)
+
+	var docs directoryResponse
+	getJSON(t, srv.URL+"/api/containers/0/volumes/0/ls/docs", http.StatusOK, &docs)
+	require.Len(t, docs.Entries, 1)
+	assert.Equal(t, "docs/readme.txt", docs.Entries[0].Path)
+	assert.Equal(t, int64(11), docs.Entries[0].Size)
+	assert.Equal(t, uint64(18), docs.Entries[0].Inode)
+
+	var errResp errorResponse
+	getJSON(t, srv.URL+"/api/containers/0/volumes/0/ls/missing", http.StatusNotFound, &errResp)
+}
+
+func TestStatInode(t *testing.T) {
+	srv := newTestServer(t)
+
+	var inode inodeResponse
+	getJSON(t, srv.URL+"/api/containers/0/volumes/Data/stat/docs/readme.txt", http.StatusOK, &inode)
+	assert.Equal(t, "readme.txt", inode.Name)
+	assert.Equal(t, "file", inode.Type)
+	assert.Equal(t, "-rw-r--r--", inode.Mode)
+	assert.Equal(t, uint64(18), inode.Inode)
+	assert.Equal(t, uint64(2), inode.ParentInode)
+	assert.Equal(t, uint32(501), inode.UID)
+	assert.Nil(t, inode.AccessTime)
+	require.NotNil(t, inode.BirthTime)
+	assert.Equal(t, int64(1690000000), inode.BirthTime.Unix())
+
+	var root inodeResponse
+	getJSON(t, srv.URL+"/api/containers/0/volumes/Data/stat/", http.StatusOK, &root)
+	assert.Equal(t, "directory", root.Type)
+	assert.Zero(t, root.Inode)
+}
+
+func TestSnapshots(t *testing.T) {
+	srv := newTestServer(t)
+
+	var snapshots []snapshotResponse
+	getJSON(t, srv.URL+"/api/containers/0/volumes/0/snapshots", http.StatusOK, &snapshots)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "nightly", snapshots[0].Name)
+	assert.Equal(t, uint64(40), snapshots[0].XID)
+
+	var dir directoryResponse
+	getJSON(t, srv.URL+"/api/containers/0/volumes/0/ls/?snapshot=nightly", http.StatusOK, &dir)
+	require.Len(t, dir.Entries, 1)
+	assert.Equal(t, "old.txt", dir.Entries[0].Name)
+
+	var errResp errorResponse
+	getJSON(t, srv.URL+"/api/containers/0/volumes/0/ls/?snapshot=weekly", http.StatusNotFound, &errResp)
+}
+
+func TestDownload(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, err := http.Get(srv.URL + "/api/containers/0/volumes/0/download/docs/readme.txt")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello, apfs", string(body))
+	assert.Equal(t, "11", resp.Header.Get("Content-Length"))
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, `attachment; filename=readme.txt`, resp.Header.Get("Content-Disposition"))
+
+	resp, err = http.Get(srv.URL + "/api/containers/0/volumes/0/download/old.txt?snapshot=nightly")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(body))
+}
+
+func TestDownloadRange(t *testing.T) {
+	srv := newTestServer(t)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/containers/0/volumes/0/download/docs/readme.txt", nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=7-")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "bytes 7-10/11", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "apfs", string(body))
+
+	req.Header.Set("Range", "bytes=20-30")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+}
+
+func TestDownloadRejectsDirectories(t *testing.T) {
+	srv := newTestServer(t)
+
+	var errResp errorResponse
+	getJSON(t, srv.URL+"/api/containers/0/volumes/0/download/docs", http.StatusBadRequest, &errResp)
+	getJSON(t, srv.URL+"/api/containers/0/volumes/0/download/missing.txt", http.StatusNotFound, &errResp)
+}
+
+func TestReadOnly(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, err := http.Post(srv.URL+"/api/containers", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestWriteErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{&fs.PathError{Op: "open", Path: "x", Err: fs.ErrNotExist}, http.StatusNotFound},
+		{apfs.ErrSnapshotNotFound, http.StatusNotFound},
+		{&fs.PathError{Op: "readdir", Path: "x", Err: apfs.ErrNotDir}, http.StatusBadRequest},
+		{errors.New("corrupt b-tree node"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		writeError(rec, tt.err)
+		assert.Equal(t, tt.status, rec.Code, tt.err.Error())
+	}
+}
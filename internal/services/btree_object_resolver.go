@@ -5,547 +5,190 @@ import (
 	"fmt"
 	"sync"
 
-	"github.com/deploymenttheory/go-apfs/internal/interfaces"
-	"github.com/deploymenttheory/go-apfs/internal/parsers/btrees"
 	objectmaps "github.com/deploymenttheory/go-apfs/internal/parsers/object_maps"
 	"github.com/deploymenttheory/go-apfs/internal/types"
 )
 
-// BTreeObjectResolver resolves virtual object IDs using B-tree traversal
+// omapKey is an object map lookup: a virtual object identifier and the
+// newest transaction the caller is interested in
+type omapKey struct {
+	oid types.OidT
+	xid types.XidT
+}
+
+// BTreeObjectResolver resolves virtual object identifiers through a single
+// object map. The container's object map holds the volume superblocks and
+// other container-level virtual objects; each volume has its own object map
+// for its file-system tree, extent reference tree and snapshot metadata.
 type BTreeObjectResolver struct {
-	container  *ContainerReader
-	oIDCache   map[string]types.Paddr // Cache key: oid+xid, value: physical address
-	cacheMutex sync.RWMutex           // Thread-safe cache access
+	container *ContainerReader
+
+	// omapAddr is the physical address of the object map, or zero for the
+	// object map of the container superblock the reader is mounted at
+	omapAddr types.OidT
+
+	cacheMutex sync.RWMutex
+	cache      map[omapKey]types.OmapValT
 }
 
-// NewBTreeObjectResolver creates a new B-tree based object resolver
+// NewBTreeObjectResolver creates a resolver for the container's object map
 func NewBTreeObjectResolver(container *ContainerReader) *BTreeObjectResolver {
+	return NewObjectMapResolver(container, 0)
+}
+
+// NewObjectMapResolver creates a resolver for the object map stored at the
+// physical address omapAddr, such as a volume's apfs_omap_oid
+func NewObjectMapResolver(container *ContainerReader, omapAddr types.OidT) *BTreeObjectResolver {
 	return &BTreeObjectResolver{
 		container: container,
-		oIDCache:  make(map[string]types.Paddr),
+		omapAddr:  omapAddr,
+		cache:     make(map[omapKey]types.OmapValT),
 	}
 }
 
-// ResolveVirtualObject resolves a virtual object ID to its physical address using B-tree traversal
+// ResolveVirtualObject returns the physical address of the version of
+// virtualOID with the largest transaction identifier not after transactionID
 func (btor *BTreeObjectResolver) ResolveVirtualObject(virtualOID types.OidT, transactionID types.XidT) (types.Paddr, error) {
-	if btor.container == nil {
-		return 0, fmt.Errorf("container reader is nil")
+	val, err := btor.LookupMapping(virtualOID, transactionID)
+	if err != nil {
+		return 0, err
 	}
+	return val.OvPaddr, nil
+}
 
-	// Generate cache key
-	cacheKey := btor.makeCacheKey(virtualOID, transactionID)
+// LookupMapping returns the object map value for virtualOID at transactionID,
+// including the flags that mark the object as encrypted or header-less
+func (btor *BTreeObjectResolver) LookupMapping(virtualOID types.OidT, transactionID types.XidT) (types.OmapValT, error) {
+	if btor.container == nil {
+		return types.OmapValT{}, fmt.Errorf("container reader is nil")
+	}
 
-	// Check cache first
+	key := omapKey{oid: virtualOID, xid: transactionID}
 	btor.cacheMutex.RLock()
-	if cached, exists := btor.oIDCache[cacheKey]; exists {
-		btor.cacheMutex.RUnlock()
-		return cached, nil
-	}
+	val, ok := btor.cache[key]
 	btor.cacheMutex.RUnlock()
-
-	containerSB := btor.container.GetSuperblock()
-	if containerSB == nil {
-		return 0, fmt.Errorf("container superblock is nil")
-	}
-
-	// Get the container's object map OID (this is a physical OID)
-	omapOID := containerSB.NxOmapOid
-	if omapOID == 0 {
-		return 0, fmt.Errorf("container object map OID is zero")
-	}
-
-	// Read and parse the object map using our new parser
-	omapData, err := btor.container.ReadBlock(uint64(omapOID))
-	if err != nil {
-		return 0, fmt.Errorf("failed to read object map at block %d: %w", omapOID, err)
+	if ok {
+		return val, nil
 	}
 
-	omapReader, err := objectmaps.NewOmapReader(omapData, binary.LittleEndian)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse object map header: %w", err)
-	}
-
-	omap := omapReader.GetOmap()
-
-	// Check if this is a manually managed object map (no B-tree)
-	if omap.OmTreeOid == 0 {
-		// Try manually managed object map parsing
-		result, err := btor.searchManuallyManagedObjectMap(omapData, virtualOID, transactionID)
-		if err == nil {
-			// Cache the result
-			btor.cacheMutex.Lock()
-			btor.oIDCache[cacheKey] = result
-			btor.cacheMutex.Unlock()
+	omapAddr := btor.omapAddr
+	if omapAddr == 0 {
+		sb := btor.container.GetSuperblock()
+		if sb == nil {
+			return types.OmapValT{}, fmt.Errorf("container superblock is nil")
 		}
-		return result, err
+		omapAddr = sb.NxOmapOid
 	}
-
-	// This object map uses a B-tree - use the proper B-tree OID and transaction context
-	// fmt.Printf("DEBUG: Object map uses B-tree at OID %d, searching for virtual OID %d with transactionID %d\n", omap.OmTreeOid, virtualOID, transactionID)
-
-	// Use the most recent snapshot XID if available, otherwise use the provided transaction ID
-	searchXID := transactionID
-	if omap.OmMostRecentSnap > 0 && omap.OmMostRecentSnap <= transactionID {
-		searchXID = omap.OmMostRecentSnap
-		// fmt.Printf("DEBUG: Using most recent snapshot XID %d instead of %d\n", searchXID, transactionID)
-	}
-
-	// First try manually managed object map (in case B-tree is empty or for fallback)
-	manualResult, err := btor.searchManuallyManagedObjectMap(omapData, virtualOID, transactionID)
-	if err == nil {
-		// fmt.Printf("DEBUG: Found mapping in manually managed section: %d\n", manualResult)
-		// Cache the result
-		btor.cacheMutex.Lock()
-		btor.oIDCache[cacheKey] = manualResult
-		btor.cacheMutex.Unlock()
-		return manualResult, nil
+	if omapAddr == 0 {
+		return types.OmapValT{}, fmt.Errorf("object map address is zero")
 	}
 
-	// If manual search failed, try B-tree approach with proper transaction ID
-	// fmt.Printf("DEBUG: Manual search failed (%v), trying B-tree approach\n", err)
-	result, err := btor.searchBTreeObjectMap(omap.OmTreeOid, virtualOID, searchXID)
-	if err == nil {
-		// Cache the result
-		btor.cacheMutex.Lock()
-		btor.oIDCache[cacheKey] = result
-		btor.cacheMutex.Unlock()
+	val, err := btor.search(omapAddr, key)
+	if err != nil {
+		return types.OmapValT{}, err
 	}
-	return result, err
-}
-
-// searchManuallyManagedObjectMap searches for object mappings in a manually managed object map
-func (btor *BTreeObjectResolver) searchManuallyManagedObjectMap(omapData []byte, virtualOID types.OidT, transactionID types.XidT) (types.Paddr, error) {
-	// Object map header is 72 bytes, entries start after that
-	entryOffset := 72
-	entrySize := 32 // OmapKeyT (16 bytes) + OmapValT (16 bytes) = 32 bytes total
-
-	for entryOffset+entrySize <= len(omapData) {
-		// Parse the key (OID + XID)
-		entryOID := types.OidT(binary.LittleEndian.Uint64(omapData[entryOffset : entryOffset+8]))
-		entryXID := types.XidT(binary.LittleEndian.Uint64(omapData[entryOffset+8 : entryOffset+16]))
-
-		// Safety check - if we hit all zeros, we've reached the end
-		if entryOID == 0 && entryXID == 0 {
-			break
-		}
-
-		// Check if this is the object we're looking for
-		// For object maps, we need the OID to match and the XID to be <= transactionID
-		if entryOID == virtualOID && entryXID <= transactionID {
-			// Parse the value to get the physical address
-			entryPaddr := types.Paddr(binary.LittleEndian.Uint64(omapData[entryOffset+24 : entryOffset+32]))
-			return entryPaddr, nil
-		}
-
-		entryOffset += entrySize
+	if val.OvFlags&types.OmapValDeleted != 0 {
+		return types.OmapValT{}, fmt.Errorf("virtual object %d was deleted by transaction %d: %w", virtualOID, transactionID, ErrNotFound)
 	}
 
-	return 0, fmt.Errorf("virtual object %d not found in manually managed object map", virtualOID)
+	btor.cacheMutex.Lock()
+	btor.cache[key] = val
+	btor.cacheMutex.Unlock()
+	return val, nil
 }
 
-// searchBTreeObjectMap searches for object mappings in a B-tree based object map
-func (btor *BTreeObjectResolver) searchBTreeObjectMap(treeOID types.OidT, virtualOID types.OidT, transactionID types.XidT) (types.Paddr, error) {
-	// The B-tree itself is stored as a virtual object, which creates a circular dependency
-	// To resolve this, we need to either:
-	// 1. Have a bootstrap mechanism where the root B-tree is stored physically
-	// 2. Use a different resolution method for the B-tree itself
-
-	// For now, we'll assume the B-tree root is stored at a physical address equal to its OID
-	// This is often the case in APFS implementations
-	fmt.Printf("DEBUG: Reading B-tree root from block %d\n", treeOID)
-	treeData, err := btor.container.ReadBlock(uint64(treeOID))
+// search descends the object map B-tree at omapAddr to the last key not
+// greater than key and returns its value when the object identifiers match
+func (btor *BTreeObjectResolver) search(omapAddr types.OidT, key omapKey) (types.OmapValT, error) {
+	omapData, err := btor.container.ReadBlock(uint64(omapAddr))
 	if err != nil {
-		fmt.Printf("DEBUG: Failed to read B-tree root: %v\n", err)
-		return 0, fmt.Errorf("failed to read B-tree root at block %d: %w", treeOID, err)
+		return types.OmapValT{}, fmt.Errorf("failed to read object map at block %d: %w", omapAddr, err)
 	}
-
-	// Parse the B-tree node
-	fmt.Printf("DEBUG: Parsing B-tree node (%d bytes)\n", len(treeData))
-
-	// Debug: Check the first few bytes to understand node structure
-	if len(treeData) >= 64 {
-		fmt.Printf("DEBUG: Node header bytes: %02x\n", treeData[:64])
-		fmt.Printf("DEBUG: Node object type: 0x%08x\n", binary.LittleEndian.Uint32(treeData[24:28]))
-		fmt.Printf("DEBUG: Node flags: 0x%04x\n", binary.LittleEndian.Uint16(treeData[32:34]))
-		fmt.Printf("DEBUG: Node level: %d\n", binary.LittleEndian.Uint16(treeData[34:36]))
-		fmt.Printf("DEBUG: Node key count: %d\n", binary.LittleEndian.Uint32(treeData[36:40]))
-	}
-
-	nodeReader, err := btrees.NewBTreeNodeReader(treeData, binary.LittleEndian)
+	omapReader, err := objectmaps.NewOmapReader(omapData, binary.LittleEndian)
 	if err != nil {
-		fmt.Printf("DEBUG: Failed to parse B-tree node: %v\n", err)
-		return 0, fmt.Errorf("failed to parse B-tree node: %w", err)
+		return types.OmapValT{}, fmt.Errorf("failed to parse object map at block %d: %w", omapAddr, err)
 	}
-
-	// Create the search key
-	searchKey := types.OmapKeyT{
-		OkOid: virtualOID,
-		OkXid: transactionID,
+	treeAddr := omapReader.GetOmap().OmTreeOid
+	if treeAddr == 0 {
+		return types.OmapValT{}, fmt.Errorf("object map at block %d has no tree", omapAddr)
 	}
 
-	fmt.Printf("DEBUG: Searching B-tree for key OID=%d, XID=%d\n", searchKey.OkOid, searchKey.OkXid)
-
-	// Search the B-tree for the mapping
-	result, err := btor.searchBTreeNode(nodeReader, searchKey)
+	block, err := btor.container.ReadBlock(uint64(treeAddr))
 	if err != nil {
-		fmt.Printf("DEBUG: B-tree search failed: %v\n", err)
-	} else {
-		fmt.Printf("DEBUG: B-tree search succeeded: physical address %d\n", result)
+		return types.OmapValT{}, fmt.Errorf("failed to read object map tree at block %d: %w", treeAddr, err)
 	}
-	return result, err
-}
-
-// searchBTreeNode recursively searches a B-tree node for an object mapping
-func (btor *BTreeObjectResolver) searchBTreeNode(nodeReader any, searchKey types.OmapKeyT) (types.Paddr, error) {
-	// Cast the interface to the actual B-tree node reader
-	node, ok := nodeReader.(interfaces.BTreeNodeReader)
-	if !ok {
-		return 0, fmt.Errorf("invalid node reader type")
-	}
-
-	// Check if this is a leaf node
-	if node.IsLeaf() {
-		// Search for the key in this leaf node
-		return btor.searchLeafNode(node, searchKey)
-	} else {
-		// This is an internal node - find the appropriate child to follow
-		childOID, err := btor.findChildNode(node, searchKey)
-		if err != nil {
-			return 0, fmt.Errorf("failed to find child node: %w", err)
-		}
-
-		// Recursively search the child node
-		return btor.searchChildNode(childOID, searchKey)
-	}
-}
-
-// searchLeafNode searches for a key in a leaf node and returns the associated physical address
-// Implements APFS spec: "use the key with the largest transaction identifier"
-func (btor *BTreeObjectResolver) searchLeafNode(node interfaces.BTreeNodeReader, searchKey types.OmapKeyT) (types.Paddr, error) {
-	// Get node data
-	nodeData := node.Data()
-	keyCount := node.KeyCount()
-
-	// Parse the table of contents to find key/value pairs
-	entries, err := btor.parseTableOfContents(node, nodeData)
+	node, err := parseBTreeNodeBlock(block, 0, 0)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse table of contents: %w", err)
+		return types.OmapValT{}, fmt.Errorf("failed to parse object map tree at block %d: %w", treeAddr, err)
 	}
 
-	// Track the best match (OID matches and XID <= searchXID, with highest XID)
-	var bestMatch *struct {
-		physAddr types.Paddr
-		xid      types.XidT
-	}
-
-	// Search for the key (with debug output)
-	fmt.Printf("DEBUG: Leaf node has %d keys, %d entries\n", keyCount, len(entries))
-	for i := uint32(0); i < keyCount; i++ {
-		if i >= uint32(len(entries)) {
-			break
-		}
-
-		// Extract key and value from the entry
-		key, value, err := btor.extractKeyValue(nodeData, entries[i], node.HasFixedKVSize())
+	for {
+		i, err := lastOmapRecordNotAfter(node, key)
 		if err != nil {
-			fmt.Printf("DEBUG: Entry %d: Failed to extract key/value: %v\n", i, err)
-			continue // Skip malformed entries
-		}
-
-		// Parse the key as an object map key
-		if len(key) >= 16 {
-			fmt.Printf("DEBUG: Entry %d: Raw key bytes: %02x\n", i, key[:16])
-			entryOID := types.OidT(binary.LittleEndian.Uint64(key[0:8]))
-			entryXID := types.XidT(binary.LittleEndian.Uint64(key[8:16]))
-
-			fmt.Printf("DEBUG: Entry %d: OID=%d (0x%016x), XID=%d (searching for OID=%d, XID<=%d)\n",
-				i, entryOID, entryOID, entryXID, searchKey.OkOid, searchKey.OkXid)
-
-			// Check if this matches our search criteria (OID exact match, XID <= searchXID)
-			if entryOID == searchKey.OkOid && entryXID <= searchKey.OkXid {
-				// Parse the value as an object map value to get physical address
-				if len(value) >= 16 {
-					physAddr := types.Paddr(binary.LittleEndian.Uint64(value[8:16])) // paddr is at offset 8
-
-					// If this is our first match, or this entry has a higher XID, use it
-					if bestMatch == nil || entryXID > bestMatch.xid {
-						bestMatch = &struct {
-							physAddr types.Paddr
-							xid      types.XidT
-						}{physAddr, entryXID}
-						fmt.Printf("DEBUG: New best match! XID=%d, Physical address: %d\n", entryXID, physAddr)
-					}
-				}
-			}
-		} else {
-			fmt.Printf("DEBUG: Entry %d: Key too short (%d bytes)\n", i, len(key))
+			return types.OmapValT{}, err
 		}
-	}
-
-	if bestMatch != nil {
-		fmt.Printf("DEBUG: B-tree search succeeded: physical address %d (XID=%d)\n", bestMatch.physAddr, bestMatch.xid)
-		return bestMatch.physAddr, nil
-	}
-
-	return 0, fmt.Errorf("key not found in leaf node")
-}
-
-// findChildNode finds the appropriate child node to follow in an internal node
-func (btor *BTreeObjectResolver) findChildNode(node interfaces.BTreeNodeReader, searchKey types.OmapKeyT) (types.OidT, error) {
-	// Get node data
-	nodeData := node.Data()
-	keyCount := node.KeyCount()
-
-	// Parse the table of contents
-	entries, err := btor.parseTableOfContents(node, nodeData)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse table of contents: %w", err)
-	}
-
-	// Find the correct child by comparing keys
-	// In B-trees, keys in internal nodes are separators
-	for i := uint32(0); i < keyCount; i++ {
-		if i >= uint32(len(entries)) {
+		if i < 0 {
 			break
 		}
 
-		// Extract key and value
-		key, value, err := btor.extractKeyValue(nodeData, entries[i], node.HasFixedKVSize())
-		if err != nil {
-			continue
-		}
-
-		// Parse the key
-		if len(key) >= 16 {
-			entryOID := types.OidT(binary.LittleEndian.Uint64(key[0:8]))
-			entryXID := types.XidT(binary.LittleEndian.Uint64(key[8:16]))
-
-			// If our search key is less than or equal to this entry's key,
-			// we should follow this child
-			if btor.compareKeys(searchKey, types.OmapKeyT{OkOid: entryOID, OkXid: entryXID}) <= 0 {
-				// The value in internal nodes contains the child OID
-				if len(value) >= 8 {
-					childOID := types.OidT(binary.LittleEndian.Uint64(value[0:8]))
-					return childOID, nil
-				}
-			}
-		}
-	}
-
-	// If we didn't find a suitable child, take the rightmost child
-	// This happens when our search key is larger than all separator keys
-	if keyCount > 0 && uint32(len(entries)) > 0 {
-		lastEntry := entries[keyCount-1]
-		_, value, err := btor.extractKeyValue(nodeData, lastEntry, node.HasFixedKVSize())
-		if err == nil && len(value) >= 8 {
-			childOID := types.OidT(binary.LittleEndian.Uint64(value[0:8]))
-			return childOID, nil
-		}
-	}
-
-	return 0, fmt.Errorf("no suitable child node found")
-}
-
-// searchChildNode loads and searches a child node
-func (btor *BTreeObjectResolver) searchChildNode(childOID types.OidT, searchKey types.OmapKeyT) (types.Paddr, error) {
-	// Read the child node (this may require recursive virtual object resolution)
-	// For now, assume child nodes are stored physically at their OID
-	childData, err := btor.container.ReadBlock(uint64(childOID))
-	if err != nil {
-		return 0, fmt.Errorf("failed to read child node at block %d: %w", childOID, err)
-	}
-
-	// Parse the child node
-	childNodeReader, err := btrees.NewBTreeNodeReader(childData, binary.LittleEndian)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse child node: %w", err)
-	}
-
-	// Recursively search the child node
-	return btor.searchBTreeNode(childNodeReader, searchKey)
-}
-
-// parseTableOfContents parses the table of contents from a B-tree node
-func (btor *BTreeObjectResolver) parseTableOfContents(node interfaces.BTreeNodeReader, nodeData []byte) ([]tableEntry, error) {
-	keyCount := node.KeyCount()
-	tableSpace := node.TableSpace()
-
-	// The table offset is relative to the btn_data field, which starts after the btree_node_phys_t header
-	// btree_node_phys_t header is 56 bytes, so btn_data starts at offset 56
-	btnDataStart := 56
-	tableOffset := btnDataStart + int(tableSpace.Off)
-
-	fmt.Printf("DEBUG: Table parsing - keyCount=%d, tableSpace.Off=%d, tableOffset=%d, nodeDataLen=%d\n",
-		keyCount, tableSpace.Off, tableOffset, len(nodeData))
-
-	// Debug: Show what's at the start of btn_data
-	if len(nodeData) > btnDataStart+32 {
-		fmt.Printf("DEBUG: btn_data start (offset %d): %02x\n", btnDataStart, nodeData[btnDataStart:btnDataStart+32])
-	}
-
-	if tableOffset >= len(nodeData) {
-		return nil, fmt.Errorf("table offset %d exceeds node data length %d", tableOffset, len(nodeData))
-	}
-
-	var entries []tableEntry
-
-	if node.HasFixedKVSize() {
-		fmt.Printf("DEBUG: Fixed-size key/value table\n")
-		// Fixed-size keys and values - table contains kvoff_t entries
-		entrySize := 4 // kvoff_t is 4 bytes (2 uint16s)
-		for i := uint32(0); i < keyCount; i++ {
-			offset := tableOffset + int(i)*entrySize
-			if offset+entrySize > len(nodeData) {
+		if node.isLeaf() {
+			found := types.OidT(binary.LittleEndian.Uint64(node.records[i].key[0:8]))
+			if found != key.oid {
 				break
 			}
-
-			keyOffset := binary.LittleEndian.Uint16(nodeData[offset : offset+2])
-			valueOffset := binary.LittleEndian.Uint16(nodeData[offset+2 : offset+4])
-
-			fmt.Printf("DEBUG: Entry %d: keyOffset=%d, valueOffset=%d\n", i, keyOffset, valueOffset)
-
-			entries = append(entries, tableEntry{
-				keyOffset:   keyOffset,
-				valueOffset: valueOffset,
-				isFixedSize: true,
-			})
-		}
-	} else {
-		fmt.Printf("DEBUG: Variable-size key/value table\n")
-		// Variable-size keys and values - table contains kvloc_t entries
-		entrySize := 8 // kvloc_t is 8 bytes (2 nloc_t, each 4 bytes)
-		for i := uint32(0); i < keyCount; i++ {
-			offset := tableOffset + int(i)*entrySize
-			if offset+entrySize > len(nodeData) {
-				break
+			value := node.records[i].value
+			if len(value) < 16 {
+				return types.OmapValT{}, fmt.Errorf("object map node %d record %d has short value (%d bytes)", node.oid, i, len(value))
 			}
-
-			keyOffset := binary.LittleEndian.Uint16(nodeData[offset : offset+2])
-			keyLen := binary.LittleEndian.Uint16(nodeData[offset+2 : offset+4])
-			valueOffset := binary.LittleEndian.Uint16(nodeData[offset+4 : offset+6])
-			valueLen := binary.LittleEndian.Uint16(nodeData[offset+6 : offset+8])
-
-			fmt.Printf("DEBUG: Entry %d: keyOffset=%d, keyLen=%d, valueOffset=%d, valueLen=%d\n",
-				i, keyOffset, keyLen, valueOffset, valueLen)
-
-			entries = append(entries, tableEntry{
-				keyOffset:   keyOffset,
-				keyLen:      keyLen,
-				valueOffset: valueOffset,
-				valueLen:    valueLen,
-				isFixedSize: false,
-			})
+			return types.OmapValT{
+				OvFlags: binary.LittleEndian.Uint32(value[0:4]),
+				OvSize:  binary.LittleEndian.Uint32(value[4:8]),
+				OvPaddr: types.Paddr(binary.LittleEndian.Uint64(value[8:16])),
+			}, nil
 		}
-	}
-
-	return entries, nil
-}
-
-// tableEntry represents an entry in the table of contents
-type tableEntry struct {
-	keyOffset   uint16
-	keyLen      uint16
-	valueOffset uint16
-	valueLen    uint16
-	isFixedSize bool
-}
-
-// extractKeyValue extracts the key and value data from a table entry
-func (btor *BTreeObjectResolver) extractKeyValue(nodeData []byte, entry tableEntry, isFixedSize bool) ([]byte, []byte, error) {
-	var key, value []byte
 
-	// Key and value offsets are relative to the btn_data field (starts at offset 56)
-	btnDataStart := 56
-
-	// Extract key
-	// Key offsets are relative to the BEGINNING of the k/v area
-	keyStart := btnDataStart + int(entry.keyOffset)
-	if isFixedSize {
-		// For fixed-size, we need to determine the size from the B-tree info
-		// For object maps, keys are 16 bytes (OID + XID)
-		keyEnd := keyStart + 16
-		if keyEnd > len(nodeData) {
-			return nil, nil, fmt.Errorf("key extends beyond node data: keyStart=%d, keyEnd=%d, nodeDataLen=%d",
-				keyStart, keyEnd, len(nodeData))
+		childAddr, err := node.childOID(i)
+		if err != nil {
+			return types.OmapValT{}, err
 		}
-		key = nodeData[keyStart:keyEnd]
-	} else {
-		keyEnd := keyStart + int(entry.keyLen)
-		if keyEnd > len(nodeData) {
-			return nil, nil, fmt.Errorf("key extends beyond node data: keyStart=%d, keyEnd=%d, nodeDataLen=%d",
-				keyStart, keyEnd, len(nodeData))
+		block, err := btor.container.ReadBlock(uint64(childAddr))
+		if err != nil {
+			return types.OmapValT{}, fmt.Errorf("failed to read object map node at block %d: %w", childAddr, err)
 		}
-		key = nodeData[keyStart:keyEnd]
-	}
-
-	// Extract value
-	// CRITICAL FIX: Value offsets are relative to the END of the value area!
-	// Reference: APFS Advent Challenge Day 6 - "Key offsets are relative to the beginning
-	// of the k/v area and value offsets from the end."
-	//
-	// For non-root nodes: value area starts at end of node
-	// For root nodes: value area starts before btree_info_t (0x28 = 40 bytes from end)
-	//
-	// TODO: Detect if this is a root node and adjust accordingly
-	// For now, assume non-root nodes (most common case)
-	valueAreaEnd := len(nodeData)
-
-	if isFixedSize {
-		// For object maps, values are 16 bytes (flags + size + paddr)
-		valueStart := valueAreaEnd - int(entry.valueOffset) - 16
-		valueEnd := valueStart + 16
-
-		if valueStart < btnDataStart || valueEnd > len(nodeData) {
-			return nil, nil, fmt.Errorf("value extends beyond node data: valueStart=%d, valueEnd=%d, nodeDataLen=%d",
-				valueStart, valueEnd, len(nodeData))
+		child, err := parseBTreeNodeBlock(block, node.keySize, node.valueSize)
+		if err != nil {
+			return types.OmapValT{}, fmt.Errorf("failed to parse object map node at block %d: %w", childAddr, err)
 		}
-		value = nodeData[valueStart:valueEnd]
-	} else {
-		valueStart := valueAreaEnd - int(entry.valueOffset) - int(entry.valueLen)
-		valueEnd := valueStart + int(entry.valueLen)
-
-		if valueStart < btnDataStart || valueEnd > len(nodeData) {
-			return nil, nil, fmt.Errorf("value extends beyond node data: valueStart=%d, valueEnd=%d, nodeDataLen=%d",
-				valueStart, valueEnd, len(nodeData))
+		if child.level+1 != node.level {
+			return types.OmapValT{}, fmt.Errorf("object map node at block %d has level %d, expected %d", childAddr, child.level, node.level-1)
 		}
-		value = nodeData[valueStart:valueEnd]
+		node = child
 	}
 
-	fmt.Printf("DEBUG: Extracted key (%d bytes) from offset %d: %02x\n", len(key), keyStart, key)
-	fmt.Printf("DEBUG: Extracted value (%d bytes) from valueAreaEnd=%d, offset=%d: %02x\n",
-		len(value), valueAreaEnd, entry.valueOffset, value)
-
-	return key, value, nil
+	return types.OmapValT{}, fmt.Errorf("virtual object %d at transaction %d %w in object map %d", key.oid, key.xid, ErrNotFound, omapAddr)
 }
 
-// compareKeys compares two object map keys for ordering
-func (btor *BTreeObjectResolver) compareKeys(key1, key2 types.OmapKeyT) int {
-	// First compare by OID
-	if key1.OkOid < key2.OkOid {
-		return -1
-	} else if key1.OkOid > key2.OkOid {
-		return 1
-	}
-
-	// If OIDs are equal, compare by XID
-	if key1.OkXid < key2.OkXid {
-		return -1
-	} else if key1.OkXid > key2.OkXid {
-		return 1
+// lastOmapRecordNotAfter returns the index of the last record of node whose
+// omap_key_t sorts at or before key, or -1 when every record sorts after it
+func lastOmapRecordNotAfter(node *btreeNode, key omapKey) (int, error) {
+	last := -1
+	for i, record := range node.records {
+		if len(record.key) < 16 {
+			return 0, fmt.Errorf("object map node %d record %d has short key (%d bytes)", node.oid, i, len(record.key))
+		}
+		oid := types.OidT(binary.LittleEndian.Uint64(record.key[0:8]))
+		xid := types.XidT(binary.LittleEndian.Uint64(record.key[8:16]))
+		if oid > key.oid || (oid == key.oid && xid > key.xid) {
+			break
+		}
+		last = i
 	}
-
-	return 0 // Keys are equal
-}
-
-// makeCacheKey generates a cache key for a given virtual OID and transaction ID
-func (btor *BTreeObjectResolver) makeCacheKey(virtualOID types.OidT, transactionID types.XidT) string {
-	return fmt.Sprintf("%d-%d", virtualOID, transactionID)
+	return last, nil
 }
 
-// ClearCache clears the cache
+// ClearCache discards the cached mappings
 func (btor *BTreeObjectResolver) ClearCache() {
 	btor.cacheMutex.Lock()
-	btor.oIDCache = make(map[string]types.Paddr)
+	btor.cache = make(map[omapKey]types.OmapValT)
 	btor.cacheMutex.Unlock()
-	fmt.Println("Cache cleared.")
 }
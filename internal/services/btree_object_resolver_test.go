@@ -210,3 +210,88 @@ func TestBTreeObjectResolverMultipleOIDs(t *testing.T) {
 		})
 	}
 }
+
+// buildTwoLevelObjectMap writes an object map at block 10 whose tree has an
+// index root at 11 and two leaves at 12 and 13
+func buildTwoLevelObjectMap(img *testImage) {
+	left := []btreeRecord{
+		omapRecord(1026, 5, 20, 0),
+		omapRecord(1026, 9, 21, 0),
+		omapRecord(1030, 3, 22, 0),
+	}
+	right := []btreeRecord{
+		omapRecord(1040, 4, 23, 0),
+		omapRecord(1040, 8, 0, types.OmapValDeleted),
+		omapRecord(1050, 2, 24, types.OmapValEncrypted),
+	}
+	img.writeObjectMapHeader(10, 11, 9)
+	img.putObject(12, buildBTreeNode(testBTreeNode{oid: 12, subtype: types.ObjectTypeOmap, keySize: 16, valueSize: 16, records: left}))
+	img.putObject(13, buildBTreeNode(testBTreeNode{oid: 13, subtype: types.ObjectTypeOmap, keySize: 16, valueSize: 16, records: right}))
+	img.putObject(11, buildBTreeNode(testBTreeNode{oid: 11, subtype: types.ObjectTypeOmap, root: true, level: 1, keySize: 16, valueSize: 16, records: []btreeRecord{
+		{key: left[0].key, value: childValue(12)},
+		{key: right[0].key, value: childValue(13)},
+	}}))
+}
+
+func TestBTreeObjectResolverTransactions(t *testing.T) {
+	img := newTestImage(32)
+	img.writeContainerSuperblock(10, 10)
+	buildTwoLevelObjectMap(img)
+	resolver := NewBTreeObjectResolver(img.containerReader(t))
+
+	for _, tc := range []struct {
+		oid  types.OidT
+		xid  types.XidT
+		want types.Paddr
+	}{
+		{1026, 5, 20},
+		{1026, 8, 20},
+		{1026, 9, 21},
+		{1026, 100, 21},
+		{1030, 9, 22},
+		{1040, 7, 23},
+	} {
+		got, err := resolver.ResolveVirtualObject(tc.oid, tc.xid)
+		require.NoError(t, err, "oid %d xid %d", tc.oid, tc.xid)
+		assert.Equal(t, tc.want, got, "oid %d xid %d", tc.oid, tc.xid)
+	}
+
+	for _, tc := range []struct {
+		oid types.OidT
+		xid types.XidT
+	}{
+		{1026, 4},  // created after the transaction
+		{1027, 9},  // never mapped
+		{1030, 2},  // created after the transaction
+		{1040, 8},  // deleted
+		{1060, 99}, // past the last key
+		{1, 99},    // before the first key
+	} {
+		_, err := resolver.ResolveVirtualObject(tc.oid, tc.xid)
+		assert.ErrorIs(t, err, ErrNotFound, "oid %d xid %d", tc.oid, tc.xid)
+	}
+
+	val, err := resolver.LookupMapping(1050, 9)
+	require.NoError(t, err)
+	assert.Equal(t, types.OmapValEncrypted, val.OvFlags)
+	assert.Equal(t, types.Paddr(24), val.OvPaddr)
+}
+
+func TestObjectMapResolverScopes(t *testing.T) {
+	img := newTestImage(32)
+	img.writeContainerSuperblock(10, 10)
+	buildTwoLevelObjectMap(img)
+	img.writeObjectMap(14, 9, omapRecord(1026, 7, 25, 0))
+	cr := img.containerReader(t)
+
+	container, err := NewBTreeObjectResolver(cr).ResolveVirtualObject(1026, 9)
+	require.NoError(t, err)
+	assert.Equal(t, types.Paddr(21), container)
+
+	volume, err := NewObjectMapResolver(cr, 14).ResolveVirtualObject(1026, 9)
+	require.NoError(t, err)
+	assert.Equal(t, types.Paddr(25), volume)
+
+	_, err = NewObjectMapResolver(cr, 14).ResolveVirtualObject(1030, 9)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
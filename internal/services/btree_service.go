@@ -25,6 +25,16 @@ func NewBTreeService(container *ContainerReader) *BTreeService {
 	}
 }
 
+// NewVolumeBTreeService creates a B-tree service for the trees of a volume,
+// whose virtual nodes are resolved through the volume's object map
+func NewVolumeBTreeService(container *ContainerReader, volumeSB *types.ApfsSuperblockT) *BTreeService {
+	return &BTreeService{
+		container: container,
+		resolver:  NewObjectMapResolver(container, volumeSB.ApfsOmapOid),
+		cache:     NewObjectMapBTreeCache(DefaultCacheConfig()),
+	}
+}
+
 // NewBTreeServiceWithCache creates a new B-tree service with custom cache settings
 func NewBTreeServiceWithCache(container *ContainerReader, config CacheConfig) *BTreeService {
 	return &BTreeService{
@@ -55,10 +65,14 @@ func (bt *BTreeService) GetFSRecordsForOID(rootTreeOID types.OidT, targetOID typ
 	return bt.searchBTreeForFSRecords(rootNode, targetOID, maxXID)
 }
 
-// GetOMapEntry gets an object map entry for a virtual OID
-func (bt *BTreeService) GetOMapEntry(omapTreeOID types.OidT, virtualOID types.OidT, maxXID types.XidT) (*OMapEntry, error) {
-	// This is essentially what our BTreeObjectResolver does, but we'll expose it as a service method
-	physAddr, err := bt.resolver.ResolveVirtualObject(virtualOID, maxXID)
+// GetOMapEntry gets the entry for a virtual OID from the object map at omapOID,
+// or from the service's own object map when omapOID is zero
+func (bt *BTreeService) GetOMapEntry(omapOID types.OidT, virtualOID types.OidT, maxXID types.XidT) (*OMapEntry, error) {
+	resolver := bt.resolver
+	if omapOID != 0 {
+		resolver = NewObjectMapResolver(bt.container, omapOID)
+	}
+	physAddr, err := resolver.ResolveVirtualObject(virtualOID, maxXID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve virtual OID %d: %w", virtualOID, err)
 	}
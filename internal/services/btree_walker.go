@@ -0,0 +1,191 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/deploymenttheory/go-apfs/internal/parsers/btrees"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+const (
+	// btreeNodeHeaderSize is the size of btree_node_phys_t up to btn_data
+	btreeNodeHeaderSize = 56
+
+	// btreeInfoSize is the size of the btree_info_t stored at the end of a root node
+	btreeInfoSize = 40
+
+	// btreeOffsetInvalid marks a table of contents entry without a value (BTOFF_INVALID)
+	btreeOffsetInvalid = 0xffff
+)
+
+// btreeRecord is a single key/value pair stored in a B-tree node
+type btreeRecord struct {
+	key   []byte
+	value []byte
+}
+
+// btreeNode is a B-tree node with its table of contents decoded into records
+type btreeNode struct {
+	oid       types.OidT
+	level     uint16
+	flags     uint16
+	keySize   uint32
+	valueSize uint32
+	records   []btreeRecord
+}
+
+// isLeaf reports whether the node is a leaf node
+func (n *btreeNode) isLeaf() bool {
+	return n.flags&types.BtnodeLeaf != 0
+}
+
+// childOID returns the child object identifier stored in the i-th record of an index node
+func (n *btreeNode) childOID(i int) (types.OidT, error) {
+	value := n.records[i].value
+	if len(value) < 8 {
+		return 0, fmt.Errorf("index node %d record %d has short value (%d bytes)", n.oid, i, len(value))
+	}
+	return types.OidT(binary.LittleEndian.Uint64(value[0:8])), nil
+}
+
+// parseBTreeNodeBlock decodes a raw B-tree node block. keySize and valueSize are
+// the fixed entry sizes from the tree's btree_info_t; they are taken from the
+// node itself when it is a root node and are only used when the node has
+// BTNODE_FIXED_KV_SIZE set.
+func parseBTreeNodeBlock(block []byte, keySize, valueSize uint32) (*btreeNode, error) {
+	reader, err := btrees.NewBTreeNodeReader(block, binary.LittleEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &btreeNode{
+		oid:       types.OidT(binary.LittleEndian.Uint64(block[8:16])),
+		level:     reader.Level(),
+		flags:     reader.Flags(),
+		keySize:   keySize,
+		valueSize: valueSize,
+	}
+
+	valueEnd := len(block)
+	if reader.IsRoot() {
+		if len(block) < btreeNodeHeaderSize+btreeInfoSize {
+			return nil, fmt.Errorf("root node %d too small for btree_info_t", node.oid)
+		}
+		valueEnd -= btreeInfoSize
+		info := block[valueEnd:]
+		node.keySize = binary.LittleEndian.Uint32(info[8:12])
+		node.valueSize = binary.LittleEndian.Uint32(info[12:16])
+	}
+
+	toc := reader.TableSpace()
+	tocStart := btreeNodeHeaderSize + int(toc.Off)
+	keyStart := tocStart + int(toc.Len)
+	if keyStart > valueEnd {
+		return nil, fmt.Errorf("node %d table of contents exceeds node bounds", node.oid)
+	}
+
+	fixed := reader.HasFixedKVSize()
+	entrySize := 8
+	if fixed {
+		entrySize = 4
+	}
+
+	count := int(reader.KeyCount())
+	if tocStart+count*entrySize > keyStart {
+		return nil, fmt.Errorf("node %d key count %d exceeds table of contents", node.oid, count)
+	}
+
+	node.records = make([]btreeRecord, 0, count)
+	for i := 0; i < count; i++ {
+		entry := block[tocStart+i*entrySize:]
+
+		var kOff, kLen, vOff, vLen int
+		if fixed {
+			kOff = int(binary.LittleEndian.Uint16(entry[0:2]))
+			vOff = int(binary.LittleEndian.Uint16(entry[2:4]))
+			kLen = int(node.keySize)
+			vLen = int(node.valueSize)
+			if !node.isLeaf() {
+				vLen = 8
+			}
+		} else {
+			kOff = int(binary.LittleEndian.Uint16(entry[0:2]))
+			kLen = int(binary.LittleEndian.Uint16(entry[2:4]))
+			vOff = int(binary.LittleEndian.Uint16(entry[4:6]))
+			vLen = int(binary.LittleEndian.Uint16(entry[6:8]))
+		}
+
+		kStart := keyStart + kOff
+		if kStart+kLen > valueEnd {
+			return nil, fmt.Errorf("node %d record %d key out of bounds", node.oid, i)
+		}
+
+		record := btreeRecord{key: block[kStart : kStart+kLen]}
+		if vOff != btreeOffsetInvalid {
+			vStart := valueEnd - vOff
+			if vStart < keyStart || vStart+vLen > valueEnd {
+				return nil, fmt.Errorf("node %d record %d value out of bounds", node.oid, i)
+			}
+			record.value = block[vStart : vStart+vLen]
+		}
+
+		node.records = append(node.records, record)
+	}
+
+	return node, nil
+}
+
+// walkPhysicalBTree visits every leaf record of a B-tree whose nodes are
+// physical objects, such as object maps and snapshot metadata trees, in key order.
+func walkPhysicalBTree(container *ContainerReader, rootAddr types.Paddr, fn func(key, value []byte) error) error {
+	block, err := container.ReadBlock(uint64(rootAddr))
+	if err != nil {
+		return fmt.Errorf("failed to read B-tree root at %d: %w", rootAddr, err)
+	}
+
+	root, err := parseBTreeNodeBlock(block, 0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to parse B-tree root at %d: %w", rootAddr, err)
+	}
+
+	return walkPhysicalBTreeNode(container, root, fn)
+}
+
+// walkPhysicalBTreeNode visits the leaf records below node
+func walkPhysicalBTreeNode(container *ContainerReader, node *btreeNode, fn func(key, value []byte) error) error {
+	if node.isLeaf() {
+		for _, record := range node.records {
+			if err := fn(record.key, record.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := range node.records {
+		childAddr, err := node.childOID(i)
+		if err != nil {
+			return err
+		}
+
+		block, err := container.ReadBlock(uint64(childAddr))
+		if err != nil {
+			return fmt.Errorf("failed to read B-tree node at %d: %w", childAddr, err)
+		}
+
+		child, err := parseBTreeNodeBlock(block, node.keySize, node.valueSize)
+		if err != nil {
+			return fmt.Errorf("failed to parse B-tree node at %d: %w", childAddr, err)
+		}
+		if child.level+1 != node.level {
+			return fmt.Errorf("B-tree node at %d has level %d, expected %d", childAddr, child.level, node.level-1)
+		}
+
+		if err := walkPhysicalBTreeNode(container, child, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBTreeNodeBlockVariableSize(t *testing.T) {
+	records := []btreeRecord{
+		{key: jKey(2, types.ApfsTypeInode), value: []byte{1, 2, 3, 4, 5}},
+		{key: jKey(2, types.ApfsTypeDirRec, 'a', 'b'), value: []byte{6, 7}},
+	}
+	block := buildBTreeNode(testBTreeNode{oid: 10, root: true, records: records})
+
+	node, err := parseBTreeNodeBlock(block, 0, 0)
+	require.NoError(t, err)
+
+	assert.True(t, node.isLeaf())
+	require.Len(t, node.records, 2)
+	for i := range records {
+		assert.Equal(t, records[i].key, node.records[i].key)
+		assert.Equal(t, records[i].value, node.records[i].value)
+	}
+}
+
+func TestParseBTreeNodeBlockFixedSize(t *testing.T) {
+	key := make([]byte, 16)
+	binary.LittleEndian.PutUint64(key[0:8], 1026)
+	binary.LittleEndian.PutUint64(key[8:16], 5)
+	value := make([]byte, 16)
+	binary.LittleEndian.PutUint64(value[8:16], 77)
+
+	block := buildBTreeNode(testBTreeNode{
+		oid: 10, root: true, keySize: 16, valueSize: 16,
+		records: []btreeRecord{{key: key, value: value}},
+	})
+
+	node, err := parseBTreeNodeBlock(block, 0, 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(16), node.keySize)
+	assert.Equal(t, uint32(16), node.valueSize)
+	require.Len(t, node.records, 1)
+	assert.Equal(t, key, node.records[0].key)
+	assert.Equal(t, value, node.records[0].value)
+}
+
+func TestParseBTreeNodeBlockRejectsBadChecksum(t *testing.T) {
+	block := buildBTreeNode(testBTreeNode{oid: 10, root: true})
+	block[100] ^= 0xff
+
+	_, err := parseBTreeNodeBlock(block, 0, 0)
+	assert.Error(t, err)
+}
+
+func TestWalkPhysicalBTree(t *testing.T) {
+	img := newTestImage(8)
+	img.writeContainerSuperblock(10, 0)
+
+	img.putObject(3, buildBTreeNode(testBTreeNode{oid: 3, records: []btreeRecord{
+		{key: jKey(1, types.ApfsTypeInode), value: []byte{1}},
+		{key: jKey(2, types.ApfsTypeInode), value: []byte{2}},
+	}}))
+	img.putObject(4, buildBTreeNode(testBTreeNode{oid: 4, records: []btreeRecord{
+		{key: jKey(3, types.ApfsTypeInode), value: []byte{3}},
+	}}))
+	img.putObject(2, buildBTreeNode(testBTreeNode{oid: 2, root: true, level: 1, records: []btreeRecord{
+		{key: jKey(1, types.ApfsTypeInode), value: childValue(3)},
+		{key: jKey(3, types.ApfsTypeInode), value: childValue(4)},
+	}}))
+
+	cr := img.containerReader(t)
+
+	var values []byte
+	err := walkPhysicalBTree(cr, 2, func(key, value []byte) error {
+		values = append(values, value...)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, values)
+}
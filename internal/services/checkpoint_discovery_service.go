@@ -3,10 +3,18 @@ package services
 import (
 	"encoding/binary"
 	"fmt"
+	"sort"
+	"time"
 
+	"github.com/deploymenttheory/go-apfs/internal/parsers/container"
+	"github.com/deploymenttheory/go-apfs/internal/parsers/objects"
 	"github.com/deploymenttheory/go-apfs/internal/types"
 )
 
+// checkpointAreaNonContiguous is the flag in the high bit of nx_xp_desc_blocks
+// and nx_xp_data_blocks marking an area whose base address is a B-tree
+const checkpointAreaNonContiguous = 0x80000000
+
 // CheckpointDiscoveryService handles finding the latest valid container superblock
 // from the checkpoint descriptor area, following the Apple APFS mounting procedure
 type CheckpointDiscoveryService struct {
@@ -22,214 +30,309 @@ func NewCheckpointDiscoveryService(container *ContainerReader) *CheckpointDiscov
 
 // CheckpointCandidate represents a potential checkpoint superblock
 type CheckpointCandidate struct {
-	Superblock   *types.NxSuperblockT
+	Superblock    *types.NxSuperblockT
 	TransactionID types.XidT
 	BlockAddress  uint64
-	IsValid      bool
-	ErrorMsg     string
+	IsValid       bool
+	ErrorMsg      string
+
+	// Mappings lists the ephemeral objects of the checkpoint, read from its
+	// checkpoint-mapping blocks
+	Mappings []types.CheckpointMappingT
 }
 
-// FindLatestValidSuperblock implements the Apple APFS mounting procedure:
-// 1. Read block zero to get initial superblock
-// 2. Use nx_xp_desc_base to locate checkpoint descriptor area
-//    - If MSB is clear: descriptor area is contiguous (simple case)
-//    - If MSB is set: descriptor area is non-contiguous, requires B-tree parsing
-// 3. Read entries in checkpoint descriptor area (checkpoint_map_phys_t or nx_superblock_t)
-// 4. Find container superblock with largest XID that isn't malformed
-func (cds *CheckpointDiscoveryService) FindLatestValidSuperblock() (*CheckpointCandidate, error) {
-	// Step 1: Read block zero superblock (this might be stale)
-	blockZeroSB := cds.container.GetSuperblock()
-	if blockZeroSB == nil {
-		return nil, fmt.Errorf("no container superblock available at block zero")
-	}
+// EphemeralObject is an ephemeral object, such as the space manager or the
+// reaper, loaded from the checkpoint data area
+type EphemeralObject struct {
+	OID     types.OidT
+	Type    uint32
+	Subtype uint32
+	FsOID   types.OidT
+	Address types.Paddr
+	Data    []byte
+}
 
-	fmt.Printf("DEBUG: Block zero superblock - XID=%d, DescBase=0x%X, DescBlocks=%d\n",
-		blockZeroSB.NxNextXid, blockZeroSB.NxXpDescBase, blockZeroSB.NxXpDescBlocks)
+// CheckpointInfo summarises a checkpoint that can still be mounted
+type CheckpointInfo struct {
+	TransactionID types.XidT
+	BlockAddress  uint64
 
-	// Step 2: Use nx_xp_desc_base to locate checkpoint descriptor area
-	// Check MSB of nx_xp_desc_base to determine if descriptor area is contiguous
-	descBaseRaw := uint64(blockZeroSB.NxXpDescBase)
-	descBlocks := blockZeroSB.NxXpDescBlocks & 0x7FFFFFFF // Clear high bit flag
+	// Timestamp is the latest modification time of the volumes at the
+	// checkpoint, zero when none of them can be read
+	Timestamp time.Time
 
-	const msbMask uint64 = 0x8000000000000000
-	isNonContiguous := (descBaseRaw & msbMask) != 0
+	VolumeCount int
 
-	var blockRanges []blockRange
-	var err error
+	// Mounted is set for the checkpoint the container reader is mounted at
+	Mounted bool
+}
 
-	if isNonContiguous {
-		// MSB is set: LSBs contain B-Tree root node address
-		// The B-Tree maps logical offsets to physical block ranges (prange_t)
-		btreeRootAddr := descBaseRaw & ^msbMask // Clear MSB to get address
-		fmt.Printf("DEBUG: Non-contiguous descriptor area - B-tree root at block %d\n", btreeRootAddr)
+// ListCheckpoints returns every checkpoint that can be mounted with
+// ContainerReader.AtCheckpoint, newest first
+func (cds *CheckpointDiscoveryService) ListCheckpoints() ([]CheckpointInfo, error) {
+	candidates, err := cds.GetCandidates()
+	if err != nil {
+		return nil, err
+	}
 
-		blockRanges, err = cds.parseDescriptorAreaBTree(btreeRootAddr)
+	var mounted types.XidT
+	if current := cds.container.GetCheckpoint(); current != nil {
+		mounted = current.TransactionID
+	}
+
+	var infos []CheckpointInfo
+	seen := make(map[types.XidT]bool)
+	for _, candidate := range candidates {
+		if !candidate.IsValid || seen[candidate.TransactionID] {
+			continue
+		}
+		pinned, err := cds.container.pin(cds, candidate)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse non-contiguous descriptor area B-tree: %w", err)
+			continue
 		}
-		fmt.Printf("DEBUG: Found %d non-contiguous ranges for descriptor area\n", len(blockRanges))
-	} else {
-		// MSB is clear: descriptor area is contiguous
-		descBase := descBaseRaw
-		fmt.Printf("DEBUG: Contiguous descriptor area at block %d, %d blocks\n", descBase, descBlocks)
-		blockRanges = []blockRange{{start: descBase, count: uint64(descBlocks)}}
-	}
-
-	// Step 3: Read entries in checkpoint descriptor area
-	candidates := []*CheckpointCandidate{}
-
-	// Add block zero candidate first
-	blockZeroCandidate := &CheckpointCandidate{
-		Superblock:    blockZeroSB,
-		TransactionID: blockZeroSB.NxNextXid,
-		BlockAddress:  0,
-		IsValid:       cds.validateSuperblock(blockZeroSB),
-		ErrorMsg:      "",
-	}
-	if !blockZeroCandidate.IsValid {
-		blockZeroCandidate.ErrorMsg = "block zero superblock validation failed"
-	}
-	candidates = append(candidates, blockZeroCandidate)
-
-	// Scan checkpoint descriptor area for additional superblocks
-	// Iterate through all block ranges (contiguous or non-contiguous)
-	for _, brange := range blockRanges {
-		for blockOffset := uint64(0); blockOffset < brange.count; blockOffset++ {
-			blockAddr := brange.start + blockOffset
-
-			blockData, err := cds.container.ReadBlock(blockAddr)
-			if err != nil {
-				fmt.Printf("DEBUG: Failed to read checkpoint block %d: %v\n", blockAddr, err)
-				continue
-			}
+		seen[candidate.TransactionID] = true
 
-			if len(blockData) < 32 {
+		info := CheckpointInfo{
+			TransactionID: candidate.TransactionID,
+			BlockAddress:  candidate.BlockAddress,
+			Mounted:       candidate.TransactionID == mounted,
+		}
+		var latest uint64
+		for _, oid := range candidate.Superblock.NxFsOid {
+			if oid == 0 {
 				continue
 			}
-
-			// Check if this block contains a container superblock
-			if cds.isSuperblock(blockData) {
-				sb, err := cds.parseSuperblock(blockData)
-				if err != nil {
-					fmt.Printf("DEBUG: Failed to parse superblock at block %d: %v\n", blockAddr, err)
-					candidate := &CheckpointCandidate{
-						Superblock:    nil,
-						TransactionID: 0,
-						BlockAddress:  blockAddr,
-						IsValid:       false,
-						ErrorMsg:      fmt.Sprintf("parse error: %v", err),
-					}
-					candidates = append(candidates, candidate)
-					continue
-				}
-
-				isValid := cds.validateSuperblock(sb)
-				candidate := &CheckpointCandidate{
-					Superblock:    sb,
-					TransactionID: sb.NxNextXid,
-					BlockAddress:  blockAddr,
-					IsValid:       isValid,
-					ErrorMsg:      "",
-				}
-				if !isValid {
-					candidate.ErrorMsg = "superblock validation failed"
-				}
-
-				fmt.Printf("DEBUG: Found superblock at block %d - XID=%d, Valid=%t\n",
-					blockAddr, sb.NxNextXid, isValid)
-				candidates = append(candidates, candidate)
-			} else {
-				// This might be a checkpoint_map_phys_t - we could parse these too
-				// but for now we're focused on finding superblocks
-				fmt.Printf("DEBUG: Block %d is not a superblock (type=0x%08X)\n",
-					blockAddr, binary.LittleEndian.Uint32(blockData[24:28]))
+			info.VolumeCount++
+			if vs, err := NewVolumeService(pinned, oid); err == nil {
+				latest = max(latest, vs.GetSuperblock().ApfsLastModTime)
 			}
 		}
+		if latest != 0 {
+			info.Timestamp = time.Unix(0, int64(latest))
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// FindLatestValidSuperblock implements the Apple APFS mounting procedure:
+//  1. Read block zero to locate the checkpoint descriptor area, which is either
+//     contiguous or, when the high bit of nx_xp_desc_blocks is set, described
+//     by a B-tree of physical ranges
+//  2. Read every container superblock in the descriptor area
+//  3. Pick the superblock with the largest transaction identifier whose
+//     checkpoint-mapping blocks are intact
+//
+// Block zero itself is a candidate, so images without a descriptor area still
+// yield a checkpoint.
+func (cds *CheckpointDiscoveryService) FindLatestValidSuperblock() (*CheckpointCandidate, error) {
+	candidates, err := cds.GetCandidates()
+	if err != nil {
+		return nil, err
 	}
 
-	// Step 4: Find the superblock with the largest XID that is valid
-	var latestValid *CheckpointCandidate
 	for _, candidate := range candidates {
-		if candidate.IsValid && candidate.Superblock != nil {
-			if latestValid == nil || candidate.TransactionID > latestValid.TransactionID {
-				latestValid = candidate
-			}
+		if candidate.IsValid {
+			return candidate, nil
 		}
 	}
+	return nil, fmt.Errorf("no valid superblocks found in checkpoint area")
+}
 
-	if latestValid == nil {
-		return nil, fmt.Errorf("no valid superblocks found in checkpoint area")
+// GetCandidates returns every container superblock found in block zero and
+// the checkpoint descriptor area, newest first. Candidates that fail
+// validation are included with IsValid unset and the reason in ErrorMsg.
+func (cds *CheckpointDiscoveryService) GetCandidates() ([]*CheckpointCandidate, error) {
+	blockZeroSB := cds.container.GetBlockZeroSuperblock()
+	if blockZeroSB == nil {
+		return nil, fmt.Errorf("no container superblock available at block zero")
 	}
 
-	fmt.Printf("DEBUG: Selected superblock at block %d with XID=%d\n", 
-		latestValid.BlockAddress, latestValid.TransactionID)
+	area, err := cds.descriptorArea(blockZeroSB)
+	if err != nil {
+		return nil, err
+	}
+
+	blockZero, err := cds.container.ReadBlock(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block zero: %w", err)
+	}
+	candidates := []*CheckpointCandidate{cds.candidate(0, blockZero, area)}
 
-	return latestValid, nil
+	for _, addr := range area {
+		block, err := cds.container.ReadBlock(addr)
+		if err != nil {
+			candidates = append(candidates, &CheckpointCandidate{
+				BlockAddress: addr,
+				ErrorMsg:     fmt.Sprintf("read error: %v", err),
+			})
+			continue
+		}
+		if objectType(block) != types.ObjectTypeNxSuperblock {
+			continue
+		}
+		candidates = append(candidates, cds.candidate(addr, block, area))
+	}
+
+	// Newest first; on a tie prefer the copy in the descriptor area over
+	// block zero
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].TransactionID != candidates[j].TransactionID {
+			return candidates[i].TransactionID > candidates[j].TransactionID
+		}
+		return candidates[i].BlockAddress != 0 && candidates[j].BlockAddress == 0
+	})
+	return candidates, nil
 }
 
-// isSuperblock checks if the data contains a container superblock
-func (cds *CheckpointDiscoveryService) isSuperblock(data []byte) bool {
-	if len(data) < 32 {
-		return false
+// LoadEphemeralObjects reads the ephemeral objects listed in the checkpoint
+// maps of a candidate from the checkpoint data area, indexed by identifier
+func (cds *CheckpointDiscoveryService) LoadEphemeralObjects(candidate *CheckpointCandidate) (map[types.OidT]*EphemeralObject, error) {
+	blockSize := uint64(cds.container.GetBlockSize())
+	loaded := make(map[types.OidT]*EphemeralObject, len(candidate.Mappings))
+
+	for _, m := range candidate.Mappings {
+		blocks := (uint64(m.CpmSize) + blockSize - 1) / blockSize
+		if blocks == 0 {
+			return nil, fmt.Errorf("ephemeral object %d has zero size", m.CpmOid)
+		}
+		data, err := cds.container.ReadBlocks(uint64(m.CpmPaddr), blocks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ephemeral object %d: %w", m.CpmOid, err)
+		}
+		data = data[:m.CpmSize]
+
+		header, err := verifyObject(data)
+		if err != nil {
+			return nil, fmt.Errorf("ephemeral object %d at block %d: %w", m.CpmOid, m.CpmPaddr, err)
+		}
+		if header.OOid != m.CpmOid || header.OXid > candidate.TransactionID {
+			return nil, fmt.Errorf("ephemeral object at block %d is object %d from transaction %d, expected object %d",
+				m.CpmPaddr, header.OOid, header.OXid, m.CpmOid)
+		}
+
+		loaded[m.CpmOid] = &EphemeralObject{
+			OID:     m.CpmOid,
+			Type:    m.CpmType,
+			Subtype: m.CpmSubtype,
+			FsOID:   m.CpmFsOid,
+			Address: m.CpmPaddr,
+			Data:    data,
+		}
+	}
+	return loaded, nil
+}
+
+// candidate validates a superblock and reads its checkpoint-mapping blocks
+func (cds *CheckpointDiscoveryService) candidate(addr uint64, block []byte, area []uint64) *CheckpointCandidate {
+	c := &CheckpointCandidate{BlockAddress: addr}
+
+	if _, err := verifyObject(block); err != nil {
+		c.ErrorMsg = err.Error()
+		return c
+	}
+	reader, err := container.NewContainerSuperblockReader(block, binary.LittleEndian)
+	if err != nil {
+		c.ErrorMsg = fmt.Sprintf("parse error: %v", err)
+		return c
 	}
+	sb := reader.(*container.ContainerSuperblockReader).Superblock
+	c.Superblock = sb
+	c.TransactionID = sb.NxO.OXid
 
-	// Check magic number at offset 24 (in obj_phys_t header)
-	objType := binary.LittleEndian.Uint32(data[24:28])
-	
-	// Container superblock type
-	return objType == types.ObjectTypeNxSuperblock
+	if !cds.validateSuperblock(sb) {
+		c.ErrorMsg = "superblock validation failed"
+		return c
+	}
+
+	mappings, err := cds.readCheckpointMaps(sb, area)
+	if err != nil {
+		c.ErrorMsg = err.Error()
+		return c
+	}
+	c.Mappings = mappings
+	c.IsValid = true
+	return c
+}
+
+// readCheckpointMaps reads the checkpoint-mapping blocks that precede a
+// superblock in the descriptor area. They start at nx_xp_desc_index and,
+// with the superblock, span nx_xp_desc_len blocks, wrapping around the end
+// of the area.
+func (cds *CheckpointDiscoveryService) readCheckpointMaps(sb *types.NxSuperblockT, area []uint64) ([]types.CheckpointMappingT, error) {
+	if sb.NxXpDescLen == 0 {
+		return nil, nil
+	}
+	if len(area) == 0 || sb.NxXpDescLen > uint32(len(area)) || sb.NxXpDescIndex >= uint32(len(area)) {
+		return nil, fmt.Errorf("checkpoint spans %d blocks from index %d of a %d block descriptor area",
+			sb.NxXpDescLen, sb.NxXpDescIndex, len(area))
+	}
+
+	var mappings []types.CheckpointMappingT
+	for i := uint32(0); i+1 < sb.NxXpDescLen; i++ {
+		addr := area[(sb.NxXpDescIndex+i)%uint32(len(area))]
+		block, err := cds.container.ReadBlock(addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read checkpoint map at block %d: %w", addr, err)
+		}
+
+		header, err := verifyObject(block)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint map at block %d: %w", addr, err)
+		}
+		if header.OType&types.ObjectTypeMask != types.ObjectTypeCheckpointMap || header.OXid != sb.NxO.OXid {
+			return nil, fmt.Errorf("block %d is not a checkpoint map of transaction %d", addr, sb.NxO.OXid)
+		}
+
+		cpm, err := container.NewCheckpointMapReader(block, binary.LittleEndian)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint map at block %d: %w", addr, err)
+		}
+		for _, m := range cpm.Mappings() {
+			mappings = append(mappings, types.CheckpointMappingT{
+				CpmType:    m.Type(),
+				CpmSubtype: m.Subtype(),
+				CpmSize:    m.Size(),
+				CpmFsOid:   m.FilesystemOID(),
+				CpmOid:     m.ObjectID(),
+				CpmPaddr:   m.PhysicalAddress(),
+			})
+		}
+
+		last := i+2 == sb.NxXpDescLen
+		if cpm.IsLast() != last {
+			return nil, fmt.Errorf("checkpoint map at block %d has an unexpected last flag", addr)
+		}
+	}
+	return mappings, nil
 }
 
-// parseSuperblock parses a container superblock from raw data
-func (cds *CheckpointDiscoveryService) parseSuperblock(data []byte) (*types.NxSuperblockT, error) {
-	// Parse the superblock directly from raw data
-	sb := &types.NxSuperblockT{}
-	
-	// Parse essential fields directly from the data
-	if len(data) < 200 { // Need enough data for all fields
-		return nil, fmt.Errorf("insufficient data for container superblock")
-	}
-	
-	// Parse object header (32 bytes)
-	copy(sb.NxO.OChecksum[:], data[0:8])
-	sb.NxO.OOid = types.OidT(binary.LittleEndian.Uint64(data[8:16]))
-	sb.NxO.OXid = types.XidT(binary.LittleEndian.Uint64(data[16:24]))
-	sb.NxO.OType = binary.LittleEndian.Uint32(data[24:28])
-	sb.NxO.OSubtype = binary.LittleEndian.Uint32(data[28:32])
-
-	// Parse superblock specific fields (starting at offset 32)
-	sb.NxMagic = binary.LittleEndian.Uint32(data[32:36])
-	sb.NxBlockSize = binary.LittleEndian.Uint32(data[36:40])
-	sb.NxBlockCount = binary.LittleEndian.Uint64(data[40:48])
-	sb.NxFeatures = binary.LittleEndian.Uint64(data[48:56])
-	sb.NxReadonlyCompatibleFeatures = binary.LittleEndian.Uint64(data[56:64])
-	sb.NxIncompatibleFeatures = binary.LittleEndian.Uint64(data[64:72])
-	copy(sb.NxUuid[:], data[72:88])
-	sb.NxNextOid = types.OidT(binary.LittleEndian.Uint64(data[88:96]))
-	sb.NxNextXid = types.XidT(binary.LittleEndian.Uint64(data[96:104]))
-	sb.NxXpDescBlocks = binary.LittleEndian.Uint32(data[104:108])
-	sb.NxXpDataBlocks = binary.LittleEndian.Uint32(data[108:112])
-	sb.NxXpDescBase = types.Paddr(binary.LittleEndian.Uint64(data[112:120]))
-	sb.NxXpDataBase = types.Paddr(binary.LittleEndian.Uint64(data[120:128]))
-	sb.NxXpDescNext = binary.LittleEndian.Uint32(data[128:132])
-	sb.NxXpDataNext = binary.LittleEndian.Uint32(data[132:136])
-	sb.NxXpDescIndex = binary.LittleEndian.Uint32(data[136:140])
-	sb.NxXpDescLen = binary.LittleEndian.Uint32(data[140:144])
-	sb.NxXpDataIndex = binary.LittleEndian.Uint32(data[144:148])
-	sb.NxXpDataLen = binary.LittleEndian.Uint32(data[148:152])
-	sb.NxSpacemanOid = types.OidT(binary.LittleEndian.Uint64(data[152:160]))
-	sb.NxOmapOid = types.OidT(binary.LittleEndian.Uint64(data[160:168]))
-	sb.NxReaperOid = types.OidT(binary.LittleEndian.Uint64(data[168:176]))
-	sb.NxTestType = binary.LittleEndian.Uint32(data[176:180])
-	sb.NxMaxFileSystems = binary.LittleEndian.Uint32(data[180:184])
-	
-	// Parse filesystem OIDs array (array of 100 OIDs starting at offset 184)
-	for i := 0; i < 100 && 184+i*8+8 <= len(data); i++ {
-		offset := 184 + i*8
-		sb.NxFsOid[i] = types.OidT(binary.LittleEndian.Uint64(data[offset:offset+8]))
-	}
-
-	return sb, nil
+// descriptorArea returns the physical address of every block of the
+// checkpoint descriptor area, in logical order
+func (cds *CheckpointDiscoveryService) descriptorArea(sb *types.NxSuperblockT) ([]uint64, error) {
+	count := uint64(sb.NxXpDescBlocks &^ checkpointAreaNonContiguous)
+	if sb.NxXpDescBlocks&checkpointAreaNonContiguous == 0 {
+		area := make([]uint64, count)
+		for i := range area {
+			area[i] = uint64(sb.NxXpDescBase) + uint64(i)
+		}
+		return area, nil
+	}
+
+	ranges, err := cds.parseDescriptorAreaBTree(uint64(sb.NxXpDescBase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse non-contiguous descriptor area B-tree: %w", err)
+	}
+	var area []uint64
+	for _, r := range ranges {
+		for i := uint64(0); i < r.count; i++ {
+			area = append(area, r.start+i)
+		}
+	}
+	if uint64(len(area)) < count {
+		return nil, fmt.Errorf("descriptor area B-tree maps %d of %d blocks", len(area), count)
+	}
+	return area[:count], nil
 }
 
 // validateSuperblock performs basic validation of a container superblock
@@ -248,8 +351,8 @@ func (cds *CheckpointDiscoveryService) validateSuperblock(sb *types.NxSuperblock
 		return false
 	}
 
-	// Check that block size is reasonable (4KB is typical)
-	if sb.NxBlockSize == 0 || sb.NxBlockSize > 65536 {
+	// The block size must match the one the container was opened with
+	if sb.NxBlockSize != cds.container.GetBlockSize() {
 		return false
 	}
 
@@ -261,64 +364,57 @@ func (cds *CheckpointDiscoveryService) validateSuperblock(sb *types.NxSuperblock
 	return true
 }
 
-// GetCandidates returns all checkpoint candidates found during discovery
-func (cds *CheckpointDiscoveryService) GetCandidates() ([]*CheckpointCandidate, error) {
-	// This is a simplified version that just calls FindLatestValidSuperblock
-	// In a full implementation, we might want to return all candidates
-	latest, err := cds.FindLatestValidSuperblock()
-	if err != nil {
-		return nil, err
-	}
-
-	return []*CheckpointCandidate{latest}, nil
-}
-
 // blockRange represents a contiguous range of blocks
 type blockRange struct {
 	start uint64 // Starting block address
 	count uint64 // Number of blocks in range
 }
 
-// parseDescriptorAreaBTree parses the B-tree that maps logical offsets to physical block ranges
-// This B-tree is used when the checkpoint descriptor area is non-contiguous (MSB of nx_xp_desc_base is set)
-// The B-tree maps uint64_t logical offsets to prange_t physical block ranges
+// parseDescriptorAreaBTree reads the B-tree used when the checkpoint descriptor
+// area is non-contiguous. It maps uint64_t logical block offsets to prange_t
+// physical block ranges; the ranges are returned in logical order.
 func (cds *CheckpointDiscoveryService) parseDescriptorAreaBTree(btreeRootAddr uint64) ([]blockRange, error) {
-	// Read the B-tree root node
-	rootData, err := cds.container.ReadBlock(btreeRootAddr)
+	var ranges []blockRange
+	err := walkPhysicalBTree(cds.container, types.Paddr(btreeRootAddr), func(key, value []byte) error {
+		if len(key) < 8 || len(value) < 16 {
+			return fmt.Errorf("descriptor area B-tree record is too short")
+		}
+		ranges = append(ranges, blockRange{
+			start: binary.LittleEndian.Uint64(value[0:8]),
+			count: binary.LittleEndian.Uint64(value[8:16]),
+		})
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read B-tree root node at block %d: %w", btreeRootAddr, err)
-	}
-
-	// For a complete implementation, we would need to:
-	// 1. Parse the btree_node_phys_t structure from rootData
-	// 2. Read the btree_info_t from the end of the root node
-	// 3. Traverse the B-tree to enumerate all key/value pairs
-	// 4. Each key is a uint64_t logical offset
-	// 5. Each value is a prange_t (pr_start_paddr, pr_block_count)
-	// 6. Sort by logical offset and return the ranges in order
-
-	// This is a simplified implementation that assumes common cases
-	// A full implementation would require using the btree service
-	if len(rootData) < 56 {
-		return nil, fmt.Errorf("B-tree root node too small: %d bytes", len(rootData))
-	}
-
-	// Check object type (should be BTREE or BTREE_NODE)
-	objType := binary.LittleEndian.Uint32(rootData[24:28])
-	if objType != types.ObjectTypeBtree && objType != types.ObjectTypeBtreeNode {
-		return nil, fmt.Errorf("invalid B-tree root node type: 0x%08X", objType)
-	}
-
-	// For now, return an error indicating this feature needs full implementation
-	// In production, this would parse the B-tree using the existing btree service
-	return nil, fmt.Errorf("non-contiguous checkpoint descriptor areas require full B-tree parsing (not yet implemented)")
-
-	// TODO: Implement full B-tree traversal:
-	// 1. Parse btree_node_phys_t header
-	// 2. Read table of contents (kvloc_t or kvoff_t based on BTNODE_FIXED_KV_SIZE flag)
-	// 3. For each entry:
-	//    - Read key (uint64_t logical offset)
-	//    - Read value (prange_t: start address + block count)
-	// 4. If non-leaf node (btn_level > 0), recursively traverse children
-	// 5. Collect all ranges and return in sorted order
-}
\ No newline at end of file
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// objectType returns the type of the object stored in block, without flags
+func objectType(block []byte) uint32 {
+	if len(block) < 32 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(block[24:28]) & types.ObjectTypeMask
+}
+
+// verifyObject decodes the obj_phys_t header of an object and checks its
+// Fletcher-64 checksum
+func verifyObject(data []byte) (*types.ObjPhysT, error) {
+	if len(data) < 32 {
+		return nil, fmt.Errorf("object is too small: %d bytes", len(data))
+	}
+	header := &types.ObjPhysT{
+		OOid:     types.OidT(binary.LittleEndian.Uint64(data[8:16])),
+		OXid:     types.XidT(binary.LittleEndian.Uint64(data[16:24])),
+		OType:    binary.LittleEndian.Uint32(data[24:28]),
+		OSubtype: binary.LittleEndian.Uint32(data[28:32]),
+	}
+	copy(header.OChecksum[:], data[0:8])
+
+	if !objects.NewChecksumInspector(header, data).VerifyChecksum() {
+		return nil, fmt.Errorf("object %d has an invalid checksum", header.OOid)
+	}
+	return header, nil
+}
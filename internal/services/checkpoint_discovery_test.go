@@ -1,10 +1,16 @@
 package services
 
 import (
+	"bytes"
+	"encoding/binary"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/deploymenttheory/go-apfs/internal/disk"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCheckpointDiscovery(t *testing.T) {
@@ -113,3 +119,229 @@ func TestCheckpointDiscovery(t *testing.T) {
 		}
 	}
 }
+
+// Checkpoint areas of the images built by writeCheckpoint
+const (
+	testDescBase   = 1
+	testDescBlocks = 8
+	testDataBase   = 9
+	testDataBlocks = 16
+
+	testSpacemanOID = 0x400
+	testReaperOID   = 0x401
+)
+
+// testCheckpoint describes a checkpoint written by writeCheckpoint
+type testCheckpoint struct {
+	xid        uint64
+	descIndex  uint32   // descriptor area index of the first checkpoint map
+	maps       int      // number of checkpoint-mapping blocks
+	dataIndex  uint32   // data area index of the space manager; the reaper follows
+	volumeOIDs []uint64 // volumes listed by the superblock
+}
+
+// writeCheckpoint writes the checkpoint maps, ephemeral objects and superblock
+// of a checkpoint into the descriptor and data areas, returning the superblock
+func (img *testImage) writeCheckpoint(cp testCheckpoint) []byte {
+	descAddr := func(i uint32) uint64 { return testDescBase + uint64(i%testDescBlocks) }
+	spaceman := testDataBase + uint64(cp.dataIndex)
+
+	// One ephemeral object per checkpoint map, the last map flagged as such
+	objects := []struct {
+		oid     uint64
+		objType uint32
+	}{{testSpacemanOID, types.ObjectTypeSpaceman}, {testReaperOID, types.ObjectTypeNxReaper}}
+	for i := 0; i < cp.maps; i++ {
+		block := make([]byte, testBlockSize)
+		putObjectHeader(block, 0, cp.xid, types.ObjectTypeCheckpointMap|types.ObjPhysical, 0)
+		if i == cp.maps-1 {
+			binary.LittleEndian.PutUint32(block[32:], types.CheckpointMapLast)
+		}
+		for j := i; j < len(objects); j += cp.maps {
+			m := block[40+40*(j/cp.maps):]
+			binary.LittleEndian.PutUint32(m[0:], objects[j].objType|types.ObjEphemeral)
+			binary.LittleEndian.PutUint32(m[8:], testBlockSize)
+			binary.LittleEndian.PutUint64(m[24:], objects[j].oid)
+			binary.LittleEndian.PutUint64(m[32:], spaceman+uint64(j))
+			binary.LittleEndian.PutUint32(block[36:], binary.LittleEndian.Uint32(block[36:])+1)
+
+			obj := make([]byte, testBlockSize)
+			putObjectHeader(obj, objects[j].oid, cp.xid, objects[j].objType|types.ObjEphemeral, 0)
+			img.putObject(spaceman+uint64(j), obj)
+		}
+		img.putObject(descAddr(cp.descIndex+uint32(i)), block)
+	}
+
+	sb := img.buildContainerSuperblock(cp.xid+1, 100, cp.volumeOIDs...)
+	binary.LittleEndian.PutUint32(sb[104:], testDescBlocks)
+	binary.LittleEndian.PutUint32(sb[108:], testDataBlocks)
+	binary.LittleEndian.PutUint64(sb[112:], testDescBase)
+	binary.LittleEndian.PutUint64(sb[120:], testDataBase)
+	binary.LittleEndian.PutUint32(sb[136:], cp.descIndex)
+	binary.LittleEndian.PutUint32(sb[140:], uint32(cp.maps+1))
+	binary.LittleEndian.PutUint64(sb[152:], testSpacemanOID)
+	binary.LittleEndian.PutUint64(sb[168:], testReaperOID)
+	putObjectHeader(sb, 1, cp.xid, types.ObjectTypeNxSuperblock|types.ObjEphemeral, 0)
+	img.putObject(descAddr(cp.descIndex+uint32(cp.maps)), sb)
+	return sb
+}
+
+// checkpointImage builds an image holding three checkpoints whose stale
+// first checkpoint is also copied to block zero. The newest checkpoint wraps
+// around the end of the descriptor area and overwrites the oldest one, which
+// survives only in block zero.
+func checkpointImage() *testImage {
+	img := newTestImage(32)
+	img.putObject(0, img.writeCheckpoint(testCheckpoint{xid: 10, descIndex: 0, maps: 1, dataIndex: 0, volumeOIDs: []uint64{1026}}))
+	img.writeCheckpoint(testCheckpoint{xid: 11, descIndex: 2, maps: 1, dataIndex: 2, volumeOIDs: []uint64{1026, 1027}})
+	img.writeCheckpoint(testCheckpoint{xid: 12, descIndex: 7, maps: 2, dataIndex: 4, volumeOIDs: []uint64{1026, 1027, 1028}})
+	return img
+}
+
+func TestCheckpointMount(t *testing.T) {
+	cr := checkpointImage().containerReader(t)
+
+	assert.Equal(t, types.XidT(10), cr.GetBlockZeroSuperblock().NxO.OXid)
+	require.NotNil(t, cr.GetCheckpoint())
+	assert.Equal(t, types.XidT(12), cr.GetCheckpoint().TransactionID)
+	assert.NoError(t, cr.MountError())
+	assert.Equal(t, uint64(testDescBase+1), cr.GetCheckpoint().BlockAddress)
+	assert.Equal(t, types.XidT(13), cr.GetSuperblock().NxNextXid)
+	assert.Equal(t, types.OidT(1028), cr.GetSuperblock().NxFsOid[2])
+
+	objs := cr.GetEphemeralObjects()
+	require.Len(t, objs, 2)
+	assert.Equal(t, types.OidT(testSpacemanOID), objs[0].OID)
+	assert.Equal(t, types.Paddr(testDataBase+4), objs[0].Address)
+	assert.Equal(t, types.ObjectTypeSpaceman|types.ObjEphemeral, objs[0].Type)
+	assert.Len(t, objs[0].Data, testBlockSize)
+
+	reaper, err := cr.GetEphemeralObject(testReaperOID)
+	require.NoError(t, err)
+	assert.Equal(t, types.Paddr(testDataBase+5), reaper.Address)
+	_, err = cr.GetEphemeralObject(0x999)
+	assert.Error(t, err)
+
+	// Ephemeral objects are served from memory by the object locator
+	obj, err := NewObjectLocatorService(cr).FindObjectByID(testReaperOID)
+	require.NoError(t, err)
+	assert.Equal(t, reaper.Data, obj)
+}
+
+func TestCheckpointCandidates(t *testing.T) {
+	cr := checkpointImage().containerReader(t)
+
+	candidates, err := NewCheckpointDiscoveryService(cr).GetCandidates()
+	require.NoError(t, err)
+
+	var xids []types.XidT
+	var valid []bool
+	for _, c := range candidates {
+		xids = append(xids, c.TransactionID)
+		valid = append(valid, c.IsValid)
+	}
+	// The block zero copy of the oldest checkpoint lost its map to the newest
+	assert.Equal(t, []types.XidT{12, 11, 10}, xids)
+	assert.Equal(t, []bool{true, true, false}, valid)
+	assert.Equal(t, uint64(0), candidates[2].BlockAddress)
+	assert.Contains(t, candidates[2].ErrorMsg, "not a checkpoint map")
+	assert.Len(t, candidates[0].Mappings, 2)
+}
+
+func TestCheckpointMountFallsBack(t *testing.T) {
+	img := checkpointImage()
+
+	// A torn write of the newest checkpoint's space manager
+	img.blocks[testDataBase+4][100] ^= 0xff
+	cr := img.containerReader(t)
+	assert.Equal(t, types.XidT(11), cr.GetCheckpoint().TransactionID)
+	assert.Equal(t, types.OidT(0), cr.GetSuperblock().NxFsOid[2])
+	eph, err := cr.GetEphemeralObject(testSpacemanOID)
+	require.NoError(t, err)
+	assert.Equal(t, types.Paddr(testDataBase+2), eph.Address)
+	assert.NoError(t, cr.MountError())
+
+	// When every checkpoint's ephemeral objects are damaged the reason is kept
+	img.blocks[testDataBase+2][100] ^= 0xff
+	cr = img.containerReader(t)
+	assert.Nil(t, cr.GetCheckpoint())
+	assert.Equal(t, types.XidT(10), cr.GetSuperblock().NxO.OXid)
+	require.Error(t, cr.MountError())
+	assert.Contains(t, cr.MountError().Error(), "checkpoint 12:")
+	assert.Contains(t, cr.MountError().Error(), "checkpoint 11:")
+
+	// Without any valid checkpoint the block zero superblock stays in use
+	img = newTestImage(8)
+	img.writeContainerSuperblock(5, 100, 1026)
+	img.blocks[0][200] ^= 0xff
+	cr = img.containerReader(t)
+	assert.Nil(t, cr.GetCheckpoint())
+	assert.Error(t, cr.MountError())
+	assert.Equal(t, types.XidT(5), cr.GetSuperblock().NxNextXid)
+	assert.Empty(t, cr.GetEphemeralObjects())
+}
+
+func TestCheckpointTimeTravel(t *testing.T) {
+	cr := checkpointImage().containerReader(t)
+
+	checkpoints, err := NewCheckpointDiscoveryService(cr).ListCheckpoints()
+	require.NoError(t, err)
+	require.Len(t, checkpoints, 2)
+	assert.Equal(t, types.XidT(12), checkpoints[0].TransactionID)
+	assert.True(t, checkpoints[0].Mounted)
+	assert.Equal(t, 3, checkpoints[0].VolumeCount)
+	assert.Equal(t, types.XidT(11), checkpoints[1].TransactionID)
+	assert.False(t, checkpoints[1].Mounted)
+	assert.Equal(t, 2, checkpoints[1].VolumeCount)
+	assert.Equal(t, uint64(testDescBase+3), checkpoints[1].BlockAddress)
+
+	pinned, err := cr.AtCheckpoint(11)
+	require.NoError(t, err)
+	defer pinned.Close()
+	assert.Equal(t, types.XidT(12), pinned.GetSuperblock().NxNextXid)
+	assert.Equal(t, types.OidT(1027), pinned.GetSuperblock().NxFsOid[1])
+	assert.Equal(t, types.OidT(0), pinned.GetSuperblock().NxFsOid[2])
+	eph, err := pinned.GetEphemeralObject(testSpacemanOID)
+	require.NoError(t, err)
+	assert.Equal(t, types.Paddr(testDataBase+2), eph.Address)
+
+	// The mounted reader is unaffected
+	assert.Equal(t, types.XidT(13), cr.GetSuperblock().NxNextXid)
+
+	_, err = cr.AtCheckpoint(10)
+	assert.ErrorContains(t, err, "checkpoint 10 is not valid")
+	_, err = cr.AtCheckpoint(99)
+	assert.ErrorContains(t, err, "checkpoint 99 not found")
+}
+
+func TestCheckpointPinnedReaderSharesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "container.img")
+	require.NoError(t, os.WriteFile(path, bytes.Join(checkpointImage().blocks, nil), 0o644))
+
+	for _, parentFirst := range []bool{true, false} {
+		cr, err := NewContainerReader(path)
+		require.NoError(t, err)
+		pinned, err := cr.AtCheckpoint(11)
+		require.NoError(t, err)
+
+		first, second := cr, pinned
+		if !parentFirst {
+			first, second = pinned, cr
+		}
+
+		// The file stays open for the reader that is still in use
+		require.NoError(t, first.Close())
+		first.ClearCache()
+		_, err = first.ReadBlock(1)
+		assert.Error(t, err)
+		second.ClearCache()
+		_, err = second.ReadBlock(1)
+		require.NoError(t, err)
+
+		file := second.file.File
+		require.NoError(t, second.Close())
+		_, err = file.Stat()
+		assert.ErrorIs(t, err, os.ErrClosed)
+		assert.NoError(t, second.Close())
+	}
+}
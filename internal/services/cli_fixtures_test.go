@@ -0,0 +1,56 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateFixtures = flag.Bool("update-fixtures", false, "rewrite the images opened by the afps command tests")
+
+// cliFixtureDir holds the images opened by the afps command tests, which
+// cannot reach the test image builder of this package
+const cliFixtureDir = "../../cmd/afps/testdata"
+
+// TestCLIFixtures checks that the afps command test images match the
+// builders they were written from. Run it with -update-fixtures after
+// changing a builder.
+func TestCLIFixtures(t *testing.T) {
+	fixtures := map[string]*testImage{
+		"volume.img.gz":    newFileSystemImage(),
+		"encrypted.img.gz": newEncryptedImage(t, bytes.Repeat([]byte{0x11, 0x22, 0x33, 0x44}, 8), "hunter2", []byte("the quick brown fox jumps over the lazy dog")),
+	}
+
+	for name, img := range fixtures {
+		data := bytes.Join(img.blocks, nil)
+		path := filepath.Join(cliFixtureDir, name)
+
+		if *updateFixtures {
+			var buf bytes.Buffer
+			zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			require.NoError(t, err)
+			_, err = zw.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, zw.Close())
+			require.NoError(t, os.MkdirAll(cliFixtureDir, 0o755))
+			require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+			continue
+		}
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		zr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		want, err := io.ReadAll(zr)
+		f.Close()
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(want, data), "%s is stale, rerun with -update-fixtures", name)
+	}
+}
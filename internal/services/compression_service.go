@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"hash/adler32"
 
+	"github.com/deploymenttheory/go-apfs/internal/compression"
 	"github.com/deploymenttheory/go-apfs/internal/types"
 )
 
@@ -24,13 +25,15 @@ func (cs *CompressionService) Decompress(compressedData []byte, method types.Com
 	case types.CompressionMethodDeflate:
 		return cs.DecompressDeflate(compressedData)
 	case types.CompressionMethodLzfse:
-		return nil, fmt.Errorf("lzfse decompression not yet implemented")
+		return cs.DecompressLZFSE(compressedData, 0)
 	case types.CompressionMethodLzvn:
-		return nil, fmt.Errorf("lzvn decompression not yet implemented")
+		return cs.DecompressLZVN(compressedData, 0)
 	case types.CompressionMethodLz4:
-		return nil, fmt.Errorf("lz4 decompression requires external package")
+		return cs.DecompressLZ4(compressedData, 0)
 	case types.CompressionMethodZstd:
 		return nil, fmt.Errorf("zstandard decompression requires external package")
+	case types.CompressionMethodLzbitmap:
+		return cs.DecompressLZBitmap(compressedData, 0)
 	default:
 		return nil, fmt.Errorf("unknown compression method: %d", method)
 	}
@@ -54,6 +57,46 @@ func (cs *CompressionService) DecompressDeflate(compressedData []byte) ([]byte,
 	return result.Bytes(), nil
 }
 
+// DecompressLZVN decompresses an LZVN stream. uncompressedSize, when known,
+// sizes the output buffer up front.
+func (cs *CompressionService) DecompressLZVN(compressedData []byte, uncompressedSize int) ([]byte, error) {
+	decompressed, err := compression.DecodeLZVN(make([]byte, 0, uncompressedSize), compressedData)
+	if err != nil {
+		return nil, fmt.Errorf("lzvn decompression failed: %w", err)
+	}
+	return decompressed, nil
+}
+
+// DecompressLZFSE decompresses an LZFSE stream. uncompressedSize, when known,
+// sizes the output buffer up front.
+func (cs *CompressionService) DecompressLZFSE(compressedData []byte, uncompressedSize int) ([]byte, error) {
+	decompressed, err := compression.DecodeLZFSE(make([]byte, 0, uncompressedSize), compressedData)
+	if err != nil {
+		return nil, fmt.Errorf("lzfse decompression failed: %w", err)
+	}
+	return decompressed, nil
+}
+
+// DecompressLZ4 decompresses an LZ4 stream in Apple's bv41/bv4- block
+// framing. uncompressedSize, when known, sizes the output buffer up front.
+func (cs *CompressionService) DecompressLZ4(compressedData []byte, uncompressedSize int) ([]byte, error) {
+	decompressed, err := compression.DecodeLZ4(make([]byte, 0, uncompressedSize), compressedData)
+	if err != nil {
+		return nil, fmt.Errorf("lz4 decompression failed: %w", err)
+	}
+	return decompressed, nil
+}
+
+// DecompressLZBitmap decompresses an LZBITMAP stream. uncompressedSize, when
+// known, sizes the output buffer up front.
+func (cs *CompressionService) DecompressLZBitmap(compressedData []byte, uncompressedSize int) ([]byte, error) {
+	decompressed, err := compression.DecodeLZBitmap(make([]byte, 0, uncompressedSize), compressedData)
+	if err != nil {
+		return nil, fmt.Errorf("lzbitmap decompression failed: %w", err)
+	}
+	return decompressed, nil
+}
+
 // DecompressDeflateZlib decompresses zlib-wrapped DEFLATE data (RFC 1950)
 func (cs *CompressionService) DecompressDeflateZlib(compressedData []byte) ([]byte, error) {
 	if len(compressedData) < 2 {
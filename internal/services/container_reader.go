@@ -2,9 +2,11 @@ package services
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"sync"
 
 	"github.com/deploymenttheory/go-apfs/internal/parsers/container"
@@ -13,7 +15,7 @@ import (
 
 // ContainerReader provides low-level access to container data
 type ContainerReader struct {
-	file             *os.File
+	file             *sharedFile
 	device           io.ReaderAt // Alternative to file for devices
 	superblock       *types.NxSuperblockT
 	blockSize        uint32
@@ -23,6 +25,18 @@ type ContainerReader struct {
 	blockCache       map[uint64][]byte
 	maxCacheSize     int
 	currentCacheSize int
+
+	// The fields below are set before the reader is returned and never
+	// change afterwards, so they are read without holding mu.
+
+	// blockZero is the superblock copy at block zero, which may be stale
+	blockZero *types.NxSuperblockT
+	// checkpoint is the mounted checkpoint, nil when block zero is used
+	checkpoint *CheckpointCandidate
+	// mountErr records why no checkpoint could be mounted
+	mountErr error
+	// ephemeral holds the ephemeral objects of the mounted checkpoint
+	ephemeral map[types.OidT]*EphemeralObject
 }
 
 // NewContainerReader opens a container file and reads its superblock
@@ -74,7 +88,7 @@ func NewContainerReader(filePath string) (*ContainerReader, error) {
 	}
 
 	cr := &ContainerReader{
-		file:          file,
+		file:          &sharedFile{File: file, refs: 1},
 		superblock:    sbReader.(*container.ContainerSuperblockReader).Superblock,
 		blockSize:     blockSize,
 		containerSize: containerSize,
@@ -82,6 +96,7 @@ func NewContainerReader(filePath string) (*ContainerReader, error) {
 		blockCache:    make(map[uint64][]byte),
 		maxCacheSize:  50 * 1024 * 1024, // 50MB cache
 	}
+	cr.mount()
 
 	return cr, nil
 }
@@ -105,20 +120,9 @@ func NewContainerReaderFromDevice(device io.ReaderAt, size uint64) (*ContainerRe
 		return nil, fmt.Errorf("failed to parse superblock: %w", err)
 	}
 
-	// Build the superblock struct manually since we need the actual types.NxSuperblockT
-	superblock := &types.NxSuperblockT{
-		NxMagic:       sbParser.Magic(),
-		NxBlockSize:   sbParser.BlockSize(),
-		NxBlockCount:  sbParser.BlockCount(),
-		NxNextOid:     sbParser.NextObjectID(),
-		NxNextXid:     sbParser.NextTransactionID(),
-		NxSpacemanOid: sbParser.SpaceManagerOID(),
-		NxOmapOid:     sbParser.ObjectMapOID(),
-	}
-
-	// Copy volume OIDs
-	for i := 0; i < 100 && i < len(sbParser.VolumeOIDs()); i++ {
-		superblock.NxFsOid[i] = sbParser.VolumeOIDs()[i]
+	superblock := sbParser.(*container.ContainerSuperblockReader).Superblock
+	if superblock.NxBlockSize == 0 {
+		return nil, fmt.Errorf("invalid block size: 0")
 	}
 
 	cr := &ContainerReader{
@@ -131,10 +135,105 @@ func NewContainerReaderFromDevice(device io.ReaderAt, size uint64) (*ContainerRe
 		maxCacheSize:     100,
 		currentCacheSize: 0,
 	}
+	cr.mount()
 
 	return cr, nil
 }
 
+// mount switches the reader from the block zero superblock to the newest
+// valid checkpoint and loads its ephemeral objects. A checkpoint whose
+// ephemeral objects cannot be read is skipped for an older one. When no
+// checkpoint can be mounted the block zero superblock stays in use and the
+// reason is kept for MountError.
+func (cr *ContainerReader) mount() {
+	cr.blockZero = cr.superblock
+
+	cds := NewCheckpointDiscoveryService(cr)
+	candidates, err := cds.GetCandidates()
+	if err != nil {
+		cr.mountErr = fmt.Errorf("failed to discover checkpoints: %w", err)
+		return
+	}
+
+	var errs []error
+	for _, candidate := range candidates {
+		if !candidate.IsValid {
+			errs = append(errs, fmt.Errorf("checkpoint %d: %s", candidate.TransactionID, candidate.ErrorMsg))
+			continue
+		}
+		ephemeral, err := cds.LoadEphemeralObjects(candidate)
+		if err != nil {
+			candidate.IsValid = false
+			candidate.ErrorMsg = err.Error()
+			errs = append(errs, fmt.Errorf("checkpoint %d: %w", candidate.TransactionID, err))
+			continue
+		}
+		cr.superblock = candidate.Superblock
+		cr.checkpoint = candidate
+		cr.ephemeral = ephemeral
+		return
+	}
+
+	if len(errs) == 0 {
+		cr.mountErr = fmt.Errorf("no checkpoint found")
+		return
+	}
+	cr.mountErr = fmt.Errorf("no valid checkpoint found: %w", errors.Join(errs...))
+}
+
+// AtCheckpoint returns a reader of the same device pinned at the older
+// checkpoint with transaction identifier xid. Volume and file system
+// services created from it see the container as it was at that checkpoint.
+// A container file opened by NewContainerReader stays open until both
+// readers are closed, in any order. A device passed to
+// NewContainerReaderFromDevice is never closed by either reader.
+func (cr *ContainerReader) AtCheckpoint(xid types.XidT) (*ContainerReader, error) {
+	cds := NewCheckpointDiscoveryService(cr)
+	candidates, err := cds.GetCandidates()
+	if err != nil {
+		return nil, err
+	}
+
+	err = fmt.Errorf("checkpoint %d not found", xid)
+	for _, candidate := range candidates {
+		if candidate.TransactionID != xid || candidate.Superblock == nil {
+			continue
+		}
+		if !candidate.IsValid {
+			err = fmt.Errorf("checkpoint %d is not valid: %s", xid, candidate.ErrorMsg)
+			continue
+		}
+		return cr.pin(cds, candidate)
+	}
+	return nil, err
+}
+
+// pin returns a reader sharing the device of cr that is mounted at candidate
+func (cr *ContainerReader) pin(cds *CheckpointDiscoveryService, candidate *CheckpointCandidate) (*ContainerReader, error) {
+	ephemeral, err := cds.LoadEphemeralObjects(candidate)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint %d: %w", candidate.TransactionID, err)
+	}
+
+	var file *sharedFile
+	if cr.file != nil {
+		file = cr.file.acquire()
+	}
+	return &ContainerReader{
+		file:          file,
+		device:        cr.device,
+		superblock:    candidate.Superblock,
+		blockSize:     cr.blockSize,
+		containerSize: cr.containerSize,
+		endianness:    cr.endianness,
+		blockCache:    make(map[uint64][]byte),
+		maxCacheSize:  cr.maxCacheSize,
+		blockZero:     cr.blockZero,
+		checkpoint:    candidate,
+		ephemeral:     ephemeral,
+	}, nil
+}
+
 // ReadBlock reads a single block from the container
 func (cr *ContainerReader) ReadBlock(blockNumber uint64) ([]byte, error) {
 	cr.mu.RLock()
@@ -232,6 +331,44 @@ func (cr *ContainerReader) GetSuperblock() *types.NxSuperblockT {
 	return cr.superblock
 }
 
+// GetBlockZeroSuperblock returns the superblock copy stored at block zero,
+// which is stale when the container was not unmounted cleanly
+func (cr *ContainerReader) GetBlockZeroSuperblock() *types.NxSuperblockT {
+	return cr.blockZero
+}
+
+// GetCheckpoint returns the checkpoint the container was mounted from, or nil
+// when no valid checkpoint was found and the block zero superblock is used
+func (cr *ContainerReader) GetCheckpoint() *CheckpointCandidate {
+	return cr.checkpoint
+}
+
+// MountError reports why the container could not be mounted at a checkpoint,
+// or nil when GetCheckpoint returns the mounted checkpoint
+func (cr *ContainerReader) MountError() error {
+	return cr.mountErr
+}
+
+// GetEphemeralObject returns an ephemeral object of the mounted checkpoint
+func (cr *ContainerReader) GetEphemeralObject(oid types.OidT) (*EphemeralObject, error) {
+	obj, ok := cr.ephemeral[oid]
+	if !ok {
+		return nil, fmt.Errorf("ephemeral object %d not found in checkpoint", oid)
+	}
+	return obj, nil
+}
+
+// GetEphemeralObjects returns the ephemeral objects of the mounted
+// checkpoint ordered by identifier
+func (cr *ContainerReader) GetEphemeralObjects() []*EphemeralObject {
+	objs := make([]*EphemeralObject, 0, len(cr.ephemeral))
+	for _, obj := range cr.ephemeral {
+		objs = append(objs, obj)
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].OID < objs[j].OID })
+	return objs
+}
+
 // SeekToBlock positions for reading at a specific block
 func (cr *ContainerReader) SeekToBlock(blockNumber uint64) error {
 	offset := int64(blockNumber) * int64(cr.blockSize)
@@ -277,15 +414,48 @@ func (cr *ContainerReader) IsCached(blockNumber uint64) bool {
 	return exists
 }
 
-// Close closes the container file or device
+// Close releases the container file, which is closed once every reader
+// sharing it is closed. Device-based readers leave the device open, as they
+// do not own it.
 func (cr *ContainerReader) Close() error {
-	if cr.file != nil {
-		return cr.file.Close()
+	cr.mu.Lock()
+	file := cr.file
+	cr.file = nil
+	cr.mu.Unlock()
+
+	if file != nil {
+		return file.release()
 	}
-	// Device-based readers don't need explicit closing since we don't own the device
 	return nil
 }
 
+// sharedFile is a container file shared by a reader and the readers pinned
+// at its checkpoints
+type sharedFile struct {
+	*os.File
+	mu   sync.Mutex
+	refs int
+}
+
+// acquire adds a reader of the file
+func (f *sharedFile) acquire() *sharedFile {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refs++
+	return f
+}
+
+// release removes a reader of the file, closing it after the last one
+func (f *sharedFile) release() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refs--
+	if f.refs > 0 {
+		return nil
+	}
+	return f.File.Close()
+}
+
 // GetEndianness returns the endianness used in the container
 func (cr *ContainerReader) GetEndianness() binary.ByteOrder {
 	return cr.endianness
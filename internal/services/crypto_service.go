@@ -1,10 +1,27 @@
 package services
 
 import (
-	"crypto/hmac"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/deploymenttheory/go-apfs/internal/kdf"
 )
 
+// ErrKeyUnwrap is returned when a wrapped key fails its integrity check,
+// which usually means the wrapping key or password is wrong
+var ErrKeyUnwrap = errors.New("key unwrap integrity check failed")
+
+// keyWrapIV is the default initial value of RFC 3394 key wrapping
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// xtsUnitSize is the size of the data unit APFS encrypts with a single tweak
+const xtsUnitSize = 512
+
 // CryptoService provides cryptographic utilities for APFS
 type CryptoService struct{}
 
@@ -16,60 +33,101 @@ func NewCryptoService() *CryptoService {
 // Pbkdf2 derives a key from a password using PBKDF2 with SHA-256
 // This matches the libfsapfs implementation for APFS encryption key derivation
 func (cs *CryptoService) Pbkdf2(password, salt []byte, iterations int, keyLen int) []byte {
-	return cs.pbkdf2(password, salt, iterations, keyLen)
+	return kdf.PBKDF2(sha256.New, password, salt, iterations, keyLen)
 }
 
-// pbkdf2 implements PBKDF2-HMAC-SHA256 key derivation per RFC 2898
-func (cs *CryptoService) pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
-	result := make([]byte, keyLen)
-	hashLen := sha256.Size
-	blockCount := (keyLen + hashLen - 1) / hashLen
-
-	for block := 1; block <= blockCount; block++ {
-		blockStart := (block - 1) * hashLen
-		blockEnd := blockStart + hashLen
-		if blockEnd > keyLen {
-			blockEnd = keyLen
+// UnwrapKey unwraps a key wrapped with the AES key wrap algorithm of RFC 3394
+// under kek, a 128, 192 or 256 bit AES key
+func (cs *CryptoService) UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, fmt.Errorf("wrapped key of %d bytes is not a multiple of 8 bytes of at least 24", len(wrapped))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encryption key: %w", err)
+	}
+
+	n := len(wrapped)/8 - 1
+	a := binary.BigEndian.Uint64(wrapped[:8])
+	r := append([]byte(nil), wrapped[8:]...)
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(b[:8], a^uint64(n*j+i))
+			copy(b[8:], r[(i-1)*8:i*8])
+			block.Decrypt(b[:], b[:])
+			a = binary.BigEndian.Uint64(b[:8])
+			copy(r[(i-1)*8:i*8], b[8:])
 		}
+	}
 
-		blockSize := blockEnd - blockStart
-		hash := cs.pbkdf2Block(password, salt, iterations, block)
-		copy(result[blockStart:blockEnd], hash[:blockSize])
+	if !bytes.Equal(binary.BigEndian.AppendUint64(nil, a), keyWrapIV) {
+		return nil, ErrKeyUnwrap
 	}
+	return r, nil
+}
 
-	return result
+// xtsCipher decrypts data encrypted with AES in XTS mode as APFS uses it:
+// data units of 512 bytes whose tweak is the unit's number, so full-disk
+// encrypted metadata uses its byte offset on disk divided by 512
+type xtsCipher struct {
+	data, tweak cipher.Block
 }
 
-// pbkdf2Block computes a single PBKDF2 block
-func (cs *CryptoService) pbkdf2Block(password, salt []byte, iterations, block int) [sha256.Size]byte {
-	h := hmac.New(sha256.New, password)
-	h.Write(salt)
-
-	// Write block number (4 bytes, big-endian)
-	blockBytes := [4]byte{
-		byte(block >> 24),
-		byte(block >> 16),
-		byte(block >> 8),
-		byte(block),
+// newXTSCipher returns an XTS cipher for key, the data key followed by the
+// tweak key
+func newXTSCipher(key []byte) (*xtsCipher, error) {
+	if len(key) != 32 && len(key) != 64 {
+		return nil, fmt.Errorf("invalid XTS key length: expected 32 or 64 bytes, got %d", len(key))
 	}
-	h.Write(blockBytes[:])
+	data, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	tweak, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &xtsCipher{data: data, tweak: tweak}, nil
+}
 
-	var u [sha256.Size]byte
-	h.Sum(u[:0])
+// decrypt decrypts data in place as consecutive 512 byte units, the first of
+// which has the given unit number
+func (x *xtsCipher) decrypt(data []byte, unit uint64) error {
+	if len(data)%xtsUnitSize != 0 {
+		return fmt.Errorf("XTS data of %d bytes is not a multiple of %d bytes", len(data), xtsUnitSize)
+	}
+	for off := 0; off < len(data); off += xtsUnitSize {
+		x.decryptUnit(data[off:off+xtsUnitSize], unit)
+		unit++
+	}
+	return nil
+}
 
-	result := u
+// decryptUnit decrypts one data unit, a multiple of the AES block size, in place
+func (x *xtsCipher) decryptUnit(data []byte, unit uint64) {
+	var t [aes.BlockSize]byte
+	binary.LittleEndian.PutUint64(t[:8], unit)
+	x.tweak.Encrypt(t[:], t[:])
 
-	for i := 1; i < iterations; i++ {
-		h = hmac.New(sha256.New, password)
-		h.Write(u[:])
-		var uNext [sha256.Size]byte
-		h.Sum(uNext[:0])
+	for off := 0; off+aes.BlockSize <= len(data); off += aes.BlockSize {
+		b := data[off : off+aes.BlockSize]
+		xorBlock(b, t[:])
+		x.data.Decrypt(b, b)
+		xorBlock(b, t[:])
 
-		for j := 0; j < sha256.Size; j++ {
-			result[j] ^= uNext[j]
+		// Multiply the tweak by x in GF(2^128)
+		carry := t[15] >> 7
+		for i := 15; i > 0; i-- {
+			t[i] = t[i]<<1 | t[i-1]>>7
 		}
-		u = uNext
+		t[0] = t[0]<<1 ^ 0x87*carry
 	}
+}
 
-	return result
+// xorBlock xors t into b
+func xorBlock(b, t []byte) {
+	for i := range b {
+		b[i] ^= t[i]
+	}
 }
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// xtsEncrypt encrypts data in place as consecutive 512 byte units, the
+// inverse of xtsCipher.decrypt
+func xtsEncrypt(t *testing.T, key, data []byte, unit uint64) {
+	t.Helper()
+	x, err := newXTSCipher(key)
+	require.NoError(t, err)
+	for off := 0; off < len(data); off += xtsUnitSize {
+		var tweak [aes.BlockSize]byte
+		binary.LittleEndian.PutUint64(tweak[:8], unit)
+		x.tweak.Encrypt(tweak[:], tweak[:])
+		for b := data[off : off+xtsUnitSize]; len(b) > 0; b = b[aes.BlockSize:] {
+			xorBlock(b[:aes.BlockSize], tweak[:])
+			x.data.Encrypt(b[:aes.BlockSize], b[:aes.BlockSize])
+			xorBlock(b[:aes.BlockSize], tweak[:])
+			carry := tweak[15] >> 7
+			for i := 15; i > 0; i-- {
+				tweak[i] = tweak[i]<<1 | tweak[i-1]>>7
+			}
+			tweak[0] = tweak[0]<<1 ^ 0x87*carry
+		}
+		unit++
+	}
+}
+
+// wrapKey wraps key under kek with the AES key wrap algorithm of RFC 3394
+func wrapKey(t *testing.T, kek, key []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(kek)
+	require.NoError(t, err)
+
+	n := len(key) / 8
+	a := binary.BigEndian.Uint64(keyWrapIV)
+	r := append([]byte(nil), key...)
+	var b [16]byte
+	for j := 0; j <= 5; j++ {
+		for i := 1; i <= n; i++ {
+			binary.BigEndian.PutUint64(b[:8], a)
+			copy(b[8:], r[(i-1)*8:i*8])
+			block.Encrypt(b[:], b[:])
+			a = binary.BigEndian.Uint64(b[:8]) ^ uint64(n*j+i)
+			copy(r[(i-1)*8:i*8], b[8:])
+		}
+	}
+	return append(binary.BigEndian.AppendUint64(nil, a), r...)
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestXTSCipherDecrypt(t *testing.T) {
+	// IEEE 1619 test vector 1
+	x, err := newXTSCipher(make([]byte, 32))
+	require.NoError(t, err)
+	data := mustHex(t, "917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e")
+	x.decryptUnit(data, 0)
+	assert.Equal(t, make([]byte, 32), data)
+
+	key := mustHex(t, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f")
+	plain := make([]byte, 2*xtsUnitSize)
+	for i := range plain {
+		plain[i] = byte(i * 7)
+	}
+	data = append([]byte(nil), plain...)
+	xtsEncrypt(t, key, data, 1234)
+	assert.NotEqual(t, plain, data)
+
+	x, err = newXTSCipher(key)
+	require.NoError(t, err)
+	require.NoError(t, x.decrypt(data, 1234))
+	assert.Equal(t, plain, data)
+
+	assert.Error(t, x.decrypt(make([]byte, 100), 0))
+	_, err = newXTSCipher(make([]byte, 16))
+	assert.Error(t, err)
+}
+
+func TestCryptoServiceUnwrapKey(t *testing.T) {
+	cs := NewCryptoService()
+	tests := []struct {
+		name    string
+		kek     string
+		key     string
+		wrapped string
+	}{
+		{
+			name:    "128-bit key with 128-bit KEK",
+			kek:     "000102030405060708090a0b0c0d0e0f",
+			key:     "00112233445566778899aabbccddeeff",
+			wrapped: "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5",
+		},
+		{
+			name:    "256-bit key with 256-bit KEK",
+			kek:     "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			key:     "00112233445566778899aabbccddeeff000102030405060708090a0b0c0d0e0f",
+			wrapped: "28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326cbc7f0e71a99f43bfb988b9b7a02dd21",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kek, key := mustHex(t, tt.kek), mustHex(t, tt.key)
+			assert.Equal(t, mustHex(t, tt.wrapped), wrapKey(t, kek, key))
+
+			unwrapped, err := cs.UnwrapKey(kek, mustHex(t, tt.wrapped))
+			require.NoError(t, err)
+			assert.Equal(t, key, unwrapped)
+
+			kek[0] ^= 1
+			_, err = cs.UnwrapKey(kek, mustHex(t, tt.wrapped))
+			assert.ErrorIs(t, err, ErrKeyUnwrap)
+		})
+	}
+
+	_, err := cs.UnwrapKey(make([]byte, 16), make([]byte, 20))
+	assert.Error(t, err)
+}
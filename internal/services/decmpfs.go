@@ -0,0 +1,347 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/deploymenttheory/go-apfs/internal/interfaces"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// DecmpfsXattrName is the extended attribute holding the header of a file
+// compressed by the file system, and its data when small enough
+const DecmpfsXattrName = "com.apple.decmpfs"
+
+// decmpfsHeaderSize is the size of the decmpfs header: the "fpmc" magic, the
+// compression type and the uncompressed size
+const decmpfsHeaderSize = 16
+
+// decmpfsChunkSize is the uncompressed size of each chunk of a compressed
+// resource fork, except the last
+const decmpfsChunkSize = 64 * 1024
+
+// decmpfsType describes a decmpfs compression type: the algorithm and
+// whether the compressed data follows the header or is in the resource fork
+type decmpfsType struct {
+	method       types.CompressionMethodType
+	uncompressed bool
+	resourceFork bool
+}
+
+// decmpfsTypes maps the compression types stored in decmpfs headers
+var decmpfsTypes = map[uint32]decmpfsType{
+	1:  {uncompressed: true},
+	3:  {method: types.CompressionMethodDeflate},
+	4:  {method: types.CompressionMethodDeflate, resourceFork: true},
+	7:  {method: types.CompressionMethodLzvn},
+	8:  {method: types.CompressionMethodLzvn, resourceFork: true},
+	9:  {uncompressed: true},
+	10: {uncompressed: true, resourceFork: true},
+	11: {method: types.CompressionMethodLzfse},
+	12: {method: types.CompressionMethodLzfse, resourceFork: true},
+	13: {method: types.CompressionMethodLzbitmap},
+	14: {method: types.CompressionMethodLzbitmap, resourceFork: true},
+}
+
+// decmpfsChunk locates one compressed chunk in the resource fork
+type decmpfsChunk struct {
+	offset uint64
+	length uint64
+}
+
+// decmpfsFile gives random access to the contents of a compressed file. Data
+// stored after the header is decompressed when the file is opened; data in
+// the resource fork is decompressed a chunk at a time as it is read.
+type decmpfsFile struct {
+	inodeID         uint64
+	compressionType uint32
+	kind            decmpfsType
+	size            uint64
+
+	data []byte
+
+	fork   io.ReaderAt
+	chunks []decmpfsChunk
+
+	mu          sync.Mutex
+	cachedChunk int
+	cached      []byte
+}
+
+// isCompressed reports whether an inode's data is compressed with decmpfs
+func isCompressed(inodeReader interfaces.InodeReader) bool {
+	return inodeReader.BSDFlags()&types.UfCompressed != 0
+}
+
+// compressedFile returns the compressed file of an inode whose UF_COMPRESSED
+// flag is set, reusing the one opened last so sequential reads decompress
+// each chunk once
+func (fs *FileSystemServiceImpl) compressedFile(inodeID uint64) (*decmpfsFile, error) {
+	fs.compressedMu.Lock()
+	last := fs.lastCompressed
+	fs.compressedMu.Unlock()
+	if last != nil && last.inodeID == inodeID {
+		return last, nil
+	}
+
+	file, err := fs.openCompressedFile(inodeID)
+	if err != nil {
+		return nil, err
+	}
+	fs.compressedMu.Lock()
+	fs.lastCompressed = file
+	fs.compressedMu.Unlock()
+	return file, nil
+}
+
+// compressionHeader reads the decmpfs header of an inode, followed by any
+// data stored with it
+func (fs *FileSystemServiceImpl) compressionHeader(inodeID uint64) ([]byte, error) {
+	header, err := fs.ReadExtendedAttribute(inodeID, DecmpfsXattrName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compression header: %w", err)
+	}
+	if len(header) < decmpfsHeaderSize || string(header[0:4]) != "fpmc" {
+		return nil, fmt.Errorf("inode %d has an invalid compression header", inodeID)
+	}
+	return header, nil
+}
+
+// compressedSize returns the uncompressed size recorded in the decmpfs
+// header of an inode
+func (fs *FileSystemServiceImpl) compressedSize(inodeID uint64) (uint64, error) {
+	header, err := fs.compressionHeader(inodeID)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(header[8:16]), nil
+}
+
+// openCompressedFile parses the decmpfs header of an inode and, for types
+// kept in the resource fork, the fork's chunk table
+func (fs *FileSystemServiceImpl) openCompressedFile(inodeID uint64) (*decmpfsFile, error) {
+	header, err := fs.compressionHeader(inodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &decmpfsFile{
+		inodeID:         inodeID,
+		compressionType: binary.LittleEndian.Uint32(header[4:8]),
+		size:            binary.LittleEndian.Uint64(header[8:16]),
+		cachedChunk:     -1,
+	}
+	kind, ok := decmpfsTypes[file.compressionType]
+	if !ok {
+		return nil, fmt.Errorf("inode %d has unsupported compression type %d", inodeID, file.compressionType)
+	}
+	file.kind = kind
+
+	if !kind.resourceFork {
+		file.data, err = file.decode(header[decmpfsHeaderSize:], file.size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress inode %d: %w", inodeID, err)
+		}
+		return file, nil
+	}
+
+	xattr, err := fs.findXattr(inodeID, ResourceForkXattrName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource fork of compressed inode %d: %w", inodeID, err)
+	}
+	fork, forkSize, err := fs.xattrReaderAt(xattr)
+	if err != nil {
+		return nil, err
+	}
+	file.fork = fork
+	if file.compressionType == 4 {
+		file.chunks, err = zlibForkChunks(fork, forkSize)
+	} else {
+		file.chunks, err = forkChunks(fork, forkSize)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk table of compressed inode %d: %w", inodeID, err)
+	}
+	if want := (file.size + decmpfsChunkSize - 1) / decmpfsChunkSize; uint64(len(file.chunks)) != want {
+		return nil, fmt.Errorf("compressed inode %d has %d chunks, expected %d for %d bytes", inodeID, len(file.chunks), want, file.size)
+	}
+	return file, nil
+}
+
+// zlibForkChunks reads the chunk table of a zlib-compressed resource fork,
+// which is a classic resource fork whose single resource starts with the
+// chunk count and a table of offset and length pairs
+func zlibForkChunks(fork io.ReaderAt, forkSize uint64) ([]decmpfsChunk, error) {
+	var header [4]byte
+	if _, err := fork.ReadAt(header[:], 0); err != nil {
+		return nil, err
+	}
+	dataOffset := uint64(binary.BigEndian.Uint32(header[:]))
+
+	var count [4]byte
+	if _, err := fork.ReadAt(count[:], int64(dataOffset)+4); err != nil {
+		return nil, err
+	}
+	n := uint64(binary.LittleEndian.Uint32(count[:]))
+	if dataOffset+8+n*8 > forkSize {
+		return nil, fmt.Errorf("chunk table of %d entries exceeds resource fork of %d bytes", n, forkSize)
+	}
+
+	table := make([]byte, n*8)
+	if _, err := fork.ReadAt(table, int64(dataOffset)+8); err != nil {
+		return nil, err
+	}
+	chunks := make([]decmpfsChunk, n)
+	for i := range chunks {
+		chunks[i] = decmpfsChunk{
+			offset: dataOffset + 4 + uint64(binary.LittleEndian.Uint32(table[i*8:])),
+			length: uint64(binary.LittleEndian.Uint32(table[i*8+4:])),
+		}
+		if chunks[i].offset+chunks[i].length > forkSize {
+			return nil, fmt.Errorf("chunk %d exceeds resource fork of %d bytes", i, forkSize)
+		}
+	}
+	return chunks, nil
+}
+
+// forkChunks reads the chunk table of an LZVN, LZFSE, LZBITMAP or
+// uncompressed resource fork: the offsets of each chunk and of the end of the
+// last, the first of which is the size of the table itself
+func forkChunks(fork io.ReaderAt, forkSize uint64) ([]decmpfsChunk, error) {
+	var first [4]byte
+	if _, err := fork.ReadAt(first[:], 0); err != nil {
+		return nil, err
+	}
+	tableSize := uint64(binary.LittleEndian.Uint32(first[:]))
+	if tableSize < 4 || tableSize%4 != 0 || tableSize > forkSize {
+		return nil, fmt.Errorf("invalid chunk table size %d", tableSize)
+	}
+
+	table := make([]byte, tableSize)
+	if _, err := fork.ReadAt(table, 0); err != nil {
+		return nil, err
+	}
+	chunks := make([]decmpfsChunk, tableSize/4-1)
+	for i := range chunks {
+		start := uint64(binary.LittleEndian.Uint32(table[i*4:]))
+		end := uint64(binary.LittleEndian.Uint32(table[i*4+4:]))
+		if end < start || end > forkSize {
+			return nil, fmt.Errorf("chunk %d spans invalid range %d-%d", i, start, end)
+		}
+		chunks[i] = decmpfsChunk{offset: start, length: end - start}
+	}
+	return chunks, nil
+}
+
+// decode decompresses a block of compressed data that must expand to size
+// bytes. Blocks that did not compress are stored after a marker byte.
+func (f *decmpfsFile) decode(block []byte, size uint64) ([]byte, error) {
+	if size == 0 {
+		return []byte{}, nil
+	}
+	if len(block) == 0 {
+		return nil, fmt.Errorf("empty compressed block")
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case f.kind.uncompressed:
+		data = block
+	case f.kind.method == types.CompressionMethodDeflate && block[0]&0x0f == 0x0f,
+		f.kind.method == types.CompressionMethodLzvn && block[0] == 0x06,
+		f.kind.method == types.CompressionMethodLzfse && block[0] == 0xff,
+		f.kind.method == types.CompressionMethodLzbitmap && block[0] == 0xff:
+		data = block[1:]
+	case f.kind.method == types.CompressionMethodDeflate:
+		data, err = NewCompressionService().DecompressDeflateZlib(block)
+	case f.kind.method == types.CompressionMethodLzvn:
+		data, err = NewCompressionService().DecompressLZVN(block, int(size))
+	case f.kind.method == types.CompressionMethodLzfse:
+		data, err = NewCompressionService().DecompressLZFSE(block, int(size))
+	case f.kind.method == types.CompressionMethodLzbitmap:
+		data, err = NewCompressionService().DecompressLZBitmap(block, int(size))
+	default:
+		data, err = NewCompressionService().Decompress(block, f.kind.method)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(data)) < size {
+		return nil, fmt.Errorf("block decompressed to %d bytes, expected %d", len(data), size)
+	}
+	return data[:size], nil
+}
+
+// chunk returns the decompressed contents of chunk i of the resource fork
+func (f *decmpfsFile) chunk(i int) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cachedChunk == i {
+		return f.cached, nil
+	}
+
+	c := f.chunks[i]
+	block := make([]byte, c.length)
+	if n, err := f.fork.ReadAt(block, int64(c.offset)); n < len(block) {
+		return nil, fmt.Errorf("failed to read chunk %d: %w", i, err)
+	}
+	size := min(uint64(decmpfsChunkSize), f.size-uint64(i)*decmpfsChunkSize)
+	data, err := f.decode(block, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk %d: %w", i, err)
+	}
+
+	f.cachedChunk, f.cached = i, data
+	return data, nil
+}
+
+// readRange reads length bytes at offset, stopping at the end of the file
+func (f *decmpfsFile) readRange(offset, length uint64) ([]byte, error) {
+	if offset >= f.size {
+		return []byte{}, nil
+	}
+	data := make([]byte, min(length, f.size-offset))
+	if n, err := f.ReadAt(data, int64(offset)); n < len(data) {
+		return nil, fmt.Errorf("failed to read compressed inode %d: %w", f.inodeID, err)
+	}
+	return data, nil
+}
+
+// ReadAt implements io.ReaderAt over the uncompressed contents, decompressing
+// only the chunks the range touches
+func (f *decmpfsFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if uint64(off) >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.fork == nil {
+		n := copy(p, f.data[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
+	n := 0
+	for n < len(p) && uint64(off) < f.size {
+		i := int(uint64(off) / decmpfsChunkSize)
+		data, err := f.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], data[uint64(off)-uint64(i)*decmpfsChunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
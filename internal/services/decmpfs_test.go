@@ -0,0 +1,252 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/deploymenttheory/go-apfs/internal/compression"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// compressedInodeRecord encodes a regular file inode with UF_COMPRESSED set
+// and no data stream, as the file system leaves compressed files
+func compressedInodeRecord(id, parentID uint64) btreeRecord {
+	record := inodeRecord(id, parentID, 0o100644, 0)
+	binary.LittleEndian.PutUint64(record.value[8:16], 0)
+	binary.LittleEndian.PutUint32(record.value[68:72], types.UfCompressed)
+	return record
+}
+
+// decmpfsHeader encodes a decmpfs header followed by data
+func decmpfsHeader(compressionType uint32, size uint64, data []byte) []byte {
+	header := []byte("fpmc")
+	header = binary.LittleEndian.AppendUint32(header, compressionType)
+	header = binary.LittleEndian.AppendUint64(header, size)
+	return append(header, data...)
+}
+
+// zlibCompress compresses data into a zlib stream
+func zlibCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// zlibResourceFork lays compressed chunks out as a classic resource fork
+func zlibResourceFork(chunks [][]byte) []byte {
+	table := binary.LittleEndian.AppendUint32(nil, uint32(len(chunks)))
+	offset := 4 + 8*len(chunks)
+	var body []byte
+	for _, chunk := range chunks {
+		table = binary.LittleEndian.AppendUint32(table, uint32(offset))
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(chunk)))
+		offset += len(chunk)
+		body = append(body, chunk...)
+	}
+	resource := append(table, body...)
+
+	fork := make([]byte, 0x100)
+	binary.BigEndian.PutUint32(fork[0:4], 0x100)
+	fork = binary.BigEndian.AppendUint32(fork, uint32(len(resource)))
+	return append(fork, resource...)
+}
+
+// offsetResourceFork lays chunks out behind a table of their offsets
+func offsetResourceFork(chunks [][]byte) []byte {
+	offset := 4 * (len(chunks) + 1)
+	table := binary.LittleEndian.AppendUint32(nil, uint32(offset))
+	var body []byte
+	for _, chunk := range chunks {
+		offset += len(chunk)
+		table = binary.LittleEndian.AppendUint32(table, uint32(offset))
+		body = append(body, chunk...)
+	}
+	return append(table, body...)
+}
+
+// writeResourceFork stores fork in consecutive blocks from addr and returns
+// the records of an extended attribute of objID holding it as stream streamID
+func (img *testImage) writeResourceFork(objID, streamID, addr uint64, fork []byte) []btreeRecord {
+	records := []btreeRecord{streamXattrRecord(objID, ResourceForkXattrName, streamID, uint64(len(fork)))}
+	for i := 0; len(fork) > 0; i++ {
+		n := copy(img.blocks[addr+uint64(i)], fork)
+		fork = fork[n:]
+		records = append(records, extentRecord(streamID, uint64(i)*testBlockSize, testBlockSize, addr+uint64(i)))
+	}
+	return records
+}
+
+func TestFileSystemServiceDecmpfs(t *testing.T) {
+	text := func(size int) []byte {
+		var buf bytes.Buffer
+		for i := 0; buf.Len() < size; i++ {
+			fmt.Fprintf(&buf, "line %06d of a compressed file\n", i)
+		}
+		return buf.Bytes()[:size]
+	}
+	small := text(3000)
+	large := text(2*decmpfsChunkSize + 100)
+	plain := text(decmpfsChunkSize + 1000)
+
+	var zlibChunks [][]byte
+	for off := 0; off < len(large); off += decmpfsChunkSize {
+		end := off + decmpfsChunkSize
+		if end > len(large) {
+			end = len(large)
+		}
+		zlibChunks = append(zlibChunks, zlibCompress(t, large[off:end]))
+	}
+	// A chunk that did not compress is stored after a marker byte
+	zlibChunks[2] = append([]byte{0xff}, large[2*decmpfsChunkSize:]...)
+
+	img := newTestImage(128)
+	records := []btreeRecord{
+		inodeRecord(2, 1, 0o040755, 0),
+		drecRecord(2, "inline.txt", 16, types.DtReg),
+		drecRecord(2, "zlib.txt", 17, types.DtReg),
+		drecRecord(2, "plain.txt", 18, types.DtReg),
+		compressedInodeRecord(16, 2),
+		xattrRecord(16, DecmpfsXattrName, decmpfsHeader(3, uint64(len(small)), zlibCompress(t, small))),
+		compressedInodeRecord(17, 2),
+		xattrRecord(17, DecmpfsXattrName, decmpfsHeader(4, uint64(len(large)), nil)),
+		compressedInodeRecord(18, 2),
+		xattrRecord(18, DecmpfsXattrName, decmpfsHeader(10, uint64(len(plain)), nil)),
+	}
+	records = append(records, img.writeResourceFork(17, 40, 60, zlibResourceFork(zlibChunks))...)
+	records = append(records, img.writeResourceFork(18, 41, 80, offsetResourceFork([][]byte{
+		plain[:decmpfsChunkSize], plain[decmpfsChunkSize:],
+	}))...)
+	fs := openTestFileSystem(t, img, types.ApfsIncompatNormalizationInsensitive, records...)
+
+	for inode, want := range map[uint64][]byte{16: small, 17: large, 18: plain} {
+		size, err := fs.GetFileSize(inode)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(len(want)), size, inode)
+
+		data, err := fs.ReadFile(inode)
+		require.NoError(t, err)
+		assert.Equal(t, want, data, inode)
+
+		node, err := fs.GetFileMetadata(inode)
+		require.NoError(t, err)
+		assert.True(t, node.IsCompressed)
+		assert.Equal(t, uint64(len(want)), node.Size)
+	}
+
+	// A range across a chunk boundary
+	data, err := fs.ReadFileRange(17, decmpfsChunkSize-10, 20)
+	require.NoError(t, err)
+	assert.Equal(t, large[decmpfsChunkSize-10:decmpfsChunkSize+10], data)
+
+	seeker, err := fs.CreateFileSeeker(17)
+	require.NoError(t, err)
+	_, err = seeker.Seek(-50, io.SeekEnd)
+	require.NoError(t, err)
+	tail, err := io.ReadAll(seeker)
+	require.NoError(t, err)
+	assert.Equal(t, large[len(large)-50:], tail)
+
+	// A fork holding compressed data is not the file's resource fork
+	has, err := fs.HasResourceFork(17)
+	require.NoError(t, err)
+	assert.False(t, has)
+	_, err = fs.GetResourceFork(17)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	entries, err := fs.ListDirectory("/")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if entry.Name == "plain.txt" {
+			assert.Equal(t, uint64(len(plain)), entry.Size)
+		}
+	}
+}
+
+func TestFileSystemServiceDecmpfsLZVN(t *testing.T) {
+	lzvn := []byte("\xe3abc\x3f\x03\x00\x06\x00\x00\x00\x00\x00\x00\x00")
+	want := "abc" + "abcabcabca"
+	fs := openTestFileSystem(t, newTestImage(64), types.ApfsIncompatNormalizationInsensitive,
+		inodeRecord(2, 1, 0o040755, 0),
+		compressedInodeRecord(16, 2),
+		xattrRecord(16, DecmpfsXattrName, decmpfsHeader(7, uint64(len(want)), lzvn)),
+		compressedInodeRecord(17, 2),
+		xattrRecord(17, DecmpfsXattrName, decmpfsHeader(7, 3, []byte("\x06raw"))),
+	)
+
+	data, err := fs.ReadFile(16)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(data))
+
+	data, err = fs.ReadFile(17)
+	require.NoError(t, err)
+	assert.Equal(t, "raw", string(data))
+}
+
+func TestFileSystemServiceDecmpfsLZFSE(t *testing.T) {
+	// An uncompressed block and an LZVN block matching into it
+	lzfse := []byte("bvx-\x03\x00\x00\x00abc" +
+		"bvxn\x0a\x00\x00\x00\x0b\x00\x00\x00\x3f\x03\x00\x06\x00\x00\x00\x00\x00\x00\x00" +
+		"bvx$")
+	want := "abc" + "abcabcabca"
+	fs := openTestFileSystem(t, newTestImage(64), types.ApfsIncompatNormalizationInsensitive,
+		inodeRecord(2, 1, 0o040755, 0),
+		compressedInodeRecord(16, 2),
+		xattrRecord(16, DecmpfsXattrName, decmpfsHeader(11, uint64(len(want)), lzfse)),
+		compressedInodeRecord(17, 2),
+		xattrRecord(17, DecmpfsXattrName, decmpfsHeader(11, 3, []byte("\xffraw"))),
+	)
+
+	data, err := fs.ReadFile(16)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(data))
+
+	data, err = fs.ReadFile(17)
+	require.NoError(t, err)
+	assert.Equal(t, "raw", string(data))
+}
+
+func TestFileSystemServiceDecmpfsLZBitmap(t *testing.T) {
+	lzbitmap := []byte("ZBM\x09" + "\x09\x00\x00\x03\x00\x00abc" + "\x06\x00\x00\x00\x00\x00")
+	coded := []byte("ZBM\x09" + "\x09\x00\x00\x10\x00\x00abc" + "\x06\x00\x00\x00\x00\x00")
+	fs := openTestFileSystem(t, newTestImage(64), types.ApfsIncompatNormalizationInsensitive,
+		inodeRecord(2, 1, 0o040755, 0),
+		compressedInodeRecord(16, 2),
+		xattrRecord(16, DecmpfsXattrName, decmpfsHeader(13, 3, lzbitmap)),
+		compressedInodeRecord(17, 2),
+		xattrRecord(17, DecmpfsXattrName, decmpfsHeader(13, 3, []byte("\xffraw"))),
+		compressedInodeRecord(18, 2),
+		xattrRecord(18, DecmpfsXattrName, decmpfsHeader(13, 16, coded)),
+	)
+
+	data, err := fs.ReadFile(16)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+
+	data, err = fs.ReadFile(17)
+	require.NoError(t, err)
+	assert.Equal(t, "raw", string(data))
+
+	_, err = fs.ReadFile(18)
+	assert.ErrorIs(t, err, compression.ErrUnsupported)
+}
+
+func TestDecmpfsFileUnsupported(t *testing.T) {
+	fs := openTestFileSystem(t, newTestImage(64), types.ApfsIncompatNormalizationInsensitive,
+		inodeRecord(2, 1, 0o040755, 0),
+		compressedInodeRecord(16, 2),
+		xattrRecord(16, DecmpfsXattrName, decmpfsHeader(5, 10, nil)),
+	)
+
+	_, err := fs.ReadFileRange(16, 0, 10)
+	assert.ErrorContains(t, err, "unsupported compression type 5")
+}
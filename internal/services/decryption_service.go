@@ -0,0 +1,364 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/deploymenttheory/go-apfs/internal/interfaces"
+	"github.com/deploymenttheory/go-apfs/internal/parsers/encryption"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// maxKeybagBlocks bounds the size of a keybag read from a damaged superblock
+const maxKeybagBlocks = 16
+
+// keyBlobFlagAES128 marks a wrapped key blob whose key is 128 bits long
+const keyBlobFlagAES128 = 0x02
+
+// DER tags of a wrapped key blob. The blob is a sequence whose constructed
+// [3] element describes the key; a KEK blob also holds the PBKDF2
+// parameters that derive its wrapping key from a password.
+const (
+	derSequence       = 0x30
+	derKeyBlob        = 0xa3
+	derBlobUUID       = 0x81
+	derBlobFlags      = 0x82
+	derBlobWrappedKey = 0x83
+	derBlobIterations = 0x84
+	derBlobSalt       = 0x85
+)
+
+// DecryptionService unlocks a software-encrypted volume, as used by FileVault
+// on Macs without a T2 or Apple silicon, and decrypts its file system.
+//
+// The volume encryption key (VEK) is wrapped in the container keybag, which
+// is encrypted with the container UUID. The same keybag locates the volume
+// keybag, encrypted with the volume UUID, whose unlock records hold the key
+// encryption key (KEK) wrapped with a key derived from each user's password
+// or the personal recovery key.
+type DecryptionService struct {
+	container *ContainerReader
+	fs        *FileSystemServiceImpl
+	crypto    *CryptoService
+
+	mu  sync.Mutex
+	vek []byte
+}
+
+var _ interfaces.DecryptionManager = (*DecryptionService)(nil)
+
+// NewDecryptionService creates a decryption service for the volume read by fs
+func NewDecryptionService(container *ContainerReader, fs *FileSystemServiceImpl) (*DecryptionService, error) {
+	if container == nil {
+		return nil, fmt.Errorf("container reader cannot be nil")
+	}
+	if fs == nil {
+		return nil, fmt.Errorf("file system service cannot be nil")
+	}
+	return &DecryptionService{container: container, fs: fs, crypto: NewCryptoService()}, nil
+}
+
+// IsDecryptionPossible checks that the volume is encrypted and that the
+// container has a keybag that may hold its key
+func (ds *DecryptionService) IsDecryptionPossible() bool {
+	return ds.fs.volumeSB.ApfsFsFlags&types.ApfsFsUnencrypted == 0 &&
+		ds.container.GetSuperblock().NxKeylocker.PrBlockCount != 0
+}
+
+// Authenticate unlocks the volume with a user's password or the personal
+// recovery key. It reports false when no unlock record accepts passphrase,
+// and on success makes the file system service decrypt the volume.
+func (ds *DecryptionService) Authenticate(passphrase string) (bool, error) {
+	if !ds.IsDecryptionPossible() {
+		return false, fmt.Errorf("volume is not software encrypted")
+	}
+
+	vek, err := ds.unlock(passphrase)
+	if errors.Is(err, ErrKeyUnwrap) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := ds.fs.SetVolumeKey(vek); err != nil {
+		return false, err
+	}
+	ds.mu.Lock()
+	ds.vek = vek
+	ds.mu.Unlock()
+	return true, nil
+}
+
+// VolumeKey returns the volume encryption key found by Authenticate, or nil
+// while the volume is locked
+func (ds *DecryptionService) VolumeKey() []byte {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.vek
+}
+
+// DecryptFile writes the decrypted contents of the file at sourcePath to
+// destinationPath on the host
+func (ds *DecryptionService) DecryptFile(sourcePath, destinationPath string) error {
+	if ds.VolumeKey() == nil {
+		return ErrLocked
+	}
+
+	node, err := ds.fs.GetInodeByPath(sourcePath)
+	if err != nil {
+		return err
+	}
+	return ds.writeFile(node.Inode, destinationPath)
+}
+
+// DecryptVolume writes the decrypted directories and regular files of the
+// volume below outputPath on the host. Other file types are skipped.
+func (ds *DecryptionService) DecryptVolume(outputPath string) error {
+	if ds.VolumeKey() == nil {
+		return ErrLocked
+	}
+
+	if err := os.MkdirAll(outputPath, 0o755); err != nil {
+		return err
+	}
+	return ds.fs.WalkTree("/", func(entry *FileEntry) error {
+		dest := filepath.Join(outputPath, filepath.FromSlash(entry.Path))
+		switch entry.Mode & 0o170000 {
+		case 0o040000:
+			return os.MkdirAll(dest, 0o755)
+		case 0o100000:
+			return ds.writeFile(entry.Inode, dest)
+		}
+		return nil
+	})
+}
+
+// writeFile copies the contents of an inode to a new host file
+func (ds *DecryptionService) writeFile(inodeID uint64, dest string) error {
+	r, err := ds.fs.CreateFileReader(inodeID)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to decrypt inode %d: %w", inodeID, err)
+	}
+	return f.Close()
+}
+
+// unlock walks the keybags to the volume encryption key. It returns an error
+// wrapping ErrKeyUnwrap when no unlock record accepts passphrase.
+func (ds *DecryptionService) unlock(passphrase string) ([]byte, error) {
+	nx := ds.container.GetSuperblock()
+	volumeUUID := ds.fs.volumeSB.ApfsVolUuid
+	helper := NewEncryptionHelper()
+
+	containerKey := helper.DeriveContainerKeybagKey(nx.NxUuid)
+	containerBag, err := ds.readKeybag(nx.NxKeylocker, containerKey, types.ObjectTypeContainerKeybag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container keybag: %w", err)
+	}
+
+	var vekData, location []byte
+	for _, entry := range containerBag.ListEntries() {
+		if entry.UUID() != volumeUUID {
+			continue
+		}
+		switch entry.Tag() {
+		case types.KbTagVolumeKey:
+			vekData = entry.KeyData()
+		case types.KbTagVolumeUnlockRecords:
+			location = entry.KeyData()
+		}
+	}
+	if vekData == nil || len(location) < 16 {
+		return nil, fmt.Errorf("container keybag has no key for volume %x: %w", volumeUUID, ErrNotFound)
+	}
+	vekBlob, err := parseKeyBlob(vekData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse wrapped volume key: %w", err)
+	}
+
+	volumeKey := helper.DeriveVolumeKeybagKey(volumeUUID)
+	volumeBag, err := ds.readKeybag(types.Prange{
+		PrStartPaddr: types.Paddr(binary.LittleEndian.Uint64(location[0:8])),
+		PrBlockCount: binary.LittleEndian.Uint64(location[8:16]),
+	}, volumeKey, types.ObjectTypeVolumeKeybag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volume keybag: %w", err)
+	}
+
+	for _, entry := range volumeBag.ListEntries() {
+		if entry.Tag() != types.KbTagVolumeUnlockRecords {
+			continue
+		}
+		kekBlob, err := parseKeyBlob(entry.KeyData())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse unlock record %x: %w", entry.UUID(), err)
+		}
+		if kekBlob.iterations == 0 || len(kekBlob.salt) == 0 {
+			continue // Not unlocked with a password
+		}
+
+		derived := ds.crypto.Pbkdf2([]byte(passphrase), kekBlob.salt, kekBlob.iterations, 32)
+		kek, err := kekBlob.unwrap(ds.crypto, derived)
+		if errors.Is(err, ErrKeyUnwrap) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap key encryption key of %x: %w", entry.UUID(), err)
+		}
+
+		vek, err := vekBlob.unwrap(ds.crypto, kek)
+		if err != nil {
+			// A KEK that unwraps must unwrap the VEK, or the keybags disagree
+			return nil, fmt.Errorf("failed to unwrap volume key with key encryption key of %x: %v", entry.UUID(), err)
+		}
+		if vekBlob.flags&keyBlobFlagAES128 != 0 {
+			// The tweak key of a 128-bit VEK is derived from the key and its UUID
+			sum := sha256.Sum256(append(append([]byte(nil), vek...), vekBlob.uuid[:]...))
+			vek = append(vek, sum[:16]...)
+		}
+		return vek, nil
+	}
+
+	return nil, fmt.Errorf("no unlock record of volume %x accepts the password: %w", volumeUUID, ErrKeyUnwrap)
+}
+
+// readKeybag reads, decrypts and verifies the keybag object stored in r
+func (ds *DecryptionService) readKeybag(r types.Prange, key [32]byte, objType uint32) (interfaces.KeybagReader, error) {
+	if r.PrBlockCount == 0 || r.PrBlockCount > maxKeybagBlocks {
+		return nil, fmt.Errorf("invalid keybag location: %d blocks at %d", r.PrBlockCount, r.PrStartPaddr)
+	}
+	data, err := ds.container.ReadBlocks(uint64(r.PrStartPaddr), r.PrBlockCount)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, err := newXTSCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	if err := cipher.decrypt(data, uint64(r.PrStartPaddr)*uint64(ds.container.GetBlockSize())/xtsUnitSize); err != nil {
+		return nil, err
+	}
+
+	header, err := verifyObject(data)
+	if err != nil {
+		return nil, fmt.Errorf("keybag at block %d does not decrypt: %w", r.PrStartPaddr, err)
+	}
+	// Keybag types are four character codes stored without storage flags
+	if header.OType != objType {
+		return nil, fmt.Errorf("object at block %d has type %#x, expected keybag type %#x", r.PrStartPaddr, header.OType, objType)
+	}
+	return encryption.NewKeybagReader(data[32:], binary.LittleEndian)
+}
+
+// keyBlob is a wrapped key from a keybag entry
+type keyBlob struct {
+	uuid       types.UUID
+	flags      uint32
+	wrapped    []byte
+	iterations int
+	salt       []byte
+}
+
+// unwrap unwraps the blob's key with kek. A 128-bit key is wrapped with the
+// first half of kek.
+func (b *keyBlob) unwrap(crypto *CryptoService, kek []byte) ([]byte, error) {
+	wrapped := b.wrapped
+	if b.flags&keyBlobFlagAES128 != 0 {
+		kek = kek[:16]
+		if len(wrapped) > 24 {
+			wrapped = wrapped[:24]
+		}
+	}
+	return crypto.UnwrapKey(kek, wrapped)
+}
+
+// parseKeyBlob decodes the DER encoding of a wrapped key
+func parseKeyBlob(data []byte) (*keyBlob, error) {
+	tag, outer, _, err := derElement(data)
+	if err != nil {
+		return nil, err
+	}
+	if tag != derSequence {
+		return nil, fmt.Errorf("key blob starts with tag %#x, expected a sequence", tag)
+	}
+	fields, err := derFields(outer)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := fields[derKeyBlob]
+	if !ok {
+		return nil, fmt.Errorf("key blob has no key description")
+	}
+	if fields, err = derFields(blob); err != nil {
+		return nil, err
+	}
+
+	b := &keyBlob{wrapped: fields[derBlobWrappedKey], salt: fields[derBlobSalt]}
+	if len(b.wrapped) == 0 {
+		return nil, fmt.Errorf("key blob has no wrapped key")
+	}
+	copy(b.uuid[:], fields[derBlobUUID])
+	if flags := fields[derBlobFlags]; len(flags) > 0 {
+		// The flags are stored little endian, unlike the DER integers
+		var buf [4]byte
+		copy(buf[:], flags)
+		b.flags = binary.LittleEndian.Uint32(buf[:])
+	}
+	for _, c := range fields[derBlobIterations] {
+		b.iterations = b.iterations<<8 | int(c)
+	}
+	return b, nil
+}
+
+// derFields decodes a run of DER elements into their values by tag
+func derFields(data []byte) (map[byte][]byte, error) {
+	fields := make(map[byte][]byte)
+	for len(data) > 0 {
+		tag, value, rest, err := derElement(data)
+		if err != nil {
+			return nil, err
+		}
+		fields[tag] = value
+		data = rest
+	}
+	return fields, nil
+}
+
+// derElement decodes the DER element at the start of data into its tag and
+// value, returning the bytes that follow it
+func derElement(data []byte) (tag byte, value, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, fmt.Errorf("truncated DER element")
+	}
+	tag, length, pos := data[0], int(data[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 || len(data) < 2+n {
+			return 0, nil, nil, fmt.Errorf("invalid DER length of element %#x", tag)
+		}
+		length = 0
+		for _, c := range data[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		pos += n
+	}
+	if length > len(data)-pos {
+		return 0, nil, nil, fmt.Errorf("DER element %#x of %d bytes exceeds its %d byte container", tag, length, len(data)-pos)
+	}
+	return tag, data[pos : pos+length], data[pos+length:], nil
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// derTLV encodes a DER element with a short or one byte long form length
+func derTLV(tag byte, value []byte) []byte {
+	if len(value) < 0x80 {
+		return append([]byte{tag, byte(len(value))}, value...)
+	}
+	return append([]byte{tag, 0x81, byte(len(value))}, value...)
+}
+
+// keyBlobDER encodes a wrapped key blob. iterations and salt are only
+// encoded when iterations is non-zero, as for a KEK.
+func keyBlobDER(uuid types.UUID, flags uint32, wrapped []byte, iterations int, salt []byte) []byte {
+	var blob []byte
+	blob = append(blob, derTLV(0x80, []byte{0})...)
+	blob = append(blob, derTLV(derBlobUUID, uuid[:])...)
+	blob = append(blob, derTLV(derBlobFlags, binary.LittleEndian.AppendUint32(nil, flags))...)
+	blob = append(blob, derTLV(derBlobWrappedKey, wrapped)...)
+	if iterations != 0 {
+		blob = append(blob, derTLV(derBlobIterations, binary.BigEndian.AppendUint32(nil, uint32(iterations)))...)
+		blob = append(blob, derTLV(derBlobSalt, salt)...)
+	}
+
+	var outer []byte
+	outer = append(outer, derTLV(0x80, []byte{0})...)
+	outer = append(outer, derTLV(0x81, make([]byte, 32))...)
+	outer = append(outer, derTLV(0x82, make([]byte, 8))...)
+	outer = append(outer, derTLV(derKeyBlob, blob)...)
+	return derTLV(derSequence, outer)
+}
+
+// testKeybagEntry describes a keybag_entry_t
+type testKeybagEntry struct {
+	uuid types.UUID
+	tag  types.KbTag
+	data []byte
+}
+
+// keybagObject encodes a keybag object holding a kb_locker_t of entries
+func keybagObject(addr uint64, objType uint32, entries ...testKeybagEntry) []byte {
+	block := make([]byte, testBlockSize)
+	putObjectHeader(block, addr, 1, objType, 0)
+	off := 48
+	for _, e := range entries {
+		copy(block[off:], e.uuid[:])
+		binary.LittleEndian.PutUint16(block[off+16:], uint16(e.tag))
+		binary.LittleEndian.PutUint16(block[off+18:], uint16(len(e.data)))
+		copy(block[off+24:], e.data)
+		off += 24 + (len(e.data)+15)&^15
+	}
+	binary.LittleEndian.PutUint16(block[32:], types.ApfsKeybagVersion)
+	binary.LittleEndian.PutUint16(block[34:], uint16(len(entries)))
+	binary.LittleEndian.PutUint32(block[36:], uint32(off-48))
+	return block
+}
+
+// openEncryptedFileSystem opens the volume written by newEncryptedImage
+func openEncryptedFileSystem(t *testing.T, vek []byte, password string, contents []byte) (*ContainerReader, *FileSystemServiceImpl) {
+	t.Helper()
+	cr := newEncryptedImage(t, vek, password, contents).containerReader(t)
+	vs, err := NewVolumeService(cr, 1026)
+	require.NoError(t, err)
+	fs, err := NewFileSystemService(cr, 1026, vs.GetSuperblock())
+	require.NoError(t, err)
+	return cr, fs
+}
+
+// newEncryptedImage writes a software-encrypted volume whose tree holds
+// /secret.txt with contents, encrypted with vek and unlocked by password
+func newEncryptedImage(t *testing.T, vek []byte, password string, contents []byte) *testImage {
+	t.Helper()
+	img := newTestImage(64)
+	containerUUID := types.UUID{0xc0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	volumeUUID := types.UUID{0x50, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	userUUID := types.UUID{0xee, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	const cryptoID = 777
+
+	sb := img.buildContainerSuperblock(20, 10, 1026)
+	copy(sb[72:88], containerUUID[:])
+	binary.LittleEndian.PutUint64(sb[0x510:], 5)
+	binary.LittleEndian.PutUint64(sb[0x518:], 1)
+	img.putObject(0, sb)
+	img.writeObjectMap(10, 15, omapRecord(1026, 15, 21, 0))
+	img.putObject(21, buildVolumeSuperblock(testVolume{
+		oid: 1026, xid: 15, name: "Macintosh HD", omapOID: 30, rootTreeOID: 1028,
+		incompat: types.ApfsIncompatNormalizationInsensitive, uuid: volumeUUID, fsFlags: types.ApfsFsOnekey,
+	}))
+
+	extent := extentRecord(16, 0, testBlockSize, 50)
+	binary.LittleEndian.PutUint64(extent.value[16:24], cryptoID)
+	records := []btreeRecord{
+		inodeRecord(2, 1, 0o040755, 0),
+		drecRecord(2, "secret.txt", 16, types.DtReg),
+		inodeRecord(16, 2, 0o100644, uint64(len(contents))),
+		extent,
+	}
+	sortFSRecords(records)
+	mappings := img.writeFSTree(1028, 40, 15, records)
+	binary.LittleEndian.PutUint32(mappings[0].value[0:4], types.OmapValEncrypted)
+	img.writeObjectMap(30, 15, mappings...)
+	xtsEncrypt(t, vek, img.blocks[40], 40*testBlockSize/xtsUnitSize)
+
+	copy(img.blocks[50], contents)
+	xtsEncrypt(t, vek, img.blocks[50], cryptoID)
+
+	crypto := NewCryptoService()
+	salt := []byte("0123456789abcdef")
+	kek := bytes.Repeat([]byte{0x4b}, 32)
+	kekBlob := keyBlobDER(userUUID, 0, wrapKey(t, crypto.Pbkdf2([]byte(password), salt, 1000, 32), kek), 1000, salt)
+	vekBlob := keyBlobDER(volumeUUID, 0, wrapKey(t, kek, vek), 0, nil)
+	location := binary.LittleEndian.AppendUint64(binary.LittleEndian.AppendUint64(nil, 6), 1)
+
+	helper := NewEncryptionHelper()
+	containerKey := helper.DeriveContainerKeybagKey(containerUUID)
+	img.putObject(5, keybagObject(5, types.ObjectTypeContainerKeybag,
+		testKeybagEntry{uuid: volumeUUID, tag: types.KbTagVolumeKey, data: vekBlob},
+		testKeybagEntry{uuid: volumeUUID, tag: types.KbTagVolumeUnlockRecords, data: location},
+	))
+	xtsEncrypt(t, containerKey[:], img.blocks[5], 5*testBlockSize/xtsUnitSize)
+	volumeKey := helper.DeriveVolumeKeybagKey(volumeUUID)
+	img.putObject(6, keybagObject(6, types.ObjectTypeVolumeKeybag,
+		testKeybagEntry{uuid: userUUID, tag: types.KbTagVolumeUnlockRecords, data: kekBlob},
+	))
+	xtsEncrypt(t, volumeKey[:], img.blocks[6], 6*testBlockSize/xtsUnitSize)
+	return img
+}
+
+func TestDecryptionServiceAuthenticate(t *testing.T) {
+	vek := bytes.Repeat([]byte{0x11, 0x22, 0x33, 0x44}, 8)
+	contents := []byte("the quick brown fox jumps over the lazy dog")
+	cr, fs := openEncryptedFileSystem(t, vek, "hunter2", contents)
+
+	_, err := fs.GetInodeByPath("/secret.txt")
+	assert.ErrorIs(t, err, ErrLocked)
+
+	ds, err := NewDecryptionService(cr, fs)
+	require.NoError(t, err)
+	assert.True(t, ds.IsDecryptionPossible())
+	assert.ErrorIs(t, ds.DecryptFile("/secret.txt", filepath.Join(t.TempDir(), "secret.txt")), ErrLocked)
+
+	ok, err := ds.Authenticate("hunter3")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, ds.VolumeKey())
+
+	ok, err = ds.Authenticate("hunter2")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, vek, ds.VolumeKey())
+
+	node, err := fs.GetInodeByPath("/secret.txt")
+	require.NoError(t, err)
+	data, err := fs.ReadFile(node.Inode)
+	require.NoError(t, err)
+	assert.Equal(t, contents, data)
+
+	dir := t.TempDir()
+	require.NoError(t, ds.DecryptFile("/secret.txt", filepath.Join(dir, "copy.txt")))
+	data, err = os.ReadFile(filepath.Join(dir, "copy.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, contents, data)
+
+	require.NoError(t, ds.DecryptVolume(filepath.Join(dir, "volume")))
+	data, err = os.ReadFile(filepath.Join(dir, "volume", "secret.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, contents, data)
+}
+
+func TestDecryptionServiceAuthenticateWhileReading(t *testing.T) {
+	vek := bytes.Repeat([]byte{0x55, 0x66, 0x77, 0x88}, 8)
+	contents := []byte("read while the volume is unlocked")
+	cr, fs := openEncryptedFileSystem(t, vek, "hunter2", contents)
+	ds, err := NewDecryptionService(cr, fs)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if node, err := fs.GetInodeByPath("/secret.txt"); err == nil {
+					fs.ReadFile(node.Inode)
+				}
+				ds.VolumeKey()
+			}
+		}()
+	}
+	ok, err := ds.Authenticate("hunter2")
+	wg.Wait()
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	node, err := fs.GetInodeByPath("/secret.txt")
+	require.NoError(t, err)
+	data, err := fs.ReadFile(node.Inode)
+	require.NoError(t, err)
+	assert.Equal(t, contents, data)
+}
+
+func TestDecryptionServiceUnencryptedVolume(t *testing.T) {
+	fs := openTestFileSystem(t, newTestImage(64), types.ApfsIncompatNormalizationInsensitive, inodeRecord(2, 1, 0o040755, 0))
+	ds, err := NewDecryptionService(fs.container, fs)
+	require.NoError(t, err)
+	assert.False(t, ds.IsDecryptionPossible())
+
+	_, err = ds.Authenticate("password")
+	assert.Error(t, err)
+}
+
+func TestParseKeyBlob(t *testing.T) {
+	uuid := types.UUID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	wrapped := bytes.Repeat([]byte{0xab}, 40)
+	blob, err := parseKeyBlob(keyBlobDER(uuid, keyBlobFlagAES128, wrapped, 100000, []byte("salt")))
+	require.NoError(t, err)
+	assert.Equal(t, uuid, blob.uuid)
+	assert.Equal(t, uint32(keyBlobFlagAES128), blob.flags)
+	assert.Equal(t, wrapped, blob.wrapped)
+	assert.Equal(t, 100000, blob.iterations)
+	assert.Equal(t, []byte("salt"), blob.salt)
+
+	_, err = parseKeyBlob([]byte{0x30, 0x10, 0x80})
+	assert.Error(t, err)
+	_, err = parseKeyBlob(derTLV(0x31, nil))
+	assert.Error(t, err)
+}
@@ -127,31 +127,29 @@ func (eh *EncryptionHelper) IncrementTweak(currentTweak uint64) uint64 {
 // Returns:
 //   - true if the object is encrypted, false otherwise
 func (eh *EncryptionHelper) IsObjectMapValueEncrypted(flags uint32) bool {
-	const omapValEncrypted uint32 = 0x00000001 // OMAP_VAL_ENCRYPTED
-	return (flags & omapValEncrypted) != 0
+	return (flags & types.OmapValEncrypted) != 0
 }
 
-// NOTE: Actual XTS-AES-128 encryption/decryption implementation would require
-// integration with a cryptographic library such as:
-//   - crypto/aes (Go standard library)
-//   - github.com/gtank/cryptopasta or similar for XTS mode
-//
-// The functions above provide the correct key derivation and tweak calculation
-// logic as specified in the APFS documentation, but the actual encryption/decryption
-// operations are not implemented. To add full encryption support:
-//
-// 1. Import a crypto library with XTS-AES support
-// 2. Implement DecryptFSTreeNode(data, key, tweak) function
-// 3. Implement DecryptExtent(data, key, tweak) function
-// 4. Implement EncryptFSTreeNode(data, key, tweak) function (for write support)
-// 5. Implement EncryptExtent(data, key, tweak) function (for write support)
-//
-// Example signature for decryption:
-//   func (eh *EncryptionHelper) DecryptBlock(
-//       ciphertext []byte,
-//       key [32]byte,
-//       tweak uint64,
-//   ) ([]byte, error)
+// DecryptBlock decrypts data encrypted with XTS-AES-128 under key, whose
+// first 512 bytes use the given tweak. The length of data must be a multiple
+// of 512 bytes.
+//
+// Reference: APFS Advent Challenge Day 18
+//
+// The file-system tree and file data of a software-encrypted volume are
+// decrypted this way with the volume encryption key once the volume is
+// unlocked; see DecryptionService.
+func (eh *EncryptionHelper) DecryptBlock(data []byte, key [32]byte, tweak uint64) ([]byte, error) {
+	cipher, err := newXTSCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	plaintext := append([]byte(nil), data...)
+	if err := cipher.decrypt(plaintext, tweak); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
 
 // ValidateEncryptionKey validates that an encryption key has the correct length
 func (eh *EncryptionHelper) ValidateEncryptionKey(key []byte) error {
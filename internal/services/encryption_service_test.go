@@ -204,17 +204,17 @@ func TestIsObjectMapValueEncrypted(t *testing.T) {
 		},
 		{
 			name:      "Encrypted flag set",
-			flags:     0x00000001,
+			flags:     0x00000004,
 			encrypted: true,
 		},
 		{
 			name:      "Other flags set, not encrypted",
-			flags:     0x00000002,
+			flags:     0x0000000B,
 			encrypted: false,
 		},
 		{
 			name:      "Encrypted flag and other flags set",
-			flags:     0x00000003,
+			flags:     0x00000006,
 			encrypted: true,
 		},
 		{
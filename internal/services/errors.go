@@ -0,0 +1,10 @@
+package services
+
+import "errors"
+
+// ErrNotFound is returned when a requested path, inode or snapshot does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrLocked is returned when reading encrypted data of a volume that has not
+// been unlocked
+var ErrLocked = errors.New("volume is locked")
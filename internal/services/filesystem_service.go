@@ -1,25 +1,30 @@
 package services
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/deploymenttheory/go-apfs/internal/interfaces"
-	"github.com/deploymenttheory/go-apfs/internal/parsers/btrees"
 	"github.com/deploymenttheory/go-apfs/internal/parsers/file_system_objects"
 	"github.com/deploymenttheory/go-apfs/internal/types"
 )
 
 // FileSystemServiceImpl implements filesystem traversal and directory listing
 type FileSystemServiceImpl struct {
-	container    *ContainerReader
-	resolver     *BTreeObjectResolver
-	volumeOID    types.OidT
-	volumeSB     *types.ApfsSuperblockT
-	rootInodeOID types.OidT
+	container *ContainerReader
+	tree      *fsTree
+	volumeOID types.OidT
+	volumeSB  *types.ApfsSuperblockT
+
+	compressedMu   sync.Mutex
+	lastCompressed *decmpfsFile
 }
 
 // FileEntry represents a file or directory entry
@@ -46,16 +51,42 @@ func NewFileSystemService(container *ContainerReader, volumeOID types.OidT, volu
 	}
 
 	fs := &FileSystemServiceImpl{
-		container:    container,
-		resolver:     NewBTreeObjectResolver(container),
-		volumeOID:    volumeOID,
-		volumeSB:     volumeSB,
-		rootInodeOID: types.OidT(volumeSB.ApfsRootTreeOid),
+		container: container,
+		tree:      newFSTree(container, volumeSB),
+		volumeOID: volumeOID,
+		volumeSB:  volumeSB,
 	}
 
 	return fs, nil
 }
 
+// NewSnapshotFileSystemService creates a FileSystemService for a snapshot of
+// the volume described by volumeSB. The snapshot's tree is resolved through
+// the volume's current object map at the snapshot's transaction, which keeps
+// the mappings of every snapshot, rather than through the object map the
+// snapshot superblock records, which later transactions may have reused.
+func NewSnapshotFileSystemService(container *ContainerReader, volumeOID types.OidT, volumeSB *types.ApfsSuperblockT, snapshot *SnapshotInfo) (*FileSystemServiceImpl, error) {
+	if volumeSB == nil {
+		return nil, fmt.Errorf("volume superblock cannot be nil")
+	}
+	if snapshot == nil || snapshot.SuperblockOID == 0 {
+		return nil, fmt.Errorf("snapshot has no superblock")
+	}
+
+	svs, err := NewVolumeServiceFromPhysicalOID(container, types.OidT(snapshot.SuperblockOID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read superblock of snapshot %d: %w", snapshot.XID, err)
+	}
+	snapSB := svs.GetSuperblock()
+
+	fs, err := NewFileSystemService(container, volumeOID, snapSB)
+	if err != nil {
+		return nil, err
+	}
+	fs.tree = newFSTreeAt(container, volumeSB.ApfsOmapOid, snapSB, types.XidT(snapshot.XID))
+	return fs, nil
+}
+
 // ListDirectory lists all entries in a directory by path
 func (fs *FileSystemServiceImpl) ListDirectory(path string) ([]FileEntry, error) {
 	// Normalize and validate path
@@ -115,276 +146,134 @@ type inodeData struct {
 
 func (fs *FileSystemServiceImpl) getInodeByPath(path string) (types.OidT, error) {
 	if path == "/" {
-		return types.OidT(fs.volumeSB.ApfsRootTreeOid), nil
+		return types.OidT(types.RootDirInoNum), nil
 	}
 
 	parts := strings.Split(strings.Trim(path, "/"), "/")
-	currentInode := types.OidT(fs.volumeSB.ApfsRootTreeOid)
+	currentInode := types.OidT(types.RootDirInoNum)
 
 	for _, part := range parts {
 		if part == "" {
 			continue
 		}
 
-		// List current directory
-		entries, err := fs.listDirectoryContents(currentInode, "/")
+		record, err := fs.lookupDirectoryEntry(currentInode, part)
 		if err != nil {
-			return 0, fmt.Errorf("failed to list directory: %w", err)
-		}
-
-		// Find matching entry
-		found := false
-		for _, entry := range entries {
-			if entry.Name == part {
-				currentInode = types.OidT(entry.Inode)
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			return 0, fmt.Errorf("path component not found: %s", part)
+			return 0, err
 		}
+		currentInode = types.OidT(record.InodeNumber)
 	}
 
 	return currentInode, nil
 }
 
-func (fs *FileSystemServiceImpl) loadInodeData(oid types.OidT) (*inodeData, error) {
-	// The OID we receive is actually a virtual object ID that points to a B-tree node
-	// We need to resolve it and then parse the B-tree node to find the actual inode record
-
-	// Resolve the virtual object ID to physical address
-	physAddr, err := fs.resolver.ResolveVirtualObject(oid, fs.container.GetSuperblock().NxNextXid-1)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve inode OID %d: %w", oid, err)
-	}
-
-	// Read the block containing the B-tree node
-	blockData, err := fs.container.ReadBlock(uint64(physAddr))
-	if err != nil {
-		return nil, fmt.Errorf("failed to read block at %d: %w", physAddr, err)
+// lookupDirectoryEntry finds the record for name among the entries of
+// directory dirInode, comparing names as the volume's flags require. The
+// search descends straight to the records with the name's hash on volumes with
+// hashed keys, and to the name itself otherwise.
+func (fs *FileSystemServiceImpl) lookupDirectoryEntry(dirInode types.OidT, name string) (*DirectoryRecord, error) {
+	r := fsRecordRange{objID: uint64(dirInode), recordType: types.ApfsTypeDirRec}
+	if fs.hashedDirectoryKeys() {
+		hash, err := NewNameHashingService().HashUTF8([]byte(name), fs.caseInsensitive())
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash path component %s: %w", name, err)
+		}
+		r.within = func(key []byte) int {
+			if len(key) < 12 {
+				return -1
+			}
+			return cmp.Compare(binary.LittleEndian.Uint32(key[8:12])>>types.JDrecHashShift, hash)
+		}
+	} else {
+		r.within = func(key []byte) int {
+			return compareKeyName(key, name)
+		}
 	}
 
-	// Parse the block as a B-tree node
-	nodeReader, err := btrees.NewBTreeNodeReader(blockData, binary.LittleEndian)
+	want := fs.comparableName(name)
+	var found *DirectoryRecord
+	err := fs.tree.search(r, func(key, value []byte) error {
+		record, err := fs.parseDirectoryRecord(key, value)
+		if err != nil {
+			return err
+		}
+		if fs.comparableName(record.Name) == want {
+			found = record
+			return errStopWalk
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse B-tree node: %w", err)
+		return nil, fmt.Errorf("failed to look up %s in directory %d: %w", name, dirInode, err)
 	}
-
-	// Search the B-tree node for the inode record
-	return fs.searchBTreeNodeForInode(nodeReader, oid)
-}
-
-// searchBTreeNodeForInode searches a B-tree node for an inode record with the given OID
-func (fs *FileSystemServiceImpl) searchBTreeNodeForInode(nodeReader interfaces.BTreeNodeReader, targetOID types.OidT) (*inodeData, error) {
-	if !nodeReader.IsLeaf() {
-		// For internal nodes, we need to traverse to the correct child
-		return fs.traverseToInodeLeaf(nodeReader, targetOID)
+	if found == nil {
+		return nil, fmt.Errorf("path component %s %w", name, ErrNotFound)
 	}
-
-	// For leaf nodes, search for the inode record
-	return fs.extractInodeFromLeaf(nodeReader, targetOID)
+	return found, nil
 }
 
-// traverseToInodeLeaf traverses an internal B-tree node to find the leaf containing the inode
-func (fs *FileSystemServiceImpl) traverseToInodeLeaf(nodeReader interfaces.BTreeNodeReader, targetOID types.OidT) (*inodeData, error) {
-	tableSpace := nodeReader.TableSpace()
-	nodeData := nodeReader.Data()
-	keyCount := nodeReader.KeyCount()
-
-	btnDataStart := 56
-	tableOffset := btnDataStart + int(tableSpace.Off)
-
-	if tableOffset >= len(nodeData) {
-		return nil, fmt.Errorf("table offset exceeds node data")
+// compareKeyName orders a key that ends in a length-prefixed, NUL-terminated
+// name, such as j_drec_key_t or j_xattr_key_t, against name
+func compareKeyName(key []byte, name string) int {
+	if len(key) < 10 {
+		return -1
 	}
-
-	var targetChildOID types.OidT
-
-	if nodeReader.HasFixedKVSize() {
-		entrySize := 4
-		for i := uint32(0); i < keyCount; i++ {
-			offset := tableOffset + int(i)*entrySize
-			if offset+entrySize > len(nodeData) {
-				break
-			}
-
-			keyOffset := binary.LittleEndian.Uint16(nodeData[offset : offset+2])
-			valueOffset := binary.LittleEndian.Uint16(nodeData[offset+2 : offset+4])
-
-			// Extract and parse the key
-			keyStart := btnDataStart + int(keyOffset)
-			if keyStart+8 <= len(nodeData) {
-				objIdAndType := binary.LittleEndian.Uint64(nodeData[keyStart : keyStart+8])
-				objID := objIdAndType & types.ObjIdMask
-
-				// If this key's object ID is >= our target, use this child
-				if objID >= uint64(targetOID) {
-					valueStart := btnDataStart + int(valueOffset)
-					if valueStart+8 <= len(nodeData) {
-						targetChildOID = types.OidT(binary.LittleEndian.Uint64(nodeData[valueStart : valueStart+8]))
-						break
-					}
-				}
-			}
-		}
-	}
-
-	if targetChildOID == 0 {
-		return nil, fmt.Errorf("no suitable child node found for inode %d", targetOID)
-	}
-
-	// Resolve and read the child node
-	physAddr, err := fs.resolver.ResolveVirtualObject(targetChildOID, fs.container.GetSuperblock().NxNextXid-1)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve child node: %w", err)
+	stored := key[10:]
+	if n := int(binary.LittleEndian.Uint16(key[8:10])); n < len(stored) {
+		stored = stored[:n]
 	}
+	return bytes.Compare(bytes.TrimSuffix(stored, []byte{0}), []byte(name))
+}
 
-	childData, err := fs.container.ReadBlock(uint64(physAddr))
+// loadInodeData finds the inode record of an inode in the file-system tree
+func (fs *FileSystemServiceImpl) loadInodeData(oid types.OidT) (*inodeData, error) {
+	var found *inodeData
+	err := fs.tree.records(uint64(oid), types.ApfsTypeInode, func(key, value []byte) error {
+		found = &inodeData{key: key, value: value}
+		return errStopWalk
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read child node: %w", err)
+		return nil, fmt.Errorf("failed to look up inode %d: %w", oid, err)
 	}
-
-	childReader, err := btrees.NewBTreeNodeReader(childData, binary.LittleEndian)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse child node: %w", err)
+	if found == nil {
+		return nil, fmt.Errorf("inode %d %w", oid, ErrNotFound)
 	}
-
-	// Recursively search the child
-	return fs.searchBTreeNodeForInode(childReader, targetOID)
+	return found, nil
 }
 
-// extractInodeFromLeaf extracts an inode record from a leaf B-tree node
-func (fs *FileSystemServiceImpl) extractInodeFromLeaf(nodeReader interfaces.BTreeNodeReader, targetOID types.OidT) (*inodeData, error) {
-	tableSpace := nodeReader.TableSpace()
-	nodeData := nodeReader.Data()
-	keyCount := nodeReader.KeyCount()
-
-	btnDataStart := 56
-	tableOffset := btnDataStart + int(tableSpace.Off)
-
-	if tableOffset >= len(nodeData) {
-		return nil, fmt.Errorf("table offset exceeds node data")
-	}
-
-	if nodeReader.HasFixedKVSize() {
-		entrySize := 4
-		for i := uint32(0); i < keyCount; i++ {
-			offset := tableOffset + int(i)*entrySize
-			if offset+entrySize > len(nodeData) {
-				break
-			}
-
-			keyOffset := binary.LittleEndian.Uint16(nodeData[offset : offset+2])
-			valueOffset := binary.LittleEndian.Uint16(nodeData[offset+2 : offset+4])
-
-			// Extract key and check if it matches our target inode
-			keyStart := btnDataStart + int(keyOffset)
-			if keyStart+8 <= len(nodeData) {
-				objIdAndType := binary.LittleEndian.Uint64(nodeData[keyStart : keyStart+8])
-				objID := objIdAndType & types.ObjIdMask
-				objType := (objIdAndType & types.ObjTypeMask) >> types.ObjTypeShift
-
-				// Check if this is an inode record for our target OID
-				if objID == uint64(targetOID) && objType == uint64(types.ApfsTypeInode) {
-					// Extract the key and value data
-					valueStart := btnDataStart + int(valueOffset)
-
-					// Determine key and value sizes
-					var keyData, valueData []byte
-
-					// For fixed-size entries, we need to determine the actual sizes
-					// This is simplified - in reality we'd need to look at the B-tree info
-					keySize := 8 // Minimum size for JInodeKeyT
-					if keyStart+keySize <= len(nodeData) {
-						keyData = nodeData[keyStart : keyStart+keySize]
-					}
-
-					// Value size is variable, read what we can
-					if valueStart < len(nodeData) {
-						// Find the next entry to determine value size, or use remaining data
-						var valueSize int
-						if i+1 < keyCount {
-							// Get next entry's offset to calculate this value's size
-							nextOffset := tableOffset + int(i+1)*entrySize
-							if nextOffset+2 <= len(nodeData) {
-								nextKeyOffset := binary.LittleEndian.Uint16(nodeData[nextOffset : nextOffset+2])
-								nextKeyStart := btnDataStart + int(nextKeyOffset)
-								valueSize = nextKeyStart - valueStart
-							}
-						} else {
-							// Last entry, use remaining data
-							valueSize = len(nodeData) - valueStart
-						}
-
-						if valueSize > 0 && valueStart+valueSize <= len(nodeData) {
-							valueData = nodeData[valueStart : valueStart+valueSize]
-						}
-					}
+// listDirectoryContents returns the entries of a directory from the directory
+// records keyed by its inode number
+func (fs *FileSystemServiceImpl) listDirectoryContents(dirInode types.OidT, dirPath string) ([]FileEntry, error) {
+	var entries []FileEntry
+	err := fs.tree.records(uint64(dirInode), types.ApfsTypeDirRec, func(key, value []byte) error {
+		record, err := fs.parseDirectoryRecord(key, value)
+		if err != nil {
+			return err
+		}
 
-					if len(keyData) > 0 && len(valueData) > 0 {
-						return &inodeData{
-							key:   keyData,
-							value: valueData,
-						}, nil
+		entry := FileEntry{
+			Inode: record.InodeNumber,
+			Name:  record.Name,
+			Path:  filepath.Join(dirPath, record.Name),
+			IsDir: uint16(record.FileType) == types.DtDir,
+		}
+		if data, err := fs.loadInodeData(types.OidT(record.InodeNumber)); err == nil {
+			if inode, err := file_system_objects.NewInodeReader(data.key, data.value, binary.LittleEndian); err == nil {
+				entry.Size = inode.Size()
+				if isCompressed(inode) {
+					if size, err := fs.compressedSize(record.InodeNumber); err == nil {
+						entry.Size = size
 					}
 				}
+				entry.Mode = uint16(inode.Mode())
+				entry.Modified = uint64(inode.ModificationTime().UnixNano())
 			}
 		}
-	}
-
-	return nil, fmt.Errorf("inode %d not found in leaf node", targetOID)
-}
-
-func (fs *FileSystemServiceImpl) listDirectoryContents(dirInode types.OidT, dirPath string) ([]FileEntry, error) {
-	var entries []FileEntry
-
-	// Load directory inode
-	inodeData, err := fs.loadInodeData(dirInode)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load directory inode: %w", err)
-	}
-
-	// Parse inode to get directory extents
-	inodeReader, err := file_system_objects.NewInodeReader(inodeData.key, inodeData.value, binary.LittleEndian)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse directory inode: %w", err)
-	}
-
-	if !inodeReader.IsDirectory() {
-		return nil, fmt.Errorf("inode is not a directory")
-	}
-
-	// Get file extents from directory inode
-	extents, err := fs.getFileExtents(inodeReader)
+		entries = append(entries, entry)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file extents: %w", err)
-	}
-	if len(extents) == 0 {
-		return entries, nil // Empty directory
-	}
-
-	// Read directory data from extents
-	for _, extent := range extents {
-		startBlock := extent.PhysicalBlock
-		blockCount := extent.PhysicalSize / uint64(fs.container.GetBlockSize())
-
-		// Read all blocks in this extent
-		for i := uint64(0); i < blockCount; i++ {
-			blockData, err := fs.container.ReadBlock(startBlock + i)
-			if err != nil {
-				continue // Skip corrupted blocks
-			}
-
-			// Parse directory entries from this block
-			blockEntries, err := fs.parseDirectoryBlock(blockData, dirPath)
-			if err != nil {
-				continue // Skip unparseable blocks
-			}
-
-			entries = append(entries, blockEntries...)
-		}
+		return nil, fmt.Errorf("failed to read directory records of inode %d: %w", dirInode, err)
 	}
 
 	return entries, nil
@@ -412,78 +301,6 @@ func (fs *FileSystemServiceImpl) walkTreeRecursive(dirPath string, callback func
 	return nil
 }
 
-// parseDirectoryBlock parses directory entries from a block of data
-func (fs *FileSystemServiceImpl) parseDirectoryBlock(blockData []byte, dirPath string) ([]FileEntry, error) {
-	var entries []FileEntry
-
-	// Directory blocks contain B-tree nodes with directory records
-	// Try to parse as B-tree node first
-	nodeReader, err := fs.parseDirectoryBTreeNode(blockData)
-	if err != nil {
-		// If B-tree parsing fails, try direct directory record parsing
-		return fs.parseDirectoryRecords(blockData, dirPath)
-	}
-
-	// If it's a B-tree node, extract directory records from it
-	if nodeReader != nil && nodeReader.IsLeaf() {
-		entries = fs.extractDirectoryEntriesFromBTreeNode(nodeReader, dirPath)
-	}
-
-	return entries, nil
-}
-
-// parseDirectoryBTreeNode attempts to parse block data as a B-tree node
-func (fs *FileSystemServiceImpl) parseDirectoryBTreeNode(blockData []byte) (interfaces.BTreeNodeReader, error) {
-	// Check if this looks like a B-tree node by examining the header
-	if len(blockData) < 56 {
-		return nil, fmt.Errorf("block too small for B-tree node")
-	}
-
-	// Check object type in header
-	objType := binary.LittleEndian.Uint32(blockData[24:28])
-	if objType&0x0000FFFF != types.ObjectTypeBtreeNode {
-		return nil, fmt.Errorf("not a B-tree node")
-	}
-
-	// Parse as B-tree node using the existing btrees package
-	return fs.parseBTreeNodeForDirectory(blockData)
-}
-
-// parseDirectoryRecords parses directory records directly from block data
-func (fs *FileSystemServiceImpl) parseDirectoryRecords(blockData []byte, dirPath string) ([]FileEntry, error) {
-	var entries []FileEntry
-	offset := 0
-
-	// Parse directory records sequentially
-	for offset+16 < len(blockData) { // Minimum record size
-		// Try to parse a directory record at this offset
-		record, recordSize, err := fs.parseDirectoryRecord(blockData[offset:])
-		if err != nil {
-			break // End of valid records
-		}
-
-		if record != nil {
-			entry := FileEntry{
-				Inode:    record.InodeNumber,
-				Name:     record.Name,
-				Path:     filepath.Join(dirPath, record.Name),
-				IsDir:    uint16(record.FileType) == types.DtDir,
-				Size:     0, // Size not available in directory record
-				Mode:     0, // Mode not available in directory record
-				Modified: 0, // Modified time not available in directory record
-			}
-			entries = append(entries, entry)
-		}
-
-		offset += recordSize
-		if recordSize == 0 {
-			break // Prevent infinite loop
-		}
-	}
-
-	return entries, nil
-}
-
 // DirectoryRecord represents a parsed directory record
 type DirectoryRecord struct {
 	InodeNumber uint64
@@ -491,50 +308,39 @@ type DirectoryRecord struct {
 	FileType    uint8
 }
 
-// parseDirectoryRecord parses a single directory record from data
-func (fs *FileSystemServiceImpl) parseDirectoryRecord(data []byte) (*DirectoryRecord, int, error) {
-	if len(data) < 16 {
-		return nil, 0, fmt.Errorf("insufficient data for directory record")
-	}
-
-	// Parse APFS directory record structure
-	// This is a simplified version - actual APFS dir records are more complex
-	inodeNumber := binary.LittleEndian.Uint64(data[0:8])
-	nameLength := binary.LittleEndian.Uint16(data[8:10])
-	fileType := data[10]
-
-	if nameLength == 0 || int(nameLength) > len(data)-16 {
-		return nil, 0, fmt.Errorf("invalid name length")
+// parseDirectoryRecord decodes a directory record. Volumes that are case or
+// normalization insensitive store the name hash in the key.
+func (fs *FileSystemServiceImpl) parseDirectoryRecord(key, value []byte) (*DirectoryRecord, error) {
+	reader, err := file_system_objects.NewDirectoryEntryReader(key, value, binary.LittleEndian, fs.hashedDirectoryKeys())
+	if err != nil {
+		return nil, err
 	}
 
-	name := string(data[16 : 16+nameLength])
-	recordSize := 16 + int(nameLength)
-
-	// Align to 8-byte boundary
-	if recordSize%8 != 0 {
-		recordSize += 8 - (recordSize % 8)
-	}
+	return &DirectoryRecord{
+		InodeNumber: reader.FileID(),
+		Name:        reader.FileName(),
+		FileType:    uint8(types.DirRecFlags(reader.FileType()) & types.DrecTypeMask),
+	}, nil
+}
 
-	record := &DirectoryRecord{
-		InodeNumber: inodeNumber,
-		Name:        name,
-		FileType:    fileType,
+// comparableName returns the form in which a path lookup compares a name:
+// case-folded and decomposed on case-insensitive volumes, decomposed on
+// normalization-insensitive ones, and as stored otherwise
+func (fs *FileSystemServiceImpl) comparableName(name string) string {
+	if !fs.hashedDirectoryKeys() {
+		return name
 	}
-
-	return record, recordSize, nil
+	return normalizeFileName(name, fs.caseInsensitive())
 }
 
-// parseBTreeNodeForDirectory parses a B-tree node containing directory entries
-func (fs *FileSystemServiceImpl) parseBTreeNodeForDirectory(blockData []byte) (interfaces.BTreeNodeReader, error) {
-	// Use the existing btrees package to parse the node
-	return btrees.NewBTreeNodeReader(blockData, binary.LittleEndian)
+// caseInsensitive reports whether the volume ignores case in file names
+func (fs *FileSystemServiceImpl) caseInsensitive() bool {
+	return fs.volumeSB.ApfsIncompatibleFeatures&types.ApfsIncompatCaseInsensitive != 0
 }
 
-// extractDirectoryEntriesFromBTreeNode extracts directory entries from a B-tree leaf node
-func (fs *FileSystemServiceImpl) extractDirectoryEntriesFromBTreeNode(nodeReader interfaces.BTreeNodeReader, dirPath string) []FileEntry {
-	// This would extract actual directory entries from the B-tree node
-	// For now, return empty slice
-	return []FileEntry{}
+// hashedDirectoryKeys reports whether directory record keys carry a name hash
+func (fs *FileSystemServiceImpl) hashedDirectoryKeys() bool {
+	return fs.volumeSB.ApfsIncompatibleFeatures&(types.ApfsIncompatCaseInsensitive|types.ApfsIncompatNormalizationInsensitive) != 0
 }
 
 // IsDirectory checks if a path is a directory
@@ -550,7 +356,7 @@ func (fs *FileSystemServiceImpl) IsDirectory(path string) (bool, error) {
 func (fs *FileSystemServiceImpl) Exists(path string) (bool, error) {
 	_, err := fs.GetInodeByPath(path)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, ErrNotFound) {
 			return false, nil
 		}
 		return false, err
@@ -558,220 +364,51 @@ func (fs *FileSystemServiceImpl) Exists(path string) (bool, error) {
 	return true, nil
 }
 
-// getFileExtents extracts file extents from an inode by looking up extent records
-func (fs *FileSystemServiceImpl) getFileExtents(inodeReader interfaces.InodeReader) ([]ExtentMapping, error) {
-	var extents []ExtentMapping
-
-	// Get the private ID from the inode - this is used to look up extent records
-	privateID := inodeReader.PrivateID()
-	if privateID == 0 {
-		return extents, nil // No data stream
-	}
-
-	// In APFS, file extents are stored as separate records in the volume's B-tree
-	// We need to search for records of type APFS_TYPE_FILE_EXTENT with our private ID
-	// This requires B-tree traversal of the volume's filesystem records
-
-	// For now, implement a simplified version that would need to be expanded
-	// with proper B-tree searching for extent records
-
-	// The actual implementation would:
-	// 1. Search the volume's filesystem B-tree for records with:
-	//    - Object type: APFS_TYPE_FILE_EXTENT
-	//    - Object ID: privateID
-	// 2. Parse each JFileExtentKeyT/JFileExtentValT pair
-	// 3. Convert to ExtentMapping structs
-
-	extent, err := fs.findFileExtentForPrivateID(privateID)
-	if err != nil {
-		return extents, nil // No extents found
-	}
-
-	if extent != nil {
-		extents = append(extents, *extent)
-	}
-
-	return extents, nil
-}
-
-// findFileExtentForPrivateID searches for file extent records for a given private ID
-func (fs *FileSystemServiceImpl) findFileExtentForPrivateID(privateID uint64) (*ExtentMapping, error) {
-	// Get the volume's filesystem tree OID
-	fsTreeOID := types.OidT(fs.volumeSB.ApfsRootTreeOid)
-	if fsTreeOID == 0 {
-		return nil, fmt.Errorf("volume filesystem tree OID is zero")
-	}
-
-	// Resolve the filesystem tree OID to physical address
-	physAddr, err := fs.resolver.ResolveVirtualObject(fsTreeOID, fs.container.GetSuperblock().NxNextXid-1)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve filesystem tree OID: %w", err)
-	}
-
-	// Read the filesystem tree root node
-	treeData, err := fs.container.ReadBlock(uint64(physAddr))
+// SetVolumeKey sets the volume encryption key used to decrypt the file-system
+// tree and file data of a software-encrypted volume. It is safe to call while
+// other goroutines read the volume.
+func (fs *FileSystemServiceImpl) SetVolumeKey(vek []byte) error {
+	cipher, err := newXTSCipher(vek)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read filesystem tree root: %w", err)
+		return fmt.Errorf("invalid volume encryption key: %w", err)
 	}
-
-	// Parse as B-tree node
-	nodeReader, err := btrees.NewBTreeNodeReader(treeData, binary.LittleEndian)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse filesystem tree node: %w", err)
-	}
-
-	// Search for file extent records with matching private ID
-	return fs.searchFileSystemTreeForExtents(nodeReader, privateID)
+	fs.tree.setVolumeCipher(cipher)
+	return nil
 }
 
-// searchFileSystemTreeForExtents searches the filesystem B-tree for file extent records
-func (fs *FileSystemServiceImpl) searchFileSystemTreeForExtents(nodeReader interfaces.BTreeNodeReader, privateID uint64) (*ExtentMapping, error) {
-	if !nodeReader.IsLeaf() {
-		// For internal nodes, we need to traverse to children
-		return fs.traverseInternalNode(nodeReader, privateID)
+// getFileExtents returns the file extent records of an inode's data stream in
+// logical order
+func (fs *FileSystemServiceImpl) getFileExtents(inodeReader interfaces.InodeReader) ([]ExtentMapping, error) {
+	privateID := inodeReader.PrivateID()
+	if privateID == 0 {
+		return nil, nil // No data stream
 	}
-
-	// For leaf nodes, parse the records looking for file extent records
-	return fs.parseLeafNodeForExtents(nodeReader, privateID)
+	return fs.streamExtents(privateID)
 }
 
-// traverseInternalNode traverses an internal B-tree node to find the correct child
-func (fs *FileSystemServiceImpl) traverseInternalNode(nodeReader interfaces.BTreeNodeReader, privateID uint64) (*ExtentMapping, error) {
-	// Get table space and parse entries
-	tableSpace := nodeReader.TableSpace()
-	nodeData := nodeReader.Data()
-	keyCount := nodeReader.KeyCount()
-
-	// Calculate table offset - table is relative to btn_data which starts at offset 56
-	btnDataStart := 56
-	tableOffset := btnDataStart + int(tableSpace.Off)
-
-	if tableOffset >= len(nodeData) {
-		return nil, fmt.Errorf("table offset exceeds node data")
-	}
-
-	// Parse table entries to find the appropriate child node
-	var targetChildOID types.OidT
-
-	if nodeReader.HasFixedKVSize() {
-		// Fixed-size entries (kvoff_t)
-		entrySize := 4
-		for i := uint32(0); i < keyCount; i++ {
-			offset := tableOffset + int(i)*entrySize
-			if offset+entrySize > len(nodeData) {
-				break
-			}
-
-			keyOffset := binary.LittleEndian.Uint16(nodeData[offset : offset+2])
-			valueOffset := binary.LittleEndian.Uint16(nodeData[offset+2 : offset+4])
-
-			// Extract and parse the key
-			keyStart := btnDataStart + int(keyOffset)
-			if keyStart+16 <= len(nodeData) {
-				// Parse the key as JKeyT to check object ID and type
-				objIdAndType := binary.LittleEndian.Uint64(nodeData[keyStart : keyStart+8])
-				objID := objIdAndType & types.ObjIdMask
-
-				// If this key's object ID is >= our target, use this child
-				if objID >= privateID {
-					// Extract child OID from value
-					valueStart := btnDataStart + int(valueOffset)
-					if valueStart+8 <= len(nodeData) {
-						targetChildOID = types.OidT(binary.LittleEndian.Uint64(nodeData[valueStart : valueStart+8]))
-						break
-					}
-				}
-			}
+// streamExtents returns the file extent records of the data stream streamID,
+// which is an inode's private identifier or an extended attribute's stream
+func (fs *FileSystemServiceImpl) streamExtents(streamID uint64) ([]ExtentMapping, error) {
+	var extents []ExtentMapping
+	err := fs.tree.records(streamID, types.ApfsTypeFileExtent, func(key, value []byte) error {
+		if len(key) < 16 || len(value) < 24 {
+			return fmt.Errorf("short file extent record for data stream %d", streamID)
 		}
-	}
-
-	if targetChildOID == 0 {
-		return nil, fmt.Errorf("no suitable child node found")
-	}
-
-	// Resolve and read the child node
-	physAddr, err := fs.resolver.ResolveVirtualObject(targetChildOID, fs.container.GetSuperblock().NxNextXid-1)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve child node: %w", err)
-	}
-
-	childData, err := fs.container.ReadBlock(uint64(physAddr))
-	if err != nil {
-		return nil, fmt.Errorf("failed to read child node: %w", err)
-	}
-
-	childReader, err := btrees.NewBTreeNodeReader(childData, binary.LittleEndian)
+		length := binary.LittleEndian.Uint64(value[0:8]) & types.JFileExtentLenMask
+		extents = append(extents, ExtentMapping{
+			LogicalOffset: binary.LittleEndian.Uint64(key[8:16]),
+			LogicalSize:   length,
+			PhysicalBlock: binary.LittleEndian.Uint64(value[8:16]),
+			PhysicalSize:  length,
+			CryptoID:      binary.LittleEndian.Uint64(value[16:24]),
+		})
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse child node: %w", err)
+		return nil, fmt.Errorf("failed to read file extents of data stream %d: %w", streamID, err)
 	}
 
-	// Recursively search the child
-	return fs.searchFileSystemTreeForExtents(childReader, privateID)
-}
-
-// parseLeafNodeForExtents parses a leaf node looking for file extent records
-func (fs *FileSystemServiceImpl) parseLeafNodeForExtents(nodeReader interfaces.BTreeNodeReader, privateID uint64) (*ExtentMapping, error) {
-	tableSpace := nodeReader.TableSpace()
-	nodeData := nodeReader.Data()
-	keyCount := nodeReader.KeyCount()
-
-	btnDataStart := 56
-	tableOffset := btnDataStart + int(tableSpace.Off)
-
-	if tableOffset >= len(nodeData) {
-		return nil, fmt.Errorf("table offset exceeds node data")
-	}
-
-	// Parse table entries looking for file extent records
-	if nodeReader.HasFixedKVSize() {
-		entrySize := 4
-		for i := uint32(0); i < keyCount; i++ {
-			offset := tableOffset + int(i)*entrySize
-			if offset+entrySize > len(nodeData) {
-				break
-			}
-
-			keyOffset := binary.LittleEndian.Uint16(nodeData[offset : offset+2])
-			valueOffset := binary.LittleEndian.Uint16(nodeData[offset+2 : offset+4])
-
-			// Extract key and check if it's a file extent record
-			keyStart := btnDataStart + int(keyOffset)
-			if keyStart+16 <= len(nodeData) {
-				// Parse JFileExtentKeyT
-				objIdAndType := binary.LittleEndian.Uint64(nodeData[keyStart : keyStart+8])
-				objID := objIdAndType & types.ObjIdMask
-				objType := (objIdAndType & types.ObjTypeMask) >> types.ObjTypeShift
-
-				// Check if this is a file extent record for our private ID
-				if objID == privateID && objType == uint64(types.ApfsTypeFileExtent) {
-					// Extract logical address from key
-					logicalAddr := binary.LittleEndian.Uint64(nodeData[keyStart+8 : keyStart+16])
-
-					// Extract value (JFileExtentValT)
-					valueStart := btnDataStart + int(valueOffset)
-					if valueStart+24 <= len(nodeData) {
-						lenAndFlags := binary.LittleEndian.Uint64(nodeData[valueStart : valueStart+8])
-						physBlockNum := binary.LittleEndian.Uint64(nodeData[valueStart+8 : valueStart+16])
-
-						// Extract length from flags field
-						physicalSize := lenAndFlags & types.JFileExtentLenMask
-
-						return &ExtentMapping{
-							LogicalOffset:   logicalAddr,
-							LogicalSize:     physicalSize,
-							PhysicalBlock:   physBlockNum,
-							PhysicalSize:    physicalSize,
-							IsCompressed:    false, // Would need to check flags
-							CompressionType: "",
-							IsEncrypted:     false, // Would need to check encryption fields
-						}, nil
-					}
-				}
-			}
-		}
-	}
-
-	return nil, fmt.Errorf("no file extent found for private ID %d", privateID)
+	return extents, nil
 }
 
 // GetInodeByPath gets the inode for a given path and returns FileNode metadata
@@ -784,17 +421,7 @@ func (fs *FileSystemServiceImpl) GetInodeByPath(path string) (*FileNode, error)
 	// Get the inode OID
 	inodeOID, err := fs.getInodeByPath(path)
 	if err != nil {
-		// If we can't get inode by path, try alternative approaches
-		// This handles forensic scenarios where object maps might be incomplete
-		if path == "/" {
-			// For root, try to find it by scanning
-			inodeOID, err = fs.findRootTreeByScanning()
-			if err != nil {
-				return nil, fmt.Errorf("failed to get inode for path %s: %w", path, err)
-			}
-		} else {
-			return nil, fmt.Errorf("failed to get inode for path %s: %w", path, err)
-		}
+		return nil, fmt.Errorf("failed to get inode for path %s: %w", path, err)
 	}
 
 	// Load inode data
@@ -813,59 +440,6 @@ func (fs *FileSystemServiceImpl) GetInodeByPath(path string) (*FileNode, error)
 	return fs.inodeToFileNode(path, inodeOID, inodeReader)
 }
 
-// findRootTreeByScanning scans the container for the root tree B-tree structure
-// This is used when object map lookup fails (forensic/recovery scenarios)
-func (fs *FileSystemServiceImpl) findRootTreeByScanning() (types.OidT, error) {
-	// The root tree OID is stored in volumeSB.ApfsRootTreeOid
-	// In normal scenarios, this is a virtual OID that needs resolving through the object map
-	// In forensic scenarios (empty object maps), we try the OID as a physical address
-	rootTreeOID := types.OidT(fs.volumeSB.ApfsRootTreeOid)
-
-	// Try treating it as a direct physical block address first
-	blockData, err := fs.container.ReadBlock(uint64(rootTreeOID))
-	if err == nil && isValidBTreeNode(blockData) {
-		return rootTreeOID, nil
-	}
-
-	// Try scanning nearby blocks for B-tree node structure
-	for scanOffset := uint64(0); scanOffset < 100; scanOffset++ {
-		blockData, err := fs.container.ReadBlock(uint64(rootTreeOID) + scanOffset)
-		if err == nil && isValidBTreeNode(blockData) {
-			return types.OidT(uint64(rootTreeOID) + scanOffset), nil
-		}
-	}
-
-	return 0, fmt.Errorf("could not locate root tree (OID=%d) via object map or scanning", rootTreeOID)
-}
-
-// isValidBTreeNode checks if a block contains a valid B-tree node structure
-func isValidBTreeNode(data []byte) bool {
-	if len(data) < 64 {
-		return false
-	}
-
-	// B-tree nodes start with obj_phys_t header
-	// Check for valid object header pattern
-	// The flags field at offset 8 should have reasonable values
-	flags := uint32(data[8]) | uint32(data[9])<<8 | uint32(data[10])<<16 | uint32(data[11])<<24
-
-	// Check if this looks like a B-tree node (flags shouldn't be all zeros or all ones)
-	if flags == 0 || flags == 0xFFFFFFFF {
-		return false
-	}
-
-	// Check B-tree specific fields
-	// btn_level at offset 76 should be reasonable (0-20 for most trees)
-	if len(data) >= 78 {
-		level := uint16(data[76]) | uint16(data[77])<<8
-		if level > 100 {
-			return false
-		}
-	}
-
-	return true
-}
-
 // ListDirectoryContents lists all entries in a directory by inode ID
 func (fs *FileSystemServiceImpl) ListDirectoryContents(inodeID uint64) ([]*FileNode, error) {
 	// Load inode data
@@ -1060,6 +634,12 @@ func (fs *FileSystemServiceImpl) inodeToFileNode(path string, inodeID types.OidT
 	mode := inodeReader.Mode()
 	// Get file size from inode - this is calculated from the data stream size
 	size := inodeReader.Size()
+	compressed := isCompressed(inodeReader)
+	if compressed {
+		if uncompressed, err := fs.compressedSize(uint64(inodeID)); err == nil {
+			size = uncompressed
+		}
+	}
 	parentID := inodeReader.ParentID()
 	mtime := inodeReader.ModificationTime()
 	ctime := inodeReader.ChangeTime()
@@ -1091,6 +671,7 @@ func (fs *FileSystemServiceImpl) inodeToFileNode(path string, inodeID types.OidT
 		IsDirectory:   isDir,
 		IsSymlink:     isSymlink,
 		IsEncrypted:   isEncrypted,
+		IsCompressed:  compressed,
 		ParentInode:   parentID,
 		HardLinkCount: hardLinkCount,
 		Flags:         flags,
@@ -1138,8 +719,29 @@ func (fs *FileSystemServiceImpl) ReadFile(inodeID uint64) ([]byte, error) {
 
 // ReadFileRange reads a specific range of bytes from a file
 func (fs *FileSystemServiceImpl) ReadFileRange(inodeID uint64, offset, length uint64) ([]byte, error) {
+	// Load inode data
+	inodeData, err := fs.loadInodeData(types.OidT(inodeID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load inode data: %w", err)
+	}
+
+	// Parse inode
+	inodeReader, err := file_system_objects.NewInodeReader(inodeData.key, inodeData.value, binary.LittleEndian)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inode: %w", err)
+	}
+
+	// Compressed files keep their data in the decmpfs attribute or resource fork
+	if isCompressed(inodeReader) {
+		file, err := fs.compressedFile(inodeID)
+		if err != nil {
+			return nil, err
+		}
+		return file.readRange(offset, length)
+	}
+
 	// Get file extents
-	extents, err := fs.GetFileExtents(inodeID)
+	extents, err := fs.getFileExtents(inodeReader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file extents: %w", err)
 	}
@@ -1148,6 +750,12 @@ func (fs *FileSystemServiceImpl) ReadFileRange(inodeID uint64, offset, length ui
 		return nil, fmt.Errorf("file has no extents")
 	}
 
+	return fs.readExtentRange(extents, offset, length)
+}
+
+// readExtentRange reads length bytes at offset from the data stream made up
+// of extents
+func (fs *FileSystemServiceImpl) readExtentRange(extents []ExtentMapping, offset, length uint64) ([]byte, error) {
 	// Build a map of logical to physical extents for efficient access
 	var data []byte
 	currentLogicalOffset := uint64(0)
@@ -1175,13 +783,6 @@ func (fs *FileSystemServiceImpl) ReadFileRange(inodeID uint64, offset, length ui
 				// Sparse extent - return zeros without reading from disk
 				zeroData := make([]byte, bytesToRead)
 				data = append(data, zeroData...)
-			} else if extent.IsCompressed {
-				// Handle compressed extent
-				extentData, err := fs.readCompressedExtent(extent, readStartInExtent, bytesToRead)
-				if err != nil {
-					return nil, fmt.Errorf("failed to read compressed extent: %w", err)
-				}
-				data = append(data, extentData...)
 			} else {
 				// Read from the physical location
 				physicalOffset := extent.PhysicalBlock*uint64(fs.container.GetBlockSize()) + readStartInExtent
@@ -1193,12 +794,19 @@ func (fs *FileSystemServiceImpl) ReadFileRange(inodeID uint64, offset, length ui
 				remainingBytes := bytesToRead
 				currentBlock := blockOffset
 				blockInternalPos := blockInternalOffset
+				cipher := fs.tree.volumeCipher()
 
 				for remainingBytes > 0 {
 					blockData, err := fs.container.ReadBlock(currentBlock)
 					if err != nil {
 						return nil, fmt.Errorf("failed to read block %d: %w", currentBlock, err)
 					}
+					if cipher != nil {
+						unit := extent.CryptoID + (currentBlock-extent.PhysicalBlock)*uint64(len(blockData))/xtsUnitSize
+						if err := cipher.decrypt(blockData, unit); err != nil {
+							return nil, fmt.Errorf("failed to decrypt block %d: %w", currentBlock, err)
+						}
+					}
 
 					// Calculate how many bytes to read from this block
 					bytesInBlock := uint64(len(blockData)) - blockInternalPos
@@ -1232,69 +840,6 @@ func (fs *FileSystemServiceImpl) ReadFileRange(inodeID uint64, offset, length ui
 	return data, nil
 }
 
-// readCompressedExtent reads and decompresses a compressed extent
-func (fs *FileSystemServiceImpl) readCompressedExtent(extent ExtentMapping, offsetInExtent, bytesToRead uint64) ([]byte, error) {
-	// Read the compressed data from the physical location
-	blockSize := fs.container.GetBlockSize()
-	physicalOffset := extent.PhysicalBlock * uint64(blockSize)
-
-	// Read the entire compressed block(s)
-	blockOffset := physicalOffset / uint64(blockSize)
-	compressedData, err := fs.container.ReadBlock(blockOffset)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read compressed block: %w", err)
-	}
-
-	// Parse compression method from the type string or infer from data
-	var compressionMethod types.CompressionMethodType
-	switch extent.CompressionType {
-	case "DEFLATE":
-		compressionMethod = types.CompressionMethodDeflate
-	case "LZFSE":
-		compressionMethod = types.CompressionMethodLzfse
-	case "LZVN":
-		compressionMethod = types.CompressionMethodLzvn
-	case "LZ4":
-		compressionMethod = types.CompressionMethodLz4
-	case "ZSTD":
-		compressionMethod = types.CompressionMethodZstd
-	default:
-		// Try to infer from compressed data header
-		if len(compressedData) >= 4 {
-			signature := binary.LittleEndian.Uint32(compressedData[0:4])
-			if signature == types.CompressionSignature {
-				// This is a compressed data block with header
-				if len(compressedData) < 16 {
-					return nil, fmt.Errorf("insufficient data for compression header")
-				}
-				compressionMethod = types.CompressionMethodType(binary.LittleEndian.Uint32(compressedData[4:8]))
-			} else {
-				compressionMethod = types.CompressionMethodDeflate // Default assumption
-			}
-		} else {
-			return nil, fmt.Errorf("unknown compression method: %s", extent.CompressionType)
-		}
-	}
-
-	// Decompress the data
-	compressionService := NewCompressionService()
-	decompressed, err := compressionService.Decompress(compressedData, compressionMethod)
-	if err != nil {
-		return nil, fmt.Errorf("decompression failed: %w", err)
-	}
-
-	// Extract the requested range from decompressed data
-	if offsetInExtent+bytesToRead > uint64(len(decompressed)) {
-		bytesToRead = uint64(len(decompressed)) - offsetInExtent
-	}
-
-	if offsetInExtent >= uint64(len(decompressed)) {
-		return nil, fmt.Errorf("offset beyond decompressed data size")
-	}
-
-	return decompressed[offsetInExtent : offsetInExtent+bytesToRead], nil
-}
-
 // GetFileSize returns the size of a file in bytes
 func (fs *FileSystemServiceImpl) GetFileSize(inodeID uint64) (uint64, error) {
 	// Load inode data
@@ -1309,10 +854,11 @@ func (fs *FileSystemServiceImpl) GetFileSize(inodeID uint64) (uint64, error) {
 		return 0, fmt.Errorf("failed to parse inode: %w", err)
 	}
 
-	// Return size - for regular files, this is the size field
-	// For compressed files, we need to use UncompressedSize
-	size := inodeReader.Size()
-	return size, nil
+	// Compressed files record their size in the decmpfs header
+	if isCompressed(inodeReader) {
+		return fs.compressedSize(inodeID)
+	}
+	return inodeReader.Size(), nil
 }
 
 // CreateFileReader creates an io.Reader for streaming file content
@@ -1345,36 +891,6 @@ func (fs *FileSystemServiceImpl) CreateFileSeeker(inodeID uint64) (io.ReadSeeker
 	}, nil
 }
 
-// GetExtendedAttributes retrieves all extended attributes for an inode
-func (fs *FileSystemServiceImpl) GetExtendedAttributes(inodeID uint64) (map[string][]byte, error) {
-	// Load inode data
-	inodeData, err := fs.loadInodeData(types.OidT(inodeID))
-	if err != nil {
-		return nil, fmt.Errorf("failed to load inode data: %w", err)
-	}
-
-	// Parse inode
-	inodeReader, err := file_system_objects.NewInodeReader(inodeData.key, inodeData.value, binary.LittleEndian)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse inode: %w", err)
-	}
-
-	// Query the filesystem tree for extended attributes
-	attributes := make(map[string][]byte)
-
-	// Scan the file system B-tree for extended attribute records
-	// Extended attributes are stored as separate B-tree entries
-	// This would require traversing the B-tree looking for EA records related to this inode
-	// For now, return empty map as a placeholder
-	// Full implementation would require:
-	// 1. Search B-tree for EA keys matching this inode OID
-	// 2. Parse each EA value
-	// 3. Aggregate into the map
-
-	_ = inodeReader // Use the reader
-	return attributes, nil
-}
-
 // GetFileExtents returns all extents for a file (already implemented, needed for interface)
 // This is a duplicate but required by the interface
 
@@ -1,9 +1,12 @@
 package services
 
 import (
+	"encoding/binary"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -817,3 +820,390 @@ func TestFileReadingWithMultipleDMGs(t *testing.T) {
 		})
 	}
 }
+
+// buildFileSystemImage opens the container written by newFileSystemImage and
+// reads the superblock of its volume
+func buildFileSystemImage(t *testing.T) (*ContainerReader, *types.ApfsSuperblockT) {
+	t.Helper()
+	cr := newFileSystemImage().containerReader(t)
+	vs, err := NewVolumeService(cr, 1026)
+	require.NoError(t, err)
+	return cr, vs.GetSuperblock()
+}
+
+// newFileSystemImage creates a container holding volume 1026, whose
+// file-system tree is spread over two leaves and resolved through the volume's
+// own object map. The container object map also maps the tree's root OID, to
+// a block that is not a tree node, so resolving through the wrong map fails.
+func newFileSystemImage() *testImage {
+	img := newTestImage(64)
+	img.writeContainerSuperblock(20, 10, 1026)
+	img.writeObjectMap(10, 15,
+		omapRecord(1026, 5, 20, 0),
+		omapRecord(1026, 15, 21, 0),
+		omapRecord(1028, 15, 50, 0),
+	)
+	img.putObject(20, buildVolumeSuperblock(testVolume{oid: 1026, xid: 5, name: "Old"}))
+	img.putObject(21, buildVolumeSuperblock(testVolume{
+		oid: 1026, xid: 15, name: "Data", omapOID: 30, rootTreeOID: 1028,
+		incompat: types.ApfsIncompatNormalizationInsensitive,
+	}))
+
+	mappings := img.writeFSTree(1028, 40, 15,
+		[]btreeRecord{
+			inodeRecord(2, 1, 0o040755, 0),
+			drecRecord(2, "hello.txt", 17, types.DtReg),
+		},
+		[]btreeRecord{
+			drecRecord(2, "docs", 16, types.DtDir),
+			inodeRecord(16, 2, 0o040755, 0),
+			drecRecord(16, "a.txt", 18, types.DtReg),
+			inodeRecord(17, 2, 0o100644, 11),
+			extentRecord(17, 0, testBlockSize, 50),
+			inodeRecord(18, 16, 0o100600, testBlockSize+4),
+			extentRecord(18, 0, testBlockSize, 51),
+			extentRecord(18, testBlockSize, testBlockSize, 52),
+		},
+	)
+	img.writeObjectMap(30, 15, mappings...)
+
+	copy(img.blocks[50], "hello world")
+	for i := range img.blocks[51] {
+		img.blocks[51][i] = 'a'
+		img.blocks[52][i] = 'b'
+	}
+	return img
+}
+
+func TestFileSystemServiceVolumeObjectMap(t *testing.T) {
+	cr, volumeSB := buildFileSystemImage(t)
+	assert.Equal(t, types.OidT(30), volumeSB.ApfsOmapOid)
+
+	fs, err := NewFileSystemService(cr, 1026, volumeSB)
+	require.NoError(t, err)
+
+	root, err := fs.ListDirectory("/")
+	require.NoError(t, err)
+	require.Len(t, root, 2)
+	assert.Equal(t, "hello.txt", root[0].Name)
+	assert.Equal(t, "/hello.txt", root[0].Path)
+	assert.Equal(t, uint16(0o100644), root[0].Mode)
+	assert.Equal(t, "docs", root[1].Name)
+	assert.True(t, root[1].IsDir)
+
+	docs, err := fs.ListDirectory("/docs")
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	assert.Equal(t, uint64(18), docs[0].Inode)
+
+	node, err := fs.GetInodeByPath("/docs/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(18), node.Inode)
+	assert.Equal(t, uint64(16), node.ParentInode)
+
+	data, err := fs.ReadFile(17)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	extents, err := fs.GetFileExtents(18)
+	require.NoError(t, err)
+	require.Len(t, extents, 2)
+	assert.Equal(t, uint64(testBlockSize), extents[1].LogicalOffset)
+
+	data, err = fs.ReadFileRange(18, testBlockSize-2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, "aabb", string(data))
+
+	_, err = fs.GetInodeByPath("/docs/missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileSystemServiceHashedLookup(t *testing.T) {
+	img := newTestImage(64)
+	img.writeContainerSuperblock(20, 10, 1026)
+	img.writeObjectMap(10, 15, omapRecord(1026, 15, 21, 0))
+	img.putObject(21, buildVolumeSuperblock(testVolume{
+		oid: 1026, xid: 15, name: "Data", omapOID: 30, rootTreeOID: 1028,
+		incompat: types.ApfsIncompatNormalizationInsensitive,
+	}))
+
+	// A directory of 60 entries, which sort by name hash over three leaves
+	var entries []btreeRecord
+	for i := 0; i < 60; i++ {
+		entries = append(entries, drecRecord(2, fmt.Sprintf("file-%02d.txt", i), uint64(100+i), types.DtReg))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return binary.LittleEndian.Uint32(entries[i].key[8:12]) < binary.LittleEndian.Uint32(entries[j].key[8:12])
+	})
+	leaves := [][]btreeRecord{
+		append([]btreeRecord{inodeRecord(2, 1, 0o040755, 0)}, entries[:20]...),
+		entries[20:40],
+		entries[40:],
+	}
+	img.writeObjectMap(30, 15, img.writeFSTree(1028, 40, 15, leaves...)...)
+
+	// Only the root and the leaf holding the entry are read, so a lookup in
+	// the first leaf succeeds with the other leaves damaged
+	clear(img.blocks[42])
+	clear(img.blocks[43])
+
+	cr := img.containerReader(t)
+	vs, err := NewVolumeService(cr, 1026)
+	require.NoError(t, err)
+	fs, err := NewFileSystemService(cr, 1026, vs.GetSuperblock())
+	require.NoError(t, err)
+
+	for _, record := range entries[:20] {
+		entry, err := fs.parseDirectoryRecord(record.key, record.value)
+		require.NoError(t, err)
+		inode, err := fs.getInodeByPath("/" + entry.Name)
+		require.NoError(t, err, entry.Name)
+		assert.Equal(t, types.OidT(entry.InodeNumber), inode)
+	}
+
+	_, err = fs.ListDirectory("/")
+	assert.Error(t, err)
+}
+
+// openTestFileSystem writes a volume with the given incompatible features
+// whose file-system tree is a single leaf holding records into img, which
+// has blocks from 50 free for file data, and opens it
+func openTestFileSystem(t *testing.T, img *testImage, incompat uint64, records ...btreeRecord) *FileSystemServiceImpl {
+	t.Helper()
+	sortFSRecords(records)
+
+	img.writeContainerSuperblock(20, 10, 1026)
+	img.writeObjectMap(10, 15, omapRecord(1026, 15, 21, 0))
+	img.putObject(21, buildVolumeSuperblock(testVolume{
+		oid: 1026, xid: 15, name: "Data", omapOID: 30, rootTreeOID: 1028, incompat: incompat,
+	}))
+	img.writeObjectMap(30, 15, img.writeFSTree(1028, 40, 15, records)...)
+
+	cr := img.containerReader(t)
+	vs, err := NewVolumeService(cr, 1026)
+	require.NoError(t, err)
+	fs, err := NewFileSystemService(cr, 1026, vs.GetSuperblock())
+	require.NoError(t, err)
+	return fs
+}
+
+func TestFileSystemServicePathComparison(t *testing.T) {
+	tree := func(caseFold bool) []btreeRecord {
+		return []btreeRecord{
+			inodeRecord(2, 1, 0o040755, 0),
+			hashedDrecRecord(2, "Users", 16, types.DtDir, caseFold),
+			inodeRecord(16, 2, 0o040755, 0),
+			hashedDrecRecord(16, "Alice", 17, types.DtDir, caseFold),
+			inodeRecord(17, 16, 0o040755, 0),
+			hashedDrecRecord(17, "Caf\u00e9.txt", 18, types.DtReg, caseFold),
+			inodeRecord(18, 17, 0o100644, 0),
+		}
+	}
+
+	t.Run("case insensitive", func(t *testing.T) {
+		fs := openTestFileSystem(t, newTestImage(64), types.ApfsIncompatCaseInsensitive, tree(true)...)
+		for _, path := range []string{
+			"/Users/Alice/Caf\u00e9.txt",
+			"/users/ALICE/cafe\u0301.TXT",
+			"/USERS/alice/CAF\u00c9.txt",
+		} {
+			inode, err := fs.getInodeByPath(path)
+			require.NoError(t, err, path)
+			assert.Equal(t, types.OidT(18), inode, path)
+		}
+	})
+
+	t.Run("case sensitive", func(t *testing.T) {
+		fs := openTestFileSystem(t, newTestImage(64), types.ApfsIncompatNormalizationInsensitive, tree(false)...)
+		inode, err := fs.getInodeByPath("/Users/Alice/Cafe\u0301.txt")
+		require.NoError(t, err)
+		assert.Equal(t, types.OidT(18), inode)
+
+		for _, path := range []string{"/users/Alice", "/Users/Alice/caf\u00e9.txt"} {
+			_, err := fs.getInodeByPath(path)
+			assert.ErrorIs(t, err, ErrNotFound, path)
+		}
+	})
+}
+
+func TestFileSystemServiceHardLinks(t *testing.T) {
+	shared := inodeRecord(20, 16, 0o100644, 5)
+	binary.LittleEndian.PutUint32(shared.value[56:60], 3)
+	fs := openTestFileSystem(t, newTestImage(64), types.ApfsIncompatNormalizationInsensitive,
+		inodeRecord(2, 1, 0o040755, 0),
+		drecRecord(2, "docs", 16, types.DtDir),
+		drecRecord(2, "backup", 17, types.DtDir),
+		inodeRecord(16, 2, 0o040755, 0),
+		inodeRecord(17, 2, 0o040755, 0),
+		drecRecord(16, "report.txt", 20, types.DtReg),
+		drecRecord(16, "copy.txt", 20, types.DtReg),
+		drecRecord(17, "report.txt", 20, types.DtReg),
+		drecRecord(16, "single.txt", 21, types.DtReg),
+		inodeRecord(21, 16, 0o100644, 1),
+		shared,
+		siblingLinkRecord(20, 30, 16, "report.txt"),
+		siblingLinkRecord(20, 31, 16, "copy.txt"),
+		siblingLinkRecord(20, 32, 17, "report.txt"),
+		siblingMapRecord(30, 20),
+		siblingMapRecord(31, 20),
+		siblingMapRecord(32, 20),
+	)
+
+	paths, err := fs.FindHardLinks(20)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/backup/report.txt", "/docs/copy.txt", "/docs/report.txt"}, paths)
+
+	paths, err = fs.FindHardLinks(21)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/docs/single.txt"}, paths)
+
+	count, err := fs.CountSiblingLinks(20)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	count, err = fs.CountSiblingLinks(21)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	link, err := fs.FindSiblingLinkByName("/backup/report.txt")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(32), link.SiblingID())
+	assert.Equal(t, uint64(20), link.InodeNumber())
+	assert.Equal(t, uint64(17), link.ParentDirectoryID())
+	_, err = fs.FindSiblingLinkByName("/docs/single.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	inode, err := fs.ResolveSiblingID(31)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), inode)
+	_, err = fs.FindSiblingMapByID(99)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	info, err := fs.GetHardLinkInfo(20)
+	require.NoError(t, err)
+	assert.True(t, info.IsHardLink())
+	assert.Equal(t, uint64(20), info.OriginalInode())
+	files, err := info.LinkedFiles()
+	require.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	info, err = fs.GetHardLinkInfo(16)
+	require.NoError(t, err)
+	assert.False(t, info.IsHardLink())
+}
+
+func TestFileSystemServiceExtendedAttributes(t *testing.T) {
+	img := newTestImage(64)
+	for i := range img.blocks[50] {
+		img.blocks[50][i] = byte(i)
+		img.blocks[51][i] = byte(i >> 8)
+	}
+	fs := openTestFileSystem(t, img, types.ApfsIncompatNormalizationInsensitive,
+		inodeRecord(2, 1, 0o040755, 0),
+		drecRecord(2, "report.pdf", 16, types.DtReg),
+		inodeRecord(16, 2, 0o100644, 0),
+		xattrRecord(16, "com.apple.quarantine", []byte("0081;5f3c;Safari;")),
+		xattrRecord(16, "com.apple.metadata:_kMDItemUserTags", []byte("bplist00")),
+		streamXattrRecord(16, "com.apple.ResourceFork", 40, testBlockSize+100),
+		extentRecord(40, 0, testBlockSize, 50),
+		extentRecord(40, testBlockSize, testBlockSize, 51),
+	)
+
+	names, err := fs.ListExtendedAttributes(16)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"com.apple.ResourceFork", "com.apple.metadata:_kMDItemUserTags", "com.apple.quarantine"}, names)
+
+	value, err := fs.ReadExtendedAttribute(16, "com.apple.quarantine")
+	require.NoError(t, err)
+	assert.Equal(t, "0081;5f3c;Safari;", string(value))
+
+	size, err := fs.GetExtendedAttributeSize(16, "com.apple.ResourceFork")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(testBlockSize+100), size)
+
+	fork, err := fs.GetResourceFork(16)
+	require.NoError(t, err)
+	require.Len(t, fork, testBlockSize+100)
+	assert.Equal(t, img.blocks[50], fork[:testBlockSize])
+	assert.Equal(t, img.blocks[51][:100], fork[testBlockSize:])
+	has, err := fs.HasResourceFork(16)
+	require.NoError(t, err)
+	assert.True(t, has)
+	has, err = fs.HasResourceFork(2)
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	has, err = fs.HasExtendedAttribute(16, "com.apple.quarantine")
+	require.NoError(t, err)
+	assert.True(t, has)
+	has, err = fs.HasExtendedAttribute(16, "com.apple.lastuseddate#PS")
+	require.NoError(t, err)
+	assert.False(t, has)
+	_, err = fs.ReadExtendedAttribute(16, "com.apple.lastuseddate#PS")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	attrs, err := fs.GetExtendedAttributes(16)
+	require.NoError(t, err)
+	assert.Len(t, attrs, 3)
+	assert.Equal(t, "bplist00", string(attrs["com.apple.metadata:_kMDItemUserTags"]))
+
+	attrs, err = fs.GetExtendedAttributes(2)
+	require.NoError(t, err)
+	assert.Empty(t, attrs)
+
+	_, err = fs.GetExtendedAttributes(99)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestSnapshotFileSystemServiceUsesVolumeObjectMap(t *testing.T) {
+	img := newTestImage(64)
+	img.writeContainerSuperblock(20, 10, 1026)
+	img.writeObjectMap(10, 15, omapRecord(1026, 15, 21, 0))
+	img.putObject(21, buildVolumeSuperblock(testVolume{
+		oid: 1026, xid: 15, name: "Data", omapOID: 30, rootTreeOID: 1028,
+		incompat: types.ApfsIncompatNormalizationInsensitive,
+	}))
+
+	// The snapshot superblock records an object map whose tree has since
+	// been reused; the volume's object map still maps the snapshot's tree
+	img.putObject(22, buildVolumeSuperblock(testVolume{
+		oid: 1026, xid: 8, name: "Data", omapOID: 35, rootTreeOID: 1028,
+		incompat: types.ApfsIncompatNormalizationInsensitive,
+	}))
+	img.writeObjectMap(35, 8, omapRecord(1028, 8, 45, 0))
+
+	snapshot := img.writeFSTree(1028, 41, 8, []btreeRecord{
+		inodeRecord(2, 1, 0o040755, 0),
+		drecRecord(2, "old.txt", 16, types.DtReg),
+		inodeRecord(16, 2, 0o100644, 0),
+	})
+	live := img.writeFSTree(1028, 40, 15, []btreeRecord{
+		inodeRecord(2, 1, 0o040755, 0),
+		drecRecord(2, "new.txt", 17, types.DtReg),
+		inodeRecord(17, 2, 0o100644, 0),
+	})
+	img.writeObjectMap(30, 15, append(snapshot, live...)...)
+
+	cr := img.containerReader(t)
+	vs, err := NewVolumeService(cr, 1026)
+	require.NoError(t, err)
+	info := &SnapshotInfo{XID: 8, Name: "nightly", SuperblockOID: 22}
+
+	fs, err := NewSnapshotFileSystemService(cr, 1026, vs.GetSuperblock(), info)
+	require.NoError(t, err)
+	node, err := fs.GetInodeByPath("/old.txt")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(16), node.Inode)
+	_, err = fs.GetInodeByPath("/new.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// The snapshot superblock's own object map no longer resolves its tree
+	svs, err := NewVolumeServiceFromPhysicalOID(cr, 22)
+	require.NoError(t, err)
+	stale, err := NewFileSystemService(cr, 1026, svs.GetSuperblock())
+	require.NoError(t, err)
+	_, err = stale.GetInodeByPath("/old.txt")
+	assert.Error(t, err)
+
+	_, err = NewSnapshotFileSystemService(cr, 1026, vs.GetSuperblock(), &SnapshotInfo{XID: 8})
+	assert.Error(t, err)
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/deploymenttheory/go-apfs/internal/interfaces"
+	"github.com/deploymenttheory/go-apfs/internal/parsers/file_system_objects"
+	"github.com/deploymenttheory/go-apfs/internal/parsers/siblings"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// maxDirectoryDepth bounds the walk up a directory's parents, so a damaged
+// tree with a parent cycle cannot loop forever
+const maxDirectoryDepth = 4096
+
+var _ interfaces.SiblingManager = (*FileSystemServiceImpl)(nil)
+
+// ListSiblingLinks returns the sibling links of an inode, one for each hard
+// link to it. An inode with a single link has none.
+func (fs *FileSystemServiceImpl) ListSiblingLinks(inodeNumber uint64) ([]interfaces.SiblingLinkReader, error) {
+	var links []interfaces.SiblingLinkReader
+	err := fs.tree.records(inodeNumber, types.ApfsTypeSiblingLink, func(key, value []byte) error {
+		link, err := siblings.NewSiblingLinkReader(key, value, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		links = append(links, link)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sibling links of inode %d: %w", inodeNumber, err)
+	}
+	return links, nil
+}
+
+// CountSiblingLinks counts the sibling links of an inode
+func (fs *FileSystemServiceImpl) CountSiblingLinks(inodeNumber uint64) (int, error) {
+	links, err := fs.ListSiblingLinks(inodeNumber)
+	if err != nil {
+		return 0, err
+	}
+	return len(links), nil
+}
+
+// FindSiblingLinkByName finds the sibling link of the hard link at the given
+// path. Sibling links are keyed by inode, so the path's directory entry
+// gives the inode whose links are searched.
+func (fs *FileSystemServiceImpl) FindSiblingLinkByName(name string) (interfaces.SiblingLinkReader, error) {
+	name = path.Clean("/" + name)
+	dir, base := path.Split(name)
+	parent, err := fs.getInodeByPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inode for path %s: %w", dir, err)
+	}
+	record, err := fs.lookupDirectoryEntry(parent, base)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := fs.ListSiblingLinks(record.InodeNumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		if link.ParentDirectoryID() == uint64(parent) && fs.comparableName(link.Name()) == fs.comparableName(record.Name) {
+			return link, nil
+		}
+	}
+	return nil, fmt.Errorf("sibling link for %s %w", name, ErrNotFound)
+}
+
+// FindSiblingMapByID finds the sibling map record of a sibling ID
+func (fs *FileSystemServiceImpl) FindSiblingMapByID(siblingID uint64) (interfaces.SiblingMapReader, error) {
+	var found interfaces.SiblingMapReader
+	err := fs.tree.records(siblingID, types.ApfsTypeSiblingMap, func(key, value []byte) error {
+		siblingMap, err := siblings.NewSiblingMapReader(key, value, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		found = siblingMap
+		return errStopWalk
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sibling map %d: %w", siblingID, err)
+	}
+	if found == nil {
+		return nil, fmt.Errorf("sibling map %d %w", siblingID, ErrNotFound)
+	}
+	return found, nil
+}
+
+// ResolveSiblingID returns the inode a sibling ID links to
+func (fs *FileSystemServiceImpl) ResolveSiblingID(siblingID uint64) (uint64, error) {
+	siblingMap, err := fs.FindSiblingMapByID(siblingID)
+	if err != nil {
+		return 0, err
+	}
+	return siblingMap.FileID(), nil
+}
+
+// FindHardLinks returns, in lexical order, the path of every directory entry
+// linking to an inode
+func (fs *FileSystemServiceImpl) FindHardLinks(inodeID uint64) ([]string, error) {
+	if inodeID == types.RootDirInoNum {
+		return []string{"/"}, nil
+	}
+
+	links, err := fs.ListSiblingLinks(inodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(links) == 0 {
+		// A file with a single link is named by its parent's entry
+		parent, err := fs.parentID(inodeID)
+		if err != nil {
+			return nil, err
+		}
+		name, err := fs.entryName(parent, inodeID)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := fs.directoryPath(parent)
+		if err != nil {
+			return nil, err
+		}
+		return []string{path.Join(dir, name)}, nil
+	}
+
+	dirs := make(map[uint64]string)
+	paths := make([]string, 0, len(links))
+	for _, link := range links {
+		dir, ok := dirs[link.ParentDirectoryID()]
+		if !ok {
+			dir, err = fs.directoryPath(link.ParentDirectoryID())
+			if err != nil {
+				return nil, err
+			}
+			dirs[link.ParentDirectoryID()] = dir
+		}
+		paths = append(paths, path.Join(dir, link.Name()))
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// GetHardLinkInfo returns the hard link information of an inode
+func (fs *FileSystemServiceImpl) GetHardLinkInfo(inodeID uint64) (interfaces.HardLinkInfo, error) {
+	data, err := fs.loadInodeData(types.OidT(inodeID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load inode data: %w", err)
+	}
+	inode, err := file_system_objects.NewInodeReader(data.key, data.value, binary.LittleEndian)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inode: %w", err)
+	}
+
+	links := int32(1)
+	if !inode.IsDirectory() {
+		links = inode.NumberOfHardLinks()
+	}
+	return &hardLinkInfo{fs: fs, inode: inodeID, links: links}, nil
+}
+
+// parentID returns the parent directory of an inode. For a file with several
+// links this is the directory of only one of them.
+func (fs *FileSystemServiceImpl) parentID(inodeID uint64) (uint64, error) {
+	data, err := fs.loadInodeData(types.OidT(inodeID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to load inode data: %w", err)
+	}
+	inode, err := file_system_objects.NewInodeReader(data.key, data.value, binary.LittleEndian)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse inode: %w", err)
+	}
+	return inode.ParentID(), nil
+}
+
+// entryName returns the name of the entry in directory dirID that names inodeID
+func (fs *FileSystemServiceImpl) entryName(dirID, inodeID uint64) (string, error) {
+	var name string
+	err := fs.tree.records(dirID, types.ApfsTypeDirRec, func(key, value []byte) error {
+		record, err := fs.parseDirectoryRecord(key, value)
+		if err != nil {
+			return err
+		}
+		if record.InodeNumber == inodeID {
+			name = record.Name
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read directory %d: %w", dirID, err)
+	}
+	if name == "" {
+		return "", fmt.Errorf("entry for inode %d in directory %d %w", inodeID, dirID, ErrNotFound)
+	}
+	return name, nil
+}
+
+// directoryPath returns the path of a directory by following its parents up
+// to the root. Directories cannot be hard linked, so the path is unique.
+func (fs *FileSystemServiceImpl) directoryPath(dirID uint64) (string, error) {
+	var names []string
+	for id := dirID; id != types.RootDirInoNum; {
+		if len(names) == maxDirectoryDepth {
+			return "", fmt.Errorf("directory %d is nested more than %d levels deep", dirID, maxDirectoryDepth)
+		}
+		parent, err := fs.parentID(id)
+		if err != nil {
+			return "", err
+		}
+		name, err := fs.entryName(parent, id)
+		if err != nil {
+			return "", err
+		}
+		names = append(names, name)
+		id = parent
+	}
+	slices.Reverse(names)
+	return "/" + strings.Join(names, "/"), nil
+}
+
+// hardLinkInfo implements interfaces.HardLinkInfo for an inode
+type hardLinkInfo struct {
+	fs    *FileSystemServiceImpl
+	inode uint64
+	links int32
+}
+
+var _ interfaces.HardLinkInfo = (*hardLinkInfo)(nil)
+
+// OriginalInode returns the inode the links refer to
+func (h *hardLinkInfo) OriginalInode() uint64 {
+	return h.inode
+}
+
+// LinkedFiles returns the path of every link to the inode
+func (h *hardLinkInfo) LinkedFiles() ([]string, error) {
+	return h.fs.FindHardLinks(h.inode)
+}
+
+// IsHardLink reports whether the inode has more than one link
+func (h *hardLinkInfo) IsHardLink() bool {
+	return h.links > 1
+}
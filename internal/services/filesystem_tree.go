@@ -0,0 +1,208 @@
+package services
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// errStopWalk ends a record walk early without reporting an error
+var errStopWalk = errors.New("stop walk")
+
+// fsTree reads the records of a volume's file-system tree. The tree's nodes
+// are virtual objects resolved through the volume's object map at the
+// volume's transaction, so a snapshot's superblock yields the snapshot's tree.
+type fsTree struct {
+	container *ContainerReader
+	omap      *BTreeObjectResolver
+	rootOID   types.OidT
+	xid       types.XidT
+	physical  bool
+
+	// cipher decrypts the nodes the object map marks as encrypted, and the
+	// file data of the volume. It is nil until the volume is unlocked, which
+	// may happen while other goroutines read the tree.
+	cipherMu sync.RWMutex
+	cipher   *xtsCipher
+}
+
+// newFSTree returns the file-system tree described by a volume superblock
+func newFSTree(container *ContainerReader, volumeSB *types.ApfsSuperblockT) *fsTree {
+	xid := volumeSB.ApfsO.OXid
+	if xid == 0 {
+		xid = container.GetSuperblock().NxNextXid - 1
+	}
+	return newFSTreeAt(container, volumeSB.ApfsOmapOid, volumeSB, xid)
+}
+
+// newFSTreeAt returns the file-system tree rooted as volumeSB describes, with
+// its nodes resolved through the object map omapOID at transaction xid
+func newFSTreeAt(container *ContainerReader, omapOID types.OidT, volumeSB *types.ApfsSuperblockT, xid types.XidT) *fsTree {
+	return &fsTree{
+		container: container,
+		omap:      NewObjectMapResolver(container, omapOID),
+		rootOID:   volumeSB.ApfsRootTreeOid,
+		xid:       xid,
+		physical:  volumeSB.ApfsRootTreeType&types.ObjStorageTypeMask == types.ObjPhysical,
+	}
+}
+
+// volumeCipher returns the cipher of an unlocked volume, or nil
+func (t *fsTree) volumeCipher() *xtsCipher {
+	t.cipherMu.RLock()
+	defer t.cipherMu.RUnlock()
+	return t.cipher
+}
+
+// setVolumeCipher sets the cipher that decrypts the volume
+func (t *fsTree) setVolumeCipher(cipher *xtsCipher) {
+	t.cipherMu.Lock()
+	defer t.cipherMu.Unlock()
+	t.cipher = cipher
+}
+
+// readNode loads the tree node with the given object identifier, decrypting
+// it when the object map marks it as encrypted
+func (t *fsTree) readNode(oid types.OidT, keySize, valueSize uint32) (*btreeNode, error) {
+	addr := types.Paddr(oid)
+	encrypted := false
+	if !t.physical {
+		val, err := t.omap.LookupMapping(oid, t.xid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve file-system tree node %d: %w", oid, err)
+		}
+		addr = val.OvPaddr
+		encrypted = val.OvFlags&types.OmapValEncrypted != 0
+	}
+
+	block, err := t.container.ReadBlock(uint64(addr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file-system tree node %d at block %d: %w", oid, addr, err)
+	}
+	if encrypted {
+		cipher := t.volumeCipher()
+		if cipher == nil {
+			return nil, fmt.Errorf("file-system tree node %d is encrypted: %w", oid, ErrLocked)
+		}
+		if err := cipher.decrypt(block, uint64(addr)*uint64(len(block))/xtsUnitSize); err != nil {
+			return nil, fmt.Errorf("failed to decrypt file-system tree node %d: %w", oid, err)
+		}
+	}
+	node, err := parseBTreeNodeBlock(block, keySize, valueSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file-system tree node %d at block %d: %w", oid, addr, err)
+	}
+	return node, nil
+}
+
+// records calls fn, in key order, for every record of the object objID with
+// the given record type, or of any type when recordType is ApfsTypeAny
+func (t *fsTree) records(objID uint64, recordType types.JObjTypes, fn func(key, value []byte) error) error {
+	return t.search(fsRecordRange{objID: objID, recordType: recordType}, fn)
+}
+
+// search calls fn, in key order, for every record in the range
+func (t *fsTree) search(r fsRecordRange, fn func(key, value []byte) error) error {
+	if t.rootOID == 0 {
+		return fmt.Errorf("volume has no file-system tree")
+	}
+	root, err := t.readNode(t.rootOID, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	err = t.visit(root, r, fn)
+	if errors.Is(err, errStopWalk) {
+		return nil
+	}
+	return err
+}
+
+// fsRecordRange selects the records of one object, optionally of one type.
+// When within is set it narrows the records of that type further by ordering
+// the rest of their key against the range, like compare.
+type fsRecordRange struct {
+	objID      uint64
+	recordType types.JObjTypes
+	within     func(key []byte) int
+}
+
+// compare orders a record key against the range: negative when the key sorts
+// before it, zero inside it and positive after it
+func (r fsRecordRange) compare(key []byte) int {
+	header := binary.LittleEndian.Uint64(key[0:8])
+	objID := header & types.ObjIdMask
+	switch {
+	case objID < r.objID:
+		return -1
+	case objID > r.objID:
+		return 1
+	case r.recordType == types.ApfsTypeAny:
+		return 0
+	}
+
+	recordType := types.JObjTypes(header >> types.ObjTypeShift)
+	switch {
+	case recordType < r.recordType:
+		return -1
+	case recordType > r.recordType:
+		return 1
+	case r.within != nil:
+		return r.within(key)
+	}
+	return 0
+}
+
+// visit calls fn for the records of node and its descendants that fall in
+// the range. A child of an index node can hold records of the range when its
+// own first key does not sort after the range and the next child's first key
+// does not sort before it.
+func (t *fsTree) visit(node *btreeNode, r fsRecordRange, fn func(key, value []byte) error) error {
+	for i, record := range node.records {
+		if len(record.key) < 8 {
+			return fmt.Errorf("file-system tree node %d record %d has short key (%d bytes)", node.oid, i, len(record.key))
+		}
+	}
+
+	if node.isLeaf() {
+		for _, record := range node.records {
+			switch c := r.compare(record.key); {
+			case c > 0:
+				return errStopWalk
+			case c == 0:
+				if err := fn(record.key, record.value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for i, record := range node.records {
+		if r.compare(record.key) > 0 {
+			return errStopWalk
+		}
+		if i+1 < len(node.records) && r.compare(node.records[i+1].key) < 0 {
+			continue
+		}
+
+		childOID, err := node.childOID(i)
+		if err != nil {
+			return err
+		}
+		child, err := t.readNode(childOID, node.keySize, node.valueSize)
+		if err != nil {
+			return err
+		}
+		if child.level+1 != node.level {
+			return fmt.Errorf("file-system tree node %d has level %d, expected %d", childOID, child.level, node.level-1)
+		}
+		if err := t.visit(child, r, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
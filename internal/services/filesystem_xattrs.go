@@ -0,0 +1,258 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/deploymenttheory/go-apfs/internal/interfaces"
+	datastreams "github.com/deploymenttheory/go-apfs/internal/parsers/data_streams"
+	"github.com/deploymenttheory/go-apfs/internal/parsers/file_system_objects"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// ResourceForkXattrName is the extended attribute holding a file's resource fork
+const ResourceForkXattrName = "com.apple.ResourceFork"
+
+var _ interfaces.ExtendedAttributeManager = (*FileSystemServiceImpl)(nil)
+
+// xattrs calls fn, in name order, for every extended attribute of an inode
+func (fs *FileSystemServiceImpl) xattrs(inodeID uint64, fn func(interfaces.ExtendedAttributeReader) error) error {
+	if _, err := fs.loadInodeData(types.OidT(inodeID)); err != nil {
+		return fmt.Errorf("failed to load inode data: %w", err)
+	}
+
+	err := fs.tree.records(inodeID, types.ApfsTypeXattr, func(key, value []byte) error {
+		xattr, err := file_system_objects.NewExtendedAttributeReader(key, value, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		return fn(xattr)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read extended attributes of inode %d: %w", inodeID, err)
+	}
+	return nil
+}
+
+// findXattr descends to the extended attribute record of an inode with the
+// given name
+func (fs *FileSystemServiceImpl) findXattr(inodeID uint64, name string) (interfaces.ExtendedAttributeReader, error) {
+	r := fsRecordRange{
+		objID:      inodeID,
+		recordType: types.ApfsTypeXattr,
+		within: func(key []byte) int {
+			return compareKeyName(key, name)
+		},
+	}
+
+	var found interfaces.ExtendedAttributeReader
+	err := fs.tree.search(r, func(key, value []byte) error {
+		xattr, err := file_system_objects.NewExtendedAttributeReader(key, value, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		found = xattr
+		return errStopWalk
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up extended attribute %s of inode %d: %w", name, inodeID, err)
+	}
+	if found == nil {
+		return nil, fmt.Errorf("extended attribute %s of inode %d %w", name, inodeID, ErrNotFound)
+	}
+	return found, nil
+}
+
+// xattrStream parses the j_xattr_dstream_t of an attribute stored in a data stream
+func xattrStream(xattr interfaces.ExtendedAttributeReader) (interfaces.ExtendedAttributeDataStreamReader, error) {
+	stream, err := datastreams.NewExtendedAttributeDataStreamReader(xattr.Data(), binary.LittleEndian)
+	if err != nil {
+		return nil, fmt.Errorf("extended attribute %s: %w", xattr.AttributeName(), err)
+	}
+	return stream, nil
+}
+
+// xattrSize returns the size of an attribute's value
+func xattrSize(xattr interfaces.ExtendedAttributeReader) (uint64, error) {
+	if !xattr.IsDataStream() {
+		return uint64(len(xattr.Data())), nil
+	}
+	stream, err := xattrStream(xattr)
+	if err != nil {
+		return 0, err
+	}
+	return stream.DataStream().Size(), nil
+}
+
+// xattrValue returns an attribute's value, reading it through the extents of
+// its data stream when it is too large to be embedded in the record
+func (fs *FileSystemServiceImpl) xattrValue(xattr interfaces.ExtendedAttributeReader) ([]byte, error) {
+	if !xattr.IsDataStream() {
+		return xattr.Data(), nil
+	}
+
+	r, size, err := fs.xattrReaderAt(xattr)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if n, err := r.ReadAt(data, 0); n < len(data) {
+		return nil, fmt.Errorf("failed to read extended attribute %s: read %d of %d bytes: %w", xattr.AttributeName(), n, size, err)
+	}
+	return data, nil
+}
+
+// xattrReaderAt returns random access to an attribute's value and its size,
+// so large values such as resource forks can be read in parts
+func (fs *FileSystemServiceImpl) xattrReaderAt(xattr interfaces.ExtendedAttributeReader) (io.ReaderAt, uint64, error) {
+	if !xattr.IsDataStream() {
+		data := xattr.Data()
+		return bytes.NewReader(data), uint64(len(data)), nil
+	}
+
+	stream, err := xattrStream(xattr)
+	if err != nil {
+		return nil, 0, err
+	}
+	extents, err := fs.streamExtents(stream.AttributeObjectID())
+	if err != nil {
+		return nil, 0, err
+	}
+	size := stream.DataStream().Size()
+	return &extentReader{fs: fs, extents: extents, size: size}, size, nil
+}
+
+// extentReader reads a data stream of the given size through its extents
+type extentReader struct {
+	fs      *FileSystemServiceImpl
+	extents []ExtentMapping
+	size    uint64
+}
+
+// ReadAt implements io.ReaderAt
+func (r *extentReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if uint64(off) >= r.size {
+		return 0, io.EOF
+	}
+
+	length := min(uint64(len(p)), r.size-uint64(off))
+	data, err := r.fs.readExtentRange(r.extents, uint64(off), length)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// GetExtendedAttributes retrieves all extended attributes for an inode
+func (fs *FileSystemServiceImpl) GetExtendedAttributes(inodeID uint64) (map[string][]byte, error) {
+	attributes := make(map[string][]byte)
+	err := fs.xattrs(inodeID, func(xattr interfaces.ExtendedAttributeReader) error {
+		value, err := fs.xattrValue(xattr)
+		if err != nil {
+			return err
+		}
+		attributes[xattr.AttributeName()] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
+// ListExtendedAttributes returns the names of an inode's extended attributes
+func (fs *FileSystemServiceImpl) ListExtendedAttributes(inodeID uint64) ([]string, error) {
+	var names []string
+	err := fs.xattrs(inodeID, func(xattr interfaces.ExtendedAttributeReader) error {
+		names = append(names, xattr.AttributeName())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// ReadExtendedAttribute reads the value of an extended attribute
+func (fs *FileSystemServiceImpl) ReadExtendedAttribute(inodeID uint64, name string) ([]byte, error) {
+	xattr, err := fs.findXattr(inodeID, name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.xattrValue(xattr)
+}
+
+// HasExtendedAttribute checks if an inode has the named extended attribute
+func (fs *FileSystemServiceImpl) HasExtendedAttribute(inodeID uint64, name string) (bool, error) {
+	_, err := fs.findXattr(inodeID, name)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetExtendedAttributeSize returns the size of an extended attribute's value
+func (fs *FileSystemServiceImpl) GetExtendedAttributeSize(inodeID uint64, name string) (uint64, error) {
+	xattr, err := fs.findXattr(inodeID, name)
+	if err != nil {
+		return 0, err
+	}
+	return xattrSize(xattr)
+}
+
+// forkHoldsCompressedData reports whether the resource fork attribute of an
+// inode holds the file's own compressed data, in which case the file has no
+// resource fork of its own
+func (fs *FileSystemServiceImpl) forkHoldsCompressedData(inodeID uint64) (bool, error) {
+	data, err := fs.loadInodeData(types.OidT(inodeID))
+	if err != nil {
+		return false, fmt.Errorf("failed to load inode data: %w", err)
+	}
+	inode, err := file_system_objects.NewInodeReader(data.key, data.value, binary.LittleEndian)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse inode: %w", err)
+	}
+	if !isCompressed(inode) {
+		return false, nil
+	}
+
+	header, err := fs.compressionHeader(inodeID)
+	if err != nil {
+		return false, err
+	}
+	return decmpfsTypes[binary.LittleEndian.Uint32(header[4:8])].resourceFork, nil
+}
+
+// GetResourceFork reads the resource fork of a file
+func (fs *FileSystemServiceImpl) GetResourceFork(inodeID uint64) ([]byte, error) {
+	hidden, err := fs.forkHoldsCompressedData(inodeID)
+	if err != nil {
+		return nil, err
+	}
+	if hidden {
+		return nil, fmt.Errorf("resource fork of inode %d %w", inodeID, ErrNotFound)
+	}
+	return fs.ReadExtendedAttribute(inodeID, ResourceForkXattrName)
+}
+
+// HasResourceFork checks if a file has a resource fork
+func (fs *FileSystemServiceImpl) HasResourceFork(inodeID uint64) (bool, error) {
+	hidden, err := fs.forkHoldsCompressedData(inodeID)
+	if err != nil || hidden {
+		return false, err
+	}
+	return fs.HasExtendedAttribute(inodeID, ResourceForkXattrName)
+}
@@ -22,6 +22,7 @@ type FileNode struct {
 	IsDirectory   bool
 	IsSymlink     bool
 	IsEncrypted   bool
+	IsCompressed  bool
 	ParentInode   uint64
 	HardLinkCount uint32
 	Flags         uint32
@@ -36,19 +37,25 @@ type ExtentMapping struct {
 	IsCompressed    bool
 	CompressionType string
 	IsEncrypted     bool
+
+	// CryptoID is the tweak of the extent's first 512 bytes on a
+	// software-encrypted volume
+	CryptoID uint64
 }
 
 // SnapshotInfo contains metadata about a snapshot
 type SnapshotInfo struct {
-	XID         uint64
-	Name        string
-	CreatedTime time.Time
-	RootInode   uint64
-	Size        uint64
-	FileCount   uint64
-	IsDataless  bool
-	ParentXID   uint64
-	UUID        [16]byte
+	XID           uint64
+	Name          string
+	CreatedTime   time.Time
+	ChangedTime   time.Time
+	RootInode     uint64
+	Size          uint64
+	FileCount     uint64
+	IsDataless    bool
+	ParentXID     uint64
+	UUID          [16]byte
+	SuperblockOID uint64
 }
 
 // SpaceStats contains filesystem space usage statistics
@@ -1,11 +1,26 @@
 package services
 
 import (
+	"encoding/binary"
 	"fmt"
-	"unicode"
+	"hash/crc32"
+	"unicode/utf8"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
 )
 
-// NameHashingService provides name hashing utilities for APFS directory operations
+// castagnoliTable is the CRC-32C table used for directory entry name hashes
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// nameHashMask keeps the 22 bits of a name hash that fit in a directory
+// record key. The specification's J_DREC_HASH_MASK (0xfffff400) drops bit 11,
+// so the mask is derived from the shift instead.
+const nameHashMask = 1<<(32-types.JDrecHashShift) - 1
+
+// NameHashingService computes the name hashes stored in the keys of directory
+// records on case- or normalization-insensitive volumes (j_drec_hashed_key_t)
 type NameHashingService struct{}
 
 // NewNameHashingService creates a new name hashing service
@@ -13,143 +28,52 @@ func NewNameHashingService() *NameHashingService {
 	return &NameHashingService{}
 }
 
-// HashUTF8 calculates the APFS name hash for a UTF-8 string
+// HashUTF8 returns the 22-bit APFS hash of a UTF-8 file name: the low bits of
+// the CRC-32C of its canonically decomposed, optionally case-folded UTF-32
+// code points. The reference implementations complement a raw CRC seeded with
+// all ones, which is the standard CRC-32C that crc32.Checksum computes.
 func (nhs *NameHashingService) HashUTF8(name []byte, useCaseFolding bool) (uint32, error) {
-	if len(name) == 0 {
-		return 0, nil
-	}
-
-	var hash uint32 = 5381
-
-	if useCaseFolding {
-		// Decode UTF-8 and apply case folding
-		runes := []rune{}
-		for len(name) > 0 {
-			r, size := nhs.decodeUTF8Rune(name)
-			if r == unicode.ReplacementChar && size == 1 {
-				return 0, fmt.Errorf("invalid UTF-8 sequence")
-			}
-			runes = append(runes, unicode.ToLower(r))
-			name = name[size:]
-		}
-
-		// Hash the case-folded runes
-		for _, r := range runes {
-			encoded := nhs.encodeUTF8Rune(r)
-			for _, b := range encoded {
-				if b == 0 {
-					break
-				}
-				hash = ((hash << 5) + hash) + uint32(b)
-			}
-		}
-	} else {
-		// Hash UTF-8 bytes directly
-		for _, b := range name {
-			hash = ((hash << 5) + hash) + uint32(b)
-		}
+	if !utf8.Valid(name) {
+		return 0, fmt.Errorf("invalid UTF-8 sequence in file name")
 	}
-
-	return hash, nil
+	return nhs.hashNormalized(normalizeFileName(string(name), useCaseFolding)), nil
 }
 
-// HashUTF16 calculates the APFS name hash for a UTF-16 string
+// HashUTF16 returns the 22-bit APFS hash of a UTF-16 file name
 func (nhs *NameHashingService) HashUTF16(name []uint16, useCaseFolding bool) uint32 {
-	if len(name) == 0 {
-		return 0
-	}
-
-	var hash uint32 = 5381
-
-	if useCaseFolding {
-		// Decode UTF-16 and apply case folding
-		runes := nhs.decodeUTF16(name)
-		for _, r := range runes {
-			r = unicode.ToLower(r)
-			encoded := nhs.encodeUTF8Rune(r)
-			for _, b := range encoded {
-				if b == 0 {
-					break
-				}
-				hash = ((hash << 5) + hash) + uint32(b)
-			}
-		}
-	} else {
-		// Hash UTF-16 code units
-		for _, codeUnit := range name {
-			hash = ((hash << 5) + hash) + uint32(codeUnit&0xFF)
-			hash = ((hash << 5) + hash) + uint32((codeUnit>>8)&0xFF)
-		}
-	}
-
-	return hash
+	return nhs.hashNormalized(normalizeFileName(string(nhs.decodeUTF16(name)), useCaseFolding))
 }
 
-// Private helper methods
-
-func (nhs *NameHashingService) decodeUTF8Rune(b []byte) (rune, int) {
-	if len(b) == 0 {
-		return unicode.ReplacementChar, 0
-	}
-
-	c0 := b[0]
-	if c0 < 0x80 {
-		return rune(c0), 1
+// NameLenAndHash returns the name_len_and_hash field of the hashed directory
+// record key for name: the hash in the upper 22 bits and the length of the
+// name, including its terminating NUL, in the lower 10
+func (nhs *NameHashingService) NameLenAndHash(name []byte, useCaseFolding bool) (uint32, error) {
+	hash, err := nhs.HashUTF8(name, useCaseFolding)
+	if err != nil {
+		return 0, err
 	}
-
-	if c0&0xE0 == 0xC0 && len(b) >= 2 {
-		c1 := b[1]
-		if nhs.isUTF8Continuation(c1) {
-			r := ((rune(c0) & 0x1F) << 6) | rune(c1&0x3F)
-			return r, 2
-		}
-	}
-
-	if c0&0xF0 == 0xE0 && len(b) >= 3 {
-		c1, c2 := b[1], b[2]
-		if nhs.isUTF8Continuation(c1) && nhs.isUTF8Continuation(c2) {
-			r := ((rune(c0) & 0x0F) << 12) | ((rune(c1) & 0x3F) << 6) | rune(c2&0x3F)
-			return r, 3
-		}
-	}
-
-	if c0&0xF8 == 0xF0 && len(b) >= 4 {
-		c1, c2, c3 := b[1], b[2], b[3]
-		if nhs.isUTF8Continuation(c1) && nhs.isUTF8Continuation(c2) && nhs.isUTF8Continuation(c3) {
-			r := ((rune(c0) & 0x07) << 18) | ((rune(c1) & 0x3F) << 12) | ((rune(c2) & 0x3F) << 6) | rune(c3&0x3F)
-			return r, 4
-		}
-	}
-
-	return unicode.ReplacementChar, 1
+	return hash<<types.JDrecHashShift | uint32(len(name)+1)&types.JDrecLenMask, nil
 }
 
-func (nhs *NameHashingService) isUTF8Continuation(b byte) bool {
-	return (b & 0xC0) == 0x80
+// hashNormalized hashes an already normalized name
+func (nhs *NameHashingService) hashNormalized(name string) uint32 {
+	utf32 := make([]byte, 0, 4*len(name))
+	for _, r := range name {
+		utf32 = binary.LittleEndian.AppendUint32(utf32, uint32(r))
+	}
+	return crc32.Checksum(utf32, castagnoliTable) & nameHashMask
 }
 
-func (nhs *NameHashingService) encodeUTF8Rune(r rune) [4]byte {
-	var result [4]byte
-
-	if r < 0x80 {
-		result[0] = byte(r)
-	} else if r < 0x800 {
-		result[0] = byte(0xC0 | (r >> 6))
-		result[1] = byte(0x80 | (r & 0x3F))
-	} else if r < 0x10000 {
-		result[0] = byte(0xE0 | (r >> 12))
-		result[1] = byte(0x80 | ((r >> 6) & 0x3F))
-		result[2] = byte(0x80 | (r & 0x3F))
-	} else if r < 0x110000 {
-		result[0] = byte(0xF0 | (r >> 18))
-		result[1] = byte(0x80 | ((r >> 12) & 0x3F))
-		result[2] = byte(0x80 | ((r >> 6) & 0x3F))
-		result[3] = byte(0x80 | (r & 0x3F))
+// normalizeFileName returns the canonical decomposition (NFD) of name, with
+// full Unicode case folding applied first when foldCase is set
+func normalizeFileName(name string, foldCase bool) string {
+	if foldCase {
+		name = cases.Fold().String(norm.NFD.String(name))
 	}
-
-	return result
+	return norm.NFD.String(name)
 }
 
+// decodeUTF16 decodes UTF-16 code units, keeping unpaired surrogates as is
 func (nhs *NameHashingService) decodeUTF16(utf16String []uint16) []rune {
 	var runes []rune
 	i := 0
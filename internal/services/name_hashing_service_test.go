@@ -0,0 +1,69 @@
+package services
+
+import (
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameHashingServiceHashUTF8(t *testing.T) {
+	nhs := NewNameHashingService()
+
+	// Known answers of the reference implementation, ~crc32c(~0, name) & 0x3fffff
+	for _, tt := range []struct {
+		name     string
+		caseFold bool
+		want     uint32
+	}{
+		{"hello.txt", false, 0x07691b},
+		{"Hello.txt", false, 0x3928bd},
+		{"Hello.txt", true, 0x07691b},
+		{"docs", false, 0x22b4a2},
+		{"Users", false, 0x02ab24},
+		{"Users", true, 0x156aa2},
+		{".DS_Store", false, 0x393e2a},
+		{"Caf\u00e9", false, 0x005698},
+		{"Cafe\u0301", false, 0x005698},
+		{"Caf\u00e9", true, 0x17971e},
+	} {
+		hash, err := nhs.HashUTF8([]byte(tt.name), tt.caseFold)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, hash, "%q case folded %t", tt.name, tt.caseFold)
+	}
+
+	hash := func(name string, caseFold bool) uint32 {
+		h, err := nhs.HashUTF8([]byte(name), caseFold)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, h, uint32(nameHashMask), name)
+		return h
+	}
+
+	// Composed and decomposed forms hash alike on every hashed volume
+	assert.Equal(t, hash("Café", false), hash("Café", false))
+	assert.NotEqual(t, hash("Café", false), hash("café", false))
+
+	// Case folding is full folding, not lower-casing
+	assert.Equal(t, hash("Café", true), hash("café", true))
+	assert.Equal(t, hash("Straße", true), hash("STRASSE", true))
+	assert.Equal(t, hash("ΣΑΣ", true), hash("σας", true))
+
+	_, err := nhs.HashUTF8([]byte{'a', 0xff}, false)
+	assert.Error(t, err)
+}
+
+func TestNameHashingServiceHashUTF16(t *testing.T) {
+	nhs := NewNameHashingService()
+	for _, name := range []string{"hello.txt", "Café", "\U0001F600.png"} {
+		want, err := nhs.HashUTF8([]byte(name), true)
+		require.NoError(t, err)
+		assert.Equal(t, want, nhs.HashUTF16(utf16.Encode([]rune(name)), true), name)
+	}
+}
+
+func TestNameHashingServiceNameLenAndHash(t *testing.T) {
+	field, err := NewNameHashingService().NameLenAndHash([]byte("hello.txt"), false)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x07691b)<<10|10, field)
+}
@@ -43,6 +43,11 @@ func (ols *ObjectLocatorServiceImpl) FindObjectByID(oid uint64) (any, error) {
 		return nil, fmt.Errorf("container superblock not available")
 	}
 
+	// Ephemeral objects live in memory, loaded from the checkpoint data area
+	if eph, err := ols.container.GetEphemeralObject(types.OidT(oid)); err == nil {
+		return eph.Data, nil
+	}
+
 	// Try to resolve the virtual object to physical address
 	physAddr, err := ols.resolver.ResolveVirtualObject(types.OidT(oid), sb.NxNextXid-1)
 	if err != nil {
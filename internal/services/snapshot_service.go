@@ -0,0 +1,164 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/deploymenttheory/go-apfs/internal/parsers/snapshot"
+	"github.com/deploymenttheory/go-apfs/internal/parsers/volumes"
+	"github.com/deploymenttheory/go-apfs/internal/types"
+)
+
+// SnapshotServiceImpl reads snapshot information from a volume's snapshot metadata tree
+type SnapshotServiceImpl struct {
+	container *ContainerReader
+	volumeSB  *types.ApfsSuperblockT
+}
+
+// NewSnapshotService creates a new SnapshotService instance for a volume
+func NewSnapshotService(container *ContainerReader, volumeSB *types.ApfsSuperblockT) (*SnapshotServiceImpl, error) {
+	if container == nil {
+		return nil, fmt.Errorf("container reader cannot be nil")
+	}
+	if volumeSB == nil {
+		return nil, fmt.Errorf("volume superblock cannot be nil")
+	}
+
+	return &SnapshotServiceImpl{
+		container: container,
+		volumeSB:  volumeSB,
+	}, nil
+}
+
+// ListAllSnapshots returns every snapshot of the volume ordered by transaction ID
+func (ss *SnapshotServiceImpl) ListAllSnapshots() ([]*SnapshotInfo, error) {
+	treeOID := ss.volumeSB.ApfsSnapMetaTreeOid
+	if treeOID == 0 {
+		return nil, nil
+	}
+
+	var snapshots []*SnapshotInfo
+	err := walkPhysicalBTree(ss.container, types.Paddr(treeOID), func(key, value []byte) error {
+		if len(key) < 8 {
+			return nil
+		}
+		hdr := binary.LittleEndian.Uint64(key[0:8])
+		if types.JObjTypes((hdr&types.ObjTypeMask)>>types.ObjTypeShift) != types.ApfsTypeSnapMetadata {
+			return nil
+		}
+
+		meta, err := snapshot.NewSnapMetadataReader(key, value, binary.LittleEndian)
+		if err != nil {
+			return fmt.Errorf("failed to parse snapshot metadata: %w", err)
+		}
+
+		info := &SnapshotInfo{
+			XID:           hdr & types.ObjIdMask,
+			Name:          meta.Name(),
+			CreatedTime:   meta.CreateTime(),
+			ChangedTime:   meta.ChangeTime(),
+			RootInode:     meta.InodeNumber(),
+			IsDataless:    meta.HasFlag(uint32(types.SnapMetaPendingDataless)),
+			SuperblockOID: meta.SuperblockOID(),
+		}
+		ss.fillFromSuperblock(info)
+
+		snapshots = append(snapshots, info)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot metadata tree: %w", err)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].XID < snapshots[j].XID
+	})
+	for i := 1; i < len(snapshots); i++ {
+		snapshots[i].ParentXID = snapshots[i-1].XID
+	}
+
+	return snapshots, nil
+}
+
+// fillFromSuperblock completes snapshot information from the snapshot's volume superblock
+func (ss *SnapshotServiceImpl) fillFromSuperblock(info *SnapshotInfo) {
+	if info.SuperblockOID == 0 {
+		return
+	}
+
+	data, err := ss.container.ReadBlock(info.SuperblockOID)
+	if err != nil {
+		return
+	}
+
+	reader, err := volumes.NewVolumeSuperblockReader(data, binary.LittleEndian)
+	if err != nil {
+		return
+	}
+
+	sb := reader.GetSuperblock()
+	info.FileCount = sb.ApfsNumFiles
+	info.Size = sb.ApfsFsAllocCount * uint64(ss.container.GetBlockSize())
+	info.UUID = sb.ApfsVolUuid
+}
+
+// GetSnapshotMetadata returns the snapshot with the given transaction ID
+func (ss *SnapshotServiceImpl) GetSnapshotMetadata(xid uint64) (*SnapshotInfo, error) {
+	snapshots, err := ss.ListAllSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snap := range snapshots {
+		if snap.XID == xid {
+			return snap, nil
+		}
+	}
+
+	return nil, fmt.Errorf("snapshot with XID %d %w", xid, ErrNotFound)
+}
+
+// FindSnapshotByName returns the snapshot with the given name
+func (ss *SnapshotServiceImpl) FindSnapshotByName(name string) (*SnapshotInfo, error) {
+	snapshots, err := ss.ListAllSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snap := range snapshots {
+		if snap.Name == name {
+			return snap, nil
+		}
+	}
+
+	return nil, fmt.Errorf("snapshot %q %w", name, ErrNotFound)
+}
+
+// GetSnapshotSize returns the number of bytes allocated to the snapshot's volume
+func (ss *SnapshotServiceImpl) GetSnapshotSize(xid uint64) (uint64, error) {
+	snap, err := ss.GetSnapshotMetadata(xid)
+	if err != nil {
+		return 0, err
+	}
+	return snap.Size, nil
+}
+
+// GetSnapshotFileCount returns the number of files recorded in the snapshot
+func (ss *SnapshotServiceImpl) GetSnapshotFileCount(xid uint64) (uint64, error) {
+	snap, err := ss.GetSnapshotMetadata(xid)
+	if err != nil {
+		return 0, err
+	}
+	return snap.FileCount, nil
+}
+
+// CompareSnapshots compares two snapshots of the volume
+func (ss *SnapshotServiceImpl) CompareSnapshots(xid1, xid2 uint64) (*DiffReport, error) {
+	return nil, fmt.Errorf("snapshot comparison not yet implemented")
+}
+
+// GetChangedFiles returns the files that changed between two snapshots
+func (ss *SnapshotServiceImpl) GetChangedFiles(xid1, xid2 uint64) ([]FileChange, error) {
+	return nil, fmt.Errorf("snapshot change tracking not yet implemented")
+}
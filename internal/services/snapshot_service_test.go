@@ -0,0 +1,98 @@
+package services
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snapMetadataValue encodes a j_snap_metadata_val_t
+func snapMetadataValue(sblockOID uint64, created time.Time, name string) []byte {
+	value := make([]byte, 50+len(name)+1)
+	binary.LittleEndian.PutUint64(value[8:16], sblockOID)
+	binary.LittleEndian.PutUint64(value[16:24], uint64(created.UnixNano()))
+	binary.LittleEndian.PutUint64(value[24:32], uint64(created.UnixNano()))
+	binary.LittleEndian.PutUint16(value[48:50], uint16(len(name)+1))
+	copy(value[50:], name)
+	return value
+}
+
+// buildSnapshotImage creates a container with one volume holding two snapshots
+func buildSnapshotImage(t *testing.T) (*ContainerReader, *types.ApfsSuperblockT) {
+	img := newTestImage(16)
+	img.writeContainerSuperblock(200, 0)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	img.putObject(6, buildVolumeSuperblock(testVolume{oid: 1026, xid: 50, name: "Data", numFiles: 3, allocCount: 10}))
+	img.putObject(7, buildVolumeSuperblock(testVolume{oid: 1026, xid: 90, name: "Data", numFiles: 5, allocCount: 12}))
+
+	img.putObject(5, buildBTreeNode(testBTreeNode{oid: 5, root: true, records: []btreeRecord{
+		{key: jKey(50, types.ApfsTypeSnapMetadata), value: snapMetadataValue(6, created, "before-update")},
+		{key: jKey(90, types.ApfsTypeSnapMetadata), value: snapMetadataValue(7, created.Add(time.Hour), "after-update")},
+		{key: jKey(types.ObjIdMask, types.ApfsTypeSnapName, 'a', 0), value: childValue(90)},
+	}}))
+
+	cr := img.containerReader(t)
+	volumeSB := &types.ApfsSuperblockT{ApfsSnapMetaTreeOid: 5}
+	return cr, volumeSB
+}
+
+func TestSnapshotServiceListAllSnapshots(t *testing.T) {
+	cr, volumeSB := buildSnapshotImage(t)
+
+	ss, err := NewSnapshotService(cr, volumeSB)
+	require.NoError(t, err)
+
+	snapshots, err := ss.ListAllSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+
+	assert.Equal(t, uint64(50), snapshots[0].XID)
+	assert.Equal(t, "before-update", snapshots[0].Name)
+	assert.Equal(t, uint64(6), snapshots[0].SuperblockOID)
+	assert.Equal(t, uint64(3), snapshots[0].FileCount)
+	assert.Equal(t, uint64(10*testBlockSize), snapshots[0].Size)
+	assert.True(t, snapshots[0].CreatedTime.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)))
+
+	assert.Equal(t, uint64(90), snapshots[1].XID)
+	assert.Equal(t, "after-update", snapshots[1].Name)
+	assert.Equal(t, uint64(50), snapshots[1].ParentXID)
+}
+
+func TestSnapshotServiceLookups(t *testing.T) {
+	cr, volumeSB := buildSnapshotImage(t)
+
+	ss, err := NewSnapshotService(cr, volumeSB)
+	require.NoError(t, err)
+
+	snap, err := ss.FindSnapshotByName("after-update")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(90), snap.XID)
+
+	count, err := ss.GetSnapshotFileCount(50)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	_, err = ss.FindSnapshotByName("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = ss.GetSnapshotMetadata(12345)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestSnapshotServiceNoSnapshots(t *testing.T) {
+	img := newTestImage(4)
+	img.writeContainerSuperblock(10, 0)
+
+	ss, err := NewSnapshotService(img.containerReader(t), &types.ApfsSuperblockT{})
+	require.NoError(t, err)
+
+	snapshots, err := ss.ListAllSnapshots()
+	require.NoError(t, err)
+	assert.Empty(t, snapshots)
+}
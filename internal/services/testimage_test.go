@@ -0,0 +1,398 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"testing"
+
+	"github.com/deploymenttheory/go-apfs/internal/types"
+	"github.com/stretchr/testify/require"
+)
+
+const testBlockSize = 4096
+
+// testImage builds a small in-memory APFS container for tests that need
+// real on-disk structures without a fixture image
+type testImage struct {
+	blocks [][]byte
+}
+
+// newTestImage creates an image with the given number of zeroed blocks
+func newTestImage(blockCount int) *testImage {
+	img := &testImage{blocks: make([][]byte, blockCount)}
+	for i := range img.blocks {
+		img.blocks[i] = make([]byte, testBlockSize)
+	}
+	return img
+}
+
+// putObject stores an object at a block address and seals its Fletcher-64 checksum
+func (img *testImage) putObject(addr uint64, data []byte) {
+	block := make([]byte, testBlockSize)
+	copy(block, data)
+	sealObject(block)
+	img.blocks[addr] = block
+}
+
+// writeContainerSuperblock writes a minimal nx_superblock_t to block 0
+func (img *testImage) writeContainerSuperblock(nextXID uint64, omapOID uint64, volumeOIDs ...uint64) {
+	img.putObject(0, img.buildContainerSuperblock(nextXID, omapOID, volumeOIDs...))
+}
+
+// buildContainerSuperblock encodes a minimal nx_superblock_t for the image
+func (img *testImage) buildContainerSuperblock(nextXID uint64, omapOID uint64, volumeOIDs ...uint64) []byte {
+	block := make([]byte, testBlockSize)
+	putObjectHeader(block, 1, nextXID-1, types.ObjectTypeNxSuperblock|types.ObjEphemeral, 0)
+	binary.LittleEndian.PutUint32(block[32:36], types.NxMagic)
+	binary.LittleEndian.PutUint32(block[36:40], testBlockSize)
+	binary.LittleEndian.PutUint64(block[40:48], uint64(len(img.blocks)))
+	binary.LittleEndian.PutUint64(block[88:96], 1024)
+	binary.LittleEndian.PutUint64(block[96:104], nextXID)
+	binary.LittleEndian.PutUint64(block[160:168], omapOID)
+	binary.LittleEndian.PutUint32(block[180:184], types.NxMaxFileSystems)
+	for i, oid := range volumeOIDs {
+		binary.LittleEndian.PutUint64(block[184+i*8:], oid)
+	}
+	return block
+}
+
+// testVolume describes the apfs_superblock_t fields used by tests
+type testVolume struct {
+	oid             uint64
+	xid             uint64
+	name            string
+	role            uint16
+	incompat        uint64
+	allocCount      uint64
+	omapOID         uint64
+	rootTreeOID     uint64
+	snapMetaTreeOID uint64
+	numFiles        uint64
+	numDirectories  uint64
+	numSnapshots    uint64
+	uuid            types.UUID
+	fsFlags         uint64
+}
+
+// buildVolumeSuperblock encodes an apfs_superblock_t using the spec's field offsets
+func buildVolumeSuperblock(v testVolume) []byte {
+	block := make([]byte, testBlockSize)
+	putObjectHeader(block, v.oid, v.xid, types.ObjectTypeFs|types.ObjVirtual, 0)
+	copy(block[32:36], "APSB")
+	binary.LittleEndian.PutUint64(block[0x38:], v.incompat)
+	binary.LittleEndian.PutUint64(block[0x58:], v.allocCount)
+	binary.LittleEndian.PutUint32(block[0x74:], types.ObjectTypeBtree|types.ObjVirtual)
+	binary.LittleEndian.PutUint32(block[0x7C:], types.ObjectTypeBtree|types.ObjPhysical)
+	binary.LittleEndian.PutUint64(block[0x80:], v.omapOID)
+	binary.LittleEndian.PutUint64(block[0x88:], v.rootTreeOID)
+	binary.LittleEndian.PutUint64(block[0x98:], v.snapMetaTreeOID)
+	binary.LittleEndian.PutUint64(block[0xB8:], v.numFiles)
+	binary.LittleEndian.PutUint64(block[0xC0:], v.numDirectories)
+	binary.LittleEndian.PutUint64(block[0xD8:], v.numSnapshots)
+	copy(block[0xF0:0x100], v.uuid[:])
+	binary.LittleEndian.PutUint64(block[0x108:], v.fsFlags)
+	copy(block[0x2C0:], v.name)
+	binary.LittleEndian.PutUint16(block[0x3C4:], v.role)
+	sealObject(block)
+	return block
+}
+
+// containerReader returns a ContainerReader backed by the image contents
+func (img *testImage) containerReader(t *testing.T) *ContainerReader {
+	t.Helper()
+	data := bytes.Join(img.blocks, nil)
+	cr, err := NewContainerReaderFromDevice(bytes.NewReader(data), uint64(len(data)))
+	require.NoError(t, err)
+	return cr
+}
+
+// putObjectHeader fills in an obj_phys_t header, leaving the checksum empty
+func putObjectHeader(block []byte, oid, xid uint64, objType, subtype uint32) {
+	binary.LittleEndian.PutUint64(block[8:16], oid)
+	binary.LittleEndian.PutUint64(block[16:24], xid)
+	binary.LittleEndian.PutUint32(block[24:28], objType)
+	binary.LittleEndian.PutUint32(block[28:32], subtype)
+}
+
+// sealObject computes the Fletcher-64 checksum of an object and stores it in its header
+func sealObject(block []byte) {
+	const mod = uint64(0xFFFFFFFF)
+	var sum1, sum2 uint64
+	for i := 8; i < len(block); i += 4 {
+		sum1 = (sum1 + uint64(binary.LittleEndian.Uint32(block[i:i+4]))) % mod
+		sum2 = (sum2 + sum1) % mod
+	}
+	ckLow := mod - ((sum1 + sum2) % mod)
+	ckHigh := mod - ((sum1 + ckLow) % mod)
+	binary.LittleEndian.PutUint64(block[0:8], ckLow|ckHigh<<32)
+}
+
+// testBTreeNode describes a B-tree node to be encoded by buildBTreeNode
+type testBTreeNode struct {
+	oid       uint64
+	xid       uint64
+	objType   uint32
+	subtype   uint32
+	root      bool
+	level     uint16
+	keySize   uint32 // non-zero for trees with fixed-size entries
+	valueSize uint32
+	records   []btreeRecord
+}
+
+// buildBTreeNode encodes a btree_node_phys_t with its table of contents,
+// key area, value area and, for root nodes, the trailing btree_info_t
+func buildBTreeNode(n testBTreeNode) []byte {
+	block := make([]byte, testBlockSize)
+
+	flags := uint16(0)
+	if n.root {
+		flags |= types.BtnodeRoot
+	}
+	if n.level == 0 {
+		flags |= types.BtnodeLeaf
+	}
+	fixed := n.keySize != 0
+	if fixed {
+		flags |= types.BtnodeFixedKvSize
+	}
+
+	objType := n.objType
+	if objType == 0 {
+		objType = types.ObjectTypeBtreeNode | types.ObjPhysical
+		if n.root {
+			objType = types.ObjectTypeBtree | types.ObjPhysical
+		}
+	}
+	putObjectHeader(block, n.oid, n.xid, objType, n.subtype)
+
+	entrySize := 8
+	if fixed {
+		entrySize = 4
+	}
+	tocLen := len(n.records) * entrySize
+
+	binary.LittleEndian.PutUint16(block[32:34], flags)
+	binary.LittleEndian.PutUint16(block[34:36], n.level)
+	binary.LittleEndian.PutUint32(block[36:40], uint32(len(n.records)))
+	binary.LittleEndian.PutUint16(block[40:42], 0)
+	binary.LittleEndian.PutUint16(block[42:44], uint16(tocLen))
+
+	valueEnd := testBlockSize
+	if n.root {
+		valueEnd -= btreeInfoSize
+		info := block[valueEnd:]
+		binary.LittleEndian.PutUint32(info[4:8], testBlockSize)
+		binary.LittleEndian.PutUint32(info[8:12], n.keySize)
+		binary.LittleEndian.PutUint32(info[12:16], n.valueSize)
+		binary.LittleEndian.PutUint64(info[24:32], uint64(len(n.records)))
+		binary.LittleEndian.PutUint64(info[32:40], 1)
+	}
+
+	keyStart := btreeNodeHeaderSize + tocLen
+	keyOff, valOff := 0, 0
+	for i, record := range n.records {
+		copy(block[keyStart+keyOff:], record.key)
+		valOff += len(record.value)
+		copy(block[valueEnd-valOff:], record.value)
+
+		entry := block[btreeNodeHeaderSize+i*entrySize:]
+		if fixed {
+			binary.LittleEndian.PutUint16(entry[0:2], uint16(keyOff))
+			binary.LittleEndian.PutUint16(entry[2:4], uint16(valOff))
+		} else {
+			binary.LittleEndian.PutUint16(entry[0:2], uint16(keyOff))
+			binary.LittleEndian.PutUint16(entry[2:4], uint16(len(record.key)))
+			binary.LittleEndian.PutUint16(entry[4:6], uint16(valOff))
+			binary.LittleEndian.PutUint16(entry[6:8], uint16(len(record.value)))
+		}
+		keyOff += len(record.key)
+	}
+
+	sealObject(block)
+	return block
+}
+
+// jKey encodes a j_key_t header followed by any extra key bytes
+func jKey(oid uint64, recordType types.JObjTypes, extra ...byte) []byte {
+	key := make([]byte, 8, 8+len(extra))
+	binary.LittleEndian.PutUint64(key, oid|uint64(recordType)<<types.ObjTypeShift)
+	return append(key, extra...)
+}
+
+// childValue encodes the child object identifier stored in an index node
+func childValue(oid uint64) []byte {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, oid)
+	return value
+}
+
+// omapRecord encodes an omap_key_t and omap_val_t mapping oid at xid to paddr
+func omapRecord(oid, xid, paddr uint64, flags uint32) btreeRecord {
+	key := make([]byte, 16)
+	binary.LittleEndian.PutUint64(key[0:8], oid)
+	binary.LittleEndian.PutUint64(key[8:16], xid)
+	value := make([]byte, 16)
+	binary.LittleEndian.PutUint32(value[0:4], flags)
+	binary.LittleEndian.PutUint32(value[4:8], testBlockSize)
+	binary.LittleEndian.PutUint64(value[8:16], paddr)
+	return btreeRecord{key: key, value: value}
+}
+
+// writeObjectMap writes an omap_phys_t at addr whose tree is a single leaf
+// root node at addr+1 holding records, which must be in key order
+func (img *testImage) writeObjectMap(addr, xid uint64, records ...btreeRecord) {
+	img.writeObjectMapHeader(addr, addr+1, xid)
+	img.putObject(addr+1, buildBTreeNode(testBTreeNode{
+		oid: addr + 1, xid: xid, subtype: types.ObjectTypeOmap, root: true,
+		keySize: 16, valueSize: 16, records: records,
+	}))
+}
+
+// writeObjectMapHeader writes an omap_phys_t at addr whose tree root is at treeAddr
+func (img *testImage) writeObjectMapHeader(addr, treeAddr, xid uint64) {
+	block := make([]byte, testBlockSize)
+	putObjectHeader(block, addr, xid, types.ObjectTypeOmap|types.ObjPhysical, 0)
+	binary.LittleEndian.PutUint32(block[40:44], types.ObjectTypeBtree|types.ObjPhysical)
+	binary.LittleEndian.PutUint64(block[48:56], treeAddr)
+	img.putObject(addr, block)
+}
+
+// inodeValue encodes a j_inode_val_t. size is stored in uncompressed_size,
+// which is what InodeReader.Size reports.
+func inodeValue(parentID, privateID uint64, mode uint16, size uint64) []byte {
+	value := make([]byte, 92)
+	binary.LittleEndian.PutUint64(value[0:8], parentID)
+	binary.LittleEndian.PutUint64(value[8:16], privateID)
+	binary.LittleEndian.PutUint32(value[56:60], 1)
+	binary.LittleEndian.PutUint16(value[80:82], mode)
+	binary.LittleEndian.PutUint64(value[84:92], size)
+	return value
+}
+
+// inodeRecord encodes the inode record of inode id
+func inodeRecord(id, parentID uint64, mode uint16, size uint64) btreeRecord {
+	return btreeRecord{key: jKey(id, types.ApfsTypeInode), value: inodeValue(parentID, id, mode, size)}
+}
+
+// drecRecord encodes a hashed directory record in directory dirID naming
+// fileID, hashed as on a case-sensitive volume
+func drecRecord(dirID uint64, name string, fileID uint64, fileType uint16) btreeRecord {
+	return hashedDrecRecord(dirID, name, fileID, fileType, false)
+}
+
+// hashedDrecRecord encodes a hashed directory record whose name hash is
+// case-folded when caseFold is set
+func hashedDrecRecord(dirID uint64, name string, fileID uint64, fileType uint16, caseFold bool) btreeRecord {
+	nameLenAndHash, err := NewNameHashingService().NameLenAndHash([]byte(name), caseFold)
+	if err != nil {
+		panic(err)
+	}
+	extra := binary.LittleEndian.AppendUint32(nil, nameLenAndHash)
+	extra = append(append(extra, name...), 0)
+	value := make([]byte, 18)
+	binary.LittleEndian.PutUint64(value[0:8], fileID)
+	binary.LittleEndian.PutUint16(value[16:18], fileType)
+	return btreeRecord{key: jKey(dirID, types.ApfsTypeDirRec, extra...), value: value}
+}
+
+// extentRecord encodes a file extent of data stream privateID
+func extentRecord(privateID, logical, length, physBlock uint64) btreeRecord {
+	value := make([]byte, 24)
+	binary.LittleEndian.PutUint64(value[0:8], length)
+	binary.LittleEndian.PutUint64(value[8:16], physBlock)
+	return btreeRecord{key: jKey(privateID, types.ApfsTypeFileExtent, binary.LittleEndian.AppendUint64(nil, logical)...), value: value}
+}
+
+// xattrRecord encodes an extended attribute of objID embedded in the record
+func xattrRecord(objID uint64, name string, data []byte) btreeRecord {
+	extra := binary.LittleEndian.AppendUint16(nil, uint16(len(name)+1))
+	extra = append(append(extra, name...), 0)
+	value := binary.LittleEndian.AppendUint16(nil, uint16(types.XattrDataEmbedded))
+	value = binary.LittleEndian.AppendUint16(value, uint16(len(data)))
+	return btreeRecord{key: jKey(objID, types.ApfsTypeXattr, extra...), value: append(value, data...)}
+}
+
+// streamXattrRecord encodes an extended attribute of objID whose value is
+// the size bytes of data stream streamID
+func streamXattrRecord(objID uint64, name string, streamID, size uint64) btreeRecord {
+	stream := binary.LittleEndian.AppendUint64(nil, streamID)
+	stream = binary.LittleEndian.AppendUint64(stream, size)
+	stream = append(stream, make([]byte, 32)...)
+	record := xattrRecord(objID, name, stream)
+	binary.LittleEndian.PutUint16(record.value[0:2], uint16(types.XattrDataStream))
+	return record
+}
+
+// siblingLinkRecord encodes the sibling link of inode id for the hard link
+// siblingID named name in directory parentID
+func siblingLinkRecord(id, siblingID, parentID uint64, name string) btreeRecord {
+	value := binary.LittleEndian.AppendUint64(nil, parentID)
+	value = binary.LittleEndian.AppendUint16(value, uint16(len(name)+1))
+	value = append(append(value, name...), 0)
+	return btreeRecord{key: jKey(id, types.ApfsTypeSiblingLink, binary.LittleEndian.AppendUint64(nil, siblingID)...), value: value}
+}
+
+// siblingMapRecord encodes the sibling map of siblingID to inode fileID
+func siblingMapRecord(siblingID, fileID uint64) btreeRecord {
+	return btreeRecord{key: jKey(siblingID, types.ApfsTypeSiblingMap), value: binary.LittleEndian.AppendUint64(nil, fileID)}
+}
+
+// sortFSRecords sorts file-system records into key order: by object
+// identifier, then record type, then the type's own key fields
+func sortFSRecords(records []btreeRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i].key, records[j].key
+		ha, hb := binary.LittleEndian.Uint64(a[0:8]), binary.LittleEndian.Uint64(b[0:8])
+		if ha&types.ObjIdMask != hb&types.ObjIdMask {
+			return ha&types.ObjIdMask < hb&types.ObjIdMask
+		}
+		if ha != hb || len(a) <= 8 || len(b) <= 8 {
+			return ha < hb
+		}
+		switch types.JObjTypes(ha >> types.ObjTypeShift) {
+		case types.ApfsTypeDirRec:
+			return binary.LittleEndian.Uint32(a[8:12]) < binary.LittleEndian.Uint32(b[8:12])
+		case types.ApfsTypeFileExtent, types.ApfsTypeSiblingLink:
+			return binary.LittleEndian.Uint64(a[8:16]) < binary.LittleEndian.Uint64(b[8:16])
+		}
+		return bytes.Compare(a[10:], b[10:]) < 0
+	})
+}
+
+// writeFSTree writes a virtual file-system tree with one leaf per element of
+// leaves, whose records must be in key order. Nodes get virtual identifiers
+// from rootOID upwards and are stored from block addr upwards. The root is an
+// index node when there are several leaves. It returns the object map
+// records that map the nodes at xid.
+func (img *testImage) writeFSTree(rootOID, addr, xid uint64, leaves ...[]btreeRecord) []btreeRecord {
+	virtual := func(root bool) uint32 {
+		if root {
+			return types.ObjectTypeBtree | types.ObjVirtual
+		}
+		return types.ObjectTypeBtreeNode | types.ObjVirtual
+	}
+
+	mappings := []btreeRecord{omapRecord(rootOID, xid, addr, 0)}
+	if len(leaves) == 1 {
+		img.putObject(addr, buildBTreeNode(testBTreeNode{
+			oid: rootOID, xid: xid, objType: virtual(true), subtype: types.ObjectTypeFstree, root: true, records: leaves[0],
+		}))
+		return mappings
+	}
+
+	var index []btreeRecord
+	for i, leaf := range leaves {
+		oid := rootOID + 1 + uint64(i)
+		img.putObject(addr+1+uint64(i), buildBTreeNode(testBTreeNode{
+			oid: oid, xid: xid, objType: virtual(false), subtype: types.ObjectTypeFstree, records: leaf,
+		}))
+		index = append(index, btreeRecord{key: leaf[0].key, value: childValue(oid)})
+		mappings = append(mappings, omapRecord(oid, xid, addr+1+uint64(i), 0))
+	}
+	img.putObject(addr, buildBTreeNode(testBTreeNode{
+		oid: rootOID, xid: xid, objType: virtual(true), subtype: types.ObjectTypeFstree, root: true, level: 1, records: index,
+	}))
+	return mappings
+}
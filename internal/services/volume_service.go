@@ -28,32 +28,11 @@ func NewVolumeService(container *ContainerReader, volumeOID types.OidT) (*Volume
 		return nil, fmt.Errorf("invalid volume OID: 0")
 	}
 
-	// APFS volume OIDs from container superblock are typically VIRTUAL object identifiers
-	// They need to be resolved through the object map to get the physical address
-	// However, in freshly created volumes, the object map may be empty, so we try different approaches
+	// Volume superblocks are virtual objects of the container's object map
 	resolver := NewBTreeObjectResolver(container)
 	physicalAddr, err := resolver.ResolveVirtualObject(volumeOID, container.GetSuperblock().NxNextXid-1)
 	if err != nil {
-		// Fallback 1: try treating volumeOID as a direct physical address
-		physicalAddr = types.Paddr(volumeOID)
-
-		// Fallback 2: if that fails, scan for volume superblock near container structures
-		volSBData, readErr := container.ReadBlock(uint64(physicalAddr))
-		if readErr != nil || !isValidVolumeSuperblock(volSBData) {
-			// Scan more blocks for the volume superblock (volumes can be stored anywhere in container)
-			found := false
-			for scanBlock := uint64(1); scanBlock < 500; scanBlock++ {
-				scanData, scanErr := container.ReadBlock(scanBlock)
-				if scanErr == nil && isValidVolumeSuperblock(scanData) {
-					physicalAddr = types.Paddr(scanBlock)
-					found = true
-					break
-				}
-			}
-			if !found {
-				return nil, fmt.Errorf("failed to locate volume superblock for OID %d", volumeOID)
-			}
-		}
+		return nil, fmt.Errorf("failed to locate volume superblock for OID %d: %w", volumeOID, err)
 	}
 
 	// Read volume superblock from resolved physical address
@@ -82,16 +61,6 @@ func NewVolumeService(container *ContainerReader, volumeOID types.OidT) (*Volume
 	return vs, nil
 }
 
-// isValidVolumeSuperblock checks if a block contains a valid APFS volume superblock
-func isValidVolumeSuperblock(data []byte) bool {
-	if len(data) < 36 {
-		return false
-	}
-	// Check for APFS volume superblock magic "APSB" (0x42535041)
-	magic := uint32(data[32]) | uint32(data[33])<<8 | uint32(data[34])<<16 | uint32(data[35])<<24
-	return magic == 0x42535041
-}
-
 // NewVolumeServiceFromPhysicalOID creates a VolumeService using a direct physical OID (bypassing object map)
 func NewVolumeServiceFromPhysicalOID(container *ContainerReader, physicalOID types.OidT) (*VolumeServiceImpl, error) {
 	if container == nil {
@@ -128,6 +97,14 @@ func NewVolumeServiceFromPhysicalOID(container *ContainerReader, physicalOID typ
 	return vs, nil
 }
 
+// GetSuperblock returns the parsed volume superblock
+func (vs *VolumeServiceImpl) GetSuperblock() *types.ApfsSuperblockT {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	return vs.volumeSB
+}
+
 // GetVolumeMetadata returns comprehensive volume metadata
 func (vs *VolumeServiceImpl) GetVolumeMetadata() (*VolumeReport, error) {
 	vs.mu.RLock()
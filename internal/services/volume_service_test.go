@@ -169,3 +169,23 @@ func TestVolumeServiceWithMultipleDMGs(t *testing.T) {
 		})
 	}
 }
+
+func TestVolumeServiceResolvesThroughObjectMap(t *testing.T) {
+	img := newTestImage(32)
+	img.writeContainerSuperblock(20, 10, 24, 25)
+	img.writeObjectMap(10, 15, omapRecord(24, 15, 21, 0))
+	img.putObject(21, buildVolumeSuperblock(testVolume{oid: 24, xid: 15, name: "Data"}))
+
+	// Superblocks stored at their own OID are stale copies unless the object
+	// map points there, so they are never read directly
+	img.putObject(24, buildVolumeSuperblock(testVolume{oid: 24, xid: 5, name: "Stale"}))
+	img.putObject(25, buildVolumeSuperblock(testVolume{oid: 25, xid: 5, name: "Unmapped"}))
+	cr := img.containerReader(t)
+
+	vs, err := NewVolumeService(cr, 24)
+	require.NoError(t, err)
+	assert.Equal(t, types.XidT(15), vs.GetSuperblock().ApfsO.OXid)
+
+	_, err = NewVolumeService(cr, 25)
+	assert.ErrorContains(t, err, "failed to locate volume superblock for OID 25")
+}
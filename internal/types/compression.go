@@ -37,6 +37,9 @@ const (
 
 	// CompressionMethodZstd uses the Zstandard compression algorithm.
 	CompressionMethodZstd CompressionMethodType = 5
+
+	// CompressionMethodLzbitmap uses the LZBITMAP compression algorithm.
+	CompressionMethodLzbitmap CompressionMethodType = 6
 )
 
 // CompressionSignature is the magic value for a compressed data block.
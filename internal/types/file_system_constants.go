@@ -1,5 +1,7 @@
 package types
 
+import "io/fs"
+
 // File-System Constants
 // Reference: Apple File System Reference, pages 683-744
 
@@ -193,3 +195,38 @@ const (
 	// Reference: page 743
 	ModeIFWHT Mode = 0o160000
 )
+
+// FileMode converts the mode to an fs.FileMode, mapping the POSIX file type
+// and the setuid, setgid and sticky bits to their Go equivalents.
+func (m Mode) FileMode() fs.FileMode {
+	mode := fs.FileMode(m) & fs.ModePerm
+
+	switch m & ModeIFMT {
+	case ModeIFDIR:
+		mode |= fs.ModeDir
+	case ModeIFLNK:
+		mode |= fs.ModeSymlink
+	case ModeIFIFO:
+		mode |= fs.ModeNamedPipe
+	case ModeIFSOCK:
+		mode |= fs.ModeSocket
+	case ModeIFCHR:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case ModeIFBLK:
+		mode |= fs.ModeDevice
+	case ModeIFWHT:
+		mode |= fs.ModeIrregular
+	}
+
+	if m&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	return mode
+}
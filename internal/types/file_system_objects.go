@@ -579,6 +579,11 @@ const ApfsValidInternalInodeFlags JInodeFlags = JInodeFlags(InodeIsApfsPrivate |
 // Reference: page 94
 const ApfsInodePinnedMask JInodeFlags = (InodePinnedToMain | InodePinnedToTier2)
 
+// UfCompressed is the BSD flag of an inode whose data is stored compressed,
+// described by a com.apple.decmpfs extended attribute.
+// See the chflags(2) man page and the <sys/stat.h> header file.
+const UfCompressed uint32 = 0x00000020
+
 // JXattrFlags represents the flags used in an extended attribute record to provide additional information.
 // Reference: page 94
 type JXattrFlags uint16